@@ -1,23 +1,35 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"anonchihaya.co.uk/internal/achievement"
 	"anonchihaya.co.uk/internal/auth"
 	"anonchihaya.co.uk/internal/coreskill"
 	"anonchihaya.co.uk/internal/education"
 	"anonchihaya.co.uk/internal/experience"
 	"anonchihaya.co.uk/internal/guestpopup"
 	"anonchihaya.co.uk/internal/learning"
+	"anonchihaya.co.uk/internal/metrics"
 	"anonchihaya.co.uk/internal/mysterycode"
 	"anonchihaya.co.uk/internal/post"
+	"anonchihaya.co.uk/internal/presence"
 	"anonchihaya.co.uk/internal/profile"
 	"anonchihaya.co.uk/internal/project"
+	"anonchihaya.co.uk/internal/report"
 	"anonchihaya.co.uk/internal/routes"
 	"anonchihaya.co.uk/internal/statistics"
 	"anonchihaya.co.uk/internal/store"
 	"anonchihaya.co.uk/internal/tracking"
+	"anonchihaya.co.uk/internal/visitor"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -56,9 +68,25 @@ func main() {
 		&learning.Learning{},
 		&post.Post{},
 		&tracking.FanTracking{},
+		&tracking.TrackingEvent{},
+		&tracking.ProcessedEvent{},
+		&tracking.Rollup{},
+		&tracking.VisitorRollup{},
+		&tracking.TrackingConsent{},
+		&tracking.RetentionSetting{},
+		&tracking.ContentView{},
+		&tracking.SessionSource{},
+		&tracking.FanStreak{},
+		&tracking.FunnelEvent{},
 		&mysterycode.MysteryCode{},
 		&guestpopup.GuestPopupConfig{},
+		&guestpopup.GuestPopupEvent{},
+		&guestpopup.GuestPopupAssignment{},
+		&guestpopup.GuestPopupTranslation{},
 		&coreskill.CoreSkill{},
+		&visitor.Visitor{},
+		&achievement.FanBadge{},
+		&report.Report{},
 	); err != nil {
 		log.Fatal(err)
 	}
@@ -87,6 +115,7 @@ func main() {
 	popup_repo := guestpopup.NewGuestPopupConfigRepository(store.DB)
 	stats_repo := statistics.NewStatisticsRepository(store.DB)
 	core_skill_repo := coreskill.NewCoreSkillRepository()
+	visitor_repo := visitor.NewVisitorRepository(store.DB)
 
 	// * Basic Information
 	DOMAIN := os.Getenv("DOMAIN")
@@ -105,7 +134,118 @@ func main() {
 	IMG_PATH := os.Getenv("IMG_PATH")
 	IMG_URL_PREFIX := os.Getenv("IMG_URL_PREFIX")
 
-	routes.InitRoutes(r, DOMAIN, ADMIN_PASS, KEY, IMG_PATH, IMG_URL_PREFIX, profile_repo, experiences_repo, educations_repo, projects_repo, posts_repo, fan_repo, session_repo, tracking_repo, mystery_code_repo, popup_repo, stats_repo, core_skill_repo)
+	// * Tracking rollups are backfilled on the first start after upgrading
+	if rebuilt, err := tracking_repo.EnsureRollups(); err != nil {
+		log.Printf("Warning: Failed to backfill tracking rollups: %v", err)
+	} else if rebuilt {
+		log.Println("Backfilled tracking rollups")
+	}
+
+	// * Tracking heartbeats are buffered in memory and flushed in batches
+	flushInterval := tracking.DefaultFlushInterval
+	if v := os.Getenv("TRACKING_FLUSH_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("Error configuring TRACKING_FLUSH_INTERVAL from .env file")
+		}
+		flushInterval = parsed
+	}
+	heartbeats := tracking_repo.EnableAggregation(flushInterval)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	heartbeats.Start(ctx)
+
+	// * Raw tracking rows past the retention period are compacted into the rollups.
+	// The admin setting overrides TRACKING_RETENTION_DAYS; 0 or unset keeps them forever.
+	retentionDays := 0
+	if v := os.Getenv("TRACKING_RETENTION_DAYS"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || tracking.ValidateRetentionDays(parsed) != nil {
+			log.Fatal("Error configuring TRACKING_RETENTION_DAYS from .env file")
+		}
+		retentionDays = parsed
+	}
+	retentionDryRun := os.Getenv("TRACKING_RETENTION_DRY_RUN") == "true"
+	retention_job := tracking.NewRetentionJob(tracking_repo, retentionDays, retentionDryRun, tracking.DefaultRetentionInterval)
+	retention_job.Start(ctx)
+
+	// * Guest visitors not seen for as long as their cookie lasts are deleted daily
+	visitor.NewPruneJob(visitor_repo, visitor.DefaultPruneInterval).Start(ctx)
+
+	// * The statistics overview is cached for STATISTICS_CACHE_TTL; 0 disables the cache
+	statsCacheTTL := statistics.DefaultOverviewTTL
+	if v := os.Getenv("STATISTICS_CACHE_TTL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			log.Fatal("Error configuring STATISTICS_CACHE_TTL from .env file")
+		}
+		statsCacheTTL = parsed
+	}
+	overview_cache := statistics.NewOverviewCache(stats_repo, tracking_repo, statsCacheTTL)
+
+	// * Badges are evaluated in the background when tracking sessions end
+	achievement_repo := achievement.NewAchievementRepository(store.DB)
+	badge_evaluator := achievement.NewEvaluator(achievement_repo, fan_repo, tracking_repo, stats_repo, mystery_code_repo)
+	badge_evaluator.Start(ctx)
+
+	// * A weekly analytics report is stored and emailed to REPORT_EMAIL once each week ends
+	report_repo := report.NewReportRepository(store.DB)
+	report_job := report.NewJob(report_repo, stats_repo, tracking_repo, os.Getenv("REPORT_EMAIL"), report.DefaultCheckInterval)
+	report_job.Start(ctx)
 
-	r.Run("localhost:" + PORT)
+	presence_hub := presence.NewHub(tracking_repo, fan_repo, presence.DefaultRefreshInterval)
+	presence_hub.Start(ctx)
+
+	// * Prometheus metrics need METRICS_TOKEN as a bearer token, or are served on their own
+	// METRICS_PORT instead of the site's; with neither they aren't exposed
+	METRICS_TOKEN := os.Getenv("METRICS_TOKEN")
+	METRICS_PORT := os.Getenv("METRICS_PORT")
+	metrics_collector := metrics.NewCollector()
+	metrics_collector.AddDBStats(sqlDB)
+	metrics_collector.AddAppMetrics(tracking_repo, stats_repo)
+
+	routeMetricsToken := METRICS_TOKEN
+	var metricsSrv *http.Server
+	if METRICS_PORT != "" {
+		routeMetricsToken = ""
+		var handler http.Handler = metrics_collector
+		if METRICS_TOKEN != "" {
+			handler = metrics.RequireToken(METRICS_TOKEN, handler)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", handler)
+		metricsSrv = &http.Server{Addr: "localhost:" + METRICS_PORT, Handler: mux}
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	routes.InitRoutes(r, DOMAIN, ADMIN_PASS, KEY, IMG_PATH, IMG_URL_PREFIX, profile_repo, experiences_repo, educations_repo, projects_repo, posts_repo, fan_repo, session_repo, tracking_repo, mystery_code_repo, popup_repo, stats_repo, core_skill_repo, visitor_repo, presence_hub, retention_job, overview_cache, achievement_repo, badge_evaluator, report_repo, report_job, metrics_collector, routeMetricsToken)
+
+	srv := &http.Server{Addr: "localhost:" + PORT, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: Server shutdown: %v", err)
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Warning: Metrics server shutdown: %v", err)
+		}
+	}
+	if err := heartbeats.Stop(); err != nil {
+		log.Printf("Warning: Failed to flush tracking heartbeats: %v", err)
+	}
 }
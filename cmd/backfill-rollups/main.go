@@ -0,0 +1,41 @@
+// Command backfill-rollups rebuilds the tracking rollups from user_trackings.
+//
+// Stop the web server first: sessions started or finalized during the rebuild could
+// otherwise be counted twice or missed.
+package main
+
+import (
+	"log"
+	"os"
+
+	"anonchihaya.co.uk/internal/store"
+	"anonchihaya.co.uk/internal/tracking"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	DBUSER := os.Getenv("DBUSER")
+	DBPASS := os.Getenv("DBPASS")
+	DBHOST := os.Getenv("DBHOST")
+	DBPORT := os.Getenv("DBPORT")
+	DBNAME := os.Getenv("DBNAME")
+
+	if DBUSER == "" || DBPASS == "" || DBHOST == "" || DBPORT == "" || DBNAME == "" {
+		log.Fatal("Error configuring database from .env file")
+	}
+	store.InitDatabase(DBUSER, DBPASS, DBHOST, DBPORT, DBNAME)
+	if err := store.DB.AutoMigrate(&tracking.FanTracking{}, &tracking.Rollup{}, &tracking.VisitorRollup{}); err != nil {
+		log.Fatal(err)
+	}
+
+	rows, err := tracking.NewFanTrackingRepository(store.DB).RebuildRollups()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Rebuilt %d rollup rows", rows)
+}
@@ -0,0 +1,163 @@
+package achievement
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/mysterycode"
+	"anonchihaya.co.uk/internal/statistics"
+	"anonchihaya.co.uk/internal/tracking"
+	"github.com/gin-gonic/gin"
+)
+
+// Thresholds for the streak and hours badges
+const (
+	streakBadgeDays  = 7
+	hoursBadgeHours  = 10.0
+	evaluationBuffer = 256
+)
+
+// ErrUnknownBadge is returned when awarding a badge that isn't in Badges
+var ErrUnknownBadge = errors.New("unknown badge")
+
+// Evaluator checks fans against the badge rules and awards the badges they have earned
+type Evaluator struct {
+	repo            *AchievementRepository
+	fanRepo         *auth.FanRepository
+	trackingRepo    *tracking.FanTrackingRepository
+	statsRepo       *statistics.StatisticsRepository
+	mysteryCodeRepo *mysterycode.MysteryCodeRepository
+	queue           chan uint
+}
+
+// NewEvaluator creates an evaluator and queues fans for evaluation whenever one of
+// their tracking sessions ends. Call Start to process the queue.
+func NewEvaluator(
+	repo *AchievementRepository,
+	fanRepo *auth.FanRepository,
+	trackingRepo *tracking.FanTrackingRepository,
+	statsRepo *statistics.StatisticsRepository,
+	mysteryCodeRepo *mysterycode.MysteryCodeRepository,
+) *Evaluator {
+	e := &Evaluator{
+		repo:            repo,
+		fanRepo:         fanRepo,
+		trackingRepo:    trackingRepo,
+		statsRepo:       statsRepo,
+		mysteryCodeRepo: mysteryCodeRepo,
+		queue:           make(chan uint, evaluationBuffer),
+	}
+	trackingRepo.OnFanSessionEnded(e.Queue)
+	return e
+}
+
+// Start evaluates queued fans in the background until ctx is cancelled
+func (e *Evaluator) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case fanID := <-e.queue:
+				if _, err := e.Evaluate(fanID); err != nil {
+					log.Printf("Warning: Failed to evaluate badges for fan %d: %v", fanID, err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Queue schedules a fan for evaluation without waiting. When the queue is full the fan
+// is skipped; their badges are still evaluated the next time they look at them.
+func (e *Evaluator) Queue(fanID uint) {
+	select {
+	case e.queue <- fanID:
+	default:
+		log.Printf("Warning: Badge evaluation queue is full, skipping fan %d", fanID)
+	}
+}
+
+// Evaluate awards a fan every badge whose rule they now meet and returns the codes of
+// the new badges. Badges are never taken away.
+func (e *Evaluator) Evaluate(fanID uint) ([]string, error) {
+	fan, err := e.fanRepo.FindByID(fanID)
+	if err != nil {
+		return nil, err
+	}
+
+	earned := make(map[string]bool)
+	earned[BadgeVerifiedEmail] = fan.EmailVerified
+
+	if earned[BadgeFirstVisit], err = e.repo.hasVisited(fanID); err != nil {
+		return nil, err
+	}
+
+	hours, err := e.trackingRepo.GetFanTotalHours(fanID)
+	if err != nil {
+		return nil, err
+	}
+	earned[BadgeHours10] = hours >= hoursBadgeHours
+
+	loc := time.Local
+	if fan.Timezone != "" {
+		if fanLoc, err := time.LoadLocation(fan.Timezone); err == nil {
+			loc = fanLoc
+		}
+	}
+	streak, err := e.statsRepo.GetFanStreak(fanID, loc)
+	if err != nil {
+		return nil, err
+	}
+	earned[BadgeStreak7] = streak >= streakBadgeDays
+
+	if earned[BadgeMysteryCode], err = e.mysteryCodeRepo.HasRedeemed(fanID); err != nil {
+		return nil, err
+	}
+
+	var awarded []string
+	now := time.Now()
+	for _, badge := range Badges {
+		if !earned[badge.Code] {
+			continue
+		}
+		isNew, err := e.repo.Award(fanID, badge.Code, now)
+		if err != nil {
+			return awarded, err
+		}
+		if isNew {
+			awarded = append(awarded, badge.Code)
+		}
+	}
+	return awarded, nil
+}
+
+// Award gives a fan a badge for an event the rules can't look up. The site has no
+// comments yet, so BadgeFirstComment is only awarded once a comments feature calls this
+// when a fan first posts.
+func (e *Evaluator) Award(fanID uint, badge string) (bool, error) {
+	if !ValidBadge(badge) {
+		return false, ErrUnknownBadge
+	}
+	return e.repo.Award(fanID, badge, time.Now())
+}
+
+// FanHook evaluates a fan's badges when they register, log in or verify their email
+func FanHook(evaluator *Evaluator) auth.FanHook {
+	return func(c *gin.Context, event auth.FanEvent, fan *auth.Fan) {
+		if _, err := evaluator.Evaluate(fan.ID); err != nil {
+			log.Printf("Warning: Failed to evaluate badges for fan %d after %s: %v", fan.ID, event, err)
+		}
+	}
+}
+
+// RedeemHook evaluates a fan's badges after they redeem a mystery code
+func RedeemHook(evaluator *Evaluator) mysterycode.RedeemHook {
+	return func(c *gin.Context, fan *auth.Fan) {
+		if _, err := evaluator.Evaluate(fan.ID); err != nil {
+			log.Printf("Warning: Failed to evaluate badges for fan %d after redeeming a code: %v", fan.ID, err)
+		}
+	}
+}
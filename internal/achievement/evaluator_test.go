@@ -0,0 +1,174 @@
+package achievement
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/mysterycode"
+	"anonchihaya.co.uk/internal/statistics"
+	"anonchihaya.co.uk/internal/store"
+	"anonchihaya.co.uk/internal/tracking"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupEvaluator(t *testing.T) (*Evaluator, *tracking.FanTrackingRepository) {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:achievement_%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&auth.Fan{}, &tracking.FanTracking{}, &tracking.Rollup{}, &tracking.VisitorRollup{}, &tracking.FanStreak{}, &mysterycode.MysteryCode{}, &FanBadge{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	store.DB = db
+
+	trackingRepo := tracking.NewFanTrackingRepository(db)
+	evaluator := NewEvaluator(
+		NewAchievementRepository(db),
+		auth.NewFanRepository(),
+		trackingRepo,
+		statistics.NewStatisticsRepository(db),
+		mysterycode.NewMysteryCodeRepository(db),
+	)
+	return evaluator, trackingRepo
+}
+
+func createFan(t *testing.T, db *gorm.DB, name string, verified bool) uint {
+	t.Helper()
+
+	fan := auth.Fan{Username: name, Email: name + "@example.com", EmailVerified: verified}
+	if err := db.Create(&fan).Error; err != nil {
+		t.Fatalf("failed to create fan: %v", err)
+	}
+	return fan.ID
+}
+
+func TestEvaluateAwardsEarnedBadges(t *testing.T) {
+	evaluator, _ := setupEvaluator(t)
+	db := evaluator.repo.db
+	fanID := createFan(t, db, "badger", true)
+	createFan(t, db, "other", false)
+
+	awarded, err := evaluator.Evaluate(fanID)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if !slices.Equal(awarded, []string{BadgeVerifiedEmail}) {
+		t.Fatalf("expected only the verified badge, got %v", awarded)
+	}
+
+	// Seven days in a row, ending today, adding up to more than ten hours
+	now := time.Now()
+	for day := 0; day < 7; day++ {
+		at := now.AddDate(0, 0, -day)
+		if err := db.Create(&tracking.Rollup{
+			Slot:        tracking.RollupSlot(at),
+			Owner:       fmt.Sprintf("fan:%d", fanID),
+			FanID:       &fanID,
+			Sessions:    1,
+			Starts:      1,
+			Seconds:     6000,
+			LastStartAt: at,
+		}).Error; err != nil {
+			t.Fatalf("failed to seed session: %v", err)
+		}
+	}
+	if _, err := tracking.NewFanTrackingRepository(db).RebuildRollups(); err != nil {
+		t.Fatalf("failed to rebuild visitor totals: %v", err)
+	}
+	usedAt := now
+	if err := db.Create(&mysterycode.MysteryCode{Code: "open-sesame", IsUsed: true, UsedBy: &fanID, UsedAt: &usedAt}).Error; err != nil {
+		t.Fatalf("failed to seed code: %v", err)
+	}
+
+	awarded, err = evaluator.Evaluate(fanID)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	want := []string{BadgeFirstVisit, BadgeStreak7, BadgeHours10, BadgeMysteryCode}
+	if !slices.Equal(awarded, want) {
+		t.Fatalf("expected %v, got %v", want, awarded)
+	}
+
+	// Badges are only awarded once
+	awarded, err = evaluator.Evaluate(fanID)
+	if err != nil || len(awarded) != 0 {
+		t.Fatalf("expected no new badges, got %v (err %v)", awarded, err)
+	}
+
+	if isNew, err := evaluator.Award(fanID, BadgeFirstComment); err != nil || !isNew {
+		t.Fatalf("Award(first_comment): new=%t err=%v", isNew, err)
+	}
+	if _, err := evaluator.Award(fanID, "moon_landing"); !errors.Is(err, ErrUnknownBadge) {
+		t.Fatalf("expected ErrUnknownBadge, got %v", err)
+	}
+
+	badges, err := evaluator.repo.GetFanBadges(fanID)
+	if err != nil || len(badges) != len(Badges) {
+		t.Fatalf("expected every badge, got %+v (err %v)", badges, err)
+	}
+}
+
+func TestBadgeRarity(t *testing.T) {
+	evaluator, _ := setupEvaluator(t)
+	db := evaluator.repo.db
+	for i := 0; i < 10; i++ {
+		fanID := createFan(t, db, fmt.Sprintf("fan-%d", i), i < 6)
+		if _, err := evaluator.Evaluate(fanID); err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+	}
+	if _, err := evaluator.Award(1, BadgeFirstComment); err != nil {
+		t.Fatalf("Award failed: %v", err)
+	}
+
+	statuses, err := evaluator.repo.GetBadgeStatuses()
+	if err != nil {
+		t.Fatalf("GetBadgeStatuses failed: %v", err)
+	}
+	if len(statuses) != len(Badges) {
+		t.Fatalf("expected %d badges, got %d", len(Badges), len(statuses))
+	}
+
+	byCode := make(map[string]BadgeStatus)
+	for _, status := range statuses {
+		byCode[status.Code] = status
+	}
+	if verified := byCode[BadgeVerifiedEmail]; verified.Holders != 6 || verified.Percentage != 60 || verified.Rarity != "common" {
+		t.Fatalf("unexpected verified badge: %+v", verified)
+	}
+	if comment := byCode[BadgeFirstComment]; comment.Holders != 1 || comment.Rarity != "rare" {
+		t.Fatalf("unexpected comment badge: %+v", comment)
+	}
+	if streak := byCode[BadgeStreak7]; streak.Holders != 0 || streak.Rarity != "legendary" {
+		t.Fatalf("unexpected streak badge: %+v", streak)
+	}
+}
+
+func TestFanSessionEndQueuesEvaluation(t *testing.T) {
+	evaluator, trackingRepo := setupEvaluator(t)
+	fanID := createFan(t, evaluator.repo.db, "queued", false)
+
+	if _, err := trackingRepo.StartTracking(&fanID, "", "queued-session", tracking.ClientInfo{}); err != nil {
+		t.Fatalf("StartTracking failed: %v", err)
+	}
+	if err := trackingRepo.EndTracking("queued-session", &fanID); err != nil {
+		t.Fatalf("EndTracking failed: %v", err)
+	}
+
+	select {
+	case queued := <-evaluator.queue:
+		if queued != fanID {
+			t.Fatalf("expected fan %d to be queued, got %d", fanID, queued)
+		}
+	default:
+		t.Fatal("expected the fan to be queued when their session ended")
+	}
+}
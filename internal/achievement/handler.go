@@ -0,0 +1,138 @@
+package achievement
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"anonchihaya.co.uk/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+type AchievementHandler struct {
+	repo      *AchievementRepository
+	evaluator *Evaluator
+	fanRepo   *auth.FanRepository
+}
+
+func NewAchievementHandler(repo *AchievementRepository, evaluator *Evaluator, fanRepo *auth.FanRepository) *AchievementHandler {
+	return &AchievementHandler{
+		repo:      repo,
+		evaluator: evaluator,
+		fanRepo:   fanRepo,
+	}
+}
+
+// GetBadges godoc
+// @Summary All badges
+// @Description Every badge with the number and share of fans who hold it and its rarity.
+// @Tags achievements
+// @Produce json
+// @Success 200 {array} achievement.BadgeStatus
+// @Failure 500 {object} ErrorResponse
+// @Router /achievements [get]
+func (h *AchievementHandler) GetBadges(c *gin.Context) {
+	statuses, err := h.repo.GetBadgeStatuses()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get badges"})
+		return
+	}
+
+	c.JSON(http.StatusOK, statuses)
+}
+
+// GetMyBadges godoc
+// @Summary Current fan's badges
+// @Description Every badge, with awarded_at set on the ones the fan has earned. The fan's badges are re-evaluated first.
+// @Tags achievements
+// @Produce json
+// @Success 200 {array} achievement.BadgeStatus
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /achievements/me [get]
+func (h *AchievementHandler) GetMyBadges(c *gin.Context) {
+	fan, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	f, ok := fan.(*auth.Fan)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid fan context"})
+		return
+	}
+
+	// Catches up on sessions whose evaluation was skipped or is still queued
+	if _, err := h.evaluator.Evaluate(f.ID); err != nil {
+		log.Printf("Warning: Failed to evaluate badges for fan %d: %v", f.ID, err)
+	}
+
+	statuses, err := h.fanBadgeStatuses(f.ID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get badges"})
+		return
+	}
+
+	c.JSON(http.StatusOK, statuses)
+}
+
+// GetFanBadges godoc
+// @Summary A fan's badges
+// @Description The badges a fan has earned, for their public profile.
+// @Tags achievements
+// @Produce json
+// @Param id path int true "Fan ID"
+// @Success 200 {array} achievement.BadgeStatus
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /achievements/fans/{id} [get]
+func (h *AchievementHandler) GetFanBadges(c *gin.Context) {
+	fanID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	if _, err := h.fanRepo.FindByID(uint(fanID)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fan not found"})
+		return
+	}
+
+	statuses, err := h.fanBadgeStatuses(uint(fanID), false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get badges"})
+		return
+	}
+
+	c.JSON(http.StatusOK, statuses)
+}
+
+// fanBadgeStatuses returns the badges a fan has earned with their award times, in
+// badge order, followed by the rest when includeLocked is set
+func (h *AchievementHandler) fanBadgeStatuses(fanID uint, includeLocked bool) ([]BadgeStatus, error) {
+	statuses, err := h.repo.GetBadgeStatuses()
+	if err != nil {
+		return nil, err
+	}
+	awarded, err := h.repo.GetFanBadges(fanID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, badge := range awarded {
+		for i := range statuses {
+			if statuses[i].Code == badge.Badge {
+				statuses[i].AwardedAt = &badge.AwardedAt
+			}
+		}
+	}
+
+	result := make([]BadgeStatus, 0, len(statuses))
+	for _, status := range statuses {
+		if status.AwardedAt != nil || includeLocked {
+			result = append(result, status)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package achievement
+
+import (
+	"time"
+)
+
+// Badge codes
+const (
+	BadgeFirstVisit    = "first_visit"
+	BadgeStreak7       = "streak_7"
+	BadgeHours10       = "hours_10"
+	BadgeVerifiedEmail = "verified_email"
+	BadgeMysteryCode   = "mystery_code"
+	BadgeFirstComment  = "first_comment"
+)
+
+// Badge describes an achievement fans can earn
+type Badge struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Icon        string `json:"icon"`
+}
+
+// Badges lists every badge in display order
+var Badges = []Badge{
+	{Code: BadgeFirstVisit, Name: "First Visit", Description: "Visited the site as a fan", Icon: "👋"},
+	{Code: BadgeStreak7, Name: "Week Streak", Description: "Visited 7 days in a row", Icon: "🔥"},
+	{Code: BadgeHours10, Name: "Ten Hours", Description: "Spent 10 hours on the site", Icon: "⏳"},
+	{Code: BadgeVerifiedEmail, Name: "Verified", Description: "Verified an email address", Icon: "✉️"},
+	{Code: BadgeMysteryCode, Name: "Code Breaker", Description: "Redeemed a mystery code", Icon: "🗝️"},
+	{Code: BadgeFirstComment, Name: "First Words", Description: "Posted a first comment", Icon: "💬"},
+}
+
+// ValidBadge reports whether code is a known badge
+func ValidBadge(code string) bool {
+	for _, badge := range Badges {
+		if badge.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// FanBadge is a badge awarded to a fan
+type FanBadge struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	FanID     uint      `gorm:"column:user_id;not null;uniqueIndex:idx_fan_badges_fan_badge" json:"user_id"`
+	Badge     string    `gorm:"type:varchar(32);not null;uniqueIndex:idx_fan_badges_fan_badge;index" json:"badge"`
+	AwardedAt time.Time `gorm:"not null" json:"awarded_at"`
+}
+
+// TableName sets the table name for awarded badges
+func (FanBadge) TableName() string {
+	return "fan_badges"
+}
+
+// BadgeStatus is a badge with how many fans hold it and, for one fan's badges, when
+// that fan earned it
+type BadgeStatus struct {
+	Badge
+	Holders    int64      `json:"holders"`
+	Percentage float64    `json:"percentage"` // Share of fans who hold the badge
+	Rarity     string     `json:"rarity"`     // common, uncommon, rare or legendary
+	AwardedAt  *time.Time `json:"awarded_at,omitempty"`
+}
+
+// rarity names a badge held by percentage percent of fans
+func rarity(percentage float64) string {
+	switch {
+	case percentage >= 50:
+		return "common"
+	case percentage >= 20:
+		return "uncommon"
+	case percentage >= 5:
+		return "rare"
+	default:
+		return "legendary"
+	}
+}
@@ -0,0 +1,81 @@
+package achievement
+
+import (
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/tracking"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AchievementRepository struct {
+	db *gorm.DB
+}
+
+func NewAchievementRepository(db *gorm.DB) *AchievementRepository {
+	return &AchievementRepository{db: db}
+}
+
+// Award gives a fan a badge unless they already hold it, and reports whether it was new
+func (r *AchievementRepository) Award(fanID uint, badge string, at time.Time) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&FanBadge{
+		FanID:     fanID,
+		Badge:     badge,
+		AwardedAt: at,
+	})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetFanBadges returns a fan's badges, oldest first
+func (r *AchievementRepository) GetFanBadges(fanID uint) ([]FanBadge, error) {
+	var badges []FanBadge
+	err := r.db.Where("user_id = ?", fanID).
+		Order("awarded_at ASC, id ASC").
+		Find(&badges).Error
+	return badges, err
+}
+
+// GetBadgeStatuses returns every badge with how many fans hold it
+func (r *AchievementRepository) GetBadgeStatuses() ([]BadgeStatus, error) {
+	var fans int64
+	if err := r.db.Model(&auth.Fan{}).Count(&fans).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Badge   string
+		Holders int64
+	}
+	if err := r.db.Model(&FanBadge{}).
+		Select("badge, COUNT(*) AS holders").
+		Group("badge").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	holders := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		holders[row.Badge] = row.Holders
+	}
+
+	statuses := make([]BadgeStatus, 0, len(Badges))
+	for _, badge := range Badges {
+		status := BadgeStatus{Badge: badge, Holders: holders[badge.Code]}
+		if fans > 0 {
+			status.Percentage = float64(status.Holders) * 100 / float64(fans)
+		}
+		status.Rarity = rarity(status.Percentage)
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// hasVisited reports whether a fan has started a tracking session
+func (r *AchievementRepository) hasVisited(fanID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&tracking.VisitorRollup{}).
+		Where("user_id = ?", fanID).
+		Limit(1).
+		Count(&count).Error
+	return count > 0, err
+}
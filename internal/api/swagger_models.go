@@ -5,6 +5,7 @@ import (
 
 	"anonchihaya.co.uk/internal/experience"
 	"anonchihaya.co.uk/internal/guestpopup"
+	"anonchihaya.co.uk/internal/tracking"
 )
 
 type ErrorResponse struct {
@@ -44,14 +45,20 @@ type FanAuthResendVerificationRequest struct {
 }
 
 type FanUpdateProfileRequest struct {
-	Bio          *string `json:"bio"`
-	ProfilePhoto *string `json:"profile_photo"`
+	Bio               *string `json:"bio"`
+	ProfilePhoto      *string `json:"profile_photo"`
+	HidePresence      *bool   `json:"hide_presence"`
+	Timezone          *string `json:"timezone"`
+	ShowOnLeaderboard *bool   `json:"show_on_leaderboard"`
 }
 
 type FanPublicProfileResponse struct {
-	Message      string `json:"message"`
-	ProfilePhoto string `json:"profile_photo"`
-	Bio          string `json:"bio"`
+	Message           string `json:"message"`
+	ProfilePhoto      string `json:"profile_photo"`
+	Bio               string `json:"bio"`
+	HidePresence      bool   `json:"hide_presence"`
+	Timezone          string `json:"timezone"`
+	ShowOnLeaderboard bool   `json:"show_on_leaderboard"`
 }
 
 type FanProfilePhotoResponse struct {
@@ -60,14 +67,17 @@ type FanProfilePhotoResponse struct {
 }
 
 type FanPublicUserResponse struct {
-	ID            uint      `json:"id"`
-	Username      string    `json:"username"`
-	Email         string    `json:"email,omitempty"`
-	IsAdmin       bool      `json:"is_admin,omitempty"`
-	ProfilePhoto  string    `json:"profile_photo,omitempty"`
-	Bio           string    `json:"bio,omitempty"`
-	EmailVerified bool      `json:"email_verified,omitempty"`
-	CreatedAt     time.Time `json:"created_at,omitempty"`
+	ID                uint      `json:"id"`
+	Username          string    `json:"username"`
+	Email             string    `json:"email,omitempty"`
+	IsAdmin           bool      `json:"is_admin,omitempty"`
+	ProfilePhoto      string    `json:"profile_photo,omitempty"`
+	Bio               string    `json:"bio,omitempty"`
+	EmailVerified     bool      `json:"email_verified,omitempty"`
+	HidePresence      bool      `json:"hide_presence,omitempty"`
+	Timezone          string    `json:"timezone,omitempty"`
+	ShowOnLeaderboard bool      `json:"show_on_leaderboard,omitempty"`
+	CreatedAt         time.Time `json:"created_at,omitempty"`
 }
 
 type FanAuthRegisterResponse struct {
@@ -89,20 +99,60 @@ type TotalHoursResponse struct {
 	TotalHours float64 `json:"total_hours"`
 }
 
+type TrackingRecordsResponse struct {
+	Records    []tracking.FanTracking `json:"records"`
+	NextCursor string                 `json:"next_cursor"`
+}
+
+type OnlineCountResponse struct {
+	Online int64 `json:"online"`
+}
+
+type TrackingConsentRequest struct {
+	Granted bool `json:"granted"`
+}
+
+type TrackingConsentResponse struct {
+	Consent       *tracking.TrackingConsent `json:"consent"`
+	Granted       bool                      `json:"granted"`
+	PolicyVersion string                    `json:"policy_version"`
+	DoNotTrack    bool                      `json:"do_not_track"`
+}
+
+type TrackingErasureResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+type TrackingRetentionRequest struct {
+	Days int `json:"days"`
+}
+
+type TrackingRetentionResponse struct {
+	Days        int                        `json:"days"`
+	DefaultDays int                        `json:"default_days"`
+	Setting     *tracking.RetentionSetting `json:"setting"`
+	MinDays     int                        `json:"min_days"`
+	DryRun      bool                       `json:"dry_run"`
+	LastReport  *tracking.RetentionReport  `json:"last_report"`
+}
+
 type StreakResponse struct {
-	Streak int `json:"streak"`
+	Streak        int    `json:"streak"`
+	Longest       int    `json:"longest"`
+	LastActiveDay string `json:"last_active_day"`
 }
 
 type OverallStatisticsResponse struct {
-	TotalUsers             int64   `json:"total_users"`
-	UniqueVisitorsEver     int64   `json:"unique_visitors_ever"`
-	UniqueVisitors24h      int64   `json:"unique_visitors_24h"`
-	RegisteredVisitorsEver int64   `json:"registered_visitors_ever"`
-	GuestVisitorsEver      int64   `json:"guest_visitors_ever"`
-	RegisteredVisitors24h  int64   `json:"registered_visitors_24h"`
-	GuestVisitors24h       int64   `json:"guest_visitors_24h"`
-	ActiveUsersToday       int64   `json:"active_users_today"`
-	TotalHours             float64 `json:"total_hours"`
+	TotalUsers             int64     `json:"total_users"`
+	UniqueVisitorsEver     int64     `json:"unique_visitors_ever"`
+	UniqueVisitors24h      int64     `json:"unique_visitors_24h"`
+	RegisteredVisitorsEver int64     `json:"registered_visitors_ever"`
+	GuestVisitorsEver      int64     `json:"guest_visitors_ever"`
+	RegisteredVisitors24h  int64     `json:"registered_visitors_24h"`
+	GuestVisitors24h       int64     `json:"guest_visitors_24h"`
+	ActiveUsersToday       int64     `json:"active_users_today"`
+	TotalHours             float64   `json:"total_hours"`
+	GeneratedAt            time.Time `json:"generated_at"`
 }
 
 type ExperienceResponse struct {
@@ -122,6 +172,65 @@ type TrackingSessionRequest struct {
 	SessionID string `json:"session_id" binding:"required"`
 }
 
+type TrackingStartRequest struct {
+	SessionID   string `json:"session_id" binding:"required"`
+	Referrer    string `json:"referrer" example:"https://news.ycombinator.com/item?id=1"`
+	UTMSource   string `json:"utm_source" example:"twitter"`
+	UTMMedium   string `json:"utm_medium" example:"social"`
+	UTMCampaign string `json:"utm_campaign" example:"launch"`
+}
+
+type TrackingEventRequest struct {
+	SessionID   string                 `json:"session_id" binding:"required"`
+	Name        string                 `json:"name" example:"pageview"`
+	Path        string                 `json:"path" example:"/projects/1"`
+	Referrer    string                 `json:"referrer"`
+	UTMSource   string                 `json:"utm_source"`
+	UTMMedium   string                 `json:"utm_medium"`
+	UTMCampaign string                 `json:"utm_campaign"`
+	UTMTerm     string                 `json:"utm_term"`
+	UTMContent  string                 `json:"utm_content"`
+	Properties  map[string]interface{} `json:"properties"`
+}
+
+type TrackingContentViewRequest struct {
+	SessionID   string `json:"session_id" binding:"required"`
+	ContentType string `json:"content_type" binding:"required" enums:"post,project"`
+	ContentID   int    `json:"content_id" binding:"required" example:"1"`
+	ReadSeconds int64  `json:"read_seconds" example:"42"`
+	ScrollDepth int    `json:"scroll_depth" example:"75"`
+}
+
+type TrackingFunnelStepRequest struct {
+	SessionID string `json:"session_id" binding:"required"`
+	Step      string `json:"step" binding:"required" enums:"popup_shown,register_form"`
+}
+
+type TrackingContentViewResponse struct {
+	Message string `json:"message"`
+	Counted bool   `json:"counted"`
+}
+
+type TrackingBatchOp struct {
+	ID   string `json:"id" binding:"required" example:"3f1c2a9e-7b1d-4c55-9a53-0d6f1e2b8c41"`
+	Type string `json:"type" binding:"required" enums:"start,update,end,event"`
+	// Event fields, used when type is "event"
+	Name        string                 `json:"name" example:"pageview"`
+	Path        string                 `json:"path" example:"/projects/1"`
+	Referrer    string                 `json:"referrer"`
+	UTMSource   string                 `json:"utm_source"`
+	UTMMedium   string                 `json:"utm_medium"`
+	UTMCampaign string                 `json:"utm_campaign"`
+	UTMTerm     string                 `json:"utm_term"`
+	UTMContent  string                 `json:"utm_content"`
+	Properties  map[string]interface{} `json:"properties"`
+}
+
+type TrackingBatchRequest struct {
+	SessionID string            `json:"session_id" binding:"required"`
+	Ops       []TrackingBatchOp `json:"ops" binding:"required"`
+}
+
 type EducationImageUpdateRequest struct {
 	ID       int    `json:"id" binding:"required"`
 	ImageURL string `json:"image_url" binding:"required"`
@@ -151,6 +260,29 @@ type MysteryCodeRequest struct {
 type GuestPopupConfigResponse = guestpopup.GuestPopupConfig
 
 type GuestPopupConfigRequest struct {
-	Title    string `json:"title" binding:"required"`
-	Benefits string `json:"benefits" binding:"required"`
+	Title                 string              `json:"title" binding:"required"`
+	Benefits              guestpopup.Benefits `json:"benefits" binding:"required"`
+	DefaultLocale         string              `json:"default_locale"`
+	Weight                *int                `json:"weight"`
+	StartsAt              *time.Time          `json:"starts_at"`
+	EndsAt                *time.Time          `json:"ends_at"`
+	ShowAfterSeconds      int                 `json:"show_after_seconds"`
+	TargetPaths           []string            `json:"target_paths"`
+	ReturningVisitorsOnly bool                `json:"returning_visitors_only"`
+	MaxViewsPerVisitor    int                 `json:"max_views_per_visitor"`
+	ViewCooldownHours     int                 `json:"view_cooldown_hours"`
+}
+
+type GuestPopupEventRequest struct {
+	ConfigID uint   `json:"config_id" binding:"required"`
+	Event    string `json:"event" binding:"required" enums:"impression,dismiss,click_register"`
 }
+
+type GuestPopupVariantReport = guestpopup.VariantReport
+
+type GuestPopupTranslationRequest struct {
+	Title    string              `json:"title" binding:"required"`
+	Benefits guestpopup.Benefits `json:"benefits" binding:"required"`
+}
+
+type GuestPopupTranslationResponse = guestpopup.GuestPopupTranslation
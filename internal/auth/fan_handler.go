@@ -3,6 +3,7 @@ package auth
 import (
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"anonchihaya.co.uk/internal/util"
@@ -13,6 +14,7 @@ type FanHandler struct {
 	fanRepo     *FanRepository
 	sessionRepo *SessionRepository
 	domain      string
+	hooks       fanHooks
 }
 
 func NewFanHandler(fanRepo *FanRepository, sessionRepo *SessionRepository, domain string) *FanHandler {
@@ -91,6 +93,8 @@ func (h *FanHandler) Register(c *gin.Context) {
 	}
 	go util.SendVerificationEmail(fan.Email, verificationToken, frontendURL)
 
+	h.hooks.fire(c, FanRegistered, fan)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Fan registered successfully. Please check your email to verify your account.",
 		"user": gin.H{
@@ -154,6 +158,8 @@ func (h *FanHandler) Login(c *gin.Context) {
 	// Set cookie
 	c.SetCookie("session_token", token, 7*24*60*60, "/", h.domain, false, true)
 
+	h.hooks.fire(c, FanLoggedIn, fan)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Login successful",
 		"user": gin.H{
@@ -205,13 +211,16 @@ func (h *FanHandler) GetCurrentUser(c *gin.Context) {
 
 	currentFan := fan.(*Fan)
 	c.JSON(http.StatusOK, gin.H{
-		"id":            currentFan.ID,
-		"username":      currentFan.Username,
-		"email":         currentFan.Email,
-		"is_admin":      currentFan.IsAdmin,
-		"profile_photo": currentFan.ProfilePhoto,
-		"bio":           currentFan.Bio,
-		"created_at":    currentFan.CreatedAt,
+		"id":                  currentFan.ID,
+		"username":            currentFan.Username,
+		"email":               currentFan.Email,
+		"is_admin":            currentFan.IsAdmin,
+		"profile_photo":       currentFan.ProfilePhoto,
+		"bio":                 currentFan.Bio,
+		"hide_presence":       currentFan.HidePresence,
+		"timezone":            currentFan.Timezone,
+		"show_on_leaderboard": currentFan.ShowOnLeaderboard,
+		"created_at":          currentFan.CreatedAt,
 	})
 }
 
@@ -237,8 +246,11 @@ func (h *FanHandler) UpdateProfile(c *gin.Context) {
 	currentFan := fan.(*Fan)
 
 	type UpdateProfileRequest struct {
-		Bio          *string `json:"bio"`
-		ProfilePhoto *string `json:"profile_photo"`
+		Bio               *string `json:"bio"`
+		ProfilePhoto      *string `json:"profile_photo"`
+		HidePresence      *bool   `json:"hide_presence"`
+		Timezone          *string `json:"timezone"`
+		ShowOnLeaderboard *bool   `json:"show_on_leaderboard"`
 	}
 
 	var req UpdateProfileRequest
@@ -254,6 +266,19 @@ func (h *FanHandler) UpdateProfile(c *gin.Context) {
 	if req.ProfilePhoto != nil {
 		currentFan.ProfilePhoto = *req.ProfilePhoto
 	}
+	if req.HidePresence != nil {
+		currentFan.HidePresence = *req.HidePresence
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || strings.EqualFold(*req.Timezone, "Local") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+			return
+		}
+		currentFan.Timezone = *req.Timezone
+	}
+	if req.ShowOnLeaderboard != nil {
+		currentFan.ShowOnLeaderboard = *req.ShowOnLeaderboard
+	}
 
 	if err := h.fanRepo.Update(currentFan); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
@@ -261,9 +286,12 @@ func (h *FanHandler) UpdateProfile(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":       "Profile updated successfully",
-		"profile_photo": currentFan.ProfilePhoto,
-		"bio":           currentFan.Bio,
+		"message":             "Profile updated successfully",
+		"profile_photo":       currentFan.ProfilePhoto,
+		"bio":                 currentFan.Bio,
+		"hide_presence":       currentFan.HidePresence,
+		"timezone":            currentFan.Timezone,
+		"show_on_leaderboard": currentFan.ShowOnLeaderboard,
 	})
 }
 
@@ -358,6 +386,7 @@ func (h *FanHandler) VerifyEmail(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
 		return
 	}
+	h.hooks.fire(c, FanEmailVerified, fan)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Email verified successfully",
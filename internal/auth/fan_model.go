@@ -16,6 +16,13 @@ type Fan struct {
 	VerificationToken string    `gorm:"type:varchar(255)" json:"-"`
 	OAuthProvider     string    `gorm:"column:o_auth_provider;type:varchar(50)" json:"oauth_provider,omitempty"`
 	OAuthID           string    `gorm:"column:o_auth_id;type:varchar(255)" json:"-"`
+	HidePresence      bool      `gorm:"default:false" json:"hide_presence"`       // Only count the fan anonymously in "online now"
+	Timezone          string    `gorm:"type:varchar(64)" json:"timezone"`         // IANA zone for statistics and streaks, empty for the server's zone
+	ShowOnLeaderboard bool      `gorm:"default:false" json:"show_on_leaderboard"` // Opted in to the public leaderboard
+	SourceReferrer    string    `gorm:"type:varchar(255)" json:"-"`               // First-touch referrer host, set at registration
+	SourceUTMSource   string    `gorm:"type:varchar(255)" json:"-"`               // First-touch campaign, set at registration
+	SourceUTMMedium   string    `gorm:"type:varchar(255)" json:"-"`
+	SourceUTMCampaign string    `gorm:"type:varchar(255)" json:"-"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 }
@@ -26,6 +26,16 @@ func (r *FanRepository) FindByID(id uint) (*Fan, error) {
 	return &fan, nil
 }
 
+// FindByIDs returns the fans with the given IDs, in no particular order
+func (r *FanRepository) FindByIDs(ids []uint) ([]*Fan, error) {
+	var fans []*Fan
+	if len(ids) == 0 {
+		return fans, nil
+	}
+	err := r.db.Where("id IN ?", ids).Find(&fans).Error
+	return fans, err
+}
+
 func (r *FanRepository) FindByUsername(username string) (*Fan, error) {
 	var fan Fan
 	err := r.db.Where("username = ?", username).First(&fan).Error
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// FanEvent identifies a fan lifecycle step that other packages can react to
+type FanEvent string
+
+const (
+	FanRegistered    FanEvent = "registered"
+	FanLoggedIn      FanEvent = "logged_in"
+	FanEmailVerified FanEvent = "email_verified"
+)
+
+// FanHook is called after a fan lifecycle step has succeeded.
+// Hooks run on the request goroutine, so they should be quick and must not write a response.
+type FanHook func(c *gin.Context, event FanEvent, fan *Fan)
+
+type fanHooks []FanHook
+
+func (hooks fanHooks) fire(c *gin.Context, event FanEvent, fan *Fan) {
+	for _, hook := range hooks {
+		hook(c, event, fan)
+	}
+}
+
+// AddHook registers a hook that is called after fan lifecycle events
+func (h *FanHandler) AddHook(hook FanHook) {
+	h.hooks = append(h.hooks, hook)
+}
+
+// AddHook registers a hook that is called after OAuth sign-ups and logins
+func (h *OAuthHandler) AddHook(hook FanHook) {
+	h.hooks = append(h.hooks, hook)
+}
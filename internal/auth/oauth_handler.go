@@ -18,6 +18,7 @@ type OAuthHandler struct {
 	sessionRepo  *SessionRepository
 	domain       string
 	googleConfig *oauth2.Config
+	hooks        fanHooks
 }
 
 func NewOAuthHandler(fanRepo *FanRepository, sessionRepo *SessionRepository, domain string) *OAuthHandler {
@@ -123,6 +124,7 @@ func (h *OAuthHandler) GoogleCallback(c *gin.Context) {
 	}
 
 	// Find or create fan
+	event := FanLoggedIn
 	fan, err := h.fanRepo.FindByOAuthID("google", googleUser.ID)
 	if err != nil {
 		// Check if email already exists (from regular registration)
@@ -156,6 +158,7 @@ func (h *OAuthHandler) GoogleCallback(c *gin.Context) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create fan"})
 				return
 			}
+			event = FanRegistered
 		}
 	}
 
@@ -175,6 +178,8 @@ func (h *OAuthHandler) GoogleCallback(c *gin.Context) {
 	// Set cookie
 	c.SetCookie("session_token", sessionToken, 7*24*60*60, "/", h.domain, false, true)
 
+	h.hooks.fire(c, event, fan)
+
 	// Redirect to frontend
 	frontendURL := os.Getenv("FRONTEND_URL")
 	if frontendURL == "" {
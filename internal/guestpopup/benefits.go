@@ -0,0 +1,123 @@
+package guestpopup
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxBenefits          = 10
+	maxBenefitTextLength = 200
+	maxBenefitIconLength = 32
+)
+
+// Benefit is one line in the popup's benefit list
+type Benefit struct {
+	Icon string `json:"icon"` // Emoji or icon name, optional
+	Text string `json:"text"`
+}
+
+// Benefits is stored as a JSON array in a text column
+type Benefits []Benefit
+
+// UnmarshalJSON accepts benefit objects as well as plain strings, which become text-only benefits
+func (b *Benefits) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return errors.New("benefits must be an array")
+	}
+
+	benefits := make(Benefits, 0, len(raw))
+	for _, item := range raw {
+		var text string
+		if err := json.Unmarshal(item, &text); err == nil {
+			benefits = append(benefits, Benefit{Text: text})
+			continue
+		}
+
+		var benefit Benefit
+		if err := json.Unmarshal(item, &benefit); err != nil {
+			return errors.New("each benefit must be a string or an object with icon and text")
+		}
+		benefits = append(benefits, benefit)
+	}
+
+	*b = benefits
+	return nil
+}
+
+// Value implements driver.Valuer
+func (b Benefits) Value() (driver.Value, error) {
+	if b == nil {
+		b = Benefits{}
+	}
+	data, err := json.Marshal([]Benefit(b))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner. Rows written before benefits were typed hold a JSON
+// array of strings, or occasionally free text, and are read leniently.
+func (b *Benefits) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*b = Benefits{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported benefits type %T", value)
+	}
+
+	if err := b.UnmarshalJSON(data); err != nil {
+		text := strings.TrimSpace(string(data))
+		*b = Benefits{}
+		if text != "" {
+			*b = Benefits{{Text: text}}
+		}
+	}
+	return nil
+}
+
+// Validate checks that the list is non-empty, bounded and every item has text
+func (b Benefits) Validate() error {
+	if len(b) == 0 {
+		return errors.New("at least one benefit is required")
+	}
+	if len(b) > maxBenefits {
+		return fmt.Errorf("at most %d benefits are allowed", maxBenefits)
+	}
+	for i, benefit := range b {
+		if strings.TrimSpace(benefit.Text) == "" {
+			return fmt.Errorf("benefit %d must have text", i+1)
+		}
+		if utf8.RuneCountInString(benefit.Text) > maxBenefitTextLength {
+			return fmt.Errorf("benefit %d text must be at most %d characters", i+1, maxBenefitTextLength)
+		}
+		if utf8.RuneCountInString(benefit.Icon) > maxBenefitIconLength {
+			return fmt.Errorf("benefit %d icon must be at most %d characters", i+1, maxBenefitIconLength)
+		}
+	}
+	return nil
+}
+
+// normalize trims whitespace from every item
+func (b Benefits) normalize() Benefits {
+	out := make(Benefits, len(b))
+	for i, benefit := range b {
+		out[i] = Benefit{
+			Icon: strings.TrimSpace(benefit.Icon),
+			Text: strings.TrimSpace(benefit.Text),
+		}
+	}
+	return out
+}
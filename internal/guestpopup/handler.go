@@ -1,9 +1,16 @@
 package guestpopup
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/visitor"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type GuestPopupHandler struct {
@@ -14,23 +21,195 @@ func NewGuestPopupHandler(popupRepo *GuestPopupConfigRepository) *GuestPopupHand
 	return &GuestPopupHandler{popupRepo: popupRepo}
 }
 
+type configRequest struct {
+	Title                 string     `json:"title" binding:"required"`
+	Benefits              Benefits   `json:"benefits" binding:"required"`
+	DefaultLocale         string     `json:"default_locale"`
+	Weight                *int       `json:"weight"`
+	StartsAt              *time.Time `json:"starts_at"`
+	EndsAt                *time.Time `json:"ends_at"`
+	ShowAfterSeconds      int        `json:"show_after_seconds"`
+	TargetPaths           []string   `json:"target_paths"`
+	ReturningVisitorsOnly bool       `json:"returning_visitors_only"`
+	MaxViewsPerVisitor    int        `json:"max_views_per_visitor"`
+	ViewCooldownHours     int        `json:"view_cooldown_hours"`
+}
+
+func (req *configRequest) toConfig() *GuestPopupConfig {
+	weight := 100
+	if req.Weight != nil {
+		weight = *req.Weight
+	}
+	locale := req.DefaultLocale
+	if locale == "" {
+		locale = DefaultLocale
+	}
+
+	return &GuestPopupConfig{
+		Weight:                weight,
+		Title:                 req.Title,
+		Benefits:              req.Benefits.normalize(),
+		DefaultLocale:         locale,
+		StartsAt:              req.StartsAt,
+		EndsAt:                req.EndsAt,
+		ShowAfterSeconds:      req.ShowAfterSeconds,
+		TargetPaths:           req.TargetPaths,
+		ReturningVisitorsOnly: req.ReturningVisitorsOnly,
+		MaxViewsPerVisitor:    req.MaxViewsPerVisitor,
+		ViewCooldownHours:     req.ViewCooldownHours,
+	}
+}
+
 // GetActiveConfig godoc
-// @Summary Get active guest popup config
+// @Summary Get the guest popup variant for the calling visitor
+// @Description Each visitor is pinned to one of the active variants, chosen by weight, and only sees it while its schedule and targeting rules match.
+// @Description Requests without a visitor cookie (e.g. with DNT) get the default variant, the one with the most weight, without view limits.
+// @Description show_after_seconds is not checked here; the client waits that long before showing the popup.
+// @Description Content is served in the translation that best matches Accept-Language, falling back to the variant's default locale.
 // @Tags guest-popup
 // @Produce json
+// @Param path query string false "Path the visitor is on"
+// @Param Accept-Language header string false "Preferred languages"
 // @Success 200 {object} GuestPopupConfigResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /guest-popup/active [get]
 func (h *GuestPopupHandler) GetActiveConfig(c *gin.Context) {
-	config, err := h.popupRepo.GetActiveConfig()
+	now := time.Now()
+	configs, err := h.popupRepo.GetScheduledConfigs(now)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get configuration"})
+		return
+	}
+
+	// Requests without a visitor (e.g. with DNT) get the default variant, without
+	// assignment or view capping
+	state := VisitorState{Path: c.DefaultQuery("path", "/")}
+	var config *GuestPopupConfig
+	if v := visitor.FromContext(c); v != nil {
+		if config, err = h.assignVariant(configs, v.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get configuration"})
+			return
+		}
+		if config != nil {
+			state.Returning = v.IsReturning(now)
+			if state.Views, state.LastView, err = h.popupRepo.GetVisitorViews(config.ID, v.ID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get configuration"})
+				return
+			}
+		}
+	} else {
+		config = defaultVariant(configs)
+	}
+	if config == nil || !config.Matches(state, now) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "No active configuration found"})
 		return
 	}
 
+	if err := h.localize(config, c.GetHeader("Accept-Language")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get configuration"})
+		return
+	}
+
+	c.Header("Content-Language", config.Locale)
 	c.JSON(http.StatusOK, config)
 }
 
+// localize swaps the config's content for the translation that best matches acceptLanguage
+func (h *GuestPopupHandler) localize(config *GuestPopupConfig, acceptLanguage string) error {
+	config.Locale = config.DefaultLocale
+
+	translations, err := h.popupRepo.GetTranslations(config.ID)
+	if err != nil {
+		return err
+	}
+	if len(translations) == 0 {
+		return nil
+	}
+
+	available := []string{config.DefaultLocale}
+	for _, t := range translations {
+		available = append(available, t.Locale)
+	}
+
+	locale := MatchLocale(acceptLanguage, available, config.DefaultLocale)
+	for _, t := range translations {
+		if t.Locale == locale {
+			config.Title = t.Title
+			config.Benefits = t.Benefits
+			config.Locale = t.Locale
+			break
+		}
+	}
+	return nil
+}
+
+// assignVariant returns the variant the visitor is pinned to, assigning one if the
+// visitor is new or their previous variant is no longer running.
+func (h *GuestPopupHandler) assignVariant(configs []GuestPopupConfig, visitorID string) (*GuestPopupConfig, error) {
+	assignment, err := h.popupRepo.GetAssignment(visitorID)
+	if err != nil {
+		return nil, err
+	}
+	if assignment != nil {
+		for i := range configs {
+			if configs[i].ID == assignment.ConfigID {
+				return &configs[i], nil
+			}
+		}
+	}
+
+	config := pickVariant(configs, visitorID)
+	if config == nil {
+		return nil, nil
+	}
+	if err := h.popupRepo.SaveAssignment(visitorID, config.ID); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// RecordEvent godoc
+// @Summary Record a guest popup interaction
+// @Tags guest-popup
+// @Accept json
+// @Produce json
+// @Param body body GuestPopupEventRequest true "Event"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /guest-popup/event [post]
+func (h *GuestPopupHandler) RecordEvent(c *gin.Context) {
+	var req struct {
+		ConfigID uint   `json:"config_id" binding:"required"`
+		Event    string `json:"event" binding:"required,oneof=impression dismiss click_register"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	v := visitor.FromContext(c)
+	if v == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown visitor"})
+		return
+	}
+
+	if _, err := h.popupRepo.GetConfig(req.ConfigID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Configuration not found"})
+		return
+	}
+
+	if err := h.popupRepo.RecordEvent(req.ConfigID, v.ID, req.Event, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record event"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Event recorded successfully"})
+}
+
 // CreateConfig godoc
 // @Summary Create guest popup config
 // @Tags guest-popup
@@ -42,18 +221,19 @@ func (h *GuestPopupHandler) GetActiveConfig(c *gin.Context) {
 // @Failure 500 {object} ErrorResponse
 // @Router /guest-popup/create [post]
 func (h *GuestPopupHandler) CreateConfig(c *gin.Context) {
-	var req struct {
-		Title    string `json:"title" binding:"required"`
-		Benefits string `json:"benefits" binding:"required"`
+	var req configRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
+	config := req.toConfig()
+	if err := config.Validate(); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	config, err := h.popupRepo.CreateConfig(req.Title, req.Benefits)
-	if err != nil {
+	if err := h.popupRepo.CreateConfig(config); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create configuration"})
 		return
 	}
@@ -70,26 +250,81 @@ func (h *GuestPopupHandler) CreateConfig(c *gin.Context) {
 // @Param body body GuestPopupConfigRequest true "Config"
 // @Success 200 {object} MessageResponse
 // @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /guest-popup/{id} [put]
 func (h *GuestPopupHandler) UpdateConfig(c *gin.Context) {
-	var req struct {
-		Title    string `json:"title" binding:"required"`
-		Benefits string `json:"benefits" binding:"required"`
+	id, err := parseConfigID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
 	}
 
+	var req configRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var id uint
-	if err := c.ShouldBindUri(&struct{ ID uint }{ID: id}); err != nil {
+	config := req.toConfig()
+	if err := config.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.popupRepo.UpdateConfig(id, config); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Configuration not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update configuration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Configuration updated successfully"})
+}
+
+// ActivateConfig godoc
+// @Summary Activate guest popup variant
+// @Description Active variants share traffic according to their weights.
+// @Tags guest-popup
+// @Produce json
+// @Param id path int true "Config ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /guest-popup/{id}/activate [put]
+func (h *GuestPopupHandler) ActivateConfig(c *gin.Context) {
+	h.setActive(c, true)
+}
+
+// DeactivateConfig godoc
+// @Summary Deactivate guest popup variant
+// @Tags guest-popup
+// @Produce json
+// @Param id path int true "Config ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /guest-popup/{id}/deactivate [put]
+func (h *GuestPopupHandler) DeactivateConfig(c *gin.Context) {
+	h.setActive(c, false)
+}
+
+func (h *GuestPopupHandler) setActive(c *gin.Context, active bool) {
+	id, err := parseConfigID(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
 		return
 	}
 
-	if err := h.popupRepo.UpdateConfig(id, req.Title, req.Benefits); err != nil {
+	if err := h.popupRepo.SetActive(id, active); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Configuration not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update configuration"})
 		return
 	}
@@ -97,6 +332,30 @@ func (h *GuestPopupHandler) UpdateConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Configuration updated successfully"})
 }
 
+// GetReport godoc
+// @Summary Guest popup A/B test report
+// @Description Conversion rate per variant with a two-proportion z-test against the control (the oldest variant with impressions).
+// @Tags guest-popup
+// @Produce json
+// @Success 200 {array} GuestPopupVariantReport
+// @Failure 500 {object} ErrorResponse
+// @Router /guest-popup/report [get]
+func (h *GuestPopupHandler) GetReport(c *gin.Context) {
+	configs, err := h.popupRepo.GetAllConfigs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get configurations"})
+		return
+	}
+
+	counts, err := h.popupRepo.GetEventCounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get popup events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, BuildReport(configs, counts))
+}
+
 // GetAllConfigs godoc
 // @Summary List guest popup configs
 // @Tags guest-popup
@@ -113,3 +372,165 @@ func (h *GuestPopupHandler) GetAllConfigs(c *gin.Context) {
 
 	c.JSON(http.StatusOK, configs)
 }
+
+// GetTranslations godoc
+// @Summary List translations of a guest popup config
+// @Tags guest-popup
+// @Produce json
+// @Param id path int true "Config ID"
+// @Success 200 {array} GuestPopupTranslationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /guest-popup/{id}/translations [get]
+func (h *GuestPopupHandler) GetTranslations(c *gin.Context) {
+	id, err := parseConfigID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	translations, err := h.popupRepo.GetTranslations(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get translations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, translations)
+}
+
+// SaveTranslation godoc
+// @Summary Create or replace a guest popup translation
+// @Tags guest-popup
+// @Accept json
+// @Produce json
+// @Param id path int true "Config ID"
+// @Param locale path string true "Locale, e.g. fr or zh-cn"
+// @Param body body GuestPopupTranslationRequest true "Translation"
+// @Success 200 {object} GuestPopupTranslationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /guest-popup/{id}/translations/{locale} [put]
+func (h *GuestPopupHandler) SaveTranslation(c *gin.Context) {
+	id, err := parseConfigID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	locale, err := NormalizeLocale(c.Param("locale"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid locale"})
+		return
+	}
+
+	var req struct {
+		Title    string   `json:"title" binding:"required"`
+		Benefits Benefits `json:"benefits" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	benefits := req.Benefits.normalize()
+	if err := benefits.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	config, err := h.popupRepo.GetConfig(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Configuration not found"})
+		return
+	}
+	if locale == config.DefaultLocale {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Edit the configuration itself to change its default locale content"})
+		return
+	}
+
+	translation := &GuestPopupTranslation{
+		ConfigID: id,
+		Locale:   locale,
+		Title:    req.Title,
+		Benefits: benefits,
+	}
+	if err := h.popupRepo.SaveTranslation(translation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save translation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, translation)
+}
+
+// DeleteTranslation godoc
+// @Summary Delete a guest popup translation
+// @Tags guest-popup
+// @Produce json
+// @Param id path int true "Config ID"
+// @Param locale path string true "Locale"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /guest-popup/{id}/translations/{locale} [delete]
+func (h *GuestPopupHandler) DeleteTranslation(c *gin.Context) {
+	id, err := parseConfigID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	locale, err := NormalizeLocale(c.Param("locale"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid locale"})
+		return
+	}
+
+	if err := h.popupRepo.DeleteTranslation(id, locale); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Translation not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete translation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Translation deleted successfully"})
+}
+
+func parseConfigID(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, errors.New("invalid id")
+	}
+	return uint(id), nil
+}
+
+// RegistrationHook attributes a new fan's registration to the popup variant the
+// visitor saw most recently.
+func RegistrationHook(popupRepo *GuestPopupConfigRepository) auth.FanHook {
+	return func(c *gin.Context, event auth.FanEvent, fan *auth.Fan) {
+		if event != auth.FanRegistered {
+			return
+		}
+
+		visitorID := visitor.ID(c)
+		if visitorID == "" {
+			return
+		}
+
+		impression, err := popupRepo.GetLastImpression(visitorID)
+		if err != nil {
+			log.Printf("Warning: Failed to look up popup impression: %v", err)
+			return
+		}
+		if impression == nil {
+			return
+		}
+
+		if err := popupRepo.RecordEvent(impression.ConfigID, visitorID, EventRegister, &fan.ID); err != nil {
+			log.Printf("Warning: Failed to attribute registration to popup: %v", err)
+		}
+	}
+}
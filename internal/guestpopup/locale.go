@@ -0,0 +1,90 @@
+package guestpopup
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is used for configs created without an explicit default locale
+const DefaultLocale = "en"
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// NormalizeLocale lower-cases a BCP 47 tag and checks its shape
+func NormalizeLocale(locale string) (string, error) {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if !localePattern.MatchString(locale) {
+		return "", errors.New("invalid locale")
+	}
+	return locale, nil
+}
+
+type languagePreference struct {
+	tag     string
+	quality float64
+}
+
+// parseAcceptLanguage returns the tags of an Accept-Language header ordered by preference
+func parseAcceptLanguage(header string) []string {
+	var prefs []languagePreference
+	for i, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag, err := NormalizeLocale(fields[0])
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+					quality = parsed
+				}
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		// Keep header order for equal weights by nudging later entries down
+		prefs = append(prefs, languagePreference{tag: tag, quality: quality - float64(i)*1e-6})
+	}
+
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].quality > prefs[j].quality })
+
+	tags := make([]string, len(prefs))
+	for i, pref := range prefs {
+		tags[i] = pref.tag
+	}
+	return tags
+}
+
+// MatchLocale picks the available locale that best fits an Accept-Language header.
+// Each preferred tag is tried as an exact match first and then by its base language,
+// before falling back to the default.
+func MatchLocale(acceptLanguage string, available []string, fallback string) string {
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		for _, locale := range available {
+			if locale == tag {
+				return locale
+			}
+		}
+
+		base, _, _ := strings.Cut(tag, "-")
+		for _, locale := range available {
+			if locale == base {
+				return locale
+			}
+		}
+		for _, locale := range available {
+			localeBase, _, _ := strings.Cut(locale, "-")
+			if localeBase == base {
+				return locale
+			}
+		}
+	}
+	return fallback
+}
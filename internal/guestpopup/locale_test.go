@@ -0,0 +1,46 @@
+package guestpopup
+
+import "testing"
+
+func TestMatchLocale(t *testing.T) {
+	available := []string{"en", "fr", "zh-cn", "pt-br"}
+
+	cases := []struct {
+		header string
+		want   string
+	}{
+		{"", "en"},
+		{"fr", "fr"},
+		{"fr-CA,en;q=0.8", "fr"},
+		{"de,en;q=0.5", "en"},
+		{"de", "en"},
+		{"en;q=0.2,zh-CN;q=0.9", "zh-cn"},
+		{"zh-TW", "zh-cn"},
+		{"pt", "pt-br"},
+		{"fr;q=0,en", "en"},
+		{"*", "en"},
+	}
+
+	for _, tc := range cases {
+		if got := MatchLocale(tc.header, available, "en"); got != tc.want {
+			t.Errorf("MatchLocale(%q) = %q, want %q", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestBenefitsScanLegacyValues(t *testing.T) {
+	var b Benefits
+	if err := b.Scan(`["Free updates","Track time"]`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(b) != 2 || b[0].Text != "Free updates" || b[0].Icon != "" {
+		t.Fatalf("unexpected benefits from legacy string array: %+v", b)
+	}
+
+	if err := b.Scan([]byte("not json")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(b) != 1 || b[0].Text != "not json" {
+		t.Fatalf("expected free text to become a single benefit, got %+v", b)
+	}
+}
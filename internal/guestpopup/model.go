@@ -6,10 +6,61 @@ import (
 
 // GuestPopupConfig stores configurable benefits shown in the guest registration popup
 type GuestPopupConfig struct {
+	ID       uint       `gorm:"primaryKey" json:"id"`
+	Title    string     `gorm:"type:varchar(255);not null" json:"title"`
+	Benefits Benefits   `gorm:"type:text;not null" json:"benefits"`
+	IsActive bool       `gorm:"default:true" json:"is_active"`
+	Weight   int        `gorm:"default:100" json:"weight"` // Share of traffic among the active variants
+	StartsAt *time.Time `json:"starts_at"`                 // Nullable, shown immediately when unset
+	EndsAt   *time.Time `json:"ends_at"`                   // Nullable, shown indefinitely when unset
+
+	// Targeting rules
+	ShowAfterSeconds      int      `gorm:"default:0" json:"show_after_seconds"`           // Enforced by the client, which waits this long before showing the popup
+	TargetPaths           []string `gorm:"type:text;serializer:json" json:"target_paths"` // Empty means every path, "/prefix/*" matches a subtree
+	ReturningVisitorsOnly bool     `gorm:"default:false" json:"returning_visitors_only"`
+	MaxViewsPerVisitor    int      `gorm:"default:0" json:"max_views_per_visitor"` // 0 means unlimited
+	ViewCooldownHours     int      `gorm:"default:0" json:"view_cooldown_hours"`   // Minimum hours between two views
+
+	// Title and Benefits are written in DefaultLocale; other locales live in GuestPopupTranslation
+	DefaultLocale string `gorm:"type:varchar(35);default:'en'" json:"default_locale"`
+	Locale        string `gorm:"-" json:"locale,omitempty"` // Locale of the content served to a visitor
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GuestPopupTranslation holds the popup content for one extra locale
+type GuestPopupTranslation struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
+	ConfigID  uint      `gorm:"uniqueIndex:idx_popup_translation_locale;not null" json:"config_id"`
+	Locale    string    `gorm:"type:varchar(35);uniqueIndex:idx_popup_translation_locale;not null" json:"locale"`
 	Title     string    `gorm:"type:varchar(255);not null" json:"title"`
-	Benefits  string    `gorm:"type:text;not null" json:"benefits"` // JSON array of benefit strings
-	IsActive  bool      `gorm:"default:true" json:"is_active"`
+	Benefits  Benefits  `gorm:"type:text;not null" json:"benefits"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const (
+	EventImpression    = "impression"
+	EventDismiss       = "dismiss"
+	EventClickRegister = "click_register"
+	EventRegister      = "register"
+)
+
+// GuestPopupEvent records an interaction between a visitor and a popup config
+type GuestPopupEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ConfigID  uint      `gorm:"index;not null" json:"config_id"`
+	VisitorID string    `gorm:"type:varchar(64);index;not null" json:"visitor_id"`
+	Event     string    `gorm:"type:varchar(32);index;not null" json:"event"`
+	FanID     *uint     `gorm:"index" json:"fan_id"` // Set for registrations
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GuestPopupAssignment pins a visitor to one popup variant so they see the same one across visits
+type GuestPopupAssignment struct {
+	VisitorID string    `gorm:"primaryKey;type:varchar(64)" json:"visitor_id"`
+	ConfigID  uint      `gorm:"index;not null" json:"config_id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
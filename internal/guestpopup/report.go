@@ -0,0 +1,114 @@
+package guestpopup
+
+import (
+	"math"
+)
+
+// significanceLevel is the p-value below which a variant counts as different from the control
+const significanceLevel = 0.05
+
+// VariantReport summarises how one popup variant performs
+type VariantReport struct {
+	ConfigID       uint    `json:"config_id"`
+	Title          string  `json:"title"`
+	IsActive       bool    `json:"is_active"`
+	Weight         int     `json:"weight"`
+	Impressions    int64   `json:"impressions"`
+	Dismisses      int64   `json:"dismisses"`
+	Clicks         int64   `json:"clicks"`
+	Registrations  int64   `json:"registrations"`
+	ClickRate      float64 `json:"click_rate"`
+	ConversionRate float64 `json:"conversion_rate"`
+	IsControl      bool    `json:"is_control"`
+	ZScore         float64 `json:"z_score"`
+	PValue         float64 `json:"p_value"`
+	Significant    bool    `json:"significant"`
+}
+
+// BuildReport combines configs and their event counts into a per-variant report.
+// The oldest variant with impressions is the control, and every other variant's
+// conversion rate is compared against it with a two-proportion z-test.
+func BuildReport(configs []GuestPopupConfig, counts []EventCount) []VariantReport {
+	byConfig := make(map[uint]map[string]int64)
+	for _, count := range counts {
+		if byConfig[count.ConfigID] == nil {
+			byConfig[count.ConfigID] = make(map[string]int64)
+		}
+		byConfig[count.ConfigID][count.Event] = count.Visitors
+	}
+
+	var reports []VariantReport
+	for _, cfg := range configs {
+		events := byConfig[cfg.ID]
+		if !cfg.IsActive && len(events) == 0 {
+			continue
+		}
+
+		report := VariantReport{
+			ConfigID:      cfg.ID,
+			Title:         cfg.Title,
+			IsActive:      cfg.IsActive,
+			Weight:        cfg.Weight,
+			Impressions:   events[EventImpression],
+			Dismisses:     events[EventDismiss],
+			Clicks:        events[EventClickRegister],
+			Registrations: events[EventRegister],
+		}
+		if report.Impressions > 0 {
+			report.ClickRate = float64(report.Clicks) / float64(report.Impressions)
+			report.ConversionRate = float64(report.Registrations) / float64(report.Impressions)
+		}
+		reports = append(reports, report)
+	}
+
+	control := -1
+	for i := range reports {
+		if reports[i].Impressions == 0 {
+			continue
+		}
+		if control == -1 || reports[i].ConfigID < reports[control].ConfigID {
+			control = i
+		}
+	}
+	if control == -1 {
+		return reports
+	}
+
+	reports[control].IsControl = true
+	reports[control].PValue = 1
+	for i := range reports {
+		if i == control || reports[i].Impressions == 0 {
+			continue
+		}
+		z, p := twoProportionZTest(
+			reports[control].Registrations, reports[control].Impressions,
+			reports[i].Registrations, reports[i].Impressions,
+		)
+		reports[i].ZScore = z
+		reports[i].PValue = p
+		reports[i].Significant = p < significanceLevel
+	}
+
+	return reports
+}
+
+// twoProportionZTest compares the success rates x1/n1 and x2/n2 and returns the
+// z statistic and two-sided p-value. A positive z means the second rate is higher.
+func twoProportionZTest(x1, n1, x2, n2 int64) (float64, float64) {
+	if n1 == 0 || n2 == 0 {
+		return 0, 1
+	}
+
+	p1 := float64(x1) / float64(n1)
+	p2 := float64(x2) / float64(n2)
+	pooled := float64(x1+x2) / float64(n1+n2)
+
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 0, 1
+	}
+
+	z := (p2 - p1) / se
+	p := math.Erfc(math.Abs(z) / math.Sqrt2)
+	return z, p
+}
@@ -1,7 +1,11 @@
 package guestpopup
 
 import (
+	"errors"
+	"time"
+
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type GuestPopupConfigRepository struct {
@@ -21,37 +25,56 @@ func (r *GuestPopupConfigRepository) GetActiveConfig() (*GuestPopupConfig, error
 	return &config, nil
 }
 
-// CreateConfig creates a new popup configuration
-func (r *GuestPopupConfigRepository) CreateConfig(title, benefits string) (*GuestPopupConfig, error) {
-	config := &GuestPopupConfig{
-		Title:    title,
-		Benefits: benefits,
-		IsActive: true,
+// GetScheduledConfigs returns active configurations whose schedule covers the given time
+func (r *GuestPopupConfigRepository) GetScheduledConfigs(now time.Time) ([]GuestPopupConfig, error) {
+	var configs []GuestPopupConfig
+	if err := r.db.Where("is_active = ?", true).
+		Where("starts_at IS NULL OR starts_at <= ?", now).
+		Where("ends_at IS NULL OR ends_at > ?", now).
+		Order("id ASC").
+		Find(&configs).Error; err != nil {
+		return nil, err
 	}
-	if err := r.db.Create(config).Error; err != nil {
+	return configs, nil
+}
+
+// GetConfig returns a popup configuration by ID
+func (r *GuestPopupConfigRepository) GetConfig(id uint) (*GuestPopupConfig, error) {
+	var config GuestPopupConfig
+	if err := r.db.First(&config, id).Error; err != nil {
 		return nil, err
 	}
-	return config, nil
+	return &config, nil
+}
+
+// CreateConfig creates a new popup configuration
+func (r *GuestPopupConfigRepository) CreateConfig(config *GuestPopupConfig) error {
+	config.IsActive = true
+	return r.db.Create(config).Error
 }
 
 // UpdateConfig updates an existing popup configuration
-func (r *GuestPopupConfigRepository) UpdateConfig(id uint, title, benefits string) error {
-	return r.db.Model(&GuestPopupConfig{}).
-		Where("id = ?", id).
-		Updates(map[string]interface{}{
-			"title":    title,
-			"benefits": benefits,
-		}).Error
-}
-
-// SetActive sets a specific config as active and deactivates others
-func (r *GuestPopupConfigRepository) SetActive(id uint) error {
-	// Deactivate all configs
-	if err := r.db.Model(&GuestPopupConfig{}).Updates(map[string]interface{}{"is_active": false}).Error; err != nil {
+func (r *GuestPopupConfigRepository) UpdateConfig(id uint, config *GuestPopupConfig) error {
+	result := r.db.Model(&GuestPopupConfig{ID: id}).
+		Select("title", "benefits", "starts_at", "ends_at", "show_after_seconds", "target_paths",
+			"returning_visitors_only", "max_views_per_visitor", "view_cooldown_hours", "weight", "default_locale").
+		Updates(config)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetActive turns a config on or off as one of the running variants
+func (r *GuestPopupConfigRepository) SetActive(id uint, active bool) error {
+	config, err := r.GetConfig(id)
+	if err != nil {
 		return err
 	}
-	// Activate the specified config
-	return r.db.Model(&GuestPopupConfig{}).Where("id = ?", id).Update("is_active", true).Error
+	return r.db.Model(config).Update("is_active", active).Error
 }
 
 // GetAllConfigs returns all popup configurations
@@ -62,3 +85,120 @@ func (r *GuestPopupConfigRepository) GetAllConfigs() ([]GuestPopupConfig, error)
 	}
 	return configs, nil
 }
+
+// RecordEvent stores a popup interaction for a visitor
+func (r *GuestPopupConfigRepository) RecordEvent(configID uint, visitorID, event string, fanID *uint) error {
+	return r.db.Create(&GuestPopupEvent{
+		ConfigID:  configID,
+		VisitorID: visitorID,
+		Event:     event,
+		FanID:     fanID,
+	}).Error
+}
+
+// GetLastImpression returns the most recent impression for a visitor, or nil if they never saw a popup
+func (r *GuestPopupConfigRepository) GetLastImpression(visitorID string) (*GuestPopupEvent, error) {
+	var event GuestPopupEvent
+	err := r.db.Where("visitor_id = ? AND event = ?", visitorID, EventImpression).
+		Order("created_at DESC").
+		First(&event).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// GetAssignment returns the variant a visitor is pinned to, or nil if they have none yet
+func (r *GuestPopupConfigRepository) GetAssignment(visitorID string) (*GuestPopupAssignment, error) {
+	var assignment GuestPopupAssignment
+	err := r.db.Where("visitor_id = ?", visitorID).First(&assignment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+// SaveAssignment pins a visitor to a variant, replacing any previous assignment
+func (r *GuestPopupConfigRepository) SaveAssignment(visitorID string, configID uint) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "visitor_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"config_id", "updated_at"}),
+	}).Create(&GuestPopupAssignment{
+		VisitorID: visitorID,
+		ConfigID:  configID,
+	}).Error
+}
+
+// EventCount is the number of distinct visitors with a given event for a config
+type EventCount struct {
+	ConfigID uint   `json:"config_id"`
+	Event    string `json:"event"`
+	Visitors int64  `json:"visitors"`
+}
+
+// GetEventCounts returns distinct visitor counts per config and event type
+func (r *GuestPopupConfigRepository) GetEventCounts() ([]EventCount, error) {
+	var counts []EventCount
+	err := r.db.Model(&GuestPopupEvent{}).
+		Select("config_id, event, COUNT(DISTINCT visitor_id) AS visitors").
+		Group("config_id, event").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// GetVisitorViews returns how many times a visitor has seen a config and when they last saw it
+func (r *GuestPopupConfigRepository) GetVisitorViews(configID uint, visitorID string) (int64, *time.Time, error) {
+	query := func() *gorm.DB {
+		return r.db.Model(&GuestPopupEvent{}).
+			Where("config_id = ? AND visitor_id = ? AND event = ?", configID, visitorID, EventImpression)
+	}
+
+	var views int64
+	if err := query().Count(&views).Error; err != nil {
+		return 0, nil, err
+	}
+	if views == 0 {
+		return 0, nil, nil
+	}
+
+	var last GuestPopupEvent
+	if err := query().Order("created_at DESC").First(&last).Error; err != nil {
+		return 0, nil, err
+	}
+	return views, &last.CreatedAt, nil
+}
+
+// GetTranslations returns every translation of a config
+func (r *GuestPopupConfigRepository) GetTranslations(configID uint) ([]GuestPopupTranslation, error) {
+	var translations []GuestPopupTranslation
+	if err := r.db.Where("config_id = ?", configID).Order("locale ASC").Find(&translations).Error; err != nil {
+		return nil, err
+	}
+	return translations, nil
+}
+
+// SaveTranslation creates or replaces the translation of a config for one locale
+func (r *GuestPopupConfigRepository) SaveTranslation(translation *GuestPopupTranslation) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "config_id"}, {Name: "locale"}},
+		DoUpdates: clause.AssignmentColumns([]string{"title", "benefits", "updated_at"}),
+	}).Create(translation).Error
+}
+
+// DeleteTranslation removes the translation of a config for one locale
+func (r *GuestPopupConfigRepository) DeleteTranslation(configID uint, locale string) error {
+	result := r.db.Where("config_id = ? AND locale = ?", configID, locale).Delete(&GuestPopupTranslation{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package guestpopup
+
+import (
+	"errors"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// VisitorState is everything the targeting rules need to know about the calling visitor
+type VisitorState struct {
+	Path      string
+	Returning bool
+	Views     int64
+	LastView  *time.Time
+}
+
+// IsScheduled reports whether the config is inside its start/end window
+func (cfg *GuestPopupConfig) IsScheduled(now time.Time) bool {
+	if cfg.StartsAt != nil && now.Before(*cfg.StartsAt) {
+		return false
+	}
+	if cfg.EndsAt != nil && !now.Before(*cfg.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// Matches evaluates the targeting rules of the config for a visitor
+func (cfg *GuestPopupConfig) Matches(state VisitorState, now time.Time) bool {
+	if !cfg.IsScheduled(now) {
+		return false
+	}
+	if cfg.ReturningVisitorsOnly && !state.Returning {
+		return false
+	}
+	if !matchesPath(cfg.TargetPaths, state.Path) {
+		return false
+	}
+	if cfg.MaxViewsPerVisitor > 0 && state.Views >= int64(cfg.MaxViewsPerVisitor) {
+		return false
+	}
+	if cfg.ViewCooldownHours > 0 && state.LastView != nil &&
+		now.Sub(*state.LastView) < time.Duration(cfg.ViewCooldownHours)*time.Hour {
+		return false
+	}
+	return true
+}
+
+// Validate checks the content, schedule and targeting fields of a config
+func (cfg *GuestPopupConfig) Validate() error {
+	if strings.TrimSpace(cfg.Title) == "" {
+		return errors.New("title is required")
+	}
+	if err := cfg.Benefits.Validate(); err != nil {
+		return err
+	}
+	locale, err := NormalizeLocale(cfg.DefaultLocale)
+	if err != nil {
+		return errors.New("default_locale must be a language tag such as en or en-gb")
+	}
+	cfg.DefaultLocale = locale
+	if cfg.StartsAt != nil && cfg.EndsAt != nil && !cfg.EndsAt.After(*cfg.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	if cfg.Weight < 1 {
+		return errors.New("weight must be at least 1")
+	}
+	if cfg.ShowAfterSeconds < 0 || cfg.MaxViewsPerVisitor < 0 || cfg.ViewCooldownHours < 0 {
+		return errors.New("targeting values must not be negative")
+	}
+	for _, p := range cfg.TargetPaths {
+		if !strings.HasPrefix(p, "/") {
+			return errors.New("target paths must start with /")
+		}
+	}
+	return nil
+}
+
+func matchesPath(patterns []string, path string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// pickVariant chooses one of the configs for a visitor in proportion to their weights.
+// The choice depends only on the visitor ID, so repeated calls give the same answer.
+func pickVariant(configs []GuestPopupConfig, visitorID string) *GuestPopupConfig {
+	var total uint64
+	for _, cfg := range configs {
+		total += uint64(cfg.Weight)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(visitorID))
+	point := h.Sum64() % total
+
+	for i := range configs {
+		weight := uint64(configs[i].Weight)
+		if point < weight {
+			return &configs[i]
+		}
+		point -= weight
+	}
+	return nil
+}
+
+// defaultVariant returns the config with the most weight, the oldest on a tie. It is
+// shown to requests without a visitor ID, which can't be pinned to a variant.
+func defaultVariant(configs []GuestPopupConfig) *GuestPopupConfig {
+	var best *GuestPopupConfig
+	for i := range configs {
+		if best == nil || configs[i].Weight > best.Weight {
+			best = &configs[i]
+		}
+	}
+	return best
+}
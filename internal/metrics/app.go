@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"anonchihaya.co.uk/internal/statistics"
+	"anonchihaya.co.uk/internal/tracking"
+	"anonchihaya.co.uk/internal/util"
+)
+
+// AddAppMetrics registers the site's business gauges: active tracking sessions,
+// registered fans and outgoing emails
+func (c *Collector) AddAppMetrics(trackingRepo *tracking.FanTrackingRepository, statsRepo *statistics.StatisticsRepository) {
+	c.AddFunc("tracking_active_sessions", "Tracking sessions that sent a heartbeat within the grace period.", TypeGauge,
+		func() (float64, error) {
+			count, err := trackingRepo.GetOnlineCount()
+			return float64(count), err
+		})
+	c.AddFunc("fans_registered", "Registered fans.", TypeGauge,
+		func() (float64, error) {
+			count, err := statsRepo.GetTotalFans()
+			return float64(count), err
+		})
+	c.AddFunc("emails_queued", "Emails currently being sent.", TypeGauge,
+		func() (float64, error) {
+			queued, _ := util.EmailStats()
+			return float64(queued), nil
+		})
+	c.AddFunc("emails_failed_total", "Emails that failed to send since the server started.", TypeCounter,
+		func() (float64, error) {
+			_, failed := util.EmailStats()
+			return float64(failed), nil
+		})
+}
@@ -0,0 +1,229 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Namespace prefixes every metric name
+const Namespace = "anoweb"
+
+// DefaultBuckets are the upper bounds, in seconds, of the request latency histogram
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metric types
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+)
+
+// Collector records HTTP request metrics and reads gauges on demand, and serves them
+// in the Prometheus text exposition format
+type Collector struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	requests map[requestKey]*requestStats
+
+	funcs []funcMetric
+}
+
+type requestKey struct {
+	method string
+	route  string
+	status string
+}
+
+type requestStats struct {
+	count   uint64
+	sum     float64
+	buckets []uint64 // Non-cumulative counts per bucket; the last is +Inf
+}
+
+type funcMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func() (float64, error)
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		buckets:  DefaultBuckets,
+		requests: make(map[requestKey]*requestStats),
+	}
+}
+
+// AddFunc registers a gauge or counter whose value is read on every scrape. Metrics
+// whose value can't be read are left out of that scrape.
+func (c *Collector) AddFunc(name, help, kind string, value func() (float64, error)) {
+	c.funcs = append(c.funcs, funcMetric{name: Namespace + "_" + name, help: help, kind: kind, value: value})
+}
+
+// AddDBStats registers the connection pool statistics of db
+func (c *Collector) AddDBStats(db *sql.DB) {
+	stat := func(read func(sql.DBStats) float64) func() (float64, error) {
+		return func() (float64, error) { return read(db.Stats()), nil }
+	}
+	c.AddFunc("db_max_open_connections", "Maximum number of open database connections.", TypeGauge,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	c.AddFunc("db_open_connections", "Established database connections, in use and idle.", TypeGauge,
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	c.AddFunc("db_in_use_connections", "Database connections currently in use.", TypeGauge,
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	c.AddFunc("db_idle_connections", "Idle database connections.", TypeGauge,
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	c.AddFunc("db_wait_count_total", "Connections waited for because the pool was exhausted.", TypeCounter,
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	c.AddFunc("db_wait_duration_seconds_total", "Time spent waiting for a connection.", TypeCounter,
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	c.AddFunc("db_max_idle_closed_total", "Connections closed because of the idle limit.", TypeCounter,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	c.AddFunc("db_max_lifetime_closed_total", "Connections closed because of their maximum lifetime.", TypeCounter,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
+
+// Middleware counts requests and times them per method, route pattern and status.
+// Requests that match no route are grouped under the route "unmatched".
+func (c *Collector) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		c.observe(requestKey{
+			method: ctx.Request.Method,
+			route:  route,
+			status: strconv.Itoa(ctx.Writer.Status()),
+		}, time.Since(start).Seconds())
+	}
+}
+
+func (c *Collector) observe(key requestKey, seconds float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats, ok := c.requests[key]
+	if !ok {
+		stats = &requestStats{buckets: make([]uint64, len(c.buckets)+1)}
+		c.requests[key] = stats
+	}
+	stats.count++
+	stats.sum += seconds
+	bucket := sort.SearchFloat64s(c.buckets, seconds) // First bound >= seconds
+	stats.buckets[bucket]++
+}
+
+// ServeHTTP writes every metric in the Prometheus text format
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := c.Write(w); err != nil {
+		log.Printf("Warning: Failed to write metrics: %v", err)
+	}
+}
+
+// Write writes every metric in the Prometheus text format
+func (c *Collector) Write(w io.Writer) error {
+	var b strings.Builder
+	c.writeRequests(&b)
+
+	for _, metric := range c.funcs {
+		value, err := metric.value()
+		if err != nil {
+			log.Printf("Warning: Failed to read metric %s: %v", metric.name, err)
+			continue
+		}
+		writeHeader(&b, metric.name, metric.help, metric.kind)
+		fmt.Fprintf(&b, "%s %s\n", metric.name, formatFloat(value))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (c *Collector) writeRequests(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]requestKey, 0, len(c.requests))
+	for key := range c.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	total := Namespace + "_http_requests_total"
+	writeHeader(b, total, "HTTP requests by method, route and status.", TypeCounter)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s{%s} %d\n", total, key.labels(), c.requests[key].count)
+	}
+
+	duration := Namespace + "_http_request_duration_seconds"
+	writeHeader(b, duration, "HTTP request latency by method, route and status.", "histogram")
+	for _, key := range keys {
+		stats := c.requests[key]
+		labels := key.labels()
+		var cumulative uint64
+		for i, bound := range c.buckets {
+			cumulative += stats.buckets[i]
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", duration, labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", duration, labels, stats.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", duration, labels, formatFloat(stats.sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", duration, labels, stats.count)
+	}
+}
+
+func (key requestKey) labels() string {
+	return fmt.Sprintf(`method="%s",route="%s",status="%s"`,
+		escapeLabel(key.method), escapeLabel(key.route), escapeLabel(key.status))
+}
+
+func writeHeader(b *strings.Builder, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// RequireToken only lets requests through to next that send token as a bearer token
+func RequireToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCollectorWritesHistograms(t *testing.T) {
+	c := NewCollector()
+	key := requestKey{method: "GET", route: `/a"b`, status: "200"}
+	c.observe(key, 0.003)
+	c.observe(key, 0.2)
+	c.observe(key, 30)
+
+	var b strings.Builder
+	if err := c.Write(&b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	out := b.String()
+
+	labels := `method="GET",route="/a\"b",status="200"`
+	for _, want := range []string{
+		"anoweb_http_requests_total{" + labels + "} 3",
+		"anoweb_http_request_duration_seconds_bucket{" + labels + `,le="0.005"} 1`,
+		"anoweb_http_request_duration_seconds_bucket{" + labels + `,le="0.1"} 1`,
+		"anoweb_http_request_duration_seconds_bucket{" + labels + `,le="0.25"} 2`,
+		"anoweb_http_request_duration_seconds_bucket{" + labels + `,le="10"} 2`,
+		"anoweb_http_request_duration_seconds_bucket{" + labels + `,le="+Inf"} 3`,
+		"anoweb_http_request_duration_seconds_sum{" + labels + "} 30.203",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestCollectorSkipsFailingFuncs(t *testing.T) {
+	c := NewCollector()
+	c.AddFunc("up", "Always one.", TypeGauge, func() (float64, error) { return 1, nil })
+	c.AddFunc("broken", "Never readable.", TypeGauge, func() (float64, error) { return 0, errors.New("boom") })
+
+	var b strings.Builder
+	if err := c.Write(&b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	out := b.String()
+	if !strings.Contains(out, "# TYPE anoweb_up gauge\nanoweb_up 1\n") {
+		t.Fatalf("expected the up gauge, got:\n%s", out)
+	}
+	if strings.Contains(out, "anoweb_broken") {
+		t.Fatalf("expected the failing gauge to be skipped, got:\n%s", out)
+	}
+}
+
+func TestMiddlewareGroupsByRoutePattern(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := NewCollector()
+	r := gin.New()
+	r.Use(c.Middleware())
+	r.GET("/posts/:id", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+
+	for _, path := range []string{"/posts/1", "/posts/2", "/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := c.requests[requestKey{method: "GET", route: "/posts/:id", status: "200"}]; got == nil || got.count != 2 {
+		t.Fatalf("expected 2 requests for /posts/:id, got %+v", got)
+	}
+	if got := c.requests[requestKey{method: "GET", route: "unmatched", status: "404"}]; got == nil || got.count != 1 {
+		t.Fatalf("expected 1 unmatched request, got %+v", got)
+	}
+}
@@ -10,8 +10,13 @@ import (
 type MysteryCodeHandler struct {
 	mysteryCodeRepo *MysteryCodeRepository
 	fanRepo         *auth.FanRepository
+	hooks           []RedeemHook
 }
 
+// RedeemHook is called after a fan has redeemed a mystery code.
+// Hooks run on the request goroutine, so they should be quick and must not write a response.
+type RedeemHook func(c *gin.Context, fan *auth.Fan)
+
 func NewMysteryCodeHandler(mysteryCodeRepo *MysteryCodeRepository, fanRepo *auth.FanRepository) *MysteryCodeHandler {
 	return &MysteryCodeHandler{
 		mysteryCodeRepo: mysteryCodeRepo,
@@ -19,6 +24,11 @@ func NewMysteryCodeHandler(mysteryCodeRepo *MysteryCodeRepository, fanRepo *auth
 	}
 }
 
+// AddHook registers a hook that is called after a code is redeemed
+func (h *MysteryCodeHandler) AddHook(hook RedeemHook) {
+	h.hooks = append(h.hooks, hook)
+}
+
 // VerifyCode godoc
 // @Summary Verify a mystery code
 // @Tags mystery-code
@@ -65,6 +75,9 @@ func (h *MysteryCodeHandler) VerifyCode(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant admin privileges"})
 		return
 	}
+	for _, hook := range h.hooks {
+		hook(c, f)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Admin privileges granted successfully"})
 }
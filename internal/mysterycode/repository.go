@@ -53,6 +53,17 @@ func (r *MysteryCodeRepository) IsCodeValid(code string) (bool, error) {
 	return count > 0, nil
 }
 
+// HasRedeemed reports whether a fan has used any mystery code
+func (r *MysteryCodeRepository) HasRedeemed(userID uint) (bool, error) {
+	var count int64
+	if err := r.db.Model(&MysteryCode{}).
+		Where("used_by = ?", userID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 // GetAllCodes returns all mystery codes (admin only)
 func (r *MysteryCodeRepository) GetAllCodes() ([]MysteryCode, error) {
 	var codes []MysteryCode
@@ -0,0 +1,84 @@
+package presence
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keepAliveInterval is how often an idle stream gets a comment so proxies keep it open
+const keepAliveInterval = 25 * time.Second
+
+type PresenceHandler struct {
+	hub *Hub
+}
+
+func NewPresenceHandler(hub *Hub) *PresenceHandler {
+	return &PresenceHandler{hub: hub}
+}
+
+// GetPresence godoc
+// @Summary Who is online now
+// @Description Fans who hide their presence are only included in the anonymous count.
+// @Tags presence
+// @Produce json
+// @Success 200 {object} presence.Snapshot
+// @Failure 500 {object} ErrorResponse
+// @Router /presence [get]
+func (h *PresenceHandler) GetPresence(c *gin.Context) {
+	snapshot, err := h.hub.Snapshot()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get presence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// Stream godoc
+// @Summary Live presence stream
+// @Description Server-Sent Events. Sends a "snapshot" event on connect, then "join" and "leave" events for visible fans and a periodic "count" event.
+// @Tags presence
+// @Produce text/event-stream
+// @Success 200 {string} string
+// @Failure 500 {object} ErrorResponse
+// @Router /presence/stream [get]
+func (h *PresenceHandler) Stream(c *gin.Context) {
+	events, snapshot, err := h.hub.Subscribe()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get presence"})
+		return
+	}
+	defer h.hub.Unsubscribe(events)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent(EventSnapshot, snapshot)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// Dropped for falling behind or shutting down; the client reconnects
+				return
+			}
+			c.SSEvent(event.Name, event.Data)
+			c.Writer.Flush()
+		case <-keepAlive.C:
+			if _, err := c.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package presence
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/tracking"
+)
+
+// Event names sent on the presence stream
+const (
+	EventSnapshot = "snapshot"
+	EventJoin     = "join"
+	EventLeave    = "leave"
+	EventCount    = "count"
+)
+
+const (
+	// DefaultRefreshInterval is how often active sessions are checked for joins and leaves
+	DefaultRefreshInterval = 5 * time.Second
+	// countEvery is how many refreshes pass between periodic count events
+	countEvery = 6
+	// subscriberBuffer is how many events a slow client may fall behind before it is dropped
+	subscriberBuffer = 32
+)
+
+// OnlineFan is a fan shown in the "online now" list
+type OnlineFan struct {
+	ID           uint   `json:"id"`
+	Username     string `json:"username"`
+	ProfilePhoto string `json:"profile_photo"`
+}
+
+// Count is the number of people online. Anonymous covers guests and fans who hide
+// their presence.
+type Count struct {
+	Online    int `json:"online"`
+	Anonymous int `json:"anonymous"`
+}
+
+// Snapshot is the full presence state
+type Snapshot struct {
+	Count
+	Fans []OnlineFan `json:"fans"`
+}
+
+// leftFan identifies a fan in a leave event
+type leftFan struct {
+	ID uint `json:"id"`
+}
+
+// Event is a single message on the presence stream
+type Event struct {
+	Name string
+	Data interface{}
+}
+
+// Hub tracks who is online from the active tracking sessions and broadcasts changes
+// to subscribers. It polls once for all clients and only while someone is listening.
+type Hub struct {
+	trackingRepo *tracking.FanTrackingRepository
+	fanRepo      *auth.FanRepository
+	interval     time.Duration
+
+	refreshMu sync.Mutex // Serializes refreshes so snapshots are applied in order
+
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	current     Snapshot
+	known       bool // Whether current holds a previous refresh to diff against
+	refreshes   int
+}
+
+func NewHub(trackingRepo *tracking.FanTrackingRepository, fanRepo *auth.FanRepository, interval time.Duration) *Hub {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	return &Hub{
+		trackingRepo: trackingRepo,
+		fanRepo:      fanRepo,
+		interval:     interval,
+		subscribers:  make(map[chan Event]struct{}),
+	}
+}
+
+// Start refreshes presence every interval until ctx is done
+func (h *Hub) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !h.hasSubscribers() {
+					continue
+				}
+				if err := h.Refresh(); err != nil {
+					log.Printf("Warning: Failed to refresh presence: %v", err)
+				}
+			case <-ctx.Done():
+				h.closeAll()
+				return
+			}
+		}
+	}()
+}
+
+// Subscribe registers a client and returns its event channel and the current snapshot.
+// Call Unsubscribe when the client goes away.
+func (h *Hub) Subscribe() (chan Event, Snapshot, error) {
+	if err := h.Refresh(); err != nil {
+		return nil, Snapshot{}, err
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[ch] = struct{}{}
+	return ch, h.current, nil
+}
+
+// Unsubscribe removes a client added by Subscribe
+func (h *Hub) Unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+	if len(h.subscribers) == 0 {
+		h.known = false
+	}
+}
+
+// Snapshot returns the current presence state, refreshing it first
+func (h *Hub) Snapshot() (Snapshot, error) {
+	if err := h.Refresh(); err != nil {
+		return Snapshot{}, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.current, nil
+}
+
+// Refresh reloads presence and broadcasts joins, leaves and, when it changed or
+// periodically, the count
+func (h *Hub) Refresh() error {
+	h.refreshMu.Lock()
+	defer h.refreshMu.Unlock()
+
+	next, err := h.load()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var events []Event
+	if h.known {
+		before := make(map[uint]bool, len(h.current.Fans))
+		for _, fan := range h.current.Fans {
+			before[fan.ID] = true
+		}
+		after := make(map[uint]bool, len(next.Fans))
+		for _, fan := range next.Fans {
+			after[fan.ID] = true
+			if !before[fan.ID] {
+				events = append(events, Event{Name: EventJoin, Data: fan})
+			}
+		}
+		for _, fan := range h.current.Fans {
+			if !after[fan.ID] {
+				events = append(events, Event{Name: EventLeave, Data: leftFan{ID: fan.ID}})
+			}
+		}
+	}
+
+	h.refreshes++
+	if !h.known || next.Count != h.current.Count || h.refreshes%countEvery == 0 {
+		events = append(events, Event{Name: EventCount, Data: next.Count})
+	}
+
+	h.current = next
+	h.known = true
+	for _, event := range events {
+		h.broadcast(event)
+	}
+	return nil
+}
+
+// load builds a snapshot from the active tracking sessions
+func (h *Hub) load() (Snapshot, error) {
+	sessions, err := h.trackingRepo.GetActiveSessions()
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	fanIDs := make(map[uint]bool)
+	guests := make(map[string]bool)
+	for _, session := range sessions {
+		if session.FanID != nil {
+			fanIDs[*session.FanID] = true
+		} else if session.VisitorID != "" {
+			guests[session.VisitorID] = true
+		} else {
+			guests[session.SessionID] = true
+		}
+	}
+
+	ids := make([]uint, 0, len(fanIDs))
+	for id := range fanIDs {
+		ids = append(ids, id)
+	}
+	fans, err := h.fanRepo.FindByIDs(ids)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	snapshot := Snapshot{Fans: []OnlineFan{}}
+	snapshot.Anonymous = len(guests)
+	for _, fan := range fans {
+		if fan.HidePresence {
+			snapshot.Anonymous++
+			continue
+		}
+		snapshot.Fans = append(snapshot.Fans, OnlineFan{
+			ID:           fan.ID,
+			Username:     fan.Username,
+			ProfilePhoto: fan.ProfilePhoto,
+		})
+	}
+	sort.Slice(snapshot.Fans, func(i, j int) bool {
+		return snapshot.Fans[i].Username < snapshot.Fans[j].Username
+	})
+	snapshot.Online = len(snapshot.Fans) + snapshot.Anonymous
+	return snapshot, nil
+}
+
+// broadcast sends an event to every subscriber, dropping clients that fell behind.
+// Callers must hold h.mu.
+func (h *Hub) broadcast(event Event) {
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// SubscriberCount returns the number of connected stream clients
+func (h *Hub) SubscriberCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+func (h *Hub) hasSubscribers() bool {
+	return h.SubscriberCount() > 0
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+	h.known = false
+}
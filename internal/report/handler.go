@@ -0,0 +1,110 @@
+package report
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+type ReportHandler struct {
+	repo *ReportRepository
+	job  *Job
+}
+
+func NewReportHandler(repo *ReportRepository, job *Job) *ReportHandler {
+	return &ReportHandler{repo: repo, job: job}
+}
+
+// ListReports godoc
+// @Summary Past analytics reports
+// @Description Stored reports, most recent first, without their HTML.
+// @Tags reports
+// @Produce json
+// @Param limit query int false "Max rows (1-100)" default(20)
+// @Success 200 {array} report.Report
+// @Failure 500 {object} ErrorResponse
+// @Router /reports [get]
+func (h *ReportHandler) ListReports(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+
+	reports, err := h.repo.List(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list reports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reports)
+}
+
+// GetReport godoc
+// @Summary One analytics report
+// @Description The stored report with its HTML. With format=html the HTML is served as the page.
+// @Tags reports
+// @Produce json
+// @Produce html
+// @Param id path int true "Report ID"
+// @Param format query string false "json or html" default(json)
+// @Success 200 {object} report.Report
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /reports/{id} [get]
+func (h *ReportHandler) GetReport(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	report, err := h.repo.FindByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get report"})
+		return
+	}
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+		return
+	}
+
+	if c.Query("format") == "html" {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(report.HTML))
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// RunReport godoc
+// @Summary Make an analytics report now
+// @Description Reports on the seven days up to now compared with the seven days before, stores it and emails it to the owner unless email is false.
+// @Tags reports
+// @Produce json
+// @Param email query bool false "Email the report" default(true)
+// @Success 201 {object} report.Report
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /reports/run [post]
+func (h *ReportHandler) RunReport(c *gin.Context) {
+	email, err := strconv.ParseBool(c.DefaultQuery("email", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email"})
+		return
+	}
+
+	report, err := h.job.RunNow(email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to make report"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, report)
+}
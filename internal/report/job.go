@@ -0,0 +1,186 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"anonchihaya.co.uk/internal/statistics"
+	"anonchihaya.co.uk/internal/tracking"
+	"anonchihaya.co.uk/internal/util"
+)
+
+// DefaultCheckInterval is how often the job checks whether last week's report is due
+const DefaultCheckInterval = time.Hour
+
+// Job makes the weekly analytics report once a week has ended and emails it to the
+// site owner. Weeks start on Monday at midnight in the server's time zone.
+type Job struct {
+	repo         *ReportRepository
+	statsRepo    *statistics.StatisticsRepository
+	trackingRepo *tracking.FanTrackingRepository
+	recipient    string
+	interval     time.Duration
+	now          func() time.Time
+	send         func(to, subject, html string) error
+
+	runMu sync.Mutex // Serializes runs
+}
+
+// NewJob creates a report job. Reports are still made and stored when recipient is
+// empty, they just aren't emailed.
+func NewJob(repo *ReportRepository, statsRepo *statistics.StatisticsRepository, trackingRepo *tracking.FanTrackingRepository, recipient string, interval time.Duration) *Job {
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+	return &Job{
+		repo:         repo,
+		statsRepo:    statsRepo,
+		trackingRepo: trackingRepo,
+		recipient:    recipient,
+		interval:     interval,
+		now:          time.Now,
+		send:         util.SendHTMLEmail,
+	}
+}
+
+// Start makes last week's report if it is due, then checks again every interval until
+// ctx is done. Checking rather than sleeping until Monday catches up after downtime.
+func (j *Job) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			if report, err := j.RunScheduled(); err != nil {
+				log.Printf("Warning: Weekly report failed: %v", err)
+			} else if report != nil {
+				log.Printf("Made the weekly report for %s", report.PeriodStart.Format(time.DateOnly))
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// RunScheduled makes the report for the last complete week unless it already exists,
+// and returns nil then
+func (j *Job) RunScheduled() (*Report, error) {
+	thisWeek := weekStart(j.now())
+	lastWeek := thisWeek.AddDate(0, 0, -7)
+
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+
+	exists, err := j.repo.HasScheduled(lastWeek)
+	if err != nil || exists {
+		return nil, err
+	}
+	return j.run(lastWeek, thisWeek, TriggerScheduled, true)
+}
+
+// RunNow makes a report for the seven days up to now, emailing it when email is set
+func (j *Job) RunNow(email bool) (*Report, error) {
+	to := j.now()
+	from := to.AddDate(0, 0, -7)
+
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+	return j.run(from, to, TriggerManual, email)
+}
+
+// run builds, stores and emails the report for the period from to to. A failed email
+// is recorded on the report rather than returned.
+func (j *Job) run(from, to time.Time, trigger string, email bool) (*Report, error) {
+	summary, err := j.Summarize(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		PeriodStart: from,
+		PeriodEnd:   to,
+		Trigger:     trigger,
+		Summary:     *summary,
+	}
+
+	var body bytes.Buffer
+	if err := reportTemplate.Execute(&body, report); err != nil {
+		return nil, err
+	}
+	report.HTML = body.String()
+
+	if email && j.recipient != "" {
+		report.Recipient = j.recipient
+		subject := fmt.Sprintf("Weekly report: %s to %s", from.Format("2 Jan"), to.Add(-time.Nanosecond).Format("2 Jan 2006"))
+		if err := j.send(j.recipient, subject, report.HTML); err != nil {
+			log.Printf("Warning: Failed to email the weekly report: %v", err)
+			report.EmailError = err.Error()
+		} else {
+			sentAt := j.now()
+			report.EmailedAt = &sentAt
+		}
+	}
+
+	if err := j.repo.Create(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Summarize collects the key numbers for the period from to to and the period of the
+// same length before it
+func (j *Job) Summarize(from, to time.Time) (*Summary, error) {
+	current, err := j.numbers(from, to)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := j.numbers(from.Add(-to.Sub(from)), from)
+	if err != nil {
+		return nil, err
+	}
+	topContent, err := j.trackingRepo.GetTopContent(from, to, "", TopContentLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Summary{
+		Current:  current,
+		Previous: previous,
+		Change: Change{
+			NewFans:  percentChange(float64(current.NewFans), float64(previous.NewFans)),
+			Visitors: percentChange(float64(current.Visitors), float64(previous.Visitors)),
+			Hours:    percentChange(current.Hours, previous.Hours),
+		},
+		TopContent: topContent,
+	}, nil
+}
+
+func (j *Job) numbers(from, to time.Time) (Numbers, error) {
+	var numbers Numbers
+	var err error
+	if numbers.NewFans, err = j.statsRepo.GetNewFansBetween(from, to); err != nil {
+		return numbers, err
+	}
+	if numbers.Visitors, err = j.statsRepo.GetVisitorsBetween(from, to); err != nil {
+		return numbers, err
+	}
+	if numbers.Hours, err = j.trackingRepo.GetHoursBetween(from, to); err != nil {
+		return numbers, err
+	}
+	return numbers, nil
+}
+
+// weekStart returns midnight on the Monday of t's week in the server's time zone
+func weekStart(t time.Time) time.Time {
+	t = t.In(time.Local)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
@@ -0,0 +1,149 @@
+package report
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/post"
+	"anonchihaya.co.uk/internal/statistics"
+	"anonchihaya.co.uk/internal/tracking"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupJob(t *testing.T, now time.Time) (*Job, *gorm.DB) {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:report_%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&auth.Fan{}, &tracking.Rollup{}, &tracking.ContentView{}, &post.Post{}, &Report{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	job := NewJob(NewReportRepository(db), statistics.NewStatisticsRepository(db), tracking.NewFanTrackingRepository(db), "owner@example.com", 0)
+	job.now = func() time.Time { return now }
+	return job, db
+}
+
+// seedWeek records new fans, guest visits and fan hours started at
+func seedWeek(t *testing.T, db *gorm.DB, at time.Time, fans, guests int, seconds int64) {
+	t.Helper()
+
+	for i := 0; i < fans; i++ {
+		name := fmt.Sprintf("fan-%d-%d", at.Unix(), i)
+		fan := auth.Fan{Username: name, Email: name + "@example.com", CreatedAt: at}
+		if err := db.Create(&fan).Error; err != nil {
+			t.Fatalf("failed to create fan: %v", err)
+		}
+		if err := db.Create(&tracking.Rollup{
+			Slot: tracking.RollupSlot(at), Owner: fmt.Sprintf("fan:%d", fan.ID),
+			FanID: &fan.ID, Sessions: 1, Starts: 1, Seconds: seconds, LastStartAt: at,
+		}).Error; err != nil {
+			t.Fatalf("failed to seed fan visit: %v", err)
+		}
+	}
+	for i := 0; i < guests; i++ {
+		key := fmt.Sprintf("guest-%d-%d", at.Unix(), i)
+		if err := db.Create(&tracking.Rollup{
+			Slot: tracking.RollupSlot(at), Owner: "guest:" + key,
+			GuestKey: key, Sessions: 1, Starts: 1, LastStartAt: at,
+		}).Error; err != nil {
+			t.Fatalf("failed to seed guest visit: %v", err)
+		}
+	}
+}
+
+func TestScheduledReportCoversLastWeek(t *testing.T) {
+	// Wednesday; last week ran from Monday 3 to Monday 10 March
+	now := time.Date(2025, time.March, 12, 9, 0, 0, 0, time.Local)
+	job, db := setupJob(t, now)
+
+	seedWeek(t, db, time.Date(2025, time.February, 25, 12, 0, 0, 0, time.Local), 1, 2, 3600)
+	seedWeek(t, db, time.Date(2025, time.March, 5, 12, 0, 0, 0, time.Local), 2, 4, 5400)
+	seedWeek(t, db, time.Date(2025, time.March, 11, 12, 0, 0, 0, time.Local), 5, 5, 3600) // This week
+
+	if err := db.Create(&post.Post{ID: 7, Name: "Hello <world>"}).Error; err != nil {
+		t.Fatalf("failed to seed post: %v", err)
+	}
+	if err := db.Create(&tracking.ContentView{
+		ContentType: tracking.ContentPost, ContentID: 7, SessionID: "reader", ReadSeconds: 120,
+		CreatedAt: time.Date(2025, time.March, 6, 12, 0, 0, 0, time.Local),
+	}).Error; err != nil {
+		t.Fatalf("failed to seed view: %v", err)
+	}
+
+	var sentTo, sentHTML string
+	job.send = func(to, subject, html string) error {
+		sentTo, sentHTML = to, html
+		return nil
+	}
+
+	report, err := job.RunScheduled()
+	if err != nil || report == nil {
+		t.Fatalf("RunScheduled: report=%v err=%v", report, err)
+	}
+	if !report.PeriodStart.Equal(time.Date(2025, time.March, 3, 0, 0, 0, 0, time.Local)) ||
+		!report.PeriodEnd.Equal(time.Date(2025, time.March, 10, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("unexpected period %s to %s", report.PeriodStart, report.PeriodEnd)
+	}
+
+	summary := report.Summary
+	if summary.Current != (Numbers{NewFans: 2, Visitors: 6, Hours: 3}) {
+		t.Fatalf("unexpected current numbers: %+v", summary.Current)
+	}
+	if summary.Previous != (Numbers{NewFans: 1, Visitors: 3, Hours: 1}) {
+		t.Fatalf("unexpected previous numbers: %+v", summary.Previous)
+	}
+	if summary.Change.NewFans == nil || *summary.Change.NewFans != 100 || *summary.Change.Hours != 200 {
+		t.Fatalf("unexpected change: %+v", summary.Change)
+	}
+	if len(summary.TopContent) != 1 || summary.TopContent[0].Title != "Hello <world>" {
+		t.Fatalf("unexpected top content: %+v", summary.TopContent)
+	}
+
+	if sentTo != "owner@example.com" || report.EmailedAt == nil {
+		t.Fatalf("expected the report to be emailed, sent to %q", sentTo)
+	}
+	if !strings.Contains(sentHTML, "Hello &lt;world&gt;") || !strings.Contains(sentHTML, "&#43;100%") {
+		t.Fatalf("unexpected HTML:\n%s", sentHTML)
+	}
+
+	// Only one scheduled report per week
+	again, err := job.RunScheduled()
+	if err != nil || again != nil {
+		t.Fatalf("expected no second report, got %v (err %v)", again, err)
+	}
+
+	stored, err := job.repo.FindByID(report.ID)
+	if err != nil || stored == nil || stored.HTML != sentHTML || stored.Summary.Current != summary.Current {
+		t.Fatalf("expected the report to be stored, got %+v (err %v)", stored, err)
+	}
+}
+
+func TestFailedEmailIsRecorded(t *testing.T) {
+	job, _ := setupJob(t, time.Now())
+	job.send = func(to, subject, html string) error { return errors.New("mailbox full") }
+
+	report, err := job.RunNow(true)
+	if err != nil {
+		t.Fatalf("RunNow failed: %v", err)
+	}
+	if report.Trigger != TriggerManual || report.EmailedAt != nil || report.EmailError != "mailbox full" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if report.Summary.Change.Visitors != nil {
+		t.Fatalf("expected no change without a previous period, got %v", *report.Summary.Change.Visitors)
+	}
+
+	reports, err := job.repo.List(10)
+	if err != nil || len(reports) != 1 || reports[0].HTML != "" {
+		t.Fatalf("expected one listed report without HTML, got %+v (err %v)", reports, err)
+	}
+}
@@ -0,0 +1,66 @@
+package report
+
+import (
+	"time"
+
+	"anonchihaya.co.uk/internal/tracking"
+)
+
+// Report triggers
+const (
+	TriggerScheduled = "scheduled"
+	TriggerManual    = "manual"
+)
+
+// TopContentLimit is how many of the most viewed items a report lists
+const TopContentLimit = 5
+
+// Report is a stored copy of an analytics report
+type Report struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	PeriodStart time.Time  `gorm:"index" json:"period_start"`
+	PeriodEnd   time.Time  `json:"period_end"`
+	Trigger     string     `gorm:"column:triggered_by;type:varchar(16)" json:"trigger"` // scheduled or manual
+	Summary     Summary    `gorm:"type:text;serializer:json" json:"summary"`
+	HTML        string     `gorm:"type:mediumtext" json:"html,omitempty"` // Left out of listings
+	Recipient   string     `gorm:"type:varchar(255)" json:"recipient"`    // Empty when no owner address is configured
+	EmailedAt   *time.Time `json:"emailed_at"`
+	EmailError  string     `gorm:"type:text" json:"email_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TableName sets the table name for analytics reports
+func (Report) TableName() string {
+	return "analytics_reports"
+}
+
+// Summary holds the numbers in a report
+type Summary struct {
+	Current    Numbers                 `json:"current"`
+	Previous   Numbers                 `json:"previous"` // The period of the same length before
+	Change     Change                  `json:"change"`
+	TopContent []tracking.ContentStats `json:"top_content"`
+}
+
+// Numbers are the key figures of one period
+type Numbers struct {
+	NewFans  int64   `json:"new_fans"`
+	Visitors int64   `json:"visitors"` // Distinct fans and guest visitors
+	Hours    float64 `json:"hours"`    // Hours fans spent in completed sessions
+}
+
+// Change is the percentage change of each figure from the previous period, nil when the
+// previous period was zero
+type Change struct {
+	NewFans  *float64 `json:"new_fans"`
+	Visitors *float64 `json:"visitors"`
+	Hours    *float64 `json:"hours"`
+}
+
+func percentChange(current, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := (current - previous) / previous * 100
+	return &change
+}
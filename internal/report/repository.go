@@ -0,0 +1,54 @@
+package report
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ReportRepository struct {
+	db *gorm.DB
+}
+
+func NewReportRepository(db *gorm.DB) *ReportRepository {
+	return &ReportRepository{db: db}
+}
+
+// Create stores a report
+func (r *ReportRepository) Create(report *Report) error {
+	return r.db.Create(report).Error
+}
+
+// List returns the most recent reports first, without their HTML
+func (r *ReportRepository) List(limit int) ([]Report, error) {
+	var reports []Report
+	err := r.db.Omit("html").
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&reports).Error
+	return reports, err
+}
+
+// FindByID returns a report with its HTML, or nil if it doesn't exist
+func (r *ReportRepository) FindByID(id uint) (*Report, error) {
+	var report Report
+	err := r.db.First(&report, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// HasScheduled reports whether the scheduled report for the week starting at
+// periodStart has been made
+func (r *ReportRepository) HasScheduled(periodStart time.Time) (bool, error) {
+	var count int64
+	err := r.db.Model(&Report{}).
+		Where("period_start = ? AND triggered_by = ?", periodStart, TriggerScheduled).
+		Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,87 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// reportTemplate renders a report as an email. Styles are inline because most mail
+// clients ignore style sheets.
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("Mon 2 Jan 2006") },
+	"lastDay": func(t time.Time) string {
+		return t.Add(-time.Nanosecond).Format("Mon 2 Jan 2006")
+	},
+	"hours": func(h float64) string { return fmt.Sprintf("%.1f", h) },
+	"change": func(change *float64) string {
+		if change == nil {
+			return "new"
+		}
+		return fmt.Sprintf("%+.0f%%", *change)
+	},
+	"changeColor": func(change *float64) string {
+		switch {
+		case change == nil || *change == 0:
+			return "#6b7280"
+		case *change > 0:
+			return "#15803d"
+		default:
+			return "#b91c1c"
+		}
+	},
+	"minutes": func(seconds float64) string { return fmt.Sprintf("%.1f min", seconds/60) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<body style="margin:0;padding:24px;background:#f3f4f6;font-family:Arial,Helvetica,sans-serif;color:#111827">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" style="max-width:600px;margin:0 auto;background:#ffffff;border-radius:8px">
+<tr><td style="padding:24px">
+<h1 style="margin:0 0 4px;font-size:22px">Analytics report</h1>
+<p style="margin:0 0 24px;color:#6b7280">{{date .PeriodStart}} to {{lastDay .PeriodEnd}}</p>
+
+<table role="presentation" width="100%" cellpadding="8" cellspacing="0" style="border-collapse:collapse">
+<tr style="background:#f9fafb;text-align:left">
+<th>Metric</th><th style="text-align:right">This period</th><th style="text-align:right">Previous</th><th style="text-align:right">Change</th>
+</tr>
+<tr style="border-top:1px solid #e5e7eb">
+<td>New fans</td>
+<td style="text-align:right">{{.Summary.Current.NewFans}}</td>
+<td style="text-align:right">{{.Summary.Previous.NewFans}}</td>
+<td style="text-align:right;color:{{changeColor .Summary.Change.NewFans}}">{{change .Summary.Change.NewFans}}</td>
+</tr>
+<tr style="border-top:1px solid #e5e7eb">
+<td>Visitors</td>
+<td style="text-align:right">{{.Summary.Current.Visitors}}</td>
+<td style="text-align:right">{{.Summary.Previous.Visitors}}</td>
+<td style="text-align:right;color:{{changeColor .Summary.Change.Visitors}}">{{change .Summary.Change.Visitors}}</td>
+</tr>
+<tr style="border-top:1px solid #e5e7eb">
+<td>Fan hours</td>
+<td style="text-align:right">{{hours .Summary.Current.Hours}}</td>
+<td style="text-align:right">{{hours .Summary.Previous.Hours}}</td>
+<td style="text-align:right;color:{{changeColor .Summary.Change.Hours}}">{{change .Summary.Change.Hours}}</td>
+</tr>
+</table>
+
+<h2 style="margin:24px 0 8px;font-size:18px">Top content</h2>
+{{if .Summary.TopContent}}
+<table role="presentation" width="100%" cellpadding="8" cellspacing="0" style="border-collapse:collapse">
+<tr style="background:#f9fafb;text-align:left">
+<th>Item</th><th style="text-align:right">Views</th><th style="text-align:right">Avg. read time</th>
+</tr>
+{{range .Summary.TopContent}}
+<tr style="border-top:1px solid #e5e7eb">
+<td>{{if .Title}}{{.Title}}{{else}}Deleted {{.ContentType}}{{end}} <span style="color:#6b7280">({{.ContentType}})</span></td>
+<td style="text-align:right">{{.Views}}</td>
+<td style="text-align:right">{{minutes .AvgReadSeconds}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p style="color:#6b7280">No posts or projects were viewed.</p>
+{{end}}
+</td></tr>
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"anonchihaya.co.uk/internal/achievement"
+	"anonchihaya.co.uk/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func registerAchievementRoutes(
+	r *gin.Engine,
+	achievementRepo *achievement.AchievementRepository,
+	evaluator *achievement.Evaluator,
+	fanRepo *auth.FanRepository,
+	sessionRepo *auth.SessionRepository,
+) {
+	handler := achievement.NewAchievementHandler(achievementRepo, evaluator, fanRepo)
+
+	achievements := r.Group(prefix + "/achievements")
+	{
+		// Public endpoints
+		achievements.GET("", handler.GetBadges)
+		achievements.GET("/fans/:id", handler.GetFanBadges)
+
+		// Authenticated endpoints
+		achievements.GET("/me", auth.AuthMiddleware(sessionRepo), handler.GetMyBadges)
+	}
+}
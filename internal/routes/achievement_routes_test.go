@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"anonchihaya.co.uk/internal/achievement"
+	"anonchihaya.co.uk/internal/mysterycode"
+	"anonchihaya.co.uk/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAchievements(t *testing.T) {
+	r := setupRouter(t)
+	cookies := createSessionCookies(t, "badge-fan", false)
+
+	w := performRequest(r, http.MethodGet, "/api/achievements/me", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	badges := func(path string, cookies ...*http.Cookie) map[string]achievement.BadgeStatus {
+		t.Helper()
+		w := performRequestWithCookies(r, http.MethodGet, path, nil, cookies...)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var statuses []achievement.BadgeStatus
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &statuses))
+		byCode := make(map[string]achievement.BadgeStatus, len(statuses))
+		for _, status := range statuses {
+			byCode[status.Code] = status
+		}
+		return byCode
+	}
+
+	// Every badge is listed, earned ones with the time they were awarded
+	mine := badges("/api/achievements/me", cookies...)
+	assert.Len(t, mine, len(achievement.Badges))
+	assert.NotNil(t, mine[achievement.BadgeVerifiedEmail].AwardedAt)
+	assert.Nil(t, mine[achievement.BadgeMysteryCode].AwardedAt)
+
+	// Redeeming a mystery code awards its badge straight away
+	_, err := mysterycode.NewMysteryCodeRepository(store.DB).CreateCode("badge-code")
+	assert.NoError(t, err)
+	w = performRequestWithCookies(r, http.MethodPost, "/api/mystery-code/verify", []byte(`{"code":"badge-code"}`), cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/auth/me", nil, cookies...)
+	var me struct {
+		ID uint `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+
+	// Profiles only show the badges a fan has earned
+	public := badges(fmt.Sprintf("/api/achievements/fans/%d", me.ID))
+	assert.Len(t, public, 2)
+	assert.Contains(t, public, achievement.BadgeVerifiedEmail)
+	assert.Contains(t, public, achievement.BadgeMysteryCode)
+
+	all := badges("/api/achievements")
+	assert.Len(t, all, len(achievement.Badges))
+	assert.GreaterOrEqual(t, all[achievement.BadgeMysteryCode].Holders, int64(1))
+	assert.NotEmpty(t, all[achievement.BadgeMysteryCode].Rarity)
+
+	w = performRequest(r, http.MethodGet, "/api/achievements/fans/999999", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = performRequest(r, http.MethodGet, "/api/achievements/fans/abc", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
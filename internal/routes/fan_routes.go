@@ -2,14 +2,21 @@ package routes
 
 import (
 	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/visitor"
 	"github.com/gin-gonic/gin"
 )
 
-func registerFanRoutes(r *gin.Engine, domain, imgPath, imgURLPrefix string, fanRepo *auth.FanRepository, sessionRepo *auth.SessionRepository) {
+func registerFanRoutes(r *gin.Engine, domain, imgPath, imgURLPrefix string, fanRepo *auth.FanRepository, sessionRepo *auth.SessionRepository, hooks ...auth.FanHook) {
 	fanHandler := auth.NewFanHandler(fanRepo, sessionRepo, domain)
 	oauthHandler := auth.NewOAuthHandler(fanRepo, sessionRepo, domain)
+	for _, hook := range hooks {
+		fanHandler.AddHook(hook)
+		oauthHandler.AddHook(hook)
+	}
 
+	// Fan hooks link the visitor who signs up or logs in to their guest activity
 	authGroup := r.Group(prefix + "/auth")
+	authGroup.Use(visitor.Identify())
 	{
 		authGroup.POST("/register", fanHandler.Register)
 		authGroup.POST("/login", fanHandler.Login)
@@ -4,21 +4,26 @@ import (
 	"anonchihaya.co.uk/internal/auth"
 	"anonchihaya.co.uk/internal/guestpopup"
 	"anonchihaya.co.uk/internal/middlewares"
+	"anonchihaya.co.uk/internal/visitor"
 	"github.com/gin-gonic/gin"
 )
 
 func registerGuestPopupRoutes(
 	r *gin.Engine,
 	key string,
+	domain string,
 	popupRepo *guestpopup.GuestPopupConfigRepository,
+	visitorRepo *visitor.VisitorRepository,
 	sessionRepo *auth.SessionRepository,
 ) {
 	handler := guestpopup.NewGuestPopupHandler(popupRepo)
 
 	// Public endpoint (no key required for GET)
 	popup := r.Group(prefix + "/guest-popup")
+	popup.Use(visitor.Middleware(visitorRepo, domain))
 	{
 		popup.GET("/active", handler.GetActiveConfig)
+		popup.POST("/event", handler.RecordEvent)
 	}
 
 	// Admin endpoints (require key, auth, and admin)
@@ -29,6 +34,12 @@ func registerGuestPopupRoutes(
 	{
 		adminPopup.POST("/create", handler.CreateConfig)
 		adminPopup.PUT("/:id", handler.UpdateConfig)
+		adminPopup.PUT("/:id/activate", handler.ActivateConfig)
+		adminPopup.PUT("/:id/deactivate", handler.DeactivateConfig)
 		adminPopup.GET("/list", handler.GetAllConfigs)
+		adminPopup.GET("/report", handler.GetReport)
+		adminPopup.GET("/:id/translations", handler.GetTranslations)
+		adminPopup.PUT("/:id/translations/:locale", handler.SaveTranslation)
+		adminPopup.DELETE("/:id/translations/:locale", handler.DeleteTranslation)
 	}
 }
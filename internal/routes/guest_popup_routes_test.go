@@ -0,0 +1,262 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createPopupConfig(t *testing.T, r http.Handler, cookies []*http.Cookie, body map[string]interface{}) uint {
+	t.Helper()
+
+	payload, _ := json.Marshal(body)
+	w := performRequestWithCookies(r, http.MethodPost, "/api/guest-popup/create", payload, cookies...)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected create to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var created struct {
+		ID uint `json:"id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode created config: %v", err)
+	}
+	return created.ID
+}
+
+// deactivateAllPopupConfigs clears configs left active by other tests sharing the database
+func deactivateAllPopupConfigs(t *testing.T, r http.Handler, cookies []*http.Cookie) {
+	t.Helper()
+
+	w := performRequestWithCookies(r, http.MethodGet, "/api/guest-popup/list", nil, cookies...)
+	var configs []struct {
+		ID uint `json:"id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &configs); err != nil {
+		t.Fatalf("failed to list configs: %v", err)
+	}
+	for _, cfg := range configs {
+		w = performRequestWithCookies(r, http.MethodPut, fmt.Sprintf("/api/guest-popup/%d/deactivate", cfg.ID), nil, cookies...)
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to deactivate config %d: %d", cfg.ID, w.Code)
+		}
+	}
+}
+
+func TestGuestPopupUpdateAndActivate(t *testing.T) {
+	r := setupRouter(t)
+	admin := createSessionCookies(t, "popup-admin", true)
+
+	firstID := createPopupConfig(t, r, admin, map[string]interface{}{"title": "First", "benefits": []string{"a"}})
+	secondID := createPopupConfig(t, r, admin, map[string]interface{}{"title": "Second", "benefits": []string{"b"}})
+
+	update, _ := json.Marshal(map[string]interface{}{"title": "First updated", "benefits": []string{"c"}})
+	w := performRequestWithCookies(r, http.MethodPut, fmt.Sprintf("/api/guest-popup/%d", firstID), update, admin...)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequestWithCookies(r, http.MethodPut, "/api/guest-popup/999999", update, admin...)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	deactivateAllPopupConfigs(t, r, admin)
+	w = performRequestWithCookies(r, http.MethodPut, fmt.Sprintf("/api/guest-popup/%d/activate", firstID), nil, admin...)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(r, http.MethodGet, "/api/guest-popup/active", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var active struct {
+		ID    uint   `json:"id"`
+		Title string `json:"title"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &active))
+	assert.Equal(t, firstID, active.ID)
+	assert.Equal(t, "First updated", active.Title)
+	assert.NotEqual(t, secondID, active.ID)
+}
+
+func TestGuestPopupTargetingRules(t *testing.T) {
+	r := setupRouter(t)
+	admin := createSessionCookies(t, "popup-targeting-admin", true)
+
+	id := createPopupConfig(t, r, admin, map[string]interface{}{
+		"title":                 "Projects only",
+		"benefits":              []string{"a"},
+		"target_paths":          []string{"/projects/*"},
+		"max_views_per_visitor": 1,
+	})
+	deactivateAllPopupConfigs(t, r, admin)
+	w := performRequestWithCookies(r, http.MethodPut, fmt.Sprintf("/api/guest-popup/%d/activate", id), nil, admin...)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(r, http.MethodGet, "/api/guest-popup/active?path=/about", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	visitorCookie := findCookie(w, "visitor_id")
+	if visitorCookie == nil {
+		t.Fatalf("expected visitor cookie to be issued")
+	}
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/guest-popup/active?path=/projects/1", nil, visitorCookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	impression, _ := json.Marshal(map[string]interface{}{"config_id": id, "event": "impression"})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/guest-popup/event", impression, visitorCookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The visitor has used up their single view
+	w = performRequestWithCookies(r, http.MethodGet, "/api/guest-popup/active?path=/projects/1", nil, visitorCookie)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Without a visitor (DNT) the default variant is served without view limits, but
+	// still only on its paths
+	doNotTrack := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/guest-popup/active?path="+path, nil)
+		req.Header.Set("DNT", "1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w = doNotTrack("/projects/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+	assert.Equal(t, http.StatusNotFound, doNotTrack("/about").Code)
+
+	invalid, _ := json.Marshal(map[string]interface{}{
+		"title":     "Bad schedule",
+		"benefits":  []string{"a"},
+		"starts_at": "2026-02-01T00:00:00Z",
+		"ends_at":   "2026-01-01T00:00:00Z",
+	})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/guest-popup/create", invalid, admin...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGuestPopupVariantsAndReport(t *testing.T) {
+	r := setupRouter(t)
+	admin := createSessionCookies(t, "popup-ab-admin", true)
+
+	deactivateAllPopupConfigs(t, r, admin)
+
+	controlID := createPopupConfig(t, r, admin, map[string]interface{}{"title": "Control", "benefits": []string{"a"}, "weight": 50})
+	variantID := createPopupConfig(t, r, admin, map[string]interface{}{"title": "Variant", "benefits": []string{"b"}, "weight": 50})
+
+	w := performRequest(r, http.MethodGet, "/api/guest-popup/active", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	visitorCookie := findCookie(w, "visitor_id")
+	if visitorCookie == nil {
+		t.Fatalf("expected visitor cookie to be issued")
+	}
+
+	var first struct {
+		ID uint `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	assert.Contains(t, []uint{controlID, variantID}, first.ID)
+
+	// The same visitor keeps seeing the same variant
+	for i := 0; i < 3; i++ {
+		w = performRequestWithCookies(r, http.MethodGet, "/api/guest-popup/active", nil, visitorCookie)
+		var again struct {
+			ID uint `json:"id"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &again))
+		assert.Equal(t, first.ID, again.ID)
+	}
+
+	impression, _ := json.Marshal(map[string]interface{}{"config_id": first.ID, "event": "impression"})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/guest-popup/event", impression, visitorCookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	invalid, _ := json.Marshal(map[string]interface{}{"config_id": first.ID, "event": "register"})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/guest-popup/event", invalid, visitorCookie)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	register, _ := json.Marshal(map[string]string{
+		"username": "popup-convert",
+		"email":    "popup-convert@example.com",
+		"password": "password123",
+	})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/auth/register", register, visitorCookie)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/guest-popup/report", nil, admin...)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var report []struct {
+		ConfigID       uint    `json:"config_id"`
+		Impressions    int64   `json:"impressions"`
+		Registrations  int64   `json:"registrations"`
+		ConversionRate float64 `json:"conversion_rate"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+
+	found := false
+	for _, variant := range report {
+		if variant.ConfigID == first.ID {
+			found = true
+			assert.Equal(t, int64(1), variant.Impressions)
+			assert.Equal(t, int64(1), variant.Registrations)
+			assert.Equal(t, 1.0, variant.ConversionRate)
+		}
+	}
+	assert.True(t, found, "expected the seen variant in the report")
+}
+
+func TestGuestPopupLocalizedContent(t *testing.T) {
+	r := setupRouter(t)
+	admin := createSessionCookies(t, "popup-locale-admin", true)
+
+	deactivateAllPopupConfigs(t, r, admin)
+	id := createPopupConfig(t, r, admin, map[string]interface{}{
+		"title": "Join us",
+		"benefits": []map[string]string{
+			{"icon": "📈", "text": "Track your hours"},
+		},
+	})
+
+	invalid, _ := json.Marshal(map[string]interface{}{"title": "Empty", "benefits": []map[string]string{{"icon": "x"}}})
+	w := performRequestWithCookies(r, http.MethodPost, "/api/guest-popup/create", invalid, admin...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	translation, _ := json.Marshal(map[string]interface{}{
+		"title":    "Rejoignez-nous",
+		"benefits": []map[string]string{{"icon": "📈", "text": "Suivez vos heures"}},
+	})
+	w = performRequestWithCookies(r, http.MethodPut, fmt.Sprintf("/api/guest-popup/%d/translations/fr", id), translation, admin...)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	type popup struct {
+		Title    string `json:"title"`
+		Locale   string `json:"locale"`
+		Benefits []struct {
+			Icon string `json:"icon"`
+			Text string `json:"text"`
+		} `json:"benefits"`
+	}
+
+	get := func(acceptLanguage string) popup {
+		req := httptest.NewRequest(http.MethodGet, "/api/guest-popup/active", nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var p popup
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+		return p
+	}
+
+	fr := get("fr-CA,fr;q=0.9,en;q=0.5")
+	assert.Equal(t, "fr", fr.Locale)
+	assert.Equal(t, "Rejoignez-nous", fr.Title)
+	assert.Equal(t, "Suivez vos heures", fr.Benefits[0].Text)
+
+	fallback := get("de-DE")
+	assert.Equal(t, "en", fallback.Locale)
+	assert.Equal(t, "Join us", fallback.Title)
+	assert.Equal(t, "📈", fallback.Benefits[0].Icon)
+}
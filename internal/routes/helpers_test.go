@@ -2,36 +2,49 @@ package routes
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"anonchihaya.co.uk/internal/achievement"
 	"anonchihaya.co.uk/internal/auth"
 	"anonchihaya.co.uk/internal/coreskill"
 	"anonchihaya.co.uk/internal/education"
 	"anonchihaya.co.uk/internal/experience"
 	"anonchihaya.co.uk/internal/guestpopup"
 	"anonchihaya.co.uk/internal/learning"
+	"anonchihaya.co.uk/internal/metrics"
 	"anonchihaya.co.uk/internal/mysterycode"
 	"anonchihaya.co.uk/internal/post"
+	"anonchihaya.co.uk/internal/presence"
 	"anonchihaya.co.uk/internal/profile"
 	"anonchihaya.co.uk/internal/project"
+	"anonchihaya.co.uk/internal/report"
 	"anonchihaya.co.uk/internal/statistics"
 	"anonchihaya.co.uk/internal/store"
 	"anonchihaya.co.uk/internal/tracking"
+	"anonchihaya.co.uk/internal/util"
+	"anonchihaya.co.uk/internal/visitor"
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 const (
-	testDomain = "example.com"
-	testAdmin  = "admin-pass"
-	testKey    = "test-key"
-	testImgDir = "/tmp"
-	testImgURL = "/public"
+	testDomain       = "example.com"
+	testAdmin        = "admin-pass"
+	testKey          = "test-key"
+	testImgDir       = "/tmp"
+	testImgURL       = "/public"
+	testMetricsToken = "metrics-token"
 )
 
+// testPresenceHub is the hub used by the router from setupRouter. It never refreshes on
+// its own, so tests call Refresh to publish changes.
+var testPresenceHub *presence.Hub
+
 func setupTestDatabase(t *testing.T) {
 	t.Helper()
 
@@ -50,9 +63,25 @@ func setupTestDatabase(t *testing.T) {
 		&learning.Learning{},
 		&post.Post{},
 		&tracking.FanTracking{},
+		&tracking.TrackingEvent{},
+		&tracking.ProcessedEvent{},
+		&tracking.Rollup{},
+		&tracking.VisitorRollup{},
+		&tracking.TrackingConsent{},
+		&tracking.RetentionSetting{},
+		&tracking.ContentView{},
+		&tracking.SessionSource{},
+		&tracking.FanStreak{},
+		&tracking.FunnelEvent{},
 		&mysterycode.MysteryCode{},
 		&guestpopup.GuestPopupConfig{},
+		&guestpopup.GuestPopupEvent{},
+		&guestpopup.GuestPopupAssignment{},
+		&guestpopup.GuestPopupTranslation{},
 		&coreskill.CoreSkill{},
+		&visitor.Visitor{},
+		&achievement.FanBadge{},
+		&report.Report{},
 	); err != nil {
 		t.Fatalf("failed to migrate test database: %v", err)
 	}
@@ -77,11 +106,27 @@ func setupRouter(t *testing.T) *gin.Engine {
 	popupRepo := guestpopup.NewGuestPopupConfigRepository(store.DB)
 	statsRepo := statistics.NewStatisticsRepository(store.DB)
 	coreSkillRepo := coreskill.NewCoreSkillRepository()
+	visitorRepo := visitor.NewVisitorRepository(store.DB)
+	testPresenceHub = presence.NewHub(trackingRepo, fanRepo, time.Hour)
+	retentionJob := tracking.NewRetentionJob(trackingRepo, 0, false, time.Hour)
+	// Tests read statistics right after writing them, so the overview isn't cached
+	overviewCache := statistics.NewOverviewCache(statsRepo, trackingRepo, 0)
+	// The evaluator isn't started; fans' badges are evaluated when they fetch them
+	achievementRepo := achievement.NewAchievementRepository(store.DB)
+	evaluator := achievement.NewEvaluator(achievementRepo, fanRepo, trackingRepo, statsRepo, mysteryCodeRepo)
+
+	// The report job isn't started and has no recipient; reports are made on demand
+	reportRepo := report.NewReportRepository(store.DB)
+	reportJob := report.NewJob(reportRepo, statsRepo, trackingRepo, "", 0)
+
+	metricsCollector := metrics.NewCollector()
+	metricsCollector.AddAppMetrics(trackingRepo, statsRepo)
 
 	r := gin.Default()
 	InitRoutes(r, testDomain, testAdmin, testKey, testImgDir, testImgURL,
 		profileRepo, experiencesRepo, educationsRepo, projectsRepo, postsRepo, fanRepo, sessionRepo,
-		trackingRepo, mysteryCodeRepo, popupRepo, statsRepo, coreSkillRepo)
+		trackingRepo, mysteryCodeRepo, popupRepo, statsRepo, coreSkillRepo, visitorRepo, testPresenceHub, retentionJob, overviewCache, achievementRepo, evaluator,
+		reportRepo, reportJob, metricsCollector, testMetricsToken)
 
 	return r
 }
@@ -96,3 +141,88 @@ func performRequest(r http.Handler, method, path string, body []byte) *httptest.
 	r.ServeHTTP(w, req)
 	return w
 }
+
+func performRequestWithCookies(r http.Handler, method, path string, body []byte, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBuffer(body))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// createSessionCookies creates a fan with a live session and returns the cookies an
+// admin client would send (session token plus the admin key).
+func createSessionCookies(t *testing.T, username string, isAdmin bool) []*http.Cookie {
+	t.Helper()
+
+	fan := &auth.Fan{
+		Username:      username,
+		Email:         username + "@example.com",
+		IsAdmin:       isAdmin,
+		EmailVerified: true,
+	}
+	if err := auth.NewFanRepository().Create(fan); err != nil {
+		t.Fatalf("failed to create fan: %v", err)
+	}
+
+	token := util.GenerateSessionToken()
+	if err := auth.NewSessionRepository().Create(&auth.Session{
+		FanID:     fan.ID,
+		Token:     token,
+		ExpiresAt: util.GetSessionExpiry(),
+	}); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	return []*http.Cookie{
+		{Name: "session_token", Value: token},
+		{Name: "key", Value: testKey},
+	}
+}
+
+// grantTrackingConsent records tracking consent for the fan behind cookies; fans are
+// not tracked without it
+func grantTrackingConsent(t *testing.T, r http.Handler, cookies []*http.Cookie) {
+	t.Helper()
+
+	w := performRequestWithCookies(r, http.MethodPut, "/api/tracking/consent", []byte(`{"granted":true}`), cookies...)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to grant tracking consent: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// overallCounts decodes the counts of a statistics overview, leaving out generated_at
+func overallCounts(t *testing.T, w *httptest.ResponseRecorder) map[string]float64 {
+	t.Helper()
+
+	var response map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode overview: %v", err)
+	}
+	if _, ok := response["generated_at"].(string); !ok {
+		t.Fatalf("overview is missing generated_at: %s", w.Body.String())
+	}
+
+	counts := make(map[string]float64, len(response))
+	for key, value := range response {
+		if count, ok := value.(float64); ok {
+			counts[key] = count
+		}
+	}
+	return counts
+}
+
+func findCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
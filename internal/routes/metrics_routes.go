@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"anonchihaya.co.uk/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+func registerMetricsRoutes(r *gin.Engine, collector *metrics.Collector, token string) {
+	handler := gin.WrapH(metrics.RequireToken(token, collector))
+	r.GET("/metrics", handler)
+	// Caddy only reverse-proxies `/api*`, so expose the metrics under the API prefix as well.
+	r.GET(prefix+"/metrics", handler)
+}
@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRequireToken(t *testing.T) {
+	router := setupRouter(t)
+
+	w := performRequest(router, http.MethodGet, "/metrics", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without a token, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer wrong-token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 with the wrong token, got %d", w.Code)
+	}
+
+	performRequest(router, http.MethodGet, "/api/tracking/online", nil)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+testMetricsToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`anoweb_http_requests_total{method="GET",route="/api/tracking/online",status="200"} 1`,
+		`anoweb_http_request_duration_seconds_count{method="GET",route="/api/tracking/online",status="200"} 1`,
+		`anoweb_http_requests_total{method="GET",route="/metrics",status="401"} 2`,
+		"# TYPE anoweb_tracking_active_sessions gauge",
+		"# TYPE anoweb_fans_registered gauge",
+		"anoweb_emails_queued 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
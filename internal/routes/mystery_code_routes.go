@@ -13,8 +13,12 @@ func registerMysteryCodeRoutes(
 	mysteryCodeRepo *mysterycode.MysteryCodeRepository,
 	fanRepo *auth.FanRepository,
 	sessionRepo *auth.SessionRepository,
+	hooks ...mysterycode.RedeemHook,
 ) {
 	handler := mysterycode.NewMysteryCodeHandler(mysteryCodeRepo, fanRepo)
+	for _, hook := range hooks {
+		handler.AddHook(hook)
+	}
 
 	// User endpoint - verify code (no KeyChecker needed, just auth)
 	mysteryCodeUser := r.Group(prefix + "/mystery-code")
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"anonchihaya.co.uk/internal/presence"
+	"github.com/gin-gonic/gin"
+)
+
+func registerPresenceRoutes(r *gin.Engine, hub *presence.Hub) {
+	handler := presence.NewPresenceHandler(hub)
+
+	presenceGroup := r.Group(prefix + "/presence")
+	{
+		presenceGroup.GET("", handler.GetPresence)
+		presenceGroup.GET("/stream", handler.Stream)
+	}
+}
@@ -0,0 +1,134 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/presence"
+	"github.com/stretchr/testify/assert"
+)
+
+func getPresence(t *testing.T, r http.Handler) presence.Snapshot {
+	t.Helper()
+
+	w := performRequest(r, http.MethodGet, "/api/presence", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var snapshot presence.Snapshot
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &snapshot))
+	return snapshot
+}
+
+func hasOnlineFan(snapshot presence.Snapshot, username string) bool {
+	for _, fan := range snapshot.Fans {
+		if fan.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPresenceHidesOptedOutFans(t *testing.T) {
+	r := setupRouter(t)
+	visible := createSessionCookies(t, "presence-visible", false)
+	hidden := createSessionCookies(t, "presence-hidden", false)
+	grantTrackingConsent(t, r, visible)
+	grantTrackingConsent(t, r, hidden)
+
+	optOut, _ := json.Marshal(map[string]bool{"hide_presence": true})
+	w := performRequestWithCookies(r, http.MethodPut, "/api/fan/profile", optOut, hidden...)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	before := getPresence(t, r)
+
+	for i, cookies := range [][]*http.Cookie{visible, hidden} {
+		body, _ := json.Marshal(map[string]string{"session_id": "presence-session-" + string(rune('a'+i))})
+		w := performRequestWithCookies(r, http.MethodPost, "/api/tracking/start", body, cookies...)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	after := getPresence(t, r)
+	assert.True(t, hasOnlineFan(after, "presence-visible"))
+	assert.False(t, hasOnlineFan(after, "presence-hidden"))
+	assert.Equal(t, before.Online+2, after.Online)
+	assert.Equal(t, before.Anonymous+1, after.Anonymous)
+}
+
+// streamRecorder is a ResponseRecorder that can be read while the handler is still writing
+type streamRecorder struct {
+	*httptest.ResponseRecorder
+	mu sync.Mutex
+}
+
+func (s *streamRecorder) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ResponseRecorder.Write(b)
+}
+
+func (s *streamRecorder) WriteString(str string) (int, error) {
+	return s.Write([]byte(str))
+}
+
+func (s *streamRecorder) Flush() {}
+
+func (s *streamRecorder) body() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ResponseRecorder.Body.String()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPresenceStreamSendsJoinAndLeave(t *testing.T) {
+	r := setupRouter(t)
+	cookies := createSessionCookies(t, "presence-streamer", false)
+	grantTrackingConsent(t, r, cookies)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/api/presence/stream", nil).WithContext(ctx)
+	w := &streamRecorder{ResponseRecorder: httptest.NewRecorder()}
+	done := make(chan struct{})
+	go func() {
+		r.ServeHTTP(w, req)
+		close(done)
+	}()
+
+	waitFor(t, func() bool { return testPresenceHub.SubscriberCount() == 1 })
+	assert.Equal(t, 1, testPresenceHub.SubscriberCount())
+
+	body, _ := json.Marshal(map[string]string{"session_id": "presence-stream-session"})
+	resp := performRequestWithCookies(r, http.MethodPost, "/api/tracking/start", body, cookies...)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, testPresenceHub.Refresh())
+
+	resp = performRequestWithCookies(r, http.MethodPost, "/api/tracking/end", body, cookies...)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, testPresenceHub.Refresh())
+
+	waitFor(t, func() bool { return strings.Contains(w.body(), "event:leave") })
+	cancel()
+	<-done
+
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	stream := w.body()
+	snapshotAt := strings.Index(stream, "event:snapshot")
+	joinAt := strings.Index(stream, "event:join")
+	leaveAt := strings.Index(stream, "event:leave")
+	assert.True(t, snapshotAt >= 0 && joinAt > snapshotAt && leaveAt > joinAt, stream)
+	assert.Contains(t, stream, `"username":"presence-streamer"`)
+	assert.Contains(t, stream, "event:count")
+	assert.Equal(t, 0, testPresenceHub.SubscriberCount())
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/middlewares"
+	"anonchihaya.co.uk/internal/report"
+	"github.com/gin-gonic/gin"
+)
+
+func registerReportRoutes(r *gin.Engine, key string, reportRepo *report.ReportRepository, reportJob *report.Job, sessionRepo *auth.SessionRepository) {
+	handler := report.NewReportHandler(reportRepo, reportJob)
+
+	// Admin endpoints (require key, auth, and admin)
+	reportAdmin := r.Group(prefix + "/reports")
+	reportAdmin.Use(middlewares.KeyChecker(key))
+	reportAdmin.Use(auth.AuthMiddleware(sessionRepo))
+	reportAdmin.Use(auth.AdminMiddleware())
+	{
+		reportAdmin.GET("", handler.ListReports)
+		reportAdmin.POST("/run", handler.RunReport)
+		reportAdmin.GET("/:id", handler.GetReport)
+	}
+}
@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportsRunAndList(t *testing.T) {
+	router := setupRouter(t)
+	adminCookies := createSessionCookies(t, "report-admin", true)
+	fanCookies := createSessionCookies(t, "report-fan", false)
+
+	w := performRequestWithCookies(router, http.MethodGet, "/api/reports", nil, fanCookies...)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Admin changes need the key like every other admin group
+	w = performRequestWithCookies(router, http.MethodPost, "/api/reports/run?email=false", nil, adminCookies[0])
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequestWithCookies(router, http.MethodPost, "/api/tracking/retention/run?dry_run=true", nil, adminCookies[0])
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequestWithCookies(router, http.MethodPost, "/api/reports/run?email=maybe", nil, adminCookies...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequestWithCookies(router, http.MethodPost, "/api/reports/run?email=false", nil, adminCookies...)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var created struct {
+		ID      uint   `json:"id"`
+		Trigger string `json:"trigger"`
+		HTML    string `json:"html"`
+		Summary struct {
+			Current struct {
+				NewFans int64 `json:"new_fans"`
+			} `json:"current"`
+		} `json:"summary"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "manual", created.Trigger)
+	assert.GreaterOrEqual(t, created.Summary.Current.NewFans, int64(2))
+	assert.Contains(t, created.HTML, "Analytics report")
+
+	w = performRequestWithCookies(router, http.MethodGet, "/api/reports", nil, adminCookies...)
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.NotEmpty(t, listed)
+	assert.EqualValues(t, created.ID, listed[0]["id"])
+	assert.NotContains(t, listed[0], "html")
+
+	w = performRequestWithCookies(router, http.MethodGet, fmt.Sprintf("/api/reports/%d?format=html", created.ID), nil, adminCookies...)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"))
+	assert.Equal(t, created.HTML, w.Body.String())
+
+	w = performRequestWithCookies(router, http.MethodGet, "/api/reports/999999", nil, adminCookies...)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
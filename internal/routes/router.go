@@ -1,17 +1,22 @@
 package routes
 
 import (
+	"anonchihaya.co.uk/internal/achievement"
 	"anonchihaya.co.uk/internal/auth"
 	"anonchihaya.co.uk/internal/coreskill"
 	"anonchihaya.co.uk/internal/education"
 	"anonchihaya.co.uk/internal/experience"
 	"anonchihaya.co.uk/internal/guestpopup"
+	"anonchihaya.co.uk/internal/metrics"
 	"anonchihaya.co.uk/internal/mysterycode"
 	"anonchihaya.co.uk/internal/post"
+	"anonchihaya.co.uk/internal/presence"
 	"anonchihaya.co.uk/internal/profile"
 	"anonchihaya.co.uk/internal/project"
+	"anonchihaya.co.uk/internal/report"
 	"anonchihaya.co.uk/internal/statistics"
 	"anonchihaya.co.uk/internal/tracking"
+	"anonchihaya.co.uk/internal/visitor"
 	"github.com/gin-gonic/gin"
 )
 
@@ -36,9 +41,32 @@ func InitRoutes(
 	popupRepo *guestpopup.GuestPopupConfigRepository,
 	statsRepo *statistics.StatisticsRepository,
 	coreSkillRepo coreskill.CoreSkillRepository,
+	visitorRepo *visitor.VisitorRepository,
+	presenceHub *presence.Hub,
+	retentionJob *tracking.RetentionJob,
+	overviewCache *statistics.OverviewCache,
+	achievementRepo *achievement.AchievementRepository,
+	evaluator *achievement.Evaluator,
+	reportRepo *report.ReportRepository,
+	reportJob *report.Job,
+	metricsCollector *metrics.Collector,
+	metricsToken string,
 ) {
+	// Registered first so every route is measured
+	if metricsCollector != nil {
+		r.Use(metricsCollector.Middleware())
+		if metricsToken != "" {
+			registerMetricsRoutes(r, metricsCollector, metricsToken)
+		}
+	}
 	registerSwaggerRoutes(r)
-	registerFanRoutes(r, domain, imgPath, imgURLPrefix, fanRepo, sessionRepo)
+	registerFanRoutes(r, domain, imgPath, imgURLPrefix, fanRepo, sessionRepo,
+		guestpopup.RegistrationHook(popupRepo),
+		tracking.MergeGuestHook(trackingRepo),
+		tracking.AttributionHook(trackingRepo, fanRepo),
+		tracking.FunnelHook(trackingRepo),
+		achievement.FanHook(evaluator),
+	)
 	registerAdminRoutes(r, domain, adminPass, key)
 	registerStaticRoutes(r, key, imgPath, imgURLPrefix, sessionRepo)
 	registerHomeRoutes(r, key, sessionRepo)
@@ -47,9 +75,12 @@ func InitRoutes(
 	registerProjectRoutes(r, key, projectsRepo, sessionRepo)
 	registerEducationRoutes(r, key, imgPath, imgURLPrefix, educationsRepo, sessionRepo)
 	registerPostRoutes(r, key, postsRepo, sessionRepo)
-	registerTrackingRoutes(r, key, trackingRepo, sessionRepo)
-	registerMysteryCodeRoutes(r, key, mysteryCodeRepo, fanRepo, sessionRepo)
-	registerGuestPopupRoutes(r, key, popupRepo, sessionRepo)
-	registerStatisticsRoutes(r, statsRepo, trackingRepo, sessionRepo)
+	registerTrackingRoutes(r, key, domain, trackingRepo, visitorRepo, sessionRepo, retentionJob)
+	registerMysteryCodeRoutes(r, key, mysteryCodeRepo, fanRepo, sessionRepo, achievement.RedeemHook(evaluator))
+	registerGuestPopupRoutes(r, key, domain, popupRepo, visitorRepo, sessionRepo)
+	registerStatisticsRoutes(r, key, statsRepo, trackingRepo, overviewCache, sessionRepo)
+	registerPresenceRoutes(r, presenceHub)
 	registerCoreSkillRoutes(r, key, coreSkillRepo, sessionRepo)
+	registerAchievementRoutes(r, achievementRepo, evaluator, fanRepo, sessionRepo)
+	registerReportRoutes(r, key, reportRepo, reportJob, sessionRepo)
 }
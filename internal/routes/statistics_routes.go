@@ -2,6 +2,7 @@ package routes
 
 import (
 	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/middlewares"
 	"anonchihaya.co.uk/internal/statistics"
 	"anonchihaya.co.uk/internal/tracking"
 	"github.com/gin-gonic/gin"
@@ -9,20 +10,29 @@ import (
 
 func registerStatisticsRoutes(
 	r *gin.Engine,
+	key string,
 	statsRepo *statistics.StatisticsRepository,
 	trackingRepo *tracking.FanTrackingRepository,
+	overviewCache *statistics.OverviewCache,
 	sessionRepo *auth.SessionRepository,
 ) {
-	handler := statistics.NewStatisticsHandler(statsRepo, trackingRepo)
+	handler := statistics.NewStatisticsHandler(statsRepo, trackingRepo, overviewCache)
 
 	statsGroup := r.Group(prefix + "/statistics")
 	{
-		// Public endpoints
-		statsGroup.GET("/overall", handler.GetOverallStatistics)
-		statsGroup.GET("/users-over-time", handler.GetUsersOverTime)
-		statsGroup.GET("/daily-active", handler.GetDailyActiveUsers)
+		// Public endpoints; signed-in fans get their own time zone
+		optionalAuth := auth.OptionalAuthMiddleware(sessionRepo)
+		statsGroup.GET("/overall", optionalAuth, handler.GetOverallStatistics)
+		statsGroup.GET("/users-over-time", optionalAuth, handler.GetUsersOverTime)
+		statsGroup.GET("/daily-active", optionalAuth, handler.GetDailyActiveUsers)
+		statsGroup.GET("/timeseries", optionalAuth, handler.GetTimeSeries)
+		statsGroup.GET("/leaderboard", optionalAuth, handler.GetLeaderboard)
 
 		// Authenticated endpoints
 		statsGroup.GET("/streak", auth.AuthMiddleware(sessionRepo), handler.GetUserStreak)
+		statsGroup.GET("/calendar", auth.AuthMiddleware(sessionRepo), handler.GetUserCalendar)
+
+		// Admin reports (require key, auth, and admin)
+		statsGroup.GET("/cohorts", middlewares.KeyChecker(key), auth.AuthMiddleware(sessionRepo), auth.AdminMiddleware(), handler.GetCohorts)
 	}
 }
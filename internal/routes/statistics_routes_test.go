@@ -1,10 +1,14 @@
 package routes
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"anonchihaya.co.uk/internal/statistics"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -52,3 +56,229 @@ func TestDailyActiveUsers(t *testing.T) {
 	// Response should be valid JSON (array or null)
 	assert.True(t, json.Valid(w.Body.Bytes()), "Response should be valid JSON")
 }
+
+func TestGuestVisitorsAreCountedAndMergedOnRegistration(t *testing.T) {
+	r := setupRouter(t)
+
+	overall := func() map[string]float64 {
+		w := performRequest(r, http.MethodGet, "/api/statistics/overall", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return overallCounts(t, w)
+	}
+
+	before := overall()
+
+	start, _ := json.Marshal(map[string]string{"session_id": "guest-merge-session"})
+	w := performRequest(r, http.MethodPost, "/api/tracking/start", start)
+	assert.Equal(t, http.StatusOK, w.Code)
+	visitorCookie := findCookie(w, "visitor_id")
+	if visitorCookie == nil {
+		t.Fatalf("expected visitor cookie to be issued")
+	}
+
+	afterVisit := overall()
+	assert.Equal(t, before["guest_visitors_ever"]+1, afterVisit["guest_visitors_ever"])
+
+	register, _ := json.Marshal(map[string]string{
+		"username": "guest-merge",
+		"email":    "guest-merge@example.com",
+		"password": "password123",
+	})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/auth/register", register, visitorCookie)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// A new fan hasn't consented to tracking yet, so the guest visits stay a guest's
+	afterRegister := overall()
+	assert.Equal(t, afterVisit["guest_visitors_ever"], afterRegister["guest_visitors_ever"])
+	assert.Equal(t, before["registered_visitors_ever"], afterRegister["registered_visitors_ever"])
+
+	login, _ := json.Marshal(map[string]string{"username": "guest-merge", "password": "password123"})
+	w = performRequest(r, http.MethodPost, "/api/auth/login", login)
+	assert.Equal(t, http.StatusOK, w.Code)
+	grantTrackingConsent(t, r, w.Result().Cookies())
+
+	// Logging in from the guest's browser merges their visits, but not with DNT
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(login))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DNT", "1")
+	req.AddCookie(visitorCookie)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, afterVisit["guest_visitors_ever"], overall()["guest_visitors_ever"])
+
+	w = performRequestWithCookies(r, http.MethodPost, "/api/auth/login", login, visitorCookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	afterLogin := overall()
+	assert.Equal(t, before["guest_visitors_ever"], afterLogin["guest_visitors_ever"])
+	assert.Equal(t, before["registered_visitors_ever"]+1, afterLogin["registered_visitors_ever"])
+}
+
+func TestStatisticsTimezone(t *testing.T) {
+	r := setupRouter(t)
+	cookies := createSessionCookies(t, "tz-fan", false)
+
+	w := performRequest(r, http.MethodGet, "/api/statistics/daily-active?tz=Mars/Olympus", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequest(r, http.MethodGet, "/api/statistics/overall?tz=Local", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequest(r, http.MethodGet, "/api/statistics/users-over-time?tz=Asia/Kolkata", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequestWithCookies(r, http.MethodPut, "/api/fan/profile", []byte(`{"timezone":"Not/AZone"}`), cookies...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequestWithCookies(r, http.MethodPut, "/api/fan/profile", []byte(`{"timezone":"America/New_York"}`), cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"timezone":"America/New_York"`)
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/statistics/streak", nil, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequestWithCookies(r, http.MethodGet, "/api/statistics/streak?tz=Europe/London", nil, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestStatisticsCohorts(t *testing.T) {
+	r := setupRouter(t)
+	fanCookies := createSessionCookies(t, "cohort-fan", false)
+	adminCookies := createSessionCookies(t, "cohort-admin", true)
+
+	w := performRequestWithCookies(r, http.MethodGet, "/api/statistics/cohorts", nil, fanCookies...)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/statistics/cohorts?granularity=day", nil, adminCookies...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequestWithCookies(r, http.MethodGet, "/api/statistics/cohorts?from=2026-03-10&to=2026-03-01", nil, adminCookies...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/statistics/cohorts?granularity=month", nil, adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var cohorts []statistics.Cohort
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &cohorts))
+	if assert.NotEmpty(t, cohorts) {
+		// The fans created above signed up this month
+		latest := cohorts[len(cohorts)-1]
+		assert.GreaterOrEqual(t, latest.Size, int64(2))
+		assert.Len(t, latest.Retention, 1)
+	}
+}
+
+func TestStatisticsTimeSeries(t *testing.T) {
+	r := setupRouter(t)
+	createSessionCookies(t, "series-fan", false)
+
+	for _, query := range []string{"metric=pageviews", "granularity=minute", "compare=maybe", "from=yesterday",
+		"from=2026-03-10&to=2026-03-01", "granularity=hour&from=2020-01-01&to=2026-01-01"} {
+		w := performRequest(r, http.MethodGet, "/api/statistics/timeseries?"+query, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	w := performRequest(r, http.MethodGet, "/api/statistics/timeseries", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var series statistics.TimeSeries
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &series))
+	assert.Equal(t, statistics.MetricVisitors, series.Metric)
+	assert.Len(t, series.Points, 31)
+	assert.Nil(t, series.Previous)
+
+	// The fan created above signed up today, so the last bucket counts them and the
+	// buckets before are filled in as zero
+	now := time.Now().UTC()
+	from := now.AddDate(0, 0, -2).Format(time.DateOnly)
+	w = performRequest(r, http.MethodGet, "/api/statistics/timeseries?metric=signups&tz=UTC&compare=true&from="+from, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	series = statistics.TimeSeries{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &series))
+	if assert.Len(t, series.Points, 3) {
+		assert.Equal(t, float64(0), series.Points[0].Value)
+		assert.GreaterOrEqual(t, series.Points[2].Value, float64(1))
+		assert.NotNil(t, series.Points[0].Previous)
+	}
+	if assert.NotNil(t, series.Previous) {
+		assert.Equal(t, now.AddDate(0, 0, -5).Format(time.DateOnly), series.Previous.From.UTC().Format(time.DateOnly))
+	}
+}
+
+func TestStatisticsLeaderboard(t *testing.T) {
+	r := setupRouter(t)
+	shyCookies := createSessionCookies(t, "board-shy", false)
+	fanCookies := createSessionCookies(t, "board-fan", false)
+
+	w := performRequest(r, http.MethodGet, "/api/statistics/leaderboard?metric=likes", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequest(r, http.MethodGet, "/api/statistics/leaderboard?page=0", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequestWithCookies(r, http.MethodPut, "/api/fan/profile", []byte(`{"show_on_leaderboard":true}`), fanCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"show_on_leaderboard":true`)
+
+	board := func(path string, cookies ...*http.Cookie) statistics.Leaderboard {
+		t.Helper()
+		w := performRequestWithCookies(r, http.MethodGet, path, nil, cookies...)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var result statistics.Leaderboard
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+
+	all := board("/api/statistics/leaderboard?metric=streak&limit=100", fanCookies...)
+	assert.Equal(t, statistics.LeaderboardStreak, all.Metric)
+	usernames := make([]string, 0, len(all.Entries))
+	for _, entry := range all.Entries {
+		usernames = append(usernames, entry.Username)
+	}
+	assert.Contains(t, usernames, "board-fan")
+	assert.NotContains(t, usernames, "board-shy")
+	if assert.NotNil(t, all.Me) {
+		assert.Equal(t, "board-fan", all.Me.Username)
+	}
+
+	// The caller's rank is included even on a page past the end
+	page := board("/api/statistics/leaderboard?page=1000&limit=1", fanCookies...)
+	assert.Empty(t, page.Entries)
+	assert.Equal(t, all.Total, page.Total)
+	if assert.NotNil(t, page.Me) {
+		assert.Equal(t, all.Me.FanID, page.Me.FanID)
+	}
+
+	// Fans who haven't opted in don't get a place
+	assert.Nil(t, board("/api/statistics/leaderboard?metric=week_hours", shyCookies...).Me)
+	assert.Nil(t, board("/api/statistics/leaderboard").Me)
+}
+
+func TestStatisticsStreakAndCalendar(t *testing.T) {
+	r := setupRouter(t)
+	cookies := createSessionCookies(t, "calendar-fan", false)
+	grantTrackingConsent(t, r, cookies)
+
+	w := performRequest(r, http.MethodGet, "/api/statistics/calendar", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = performRequestWithCookies(r, http.MethodGet, "/api/statistics/calendar?year=abc", nil, cookies...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	start, _ := json.Marshal(map[string]string{"session_id": "calendar-session"})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/tracking/start", start, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	today := time.Now().In(time.UTC).Format(time.DateOnly)
+	w = performRequestWithCookies(r, http.MethodGet, "/api/statistics/streak?tz=UTC", nil, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var streak statistics.StreakSummary
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &streak))
+	assert.Equal(t, statistics.StreakSummary{Current: 1, Longest: 1, LastActiveDay: today}, streak)
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/statistics/calendar?tz=UTC", nil, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var calendar statistics.Calendar
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &calendar))
+	assert.Equal(t, time.Now().In(time.UTC).Year(), calendar.Year)
+	if assert.Len(t, calendar.Days, 1) {
+		assert.Equal(t, today, calendar.Days[0].Date)
+		assert.Equal(t, int64(1), calendar.Days[0].Sessions)
+	}
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/statistics/calendar?year=2001", nil, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"year":2001,"days":[]}`, w.Body.String())
+}
@@ -2,26 +2,40 @@ package routes
 
 import (
 	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/middlewares"
 	"anonchihaya.co.uk/internal/tracking"
+	"anonchihaya.co.uk/internal/visitor"
 	"github.com/gin-gonic/gin"
 )
 
 func registerTrackingRoutes(
 	r *gin.Engine,
 	key string,
+	domain string,
 	trackingRepo *tracking.FanTrackingRepository,
+	visitorRepo *visitor.VisitorRepository,
 	sessionRepo *auth.SessionRepository,
+	retentionJob *tracking.RetentionJob,
 ) {
 	handler := tracking.NewTrackingHandler(trackingRepo)
+	retentionHandler := tracking.NewRetentionHandler(retentionJob)
 
 	// Public tracking endpoints with optional auth (to capture user ID when logged in)
+	// Guests are identified by their visitor cookie
 	trackingPublic := r.Group(prefix + "/tracking")
 	trackingPublic.Use(auth.OptionalAuthMiddleware(sessionRepo))
+	trackingPublic.Use(visitor.Middleware(visitorRepo, domain))
 	{
 		trackingPublic.POST("/start", handler.StartTracking)
 		trackingPublic.POST("/end", handler.EndTracking)
 		trackingPublic.POST("/update", handler.UpdateTracking)
+		trackingPublic.POST("/event", handler.RecordEvent)
+		trackingPublic.POST("/batch", handler.TrackBatch)
+		trackingPublic.POST("/content", handler.RecordContentView)
+		trackingPublic.POST("/funnel", handler.RecordFunnelStep)
+		trackingPublic.GET("/content/:type/:id", handler.GetContentStats)
 		trackingPublic.GET("/total-hours", handler.GetTotalHours)
+		trackingPublic.GET("/online", handler.GetOnlineCount)
 	}
 
 	// Authenticated tracking endpoints
@@ -29,6 +43,28 @@ func registerTrackingRoutes(
 	trackingAuth.Use(auth.AuthMiddleware(sessionRepo))
 	{
 		trackingAuth.GET("/user-hours", handler.GetUserTotalHours)
-		trackingAuth.GET("/records", handler.GetAllTrackingRecords)
+		trackingAuth.GET("/records", handler.ListTrackingRecords)
+		trackingAuth.DELETE("/records", handler.EraseTracking)
+		trackingAuth.GET("/consent", handler.GetConsent)
+		trackingAuth.PUT("/consent", handler.UpdateConsent)
+	}
+
+	// Admin reports (require key, auth, and admin)
+	trackingAdmin := r.Group(prefix + "/tracking")
+	trackingAdmin.Use(middlewares.KeyChecker(key))
+	trackingAdmin.Use(auth.AuthMiddleware(sessionRepo))
+	trackingAdmin.Use(auth.AdminMiddleware())
+	{
+		trackingAdmin.GET("/top-pages", handler.GetTopPages)
+		trackingAdmin.GET("/top-referrers", handler.GetTopReferrers)
+		trackingAdmin.GET("/sources", handler.GetSourceReport)
+		trackingAdmin.GET("/funnel", handler.GetFunnel)
+		trackingAdmin.GET("/clients", handler.GetClientBreakdown)
+		trackingAdmin.GET("/event-counts", handler.GetEventCounts)
+		trackingAdmin.GET("/content/top", handler.GetTopContent)
+		trackingAdmin.GET("/records/export", handler.ExportTrackingRecords)
+		trackingAdmin.GET("/retention", retentionHandler.GetRetention)
+		trackingAdmin.PUT("/retention", retentionHandler.UpdateRetention)
+		trackingAdmin.POST("/retention/run", retentionHandler.RunRetention)
 	}
 }
@@ -0,0 +1,566 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/post"
+	"anonchihaya.co.uk/internal/store"
+	"anonchihaya.co.uk/internal/tracking"
+	"anonchihaya.co.uk/internal/visitor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackingBatchBeaconIsIdempotent(t *testing.T) {
+	r := setupRouter(t)
+	cookies := createSessionCookies(t, "batch-fan", false)
+	grantTrackingConsent(t, r, cookies)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"session_id": "batch-session",
+		"ops": []map[string]interface{}{
+			{"id": "op-1", "type": "start"},
+			{"id": "op-2", "type": "event", "path": "/projects", "referrer": "https://example.com"},
+			{"id": "op-3", "type": "update"},
+			{"id": "op-4", "type": "end"},
+		},
+	})
+
+	send := func() tracking.BatchResult {
+		req := httptest.NewRequest(http.MethodPost, "/api/tracking/batch", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var result tracking.BatchResult
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+
+	assert.Equal(t, tracking.BatchResult{Applied: 4}, send())
+	assert.Equal(t, tracking.BatchResult{Duplicates: 4}, send())
+
+	var sessions []tracking.FanTracking
+	assert.NoError(t, store.DB.Where("session_id = ?", "batch-session").Find(&sessions).Error)
+	if assert.Len(t, sessions, 1) {
+		assert.NotNil(t, sessions[0].FanID)
+		assert.NotNil(t, sessions[0].EndTime)
+	}
+
+	var events int64
+	assert.NoError(t, store.DB.Model(&tracking.TrackingEvent{}).Where("session_id = ?", "batch-session").Count(&events).Error)
+	assert.Equal(t, int64(1), events)
+}
+
+func TestTrackingBatchRejectsInvalidOps(t *testing.T) {
+	r := setupRouter(t)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"session_id": "batch-invalid",
+		"ops": []map[string]interface{}{
+			{"id": "op-1", "type": "start"},
+			{"id": "op-2", "type": "event"},
+		},
+	})
+	w := performRequest(r, http.MethodPost, "/api/tracking/batch", body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"session_id": "batch-invalid",
+		"ops":        []map[string]interface{}{{"id": "op-1", "type": "explode"}},
+	})
+	w = performRequest(r, http.MethodPost, "/api/tracking/batch", body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var count int64
+	assert.NoError(t, store.DB.Model(&tracking.FanTracking{}).Where("session_id = ?", "batch-invalid").Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestTrackingRecordsPaginationAndFilters(t *testing.T) {
+	r := setupRouter(t)
+	adminCookies := createSessionCookies(t, "records-admin", true)
+	fanCookies := createSessionCookies(t, "records-fan", false)
+
+	var fan auth.Fan
+	assert.NoError(t, store.DB.Where("username = ?", "records-fan").First(&fan).Error)
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		end := start.Add(time.Duration(i) * time.Hour).Add(time.Minute)
+		record := tracking.FanTracking{
+			FanID:     &fan.ID,
+			SessionID: fmt.Sprintf("records-%d", i),
+			StartTime: start.Add(time.Duration(i) * time.Hour),
+			Duration:  int64(i * 60),
+		}
+		if i < 4 {
+			record.EndTime = &end
+		}
+		assert.NoError(t, store.DB.Create(&record).Error)
+	}
+
+	type page struct {
+		Records    []tracking.FanTracking `json:"records"`
+		NextCursor string                 `json:"next_cursor"`
+	}
+	get := func(path string, cookies []*http.Cookie) page {
+		w := performRequestWithCookies(r, http.MethodGet, path, nil, cookies...)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var p page
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		return p
+	}
+
+	base := fmt.Sprintf("/api/tracking/records?user_id=%d&from=2026-03-01&to=2026-03-02", fan.ID)
+	first := get(base+"&limit=2", adminCookies)
+	if assert.Len(t, first.Records, 2) {
+		assert.Equal(t, "records-4", first.Records[0].SessionID)
+	}
+	assert.NotEmpty(t, first.NextCursor)
+
+	second := get(base+"&limit=2&cursor="+first.NextCursor, adminCookies)
+	if assert.Len(t, second.Records, 2) {
+		assert.Equal(t, "records-2", second.Records[0].SessionID)
+	}
+	last := get(base+"&limit=2&cursor="+second.NextCursor, adminCookies)
+	assert.Len(t, last.Records, 1)
+	assert.Empty(t, last.NextCursor)
+
+	filtered := get(base+"&status=closed&min_duration=120", adminCookies)
+	assert.Len(t, filtered.Records, 2)
+
+	open := get(base+"&status=open", adminCookies)
+	if assert.Len(t, open.Records, 1) {
+		assert.Nil(t, open.Records[0].EndTime)
+	}
+
+	// Non-admins only ever see their own records, whatever user_id they ask for
+	own := get("/api/tracking/records?user_id=999999", fanCookies)
+	assert.Len(t, own.Records, 5)
+
+	w := performRequestWithCookies(r, http.MethodGet, "/api/tracking/records?status=maybe", nil, adminCookies...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestTrackingRecordsExport(t *testing.T) {
+	r := setupRouter(t)
+	adminCookies := createSessionCookies(t, "export-admin", true)
+	fanCookies := createSessionCookies(t, "export-fan", false)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, store.DB.Create(&tracking.FanTracking{
+			SessionID: fmt.Sprintf("export-%d", i),
+			VisitorID: "export-visitor",
+			StartTime: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+			Duration:  90,
+		}).Error)
+	}
+	path := "/api/tracking/records/export?from=2025-01-01&to=2025-01-03"
+
+	w := performRequestWithCookies(r, http.MethodGet, path, nil, adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 4) {
+		assert.Equal(t, "session_id", rows[0][3])
+		assert.Equal(t, "export-2", rows[1][3])
+		assert.Equal(t, "2025-01-02T03:04:05Z", rows[1][4])
+	}
+
+	w = performRequestWithCookies(r, http.MethodGet, path+"&format=ndjson", nil, adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 3)
+	var record tracking.FanTracking
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "export-visitor", record.VisitorID)
+
+	w = performRequestWithCookies(r, http.MethodGet, path, nil, fanCookies...)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestTrackingRequiresConsentAndHonoursDoNotTrack(t *testing.T) {
+	r := setupRouter(t)
+	cookies := createSessionCookies(t, "consent-fan", false)
+
+	count := func(sessionID string) int64 {
+		var n int64
+		assert.NoError(t, store.DB.Model(&tracking.FanTracking{}).Where("session_id = ?", sessionID).Count(&n).Error)
+		return n
+	}
+
+	// No consent yet: start and update succeed but record nothing
+	body, _ := json.Marshal(map[string]string{"session_id": "consent-missing"})
+	w := performRequestWithCookies(r, http.MethodPost, "/api/tracking/start", body, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "no_consent")
+	w = performRequestWithCookies(r, http.MethodPost, "/api/tracking/update", body, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Zero(t, count("consent-missing"))
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/tracking/consent", nil, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"granted":false`)
+
+	grantTrackingConsent(t, r, cookies)
+	body, _ = json.Marshal(map[string]string{"session_id": "consent-granted"})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/tracking/start", body, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(1), count("consent-granted"))
+
+	// Denying consent ends the active session
+	w = performRequestWithCookies(r, http.MethodPut, "/api/tracking/consent", []byte(`{"granted":false}`), cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var open int64
+	assert.NoError(t, store.DB.Model(&tracking.FanTracking{}).
+		Where("session_id = ? AND end_time IS NULL", "consent-granted").Count(&open).Error)
+	assert.Zero(t, open)
+
+	// DNT and Sec-GPC opt guests out too
+	var visitorsBefore int64
+	assert.NoError(t, store.DB.Model(&visitor.Visitor{}).Count(&visitorsBefore).Error)
+	for _, header := range []string{"DNT", "Sec-GPC"} {
+		sessionID := "dnt-" + header
+		body, _ := json.Marshal(map[string]string{"session_id": sessionID})
+		req := httptest.NewRequest(http.MethodPost, "/api/tracking/start", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(header, "1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "do_not_track")
+		assert.Zero(t, count(sessionID))
+		// Nor are they given a visitor cookie or recorded as visitors
+		assert.Empty(t, w.Result().Cookies())
+	}
+	var visitors int64
+	assert.NoError(t, store.DB.Model(&visitor.Visitor{}).Count(&visitors).Error)
+	assert.Equal(t, visitorsBefore, visitors)
+}
+
+func TestTrackingErasureIsReflectedInStatistics(t *testing.T) {
+	r := setupRouter(t)
+	cookies := createSessionCookies(t, "erase-fan", false)
+	grantTrackingConsent(t, r, cookies)
+
+	overall := func() map[string]float64 {
+		w := performRequest(r, http.MethodGet, "/api/statistics/overall", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return overallCounts(t, w)
+	}
+
+	before := overall()
+
+	body, _ := json.Marshal(map[string]string{"session_id": "erase-session"})
+	w := performRequestWithCookies(r, http.MethodPost, "/api/tracking/start", body, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequestWithCookies(r, http.MethodPost, "/api/tracking/end", body, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	tracked := overall()
+	assert.Equal(t, before["registered_visitors_ever"]+1, tracked["registered_visitors_ever"])
+
+	w = performRequestWithCookies(r, http.MethodDelete, "/api/tracking/records", nil, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"deleted":1}`, w.Body.String())
+
+	erased := overall()
+	assert.Equal(t, before["registered_visitors_ever"], erased["registered_visitors_ever"])
+	assert.Equal(t, before["unique_visitors_ever"], erased["unique_visitors_ever"])
+
+	var rows int64
+	assert.NoError(t, store.DB.Model(&tracking.FanTracking{}).Where("session_id = ?", "erase-session").Count(&rows).Error)
+	assert.Zero(t, rows)
+}
+
+func TestTrackingRetentionKeepsStatistics(t *testing.T) {
+	r := setupRouter(t)
+	adminCookies := createSessionCookies(t, "retention-admin", true)
+
+	var fan auth.Fan
+	assert.NoError(t, store.DB.Where("username = ?", "retention-admin").First(&fan).Error)
+	start := time.Now().AddDate(0, 0, -40)
+	for i := 0; i < 3; i++ {
+		end := start.Add(time.Duration(i+1) * time.Minute)
+		assert.NoError(t, store.DB.Create(&tracking.FanTracking{
+			FanID:     &fan.ID,
+			SessionID: fmt.Sprintf("retention-%d", i),
+			StartTime: start.AddDate(0, 0, i),
+			EndTime:   &end,
+			Duration:  int64((i + 1) * 60),
+		}).Error)
+	}
+	_, err := tracking.NewFanTrackingRepository(store.DB).RebuildRollups()
+	assert.NoError(t, err)
+
+	snapshot := func() (map[string]float64, string) {
+		overall := performRequest(r, http.MethodGet, "/api/statistics/overall", nil)
+		assert.Equal(t, http.StatusOK, overall.Code)
+		daily := performRequest(r, http.MethodGet, "/api/statistics/daily-active?days=60", nil)
+		assert.Equal(t, http.StatusOK, daily.Code)
+		return overallCounts(t, overall), daily.Body.String()
+	}
+	overallBefore, dailyBefore := snapshot()
+
+	w := performRequestWithCookies(r, http.MethodPut, "/api/tracking/retention", []byte(`{"days":3}`), adminCookies...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequestWithCookies(r, http.MethodPut, "/api/tracking/retention", []byte(`{"days":30}`), adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = performRequestWithCookies(r, http.MethodPost, "/api/tracking/retention/run", nil, adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var dry tracking.RetentionReport
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &dry))
+	assert.True(t, dry.DryRun)
+	assert.GreaterOrEqual(t, dry.Deleted, int64(3))
+
+	var raw int64
+	assert.NoError(t, store.DB.Model(&tracking.FanTracking{}).Where("session_id LIKE ?", "retention-%").Count(&raw).Error)
+	assert.Equal(t, int64(3), raw)
+
+	w = performRequestWithCookies(r, http.MethodPost, "/api/tracking/retention/run?dry_run=false", nil, adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, store.DB.Model(&tracking.FanTracking{}).Where("session_id LIKE ?", "retention-%").Count(&raw).Error)
+	assert.Zero(t, raw)
+
+	overallAfter, dailyAfter := snapshot()
+	assert.Equal(t, overallBefore, overallAfter)
+	assert.JSONEq(t, dailyBefore, dailyAfter)
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/tracking/retention", nil, adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"days":30`)
+}
+
+func TestContentViews(t *testing.T) {
+	r := setupRouter(t)
+	adminCookies := createSessionCookies(t, "content-admin", true)
+
+	article := post.Post{Name: "Content views post", ContentMD: "# Hello"}
+	assert.NoError(t, store.DB.Create(&article).Error)
+
+	ping := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		return performRequest(r, http.MethodPost, "/api/tracking/content", payload)
+	}
+
+	w := ping(map[string]interface{}{"session_id": "content-session", "content_type": "post", "content_id": article.ID})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"counted":true`)
+
+	w = ping(map[string]interface{}{"session_id": "content-session", "content_type": "post", "content_id": article.ID, "scroll_depth": 100})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"counted":false`)
+
+	w = ping(map[string]interface{}{"session_id": "content-session", "content_type": "post", "content_id": 999999})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = ping(map[string]interface{}{"session_id": "content-session", "content_type": "page", "content_id": article.ID})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequest(r, http.MethodGet, fmt.Sprintf("/api/tracking/content/post/%d", article.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stats tracking.ContentStats
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, "Content views post", stats.Title)
+	assert.Equal(t, int64(1), stats.Views)
+	if assert.NotNil(t, stats.ScrollDepth) {
+		assert.Equal(t, int64(1), stats.ScrollDepth.Completed)
+	}
+
+	w = performRequest(r, http.MethodGet, "/api/tracking/content/top?type=post", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/tracking/content/top?type=post&limit=100", nil, adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"title":"Content views post"`)
+}
+
+func TestSourceAttribution(t *testing.T) {
+	r := setupRouter(t)
+	adminCookies := createSessionCookies(t, "source-admin", true)
+
+	start, _ := json.Marshal(map[string]string{
+		"session_id":   "source-session",
+		"referrer":     "https://t.co/abc",
+		"utm_source":   "twitter",
+		"utm_medium":   "social",
+		"utm_campaign": "source-attribution-test",
+	})
+	w := performRequest(r, http.MethodPost, "/api/tracking/start", start)
+	assert.Equal(t, http.StatusOK, w.Code)
+	visitorCookie := findCookie(w, "visitor_id")
+	if visitorCookie == nil {
+		t.Fatalf("expected visitor cookie to be issued")
+	}
+
+	// The source is stored under the visitor's cookie ID
+	var stored tracking.SessionSource
+	assert.NoError(t, store.DB.Where("session_id = ?", "source-session").First(&stored).Error)
+	assert.Equal(t, visitorCookie.Value, stored.VisitorID)
+
+	// Later calls of the session don't change its source
+	event, _ := json.Marshal(map[string]string{"session_id": "source-session", "path": "/", "utm_source": "newsletter"})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/tracking/event", event, visitorCookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	register, _ := json.Marshal(map[string]string{
+		"username": "source-fan",
+		"email":    "source-fan@example.com",
+		"password": "password123",
+	})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/auth/register", register, visitorCookie)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var fan auth.Fan
+	assert.NoError(t, store.DB.Where("username = ?", "source-fan").First(&fan).Error)
+	assert.Equal(t, "t.co", fan.SourceReferrer)
+	assert.Equal(t, "twitter", fan.SourceUTMSource)
+	assert.Equal(t, "source-attribution-test", fan.SourceUTMCampaign)
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/tracking/sources?limit=100", nil, adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var sources []tracking.SourceCount
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sources))
+	assert.Contains(t, sources, tracking.SourceCount{
+		Source: "twitter", Medium: "social", Campaign: "source-attribution-test",
+		Sessions: 1, Visitors: 1, Registrations: 1,
+	})
+}
+
+func TestRegistrationFunnel(t *testing.T) {
+	r := setupRouter(t)
+	adminCookies := createSessionCookies(t, "funnel-admin", true)
+
+	start, _ := json.Marshal(map[string]string{"session_id": "funnel-session", "utm_source": "funnel-test"})
+	w := performRequest(r, http.MethodPost, "/api/tracking/start", start)
+	assert.Equal(t, http.StatusOK, w.Code)
+	visitorCookie := findCookie(w, "visitor_id")
+	if visitorCookie == nil {
+		t.Fatalf("expected visitor cookie to be issued")
+	}
+
+	// Landing and registering are recorded by the server
+	step, _ := json.Marshal(map[string]string{"session_id": "funnel-session", "step": "registered"})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/tracking/funnel", step, visitorCookie)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	for _, name := range []string{"popup_shown", "register_form"} {
+		step, _ := json.Marshal(map[string]string{"session_id": "funnel-session", "step": name})
+		w = performRequestWithCookies(r, http.MethodPost, "/api/tracking/funnel", step, visitorCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	register, _ := json.Marshal(map[string]string{
+		"username": "funnel-fan",
+		"email":    "funnel-fan@example.com",
+		"password": "password123",
+	})
+	w = performRequestWithCookies(r, http.MethodPost, "/api/auth/register", register, visitorCookie)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// The verification link is opened without the visitor cookie
+	var fan auth.Fan
+	assert.NoError(t, store.DB.Where("username = ?", "funnel-fan").First(&fan).Error)
+	w = performRequest(r, http.MethodGet, "/api/auth/verify-email?token="+fan.VerificationToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/tracking/funnel?source=funnel-test", nil, adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var funnel tracking.Funnel
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &funnel))
+	assert.Equal(t, "funnel-test", funnel.Source)
+	if assert.Len(t, funnel.Steps, len(tracking.FunnelSteps)) {
+		for _, step := range funnel.Steps {
+			assert.Equal(t, int64(1), step.Count, step.Step)
+			assert.Equal(t, 1.0, step.ConversionRate, step.Step)
+		}
+	}
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/tracking/funnel?source=funnel-test&from=bad", nil, adminCookies...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestBatchStartEntersFunnel(t *testing.T) {
+	r := setupRouter(t)
+	adminCookies := createSessionCookies(t, "batch-funnel-admin", true)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"session_id": "batch-funnel-session",
+		"ops": []map[string]interface{}{
+			{"id": "op-1", "type": "start", "utm_source": "batch-funnel-test"},
+			{"id": "op-2", "type": "update"},
+		},
+	})
+	w := performRequest(r, http.MethodPost, "/api/tracking/batch", body)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = performRequestWithCookies(r, http.MethodGet, "/api/tracking/funnel?source=batch-funnel-test", nil, adminCookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var funnel tracking.Funnel
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &funnel))
+	if assert.NotEmpty(t, funnel.Steps) {
+		assert.Equal(t, tracking.FunnelLanding, funnel.Steps[0].Step)
+		assert.Equal(t, int64(1), funnel.Steps[0].Count)
+	}
+}
+
+func TestClientBreakdown(t *testing.T) {
+	r := setupRouter(t)
+	adminCookies := createSessionCookies(t, "clients-admin", true)
+
+	breakdown := func() tracking.ClientBreakdown {
+		t.Helper()
+		w := performRequestWithCookies(r, http.MethodGet, "/api/tracking/clients", nil, adminCookies...)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var result tracking.ClientBreakdown
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+	sessionsOf := func(counts []tracking.ClientCount, name string) int64 {
+		for _, count := range counts {
+			if count.Name == name {
+				return count.Sessions
+			}
+		}
+		return 0
+	}
+	before := breakdown()
+
+	for sessionID, userAgent := range map[string]string{
+		"clients-firefox":   "Mozilla/5.0 (Android 14; Mobile; rv:128.0) Gecko/128.0 Firefox/128.0",
+		"clients-googlebot": "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+	} {
+		body, _ := json.Marshal(map[string]string{"session_id": sessionID})
+		req := httptest.NewRequest(http.MethodPost, "/api/tracking/start", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"browser"`)
+	}
+
+	after := breakdown()
+	assert.Equal(t, before.Sessions+1, after.Sessions)
+	assert.Equal(t, before.Bots+1, after.Bots)
+	assert.Equal(t, sessionsOf(before.Browsers, "Firefox")+1, sessionsOf(after.Browsers, "Firefox"))
+	assert.Equal(t, sessionsOf(before.OS, "Android")+1, sessionsOf(after.OS, "Android"))
+	assert.Equal(t, sessionsOf(before.Devices, tracking.DeviceMobile)+1, sessionsOf(after.Devices, tracking.DeviceMobile))
+
+	w := performRequestWithCookies(r, http.MethodGet, "/api/tracking/clients?from=2026-03-10&to=2026-03-01", nil, adminCookies...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,166 @@
+package statistics
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"anonchihaya.co.uk/internal/tracking"
+)
+
+// DefaultOverviewTTL is how long a computed overview is served before it is recomputed
+const DefaultOverviewTTL = 30 * time.Second
+
+// overviewRefreshInterval is how long an overview is still served after sessions are
+// finalized, so a busy site recomputes it at most this often instead of on every one
+const overviewRefreshInterval = 5 * time.Second
+
+// Overview is the overall statistics shown on the community page
+type Overview struct {
+	TotalUsers             int64     `json:"total_users"`
+	UniqueVisitorsEver     int64     `json:"unique_visitors_ever"`
+	UniqueVisitors24h      int64     `json:"unique_visitors_24h"`
+	RegisteredVisitorsEver int64     `json:"registered_visitors_ever"`
+	GuestVisitorsEver      int64     `json:"guest_visitors_ever"`
+	RegisteredVisitors24h  int64     `json:"registered_visitors_24h"`
+	GuestVisitors24h       int64     `json:"guest_visitors_24h"`
+	ActiveUsersToday       int64     `json:"active_users_today"`
+	TotalHours             float64   `json:"total_hours"`
+	GeneratedAt            time.Time `json:"generated_at"`
+}
+
+// OverviewCache holds the computed overview per time zone for a TTL.
+// Concurrent requests for the same zone share one computation. Finalized tracking
+// sessions mark the cached overviews stale, and stale ones are recomputed once they
+// are overviewRefreshInterval old.
+type OverviewCache struct {
+	statsRepo    *StatisticsRepository
+	trackingRepo *tracking.FanTrackingRepository
+	ttl          time.Duration // 0 disables caching
+
+	mu         sync.Mutex
+	entries    map[string]overviewEntry // Keyed by zone name
+	calls      map[string]*overviewCall // Computations in flight
+	generation uint64                   // Bumped on invalidation
+}
+
+// overviewEntry is a cached overview and the generation it was computed in
+type overviewEntry struct {
+	overview   *Overview
+	generation uint64
+}
+
+// overviewCall is a computation shared by the requests waiting on it
+type overviewCall struct {
+	done     chan struct{}
+	overview *Overview
+	err      error
+}
+
+// NewOverviewCache creates an overview cache and subscribes it to finalized sessions
+func NewOverviewCache(statsRepo *StatisticsRepository, trackingRepo *tracking.FanTrackingRepository, ttl time.Duration) *OverviewCache {
+	cache := &OverviewCache{
+		statsRepo:    statsRepo,
+		trackingRepo: trackingRepo,
+		ttl:          ttl,
+		entries:      make(map[string]overviewEntry),
+		calls:        make(map[string]*overviewCall),
+	}
+	trackingRepo.OnSessionsFinalized(cache.Invalidate)
+	return cache
+}
+
+// Get returns the overview for loc, computing it if the cached one is missing or expired
+func (c *OverviewCache) Get(loc *time.Location) (*Overview, error) {
+	key := loc.String()
+
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && c.fresh(entry) {
+		c.mu.Unlock()
+		return entry.overview, nil
+	}
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.overview, call.err
+	}
+	call := &overviewCall{done: make(chan struct{})}
+	c.calls[key] = call
+	generation := c.generation
+	c.mu.Unlock()
+
+	c.run(key, loc, call, generation)
+	return call.overview, call.err
+}
+
+// run computes the overview of a call and completes it, releasing its waiters even
+// if the computation panics
+func (c *OverviewCache) run(key string, loc *time.Location, call *overviewCall, generation uint64) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.overview, call.err = nil, fmt.Errorf("computing overview panicked: %v", r)
+		}
+
+		c.mu.Lock()
+		delete(c.calls, key)
+		// A result computed across an invalidation keeps the older generation, so it is stale
+		if call.err == nil && c.ttl > 0 {
+			c.entries[key] = overviewEntry{overview: call.overview, generation: generation}
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.overview, call.err = c.compute(loc)
+}
+
+// Invalidate marks all cached overviews stale
+func (c *OverviewCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+}
+
+// fresh reports whether a cached overview can still be served: it is within the TTL,
+// and if it has gone stale, within the refresh interval
+func (c *OverviewCache) fresh(entry overviewEntry) bool {
+	age := c.statsRepo.now().Sub(entry.overview.GeneratedAt)
+	if age >= c.ttl {
+		return false
+	}
+	return entry.generation == c.generation || age < overviewRefreshInterval
+}
+
+func (c *OverviewCache) compute(loc *time.Location) (*Overview, error) {
+	overview := &Overview{GeneratedAt: c.statsRepo.now()}
+	var err error
+
+	if overview.TotalUsers, err = c.statsRepo.GetTotalFans(); err != nil {
+		return nil, fmt.Errorf("total fans: %w", err)
+	}
+	if overview.UniqueVisitorsEver, err = c.statsRepo.GetUniqueVisitors(); err != nil {
+		return nil, fmt.Errorf("unique visitors: %w", err)
+	}
+	if overview.UniqueVisitors24h, err = c.statsRepo.GetUniqueVisitorsLast24Hours(); err != nil {
+		return nil, fmt.Errorf("24h visitors: %w", err)
+	}
+	if overview.RegisteredVisitorsEver, err = c.statsRepo.GetRegisteredVisitorsEver(); err != nil {
+		return nil, fmt.Errorf("registered visitors: %w", err)
+	}
+	if overview.GuestVisitorsEver, err = c.statsRepo.GetGuestVisitorsEver(); err != nil {
+		return nil, fmt.Errorf("guest visitors: %w", err)
+	}
+	if overview.RegisteredVisitors24h, err = c.statsRepo.GetRegisteredVisitorsLast24Hours(); err != nil {
+		return nil, fmt.Errorf("registered visitors 24h: %w", err)
+	}
+	if overview.GuestVisitors24h, err = c.statsRepo.GetGuestVisitorsLast24Hours(); err != nil {
+		return nil, fmt.Errorf("guest visitors 24h: %w", err)
+	}
+	if overview.ActiveUsersToday, err = c.statsRepo.GetActiveUsersToday(loc); err != nil {
+		return nil, fmt.Errorf("active users today: %w", err)
+	}
+	if overview.TotalHours, err = c.trackingRepo.GetTotalHours(); err != nil {
+		return nil, fmt.Errorf("total hours: %w", err)
+	}
+	return overview, nil
+}
@@ -0,0 +1,141 @@
+package statistics
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/tracking"
+)
+
+func setupOverviewCache(t *testing.T, now *time.Time, ttl time.Duration) (*OverviewCache, *tracking.FanTrackingRepository) {
+	t.Helper()
+
+	repo := setupStatisticsRepo(t, *now)
+	repo.now = func() time.Time { return *now }
+	if err := repo.db.AutoMigrate(&auth.Fan{}, &tracking.FanTracking{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	trackingRepo := tracking.NewFanTrackingRepository(repo.db)
+	return NewOverviewCache(repo, trackingRepo, ttl), trackingRepo
+}
+
+func TestOverviewCacheExpiresAfterTTL(t *testing.T) {
+	now := time.Now()
+	cache, _ := setupOverviewCache(t, &now, time.Minute)
+
+	first, err := cache.Get(time.UTC)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !first.GeneratedAt.Equal(now) {
+		t.Fatalf("generated_at = %v, want %v", first.GeneratedAt, now)
+	}
+
+	seedVisit(t, cache.statsRepo, nil, "ttl-session", now.Add(-time.Minute))
+
+	cached, err := cache.Get(time.UTC)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if cached != first {
+		t.Fatalf("expected the cached overview within the TTL")
+	}
+
+	now = now.Add(time.Minute)
+	fresh, err := cache.Get(time.UTC)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if fresh.UniqueVisitorsEver != first.UniqueVisitorsEver+1 {
+		t.Fatalf("unique visitors = %d, want %d", fresh.UniqueVisitorsEver, first.UniqueVisitorsEver+1)
+	}
+	if !fresh.GeneratedAt.Equal(now) {
+		t.Fatalf("generated_at = %v, want %v", fresh.GeneratedAt, now)
+	}
+}
+
+func TestOverviewCacheInvalidatedWhenSessionEnds(t *testing.T) {
+	now := time.Now()
+	cache, trackingRepo := setupOverviewCache(t, &now, time.Hour)
+
+	before, err := cache.Get(time.UTC)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if _, err := trackingRepo.StartTracking(nil, "visitor-1", "finalize-session", tracking.ClientInfo{}); err != nil {
+		t.Fatalf("StartTracking: %v", err)
+	}
+	if overview, _ := cache.Get(time.UTC); overview != before {
+		t.Fatalf("starting a session should not invalidate the overview")
+	}
+
+	if err := trackingRepo.EndTracking("finalize-session", nil); err != nil {
+		t.Fatalf("EndTracking: %v", err)
+	}
+	if overview, _ := cache.Get(time.UTC); overview != before {
+		t.Fatalf("expected the stale overview within the refresh interval")
+	}
+
+	now = now.Add(overviewRefreshInterval)
+	after, err := cache.Get(time.UTC)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if after.UniqueVisitorsEver != before.UniqueVisitorsEver+1 {
+		t.Fatalf("unique visitors = %d, want %d", after.UniqueVisitorsEver, before.UniqueVisitorsEver+1)
+	}
+
+	// Many sessions ending at once lead to a single recomputation
+	for i := 0; i < 3; i++ {
+		cache.Invalidate()
+		if overview, _ := cache.Get(time.UTC); overview != after {
+			t.Fatalf("expected invalidation %d to be debounced", i)
+		}
+	}
+}
+
+func TestOverviewCacheSharesConcurrentComputations(t *testing.T) {
+	now := time.Now()
+	cache, _ := setupOverviewCache(t, &now, time.Hour)
+
+	results := make([]*Overview, 16)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			overview, err := cache.Get(time.UTC)
+			if err != nil {
+				t.Errorf("Get: %v", err)
+			}
+			results[i] = overview
+		}()
+	}
+	wg.Wait()
+
+	for i, overview := range results {
+		if overview != results[0] {
+			t.Fatalf("result %d was computed separately", i)
+		}
+	}
+}
+
+func TestOverviewCacheRecoversFromPanics(t *testing.T) {
+	now := time.Now()
+	cache, _ := setupOverviewCache(t, &now, time.Hour)
+
+	cache.statsRepo.now = func() time.Time { panic("clock broke") }
+	if _, err := cache.Get(time.UTC); err == nil {
+		t.Fatalf("expected the panic to be returned as an error")
+	}
+
+	// The failed call doesn't keep later requests waiting
+	cache.statsRepo.now = func() time.Time { return now }
+	if _, err := cache.Get(time.UTC); err != nil {
+		t.Fatalf("Get after the panic: %v", err)
+	}
+}
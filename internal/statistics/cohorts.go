@@ -0,0 +1,162 @@
+package statistics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"anonchihaya.co.uk/internal/tracking"
+)
+
+// Cohort granularities
+const (
+	CohortWeek  = "week"
+	CohortMonth = "month"
+)
+
+// Cohort is the fans who signed up in one period and how many of them were active in
+// each period since
+type Cohort struct {
+	Start     string         `json:"start"` // First day of the signup period
+	Size      int64          `json:"size"`
+	Retention []CohortPeriod `json:"retention"` // Period 0 is the signup period, up to the current one
+}
+
+// CohortPeriod is the activity of a cohort in the period'th period after signup
+type CohortPeriod struct {
+	Period     int     `json:"period"`
+	Active     int64   `json:"active"`
+	Percentage float64 `json:"percentage"`
+}
+
+// GetCohorts groups the fans who signed up between from and to by signup week or month
+// and returns, for each later period, the share of them with tracked activity. Activity
+// is read from the rollups, so it includes tracking compacted by retention. Signups and
+// activity are both placed in periods in loc, and weeks start on Monday.
+func (r *StatisticsRepository) GetCohorts(granularity string, from, to time.Time, loc *time.Location) ([]Cohort, error) {
+	if granularity != CohortWeek && granularity != CohortMonth {
+		return nil, errors.New("granularity must be week or month")
+	}
+
+	from = periodStart(from.In(loc), granularity)
+	to = nextPeriod(periodStart(to.In(loc), granularity), granularity)
+
+	// Every period from the first cohort's up to the current one. Signups and activity
+	// are bucketed into them in SQL, so only counts per cohort and period are loaded.
+	current := periodStart(r.now().In(loc), granularity)
+	var starts []time.Time
+	for start := from; !start.After(current); start = nextPeriod(start, granularity) {
+		starts = append(starts, start)
+	}
+	if len(starts) == 0 {
+		return []Cohort{}, nil
+	}
+
+	// Signup times are written in the server's zone, so they are compared in it
+	cohortExpr, cohortArgs := periodIndex("users.created_at", starts, func(t time.Time) interface{} { return t.In(time.Local) })
+	var sizes []struct {
+		Cohort int
+		Size   int64
+	}
+	if err := r.db.Table("users").
+		Select(cohortExpr+" AS cohort, COUNT(*) AS size", cohortArgs...).
+		Where("created_at >= ? AND created_at < ?", from.In(time.Local), to.In(time.Local)).
+		Group("cohort").
+		Order("cohort").
+		Scan(&sizes).Error; err != nil {
+		return nil, err
+	}
+
+	slotExpr, slotArgs := periodIndex("tracking_rollups.slot", starts, func(t time.Time) interface{} { return tracking.RollupSlot(t) })
+	var active []struct {
+		Cohort int
+		Period int
+		Active int64
+	}
+	if err := r.rollups().
+		Select(cohortExpr+" AS cohort, "+slotExpr+" AS period, COUNT(DISTINCT tracking_rollups.user_id) AS active", append(cohortArgs, slotArgs...)...).
+		Joins("JOIN users ON users.id = tracking_rollups.user_id").
+		Where("users.created_at >= ? AND users.created_at < ?", from.In(time.Local), to.In(time.Local)).
+		Where("tracking_rollups.slot >= ?", tracking.RollupSlot(from)).
+		Group("cohort, period").
+		Scan(&active).Error; err != nil {
+		return nil, err
+	}
+
+	activeFans := make(map[int]map[int]int64)
+	for _, row := range active {
+		// Guest activity merged in from before signup is not retention
+		if row.Period < row.Cohort {
+			continue
+		}
+		if activeFans[row.Cohort] == nil {
+			activeFans[row.Cohort] = make(map[int]int64)
+		}
+		activeFans[row.Cohort][row.Period-row.Cohort] = row.Active
+	}
+
+	cohorts := make([]Cohort, 0, len(sizes))
+	for _, size := range sizes {
+		cohort := Cohort{Start: starts[size.Cohort].Format(time.DateOnly), Size: size.Size, Retention: []CohortPeriod{}}
+		for period := 0; size.Cohort+period < len(starts); period++ {
+			count := activeFans[size.Cohort][period]
+			cohort.Retention = append(cohort.Retention, CohortPeriod{
+				Period:     period,
+				Active:     count,
+				Percentage: float64(count) * 100 / float64(size.Size),
+			})
+		}
+		cohorts = append(cohorts, cohort)
+	}
+	return cohorts, nil
+}
+
+// periodIndex returns a SQL expression for the index in starts of the period column
+// falls in, with value converting each start to the column's representation. Values
+// before the first period count as in it.
+func periodIndex(column string, starts []time.Time, value func(time.Time) interface{}) (string, []interface{}) {
+	if len(starts) == 1 {
+		return "0", nil
+	}
+
+	var expr strings.Builder
+	args := make([]interface{}, 0, len(starts)-1)
+	expr.WriteString("CASE")
+	for i, start := range starts[1:] {
+		fmt.Fprintf(&expr, " WHEN %s < ? THEN %d", column, i)
+		args = append(args, value(start))
+	}
+	fmt.Fprintf(&expr, " ELSE %d END", len(starts)-1)
+	return expr.String(), args
+}
+
+// periodStart returns midnight on the first day of t's week or month
+func periodStart(t time.Time, granularity string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if granularity == CohortMonth {
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// nextPeriod returns the start of the period after the one starting at start
+func nextPeriod(start time.Time, granularity string) time.Time {
+	if granularity == CohortMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 7)
+}
+
+// periodsBetween returns how many whole weeks or months the period containing to is
+// after the one starting at from. Both are calendar dates at midnight UTC.
+func periodsBetween(from, to time.Time, granularity string) int {
+	if granularity == CohortMonth {
+		return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+	}
+	days := int(to.Sub(from).Hours() / 24)
+	if days < 0 {
+		return -((-days + 6) / 7)
+	}
+	return days / 7
+}
@@ -0,0 +1,122 @@
+package statistics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+)
+
+func TestCohorts(t *testing.T) {
+	day := func(date string) time.Time {
+		parsed, err := time.ParseInLocation(time.DateOnly, date, time.Local)
+		if err != nil {
+			t.Fatalf("bad date %s: %v", date, err)
+		}
+		return parsed.Add(12 * time.Hour)
+	}
+
+	repo := setupStatisticsRepo(t, day("2026-03-20"))
+	if err := repo.db.AutoMigrate(&auth.Fan{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	signups := map[string]string{"a": "2026-03-02", "b": "2026-03-04", "c": "2026-03-10", "old": "2026-01-05"}
+	ids := make(map[string]uint)
+	for name, date := range signups {
+		fan := auth.Fan{Username: "cohort-" + name, Email: name + "@example.com", CreatedAt: day(date)}
+		if err := repo.db.Create(&fan).Error; err != nil {
+			t.Fatalf("failed to create fan: %v", err)
+		}
+		ids[name] = fan.ID
+	}
+
+	visits := []struct{ fan, date string }{
+		{"a", "2026-02-20"}, // As a guest before signing up
+		{"a", "2026-03-03"},
+		{"a", "2026-03-17"},
+		{"b", "2026-03-11"},
+		{"c", "2026-03-12"},
+		{"c", "2026-03-16"},
+		{"c", "2026-03-16"},
+	}
+	for i, visit := range visits {
+		fanID := ids[visit.fan]
+		seedVisit(t, repo, &fanID, fmt.Sprintf("cohort-%d", i), day(visit.date))
+	}
+
+	weeks, err := repo.GetCohorts(CohortWeek, day("2026-03-01"), day("2026-03-20"), time.Local)
+	if err != nil {
+		t.Fatalf("GetCohorts failed: %v", err)
+	}
+	want := []struct {
+		start  string
+		size   int64
+		active []int64
+	}{
+		{"2026-03-02", 2, []int64{1, 1, 1}},
+		{"2026-03-09", 1, []int64{1, 1}},
+	}
+	if len(weeks) != len(want) {
+		t.Fatalf("expected %d weekly cohorts, got %+v", len(want), weeks)
+	}
+	for i, w := range want {
+		cohort := weeks[i]
+		if cohort.Start != w.start || cohort.Size != w.size || len(cohort.Retention) != len(w.active) {
+			t.Fatalf("cohort %d = %+v, want start %s size %d", i, cohort, w.start, w.size)
+		}
+		for period, active := range w.active {
+			if cohort.Retention[period].Active != active {
+				t.Errorf("cohort %s period %d: active = %d, want %d", w.start, period, cohort.Retention[period].Active, active)
+			}
+		}
+	}
+	if weeks[0].Retention[1].Percentage != 50 {
+		t.Errorf("percentage = %v, want 50", weeks[0].Retention[1].Percentage)
+	}
+
+	months, err := repo.GetCohorts(CohortMonth, day("2026-03-01"), day("2026-03-20"), time.Local)
+	if err != nil {
+		t.Fatalf("GetCohorts failed: %v", err)
+	}
+	if len(months) != 1 || months[0].Start != "2026-03-01" || months[0].Size != 3 {
+		t.Fatalf("unexpected monthly cohorts: %+v", months)
+	}
+	if len(months[0].Retention) != 1 || months[0].Retention[0].Active != 3 || months[0].Retention[0].Percentage != 100 {
+		t.Fatalf("unexpected monthly retention: %+v", months[0].Retention)
+	}
+
+	if _, err := repo.GetCohorts("day", day("2026-03-01"), day("2026-03-20"), time.Local); err == nil {
+		t.Fatalf("expected an error for an unknown granularity")
+	}
+}
+
+func TestCohortsUseOneZone(t *testing.T) {
+	tokyo := mustLoad(t, "Asia/Tokyo")
+	repo := setupStatisticsRepo(t, time.Date(2026, 3, 20, 12, 0, 0, 0, tokyo))
+	if err := repo.db.AutoMigrate(&auth.Fan{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	// Sunday evening in UTC is Monday morning in Tokyo, for the signup and the visit alike.
+	// Signup times are written in the server's zone, like GORM's own timestamps.
+	fan := auth.Fan{Username: "cohort-tokyo", Email: "tokyo@example.com", CreatedAt: time.Date(2026, 3, 8, 20, 0, 0, 0, time.UTC).In(time.Local)}
+	if err := repo.db.Create(&fan).Error; err != nil {
+		t.Fatalf("failed to create fan: %v", err)
+	}
+	seedVisit(t, repo, &fan.ID, "cohort-tokyo", time.Date(2026, 3, 8, 21, 0, 0, 0, time.UTC))
+
+	for _, zone := range []struct {
+		loc   *time.Location
+		start string
+	}{{tokyo, "2026-03-09"}, {time.UTC, "2026-03-02"}} {
+		cohorts, err := repo.GetCohorts(CohortWeek, time.Date(2026, 3, 1, 0, 0, 0, 0, zone.loc), time.Date(2026, 3, 20, 0, 0, 0, 0, zone.loc), zone.loc)
+		if err != nil {
+			t.Fatalf("GetCohorts failed: %v", err)
+		}
+		if len(cohorts) != 1 || cohorts[0].Start != zone.start || cohorts[0].Retention[0].Active != 1 {
+			t.Fatalf("expected the fan active in their signup week %s in %s, got %+v", zone.start, zone.loc, cohorts)
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package statistics
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"anonchihaya.co.uk/internal/auth"
 	"anonchihaya.co.uk/internal/tracking"
@@ -12,99 +17,99 @@ import (
 type StatisticsHandler struct {
 	statsRepo    *StatisticsRepository
 	trackingRepo *tracking.FanTrackingRepository
+	overview     *OverviewCache
 }
 
-func NewStatisticsHandler(statsRepo *StatisticsRepository, trackingRepo *tracking.FanTrackingRepository) *StatisticsHandler {
+func NewStatisticsHandler(statsRepo *StatisticsRepository, trackingRepo *tracking.FanTrackingRepository, overview *OverviewCache) *StatisticsHandler {
 	return &StatisticsHandler{
 		statsRepo:    statsRepo,
 		trackingRepo: trackingRepo,
+		overview:     overview,
 	}
 }
 
 // GetOverallStatistics godoc
 // @Summary Overall statistics
+// @Description "Today" is taken in the tz zone, else the signed-in fan's zone, else the server's.
+// @Description The overview is cached briefly; generated_at is when it was computed.
 // @Tags statistics
 // @Produce json
+// @Param tz query string false "IANA time zone, e.g. Europe/London"
 // @Success 200 {object} OverallStatisticsResponse
+// @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /statistics/overall [get]
 func (h *StatisticsHandler) GetOverallStatistics(c *gin.Context) {
-	totalFans, err := h.statsRepo.GetTotalFans()
+	loc, err := requestLocation(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get total fans"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	uniqueVisitors, err := h.statsRepo.GetUniqueVisitors()
+	overview, err := h.overview.Get(loc)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get unique visitors"})
+		log.Printf("Warning: Failed to compute statistics overview: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get overall statistics"})
 		return
 	}
 
-	visitors24h, err := h.statsRepo.GetUniqueVisitorsLast24Hours()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get 24h visitors"})
-		return
-	}
-
-	registeredEver, err := h.statsRepo.GetRegisteredVisitorsEver()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get registered visitors"})
-		return
-	}
-
-	guestEver, err := h.statsRepo.GetGuestVisitorsEver()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get guest visitors"})
-		return
-	}
+	c.JSON(http.StatusOK, overview)
+}
 
-	registered24h, err := h.statsRepo.GetRegisteredVisitorsLast24Hours()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get registered visitors 24h"})
+// GetUserStreak godoc
+// @Summary Current user streak
+// @Description The current and longest runs of consecutive active days and the last active day.
+// @Description Days are counted in the tz zone, else the fan's zone, else the server's.
+// @Tags statistics
+// @Produce json
+// @Param tz query string false "IANA time zone, e.g. Europe/London"
+// @Success 200 {object} StreakResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /statistics/streak [get]
+func (h *StatisticsHandler) GetUserStreak(c *gin.Context) {
+	fan, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
 		return
 	}
 
-	guest24h, err := h.statsRepo.GetGuestVisitorsLast24Hours()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get guest visitors 24h"})
+	f, ok := fan.(*auth.Fan)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid fan context"})
 		return
 	}
 
-	activeToday, err := h.statsRepo.GetActiveUsersToday()
+	loc, err := requestLocation(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active users today"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	totalHours, err := h.trackingRepo.GetTotalHours()
+	summary, err := h.statsRepo.GetFanStreakSummary(f.ID, loc)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get total hours"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get fan streak"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"total_users":              totalFans,
-		"unique_visitors_ever":     uniqueVisitors,
-		"unique_visitors_24h":      visitors24h,
-		"registered_visitors_ever": registeredEver,
-		"guest_visitors_ever":      guestEver,
-		"registered_visitors_24h":  registered24h,
-		"guest_visitors_24h":       guest24h,
-		"active_users_today":       activeToday,
-		"total_hours":              totalHours,
-	})
+	c.JSON(http.StatusOK, summary)
 }
 
-// GetUserStreak godoc
-// @Summary Current user streak
+// GetUserCalendar godoc
+// @Summary Current user's active days
+// @Description The days of a year on which the fan was active, for a contribution-style heatmap.
+// @Description Days are taken in the tz zone, else the fan's zone, else the server's.
 // @Tags statistics
 // @Produce json
-// @Success 200 {object} StreakResponse
+// @Param year query int false "Year, defaults to the current one"
+// @Param tz query string false "IANA time zone, e.g. Europe/London"
+// @Success 200 {object} statistics.Calendar
+// @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /statistics/streak [get]
-func (h *StatisticsHandler) GetUserStreak(c *gin.Context) {
+// @Router /statistics/calendar [get]
+func (h *StatisticsHandler) GetUserCalendar(c *gin.Context) {
 	fan, exists := c.Get("user")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
@@ -117,15 +122,29 @@ func (h *StatisticsHandler) GetUserStreak(c *gin.Context) {
 		return
 	}
 
-	streak, err := h.statsRepo.GetFanStreak(f.ID)
+	loc, err := requestLocation(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get fan streak"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"streak": streak,
-	})
+	year := time.Now().In(loc).Year()
+	if y := c.Query("year"); y != "" {
+		parsed, err := parseIntQuery(y)
+		if err != nil || parsed < 2000 || parsed > 9999 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid year"})
+			return
+		}
+		year = parsed
+	}
+
+	calendar, err := h.statsRepo.GetFanCalendar(f.ID, year, loc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get activity calendar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, calendar)
 }
 
 // GetUsersOverTime godoc
@@ -133,10 +152,18 @@ func (h *StatisticsHandler) GetUserStreak(c *gin.Context) {
 // @Tags statistics
 // @Produce json
 // @Param hours query int false "Hours (1-168)" default(24)
+// @Param tz query string false "IANA time zone for the hour buckets, e.g. Europe/London"
 // @Success 200 {array} repositories.FansOverTimePoint
+// @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /statistics/users-over-time [get]
 func (h *StatisticsHandler) GetUsersOverTime(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	hours := 24 // default
 	if h := c.Query("hours"); h != "" {
 		if parsed, err := parseIntQuery(h); err == nil && parsed > 0 && parsed <= 168 {
@@ -144,7 +171,7 @@ func (h *StatisticsHandler) GetUsersOverTime(c *gin.Context) {
 		}
 	}
 
-	data, err := h.statsRepo.GetFansOverTime(hours)
+	data, err := h.statsRepo.GetFansOverTime(hours, loc)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get visitors over time"})
 		return
@@ -158,10 +185,18 @@ func (h *StatisticsHandler) GetUsersOverTime(c *gin.Context) {
 // @Tags statistics
 // @Produce json
 // @Param days query int false "Days (1-365)" default(30)
+// @Param tz query string false "IANA time zone for the day buckets, e.g. Europe/London"
 // @Success 200 {array} repositories.DailyActiveUsersPoint
+// @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /statistics/daily-active [get]
 func (h *StatisticsHandler) GetDailyActiveUsers(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	days := 30 // default
 	if d := c.Query("days"); d != "" {
 		if parsed, err := parseIntQuery(d); err == nil && parsed > 0 && parsed <= 365 {
@@ -169,7 +204,7 @@ func (h *StatisticsHandler) GetDailyActiveUsers(c *gin.Context) {
 		}
 	}
 
-	data, err := h.statsRepo.GetDailyActiveUsers(days)
+	data, err := h.statsRepo.GetDailyActiveUsers(days, loc)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get daily active users"})
 		return
@@ -178,6 +213,253 @@ func (h *StatisticsHandler) GetDailyActiveUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, data)
 }
 
+// GetCohorts godoc
+// @Summary Retention cohorts
+// @Description Fans are grouped by signup week (from Monday) or month in tz; each cohort lists the share active in every period since.
+// @Tags statistics
+// @Produce json
+// @Param granularity query string false "Cohort period" Enums(week, month) default(week)
+// @Param from query string false "First signup date (YYYY-MM-DD), defaults to 11 periods before to"
+// @Param to query string false "Last signup date (YYYY-MM-DD), defaults to today"
+// @Param tz query string false "IANA time zone for the periods, e.g. Europe/London"
+// @Success 200 {array} statistics.Cohort
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /statistics/cohorts [get]
+func (h *StatisticsHandler) GetCohorts(c *gin.Context) {
+	granularity := c.DefaultQuery("granularity", CohortWeek)
+	if granularity != CohortWeek && granularity != CohortMonth {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "granularity must be week or month"})
+		return
+	}
+
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	to := time.Now().In(loc)
+	if v := c.Query("to"); v != "" {
+		parsed, err := time.ParseInLocation(time.DateOnly, v, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -7*(defaultCohorts-1))
+	if granularity == CohortMonth {
+		from = to.AddDate(0, -(defaultCohorts - 1), 0)
+	}
+	if v := c.Query("from"); v != "" {
+		parsed, err := time.ParseInLocation(time.DateOnly, v, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+		from = parsed
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+
+	cohorts, err := h.statsRepo.GetCohorts(granularity, from, to, loc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get cohorts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cohorts)
+}
+
+// GetTimeSeries godoc
+// @Summary Metric over time
+// @Description Buckets visitors, registered visitors, fan hours or signups by hour, day, week (from Monday) or month. Empty buckets are returned as zero.
+// @Description With compare, every point also has the value of the matching bucket in the period just before, and previous has that period's total.
+// @Tags statistics
+// @Produce json
+// @Param metric query string false "Metric" Enums(visitors, registered_visitors, hours, signups) default(visitors)
+// @Param granularity query string false "Bucket size" Enums(hour, day, week, month) default(day)
+// @Param from query string false "Start (RFC3339 or YYYY-MM-DD in tz), defaults to 24 hours, 30 days, 12 weeks or 12 months before to"
+// @Param to query string false "End, exclusive (RFC3339 or YYYY-MM-DD in tz), defaults to now"
+// @Param compare query bool false "Include the previous period" default(false)
+// @Param tz query string false "IANA time zone for the buckets, e.g. Europe/London"
+// @Success 200 {object} statistics.TimeSeries
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /statistics/timeseries [get]
+func (h *StatisticsHandler) GetTimeSeries(c *gin.Context) {
+	metric := c.DefaultQuery("metric", MetricVisitors)
+	if !ValidSeriesMetric(metric) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metric must be visitors, registered_visitors, hours or signups"})
+		return
+	}
+	granularity := c.DefaultQuery("granularity", GranularityDay)
+	if !ValidGranularity(granularity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "granularity must be hour, day, week or month"})
+		return
+	}
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	to := time.Now()
+	if v := c.Query("to"); v != "" {
+		if to, err = parseSeriesTime(v, loc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+	}
+	var from time.Time
+	switch granularity {
+	case GranularityHour:
+		from = to.Add(-24 * time.Hour)
+	case GranularityDay:
+		from = to.In(loc).AddDate(0, 0, -30)
+	case GranularityWeek:
+		from = to.In(loc).AddDate(0, 0, -7*12)
+	default:
+		from = to.In(loc).AddDate(0, -12, 0)
+	}
+	if v := c.Query("from"); v != "" {
+		if from, err = parseSeriesTime(v, loc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	compare := false
+	if v := c.Query("compare"); v != "" {
+		if compare, err = strconv.ParseBool(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid compare"})
+			return
+		}
+	}
+
+	series, err := h.statsRepo.GetTimeSeries(metric, granularity, from, to, loc, compare)
+	if errors.Is(err, ErrTooManyPoints) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get time series"})
+		return
+	}
+
+	c.JSON(http.StatusOK, series)
+}
+
+// parseSeriesTime parses an RFC3339 time, or a date as midnight in loc
+func parseSeriesTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, s, loc)
+}
+
+// GetLeaderboard godoc
+// @Summary Fan leaderboard
+// @Description Ranks the fans who opted in (show_on_leaderboard) by total hours, hours since Monday, or current streak in days.
+// @Description me is the signed-in fan's own place, even when it is not on the requested page.
+// @Tags statistics
+// @Produce json
+// @Param metric query string false "Ranking" Enums(total_hours, week_hours, streak) default(total_hours)
+// @Param page query int false "Page (from 1)" default(1)
+// @Param limit query int false "Fans per page (1-100)" default(20)
+// @Param tz query string false "IANA time zone for weeks and streaks, e.g. Europe/London"
+// @Success 200 {object} statistics.Leaderboard
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /statistics/leaderboard [get]
+func (h *StatisticsHandler) GetLeaderboard(c *gin.Context) {
+	metric := c.DefaultQuery("metric", LeaderboardTotalHours)
+	if !ValidLeaderboardMetric(metric) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metric must be total_hours, week_hours or streak"})
+		return
+	}
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page := 1
+	if p := c.Query("page"); p != "" {
+		parsed, err := parseIntQuery(p)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+			return
+		}
+		page = parsed
+	}
+	limit := defaultLeaderboardLimit
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := parseIntQuery(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+
+	var meID uint
+	if fan, exists := c.Get("user"); exists {
+		if f, ok := fan.(*auth.Fan); ok {
+			meID = f.ID
+		}
+	}
+
+	board, err := h.statsRepo.GetLeaderboard(metric, loc, h.trackingRepo.GetFanActiveSeconds, page, limit, meID)
+	if err != nil {
+		log.Printf("Warning: Failed to compute leaderboard: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get leaderboard"})
+		return
+	}
+
+	c.JSON(http.StatusOK, board)
+}
+
+// defaultLeaderboardLimit is how many fans a leaderboard page has without a limit
+const defaultLeaderboardLimit = 20
+
+// defaultCohorts is how many cohorts are returned without a from date
+const defaultCohorts = 12
+
+// requestLocation resolves the time zone for bucketing: the tz query parameter, then
+// the signed-in fan's preference, then the server's local zone
+func requestLocation(c *gin.Context) (*time.Location, error) {
+	if tz := c.Query("tz"); tz != "" {
+		loc, err := loadLocation(tz)
+		if err != nil {
+			return nil, errors.New("invalid tz")
+		}
+		return loc, nil
+	}
+
+	if fan, exists := c.Get("user"); exists {
+		if f, ok := fan.(*auth.Fan); ok && f.Timezone != "" {
+			if loc, err := loadLocation(f.Timezone); err == nil {
+				return loc, nil
+			}
+		}
+	}
+	return time.Local, nil
+}
+
+// loadLocation loads an IANA zone. "Local" is rejected so the result doesn't depend on
+// the server's configuration.
+func loadLocation(name string) (*time.Location, error) {
+	if strings.EqualFold(name, "Local") {
+		return nil, errors.New("unknown time zone " + name)
+	}
+	return time.LoadLocation(name)
+}
+
 func parseIntQuery(s string) (int, error) {
 	var result int
 	_, err := fmt.Sscanf(s, "%d", &result)
@@ -0,0 +1,267 @@
+package statistics
+
+import (
+	"strings"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/tracking"
+	"gorm.io/gorm"
+)
+
+// Leaderboard metrics
+const (
+	LeaderboardTotalHours = "total_hours"
+	LeaderboardWeekHours  = "week_hours"
+	LeaderboardStreak     = "streak"
+)
+
+// LeaderboardEntry is one fan's place on the leaderboard
+type LeaderboardEntry struct {
+	Rank         int     `json:"rank"` // Fans with equal values share a rank
+	FanID        uint    `json:"user_id"`
+	Username     string  `json:"username"`
+	ProfilePhoto string  `json:"profile_photo"`
+	Value        float64 `json:"value"` // Hours, or days for streaks
+}
+
+// Leaderboard is one page of the leaderboard
+type Leaderboard struct {
+	Metric  string             `json:"metric"`
+	Page    int                `json:"page"`
+	Limit   int                `json:"limit"`
+	Total   int                `json:"total"` // Fans on the leaderboard
+	Entries []LeaderboardEntry `json:"entries"`
+	Me      *LeaderboardEntry  `json:"me"` // The caller's place, on this page or not; null unless they opted in
+}
+
+// ActiveSeconds returns the time in each fan's open sessions started at or after since
+type ActiveSeconds func(since time.Time) (map[uint]int64, error)
+
+// ValidLeaderboardMetric reports whether fans can be ranked by metric
+func ValidLeaderboardMetric(metric string) bool {
+	switch metric {
+	case LeaderboardTotalHours, LeaderboardWeekHours, LeaderboardStreak:
+		return true
+	}
+	return false
+}
+
+// GetLeaderboard returns one page of the fans who opted in, ranked by metric, highest
+// first, with ties in username order. Hours this week count sessions started since
+// Monday in loc and streaks count days in loc. active adds the sessions still open to
+// the hours. meID, when not 0, is the fan to return in Me.
+// Ranking, paging and the caller's place are worked out in SQL, so only the page is
+// loaded.
+func (r *StatisticsRepository) GetLeaderboard(metric string, loc *time.Location, active ActiveSeconds, page, limit int, meID uint) (*Leaderboard, error) {
+	board := &Leaderboard{Metric: metric, Page: page, Limit: limit, Entries: []LeaderboardEntry{}}
+
+	var total int64
+	if err := r.db.Model(&auth.Fan{}).Where("show_on_leaderboard = ?", true).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	board.Total = int(total)
+	if total == 0 {
+		return board, nil
+	}
+
+	var values *gorm.DB
+	var err error
+	if metric == LeaderboardStreak {
+		values, err = r.leaderboardStreaks(loc)
+	} else {
+		values, err = r.leaderboardSeconds(metric, loc, active)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []leaderboardRow
+	if err := r.db.Table("(?) AS board", values).
+		Order("value DESC, username ASC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		entry := row.entry(metric)
+		switch {
+		case i > 0 && row.Value == rows[i-1].Value:
+			entry.Rank = board.Entries[i-1].Rank
+		case i > 0:
+			entry.Rank = (page-1)*limit + i + 1
+		default:
+			if entry.Rank, err = r.leaderboardRank(values, row.Value); err != nil {
+				return nil, err
+			}
+		}
+		board.Entries = append(board.Entries, entry)
+	}
+
+	if meID != 0 {
+		var me []leaderboardRow
+		if err := r.db.Table("(?) AS board", values).Where("fan_id = ?", meID).Scan(&me).Error; err != nil {
+			return nil, err
+		}
+		if len(me) == 1 {
+			entry := me[0].entry(metric)
+			if entry.Rank, err = r.leaderboardRank(values, me[0].Value); err != nil {
+				return nil, err
+			}
+			board.Me = &entry
+		}
+	}
+	return board, nil
+}
+
+// leaderboardRow is an opted-in fan with their value in seconds, or days for streaks
+type leaderboardRow struct {
+	FanID        uint
+	Username     string
+	ProfilePhoto string
+	Value        int64
+}
+
+func (row leaderboardRow) entry(metric string) LeaderboardEntry {
+	entry := LeaderboardEntry{FanID: row.FanID, Username: row.Username, ProfilePhoto: row.ProfilePhoto, Value: float64(row.Value)}
+	if metric != LeaderboardStreak {
+		entry.Value /= 3600.0
+	}
+	return entry
+}
+
+// leaderboardRank returns the place of a value: one more than the fans ranked above it,
+// so fans with equal values share it
+func (r *StatisticsRepository) leaderboardRank(values *gorm.DB, value int64) (int, error) {
+	var above int64
+	err := r.db.Table("(?) AS board", values).Where("value > ?", value).Count(&above).Error
+	return int(above) + 1, err
+}
+
+// leaderboardFans starts the query of the leaderboard's values on the opted-in fans
+func (r *StatisticsRepository) leaderboardFans() *gorm.DB {
+	return r.db.Table("users").Where("users.show_on_leaderboard = ?", true)
+}
+
+// leaderboardSeconds selects the seconds of each opted-in fan, in total or since the
+// start of the week, with the time in their open sessions added
+func (r *StatisticsRepository) leaderboardSeconds(metric string, loc *time.Location, active ActiveSeconds) (*gorm.DB, error) {
+	query := r.leaderboardFans()
+	var since time.Time
+	if metric == LeaderboardWeekHours {
+		since = periodStart(r.now().In(loc), CohortWeek)
+		query = query.Joins("LEFT JOIN (?) AS totals ON totals.user_id = users.id",
+			r.rollupsSince(since).
+				Select("user_id, SUM(seconds) AS seconds").
+				Where("user_id IS NOT NULL").
+				Group("user_id"))
+	} else {
+		query = query.Joins("LEFT JOIN (?) AS totals ON totals.user_id = users.id",
+			r.visitorRollups().
+				Select("user_id, seconds").
+				Where("user_id IS NOT NULL"))
+	}
+
+	// Add sessions that haven't been rolled up yet; only fans online now have any
+	open, err := active(since)
+	if err != nil {
+		return nil, err
+	}
+	value, args := perFanValues("COALESCE(totals.seconds, 0) + ", open, "0")
+	return query.Select("users.id AS fan_id, users.username, users.profile_photo, "+value+" AS value", args...), nil
+}
+
+// leaderboardStreaks selects the current streak of each opted-in fan. Streak records
+// kept in loc are used as they are; the streaks of fans without one are counted from
+// their recent rollups.
+func (r *StatisticsRepository) leaderboardStreaks(loc *time.Location) (*gorm.DB, error) {
+	zones := []string{loc.String()}
+	if loc == time.Local {
+		zones = append(zones, "")
+	}
+	yesterday := civilDate(r.now(), loc).AddDate(0, 0, -1).Format(time.DateOnly)
+	recorded := "CASE WHEN fan_streaks.timezone IN ? AND fan_streaks.last_active_day >= ? THEN fan_streaks.current ELSE 0 END"
+
+	// A streak only continues if it reached yesterday, so only fans active since then
+	// can have one to count
+	var uncounted []uint
+	if err := r.rollups().
+		Joins("JOIN users ON users.id = tracking_rollups.user_id AND users.show_on_leaderboard = ?", true).
+		Where("tracking_rollups.slot >= ?", tracking.RollupSlot(startOfDay(r.now(), loc).AddDate(0, 0, -1))).
+		Where("NOT EXISTS (?)", r.db.Model(&tracking.FanStreak{}).
+			Select("1").
+			Where("fan_streaks.user_id = tracking_rollups.user_id AND fan_streaks.timezone IN ?", zones)).
+		Distinct("tracking_rollups.user_id").
+		Pluck("tracking_rollups.user_id", &uncounted).Error; err != nil {
+		return nil, err
+	}
+	counted, err := r.recentStreaks(uncounted, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	value, args := perFanValues("", counted, recorded)
+	args = append(args, zones, yesterday)
+	return r.leaderboardFans().
+		Joins("LEFT JOIN fan_streaks ON fan_streaks.user_id = users.id").
+		Select("users.id AS fan_id, users.username, users.profile_photo, "+value+" AS value", args...), nil
+}
+
+// recentStreaks counts the current streaks of fans in loc from their rollups, reading
+// back only as far as their streaks go
+func (r *StatisticsRepository) recentStreaks(fanIDs []uint, loc *time.Location) (map[uint]int64, error) {
+	streaks := make(map[uint]int64, len(fanIDs))
+	for days := 32; len(fanIDs) > 0; days *= 2 {
+		since := startOfDay(r.now(), loc).AddDate(0, 0, -days)
+
+		var rows []struct {
+			FanID uint
+			Slot  time.Time
+		}
+		if err := r.rollups().
+			Select("DISTINCT user_id AS fan_id, slot").
+			Where("user_id IN ? AND slot >= ?", fanIDs, tracking.RollupSlot(since)).
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+
+		visited := make(map[uint]map[time.Time]bool, len(fanIDs))
+		for _, row := range rows {
+			if visited[row.FanID] == nil {
+				visited[row.FanID] = make(map[time.Time]bool)
+			}
+			visited[row.FanID][civilDate(row.Slot, loc)] = true
+		}
+
+		// Streaks reaching the start of the window may go back further
+		var longer []uint
+		for _, fanID := range fanIDs {
+			streak := r.streak(visited[fanID], loc)
+			streaks[fanID] = int64(streak)
+			if streak >= days {
+				longer = append(longer, fanID)
+			}
+		}
+		fanIDs = longer
+	}
+	return streaks, nil
+}
+
+// perFanValues returns a SQL expression for a per-fan value: prefix followed by the
+// fan's value in values, or by otherwise for fans not in it
+func perFanValues(prefix string, values map[uint]int64, otherwise string) (string, []interface{}) {
+	if len(values) == 0 {
+		return prefix + otherwise, nil
+	}
+
+	var expr strings.Builder
+	args := make([]interface{}, 0, 2*len(values))
+	expr.WriteString(prefix + "CASE users.id")
+	for fanID, value := range values {
+		expr.WriteString(" WHEN ? THEN ?")
+		args = append(args, fanID, value)
+	}
+	expr.WriteString(" ELSE " + otherwise + " END")
+	return expr.String(), args
+}
@@ -0,0 +1,113 @@
+package statistics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/tracking"
+)
+
+func TestLeaderboard(t *testing.T) {
+	// Wednesday; the week started on Monday 16 March
+	now := time.Date(2026, 3, 18, 12, 0, 0, 0, time.Local)
+	repo := setupStatisticsRepo(t, now)
+	if err := repo.db.AutoMigrate(&auth.Fan{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	ids := make(map[string]uint)
+	for _, name := range []string{"ann", "bob", "cat", "dan"} {
+		fan := auth.Fan{Username: name, Email: name + "@example.com", ShowOnLeaderboard: name != "dan"}
+		if err := repo.db.Create(&fan).Error; err != nil {
+			t.Fatalf("failed to create fan: %v", err)
+		}
+		ids[name] = fan.ID
+	}
+
+	sessions := []struct {
+		fan     string
+		at      time.Time
+		seconds int64
+	}{
+		{"ann", now.AddDate(0, 0, -10), 7200},
+		{"ann", now.AddDate(0, 0, -1), 1800},
+		{"ann", now.Add(-time.Hour), 1800},
+		{"bob", now.AddDate(0, 0, -1), 3600},
+		{"bob", now.Add(-time.Hour), 3600},
+		{"dan", now.Add(-time.Hour), 36000}, // Not opted in
+	}
+	for i, session := range sessions {
+		fanID := ids[session.fan]
+		seedSession(t, repo, &fanID, fmt.Sprintf("board-%d", i), session.at, session.seconds, false)
+	}
+
+	// cat is online now, in a session that hasn't been rolled up
+	active := func(since time.Time) (map[uint]int64, error) {
+		return map[uint]int64{ids["cat"]: 1800, ids["dan"]: 1800}, nil
+	}
+
+	check := func(metric string, want []LeaderboardEntry) {
+		t.Helper()
+
+		board, err := repo.GetLeaderboard(metric, time.Local, active, 1, 100, 0)
+		if err != nil {
+			t.Fatalf("GetLeaderboard(%s) failed: %v", metric, err)
+		}
+		entries := board.Entries
+		if len(entries) != len(want) {
+			t.Fatalf("%s: expected %d entries, got %+v", metric, len(want), entries)
+		}
+		for i, entry := range entries {
+			if entry.Rank != want[i].Rank || entry.Username != want[i].Username || entry.Value != want[i].Value {
+				t.Fatalf("%s: entry %d is %+v, want %+v", metric, i, entry, want[i])
+			}
+		}
+	}
+
+	check(LeaderboardTotalHours, []LeaderboardEntry{
+		{Rank: 1, Username: "ann", Value: 3},
+		{Rank: 2, Username: "bob", Value: 2},
+		{Rank: 3, Username: "cat", Value: 0.5},
+	})
+	// ann's session ten days ago was last week
+	check(LeaderboardWeekHours, []LeaderboardEntry{
+		{Rank: 1, Username: "bob", Value: 2},
+		{Rank: 2, Username: "ann", Value: 1},
+		{Rank: 3, Username: "cat", Value: 0.5},
+	})
+	// ann and bob tie, so they share first place in username order
+	check(LeaderboardStreak, []LeaderboardEntry{
+		{Rank: 1, Username: "ann", Value: 2},
+		{Rank: 1, Username: "bob", Value: 2},
+		{Rank: 3, Username: "cat", Value: 0},
+	})
+
+	// A later page keeps the shared rank, and the caller's place is found off the page
+	board, err := repo.GetLeaderboard(LeaderboardStreak, time.Local, active, 2, 1, ids["cat"])
+	if err != nil {
+		t.Fatalf("GetLeaderboard failed: %v", err)
+	}
+	if board.Total != 3 || len(board.Entries) != 1 || board.Entries[0].Username != "bob" || board.Entries[0].Rank != 1 {
+		t.Fatalf("unexpected second page: %+v", board)
+	}
+	if board.Me == nil || board.Me.Username != "cat" || board.Me.Rank != 3 {
+		t.Fatalf("expected cat in third place, got %+v", board.Me)
+	}
+
+	// Streak records kept in the requested zone are used as they are, others are
+	// counted from the rollups
+	records := []tracking.FanStreak{
+		{FanID: ids["cat"], Current: 5, Longest: 5, LastActiveDay: now.AddDate(0, 0, -1).Format(time.DateOnly)},
+		{FanID: ids["bob"], Timezone: "Pacific/Kiritimati", Current: 9, Longest: 9, LastActiveDay: now.Format(time.DateOnly)},
+	}
+	if err := repo.db.Create(&records).Error; err != nil {
+		t.Fatalf("failed to seed streak records: %v", err)
+	}
+	check(LeaderboardStreak, []LeaderboardEntry{
+		{Rank: 1, Username: "cat", Value: 5},
+		{Rank: 2, Username: "ann", Value: 2},
+		{Rank: 2, Username: "bob", Value: 2},
+	})
+}
@@ -1,17 +1,20 @@
 package statistics
 
 import (
+	"sort"
 	"time"
 
+	"anonchihaya.co.uk/internal/tracking"
 	"gorm.io/gorm"
 )
 
 type StatisticsRepository struct {
-	db *gorm.DB
+	db  *gorm.DB
+	now func() time.Time // Overridden in tests
 }
 
 func NewStatisticsRepository(db *gorm.DB) *StatisticsRepository {
-	return &StatisticsRepository{db: db}
+	return &StatisticsRepository{db: db, now: time.Now}
 }
 
 // GetTotalFans returns the total number of registered fans
@@ -21,33 +24,27 @@ func (r *StatisticsRepository) GetTotalFans() (int64, error) {
 	return count, err
 }
 
-// GetUniqueVisitors returns the count of unique visitors (registered fans + guests)
+// GetUniqueVisitors returns the count of unique visitors (registered fans + guests),
+// counted in sessions
 func (r *StatisticsRepository) GetUniqueVisitors() (int64, error) {
 	var count int64
-	// Count distinct session_ids from user_trackings
-	err := r.db.Table("user_trackings").
-		Distinct("session_id").
-		Count(&count).Error
+	err := r.visitorRollups().
+		Select("COALESCE(SUM(sessions), 0)").
+		Scan(&count).Error
 	return count, err
 }
 
-// GetUniqueVisitorsLast24Hours returns unique visitors in the last 24 hours
+// GetUniqueVisitorsLast24Hours returns unique visitors in the last 24 hours, counted in
+// sessions
 func (r *StatisticsRepository) GetUniqueVisitorsLast24Hours() (int64, error) {
-	var count int64
-	since := time.Now().Add(-24 * time.Hour)
-	err := r.db.Table("user_trackings").
-		Where("start_time >= ?", since).
-		Distinct("session_id").
-		Count(&count).Error
-	return count, err
+	return r.sessionsSince(r.now().Add(-24 * time.Hour))
 }
 
 // GetRegisteredVisitorsEver returns count of registered fans who have visited
 func (r *StatisticsRepository) GetRegisteredVisitorsEver() (int64, error) {
 	var count int64
-	err := r.db.Table("user_trackings").
+	err := r.visitorRollups().
 		Where("user_id IS NOT NULL").
-		Distinct("user_id").
 		Count(&count).Error
 	return count, err
 }
@@ -55,21 +52,18 @@ func (r *StatisticsRepository) GetRegisteredVisitorsEver() (int64, error) {
 // GetGuestVisitorsEver returns count of guest sessions
 func (r *StatisticsRepository) GetGuestVisitorsEver() (int64, error) {
 	var count int64
-	err := r.db.Table("user_trackings").
+	err := r.visitorRollups().
 		Where("user_id IS NULL").
-		Distinct("session_id").
-		Count(&count).Error
+		Select("COALESCE(SUM(sessions), 0)").
+		Scan(&count).Error
 	return count, err
 }
 
 // GetRegisteredVisitorsLast24Hours returns registered fans who visited in last 24h
 func (r *StatisticsRepository) GetRegisteredVisitorsLast24Hours() (int64, error) {
 	var count int64
-	since := time.Now().Add(-24 * time.Hour)
-	err := r.db.Table("user_trackings").
+	err := r.visitorRollupsSince(r.now().Add(-24 * time.Hour)).
 		Where("user_id IS NOT NULL").
-		Where("start_time >= ?", since).
-		Distinct("user_id").
 		Count(&count).Error
 	return count, err
 }
@@ -77,74 +71,70 @@ func (r *StatisticsRepository) GetRegisteredVisitorsLast24Hours() (int64, error)
 // GetGuestVisitorsLast24Hours returns guest sessions in last 24h
 func (r *StatisticsRepository) GetGuestVisitorsLast24Hours() (int64, error) {
 	var count int64
-	since := time.Now().Add(-24 * time.Hour)
-	err := r.db.Table("user_trackings").
+	err := r.rollupsSince(r.now().Add(-24 * time.Hour)).
 		Where("user_id IS NULL").
-		Where("start_time >= ?", since).
-		Distinct("session_id").
-		Count(&count).Error
+		Select("COALESCE(SUM(sessions), 0)").
+		Scan(&count).Error
 	return count, err
 }
 
-// GetActiveUsersToday returns visitors who have visited today (fans + guests)
-func (r *StatisticsRepository) GetActiveUsersToday() (int64, error) {
+// GetNewFansBetween returns the number of fans who registered between from and to
+func (r *StatisticsRepository) GetNewFansBetween(from, to time.Time) (int64, error) {
 	var count int64
-	today := time.Now().Truncate(24 * time.Hour)
-	err := r.db.Table("user_trackings").
-		Where("start_time >= ?", today).
-		Distinct("session_id").
+	err := r.db.Table("users").
+		Where("created_at >= ? AND created_at < ?", from, to).
 		Count(&count).Error
 	return count, err
 }
 
-// GetFanStreak returns the current streak (consecutive days) for a fan
-func (r *StatisticsRepository) GetFanStreak(fanID uint) (int, error) {
-	var dates []time.Time
+// GetVisitorsBetween returns the number of distinct fans and guest visitors who started
+// a session between from and to
+func (r *StatisticsRepository) GetVisitorsBetween(from, to time.Time) (int64, error) {
+	var count int64
+	err := r.rollupsBetween(from, to).
+		Distinct("owner").
+		Count(&count).Error
+	return count, err
+}
 
-	// Get all distinct dates the fan has visited, ordered by date descending
-	err := r.db.Table("user_trackings").
-		Select("DATE(start_time) as date").
-		Where("user_id = ?", fanID).
-		Group("DATE(start_time)").
-		Order("date DESC").
-		Scan(&dates).Error
+// GetActiveUsersToday returns visitors who have visited today in loc (fans + guests),
+// counted in sessions
+func (r *StatisticsRepository) GetActiveUsersToday(loc *time.Location) (int64, error) {
+	return r.sessionsSince(startOfDay(r.now(), loc))
+}
 
-	if err != nil {
-		return 0, err
-	}
+// sessionsSince returns the number of sessions first started at or after since
+func (r *StatisticsRepository) sessionsSince(since time.Time) (int64, error) {
+	var count int64
+	err := r.rollupsSince(since).
+		Select("COALESCE(SUM(sessions), 0)").
+		Scan(&count).Error
+	return count, err
+}
 
-	if len(dates) == 0 {
-		return 0, nil
-	}
+// GetFanStreak returns the current streak (consecutive days in loc) for a fan
+func (r *StatisticsRepository) GetFanStreak(fanID uint, loc *time.Location) (int, error) {
+	summary, err := r.GetFanStreakSummary(fanID, loc)
+	return summary.Current, err
+}
 
-	// Calculate streak
-	streak := 0
-	today := time.Now().Truncate(24 * time.Hour)
-	expectedDate := today
-
-	// Check if fan visited today or yesterday (streak can continue)
-	firstDate := dates[0].Truncate(24 * time.Hour)
-	if firstDate.Equal(today) {
-		expectedDate = today
-	} else if firstDate.Equal(today.Add(-24 * time.Hour)) {
-		expectedDate = today.Add(-24 * time.Hour)
-	} else {
-		// Streak is broken
-		return 0, nil
+// streak counts the consecutive visited days (from civilDate) ending today or yesterday
+func (r *StatisticsRepository) streak(visited map[time.Time]bool, loc *time.Location) int {
+	// Check if fan visited today or yesterday (streak can continue).
+	// Dates are counted in calendar days, so DST transitions don't shift them.
+	expectedDate := civilDate(r.now(), loc)
+	if !visited[expectedDate] {
+		expectedDate = expectedDate.AddDate(0, 0, -1)
 	}
 
 	// Count consecutive days
-	for _, date := range dates {
-		dateOnly := date.Truncate(24 * time.Hour)
-		if dateOnly.Equal(expectedDate) {
-			streak++
-			expectedDate = expectedDate.Add(-24 * time.Hour)
-		} else {
-			break
-		}
+	streak := 0
+	for visited[expectedDate] {
+		streak++
+		expectedDate = expectedDate.AddDate(0, 0, -1)
 	}
 
-	return streak, nil
+	return streak
 }
 
 // FansOverTimePoint represents a data point for visitors over time
@@ -153,41 +143,38 @@ type FansOverTimePoint struct {
 	Count int64  `json:"count"`
 }
 
-// GetFansOverTime returns hourly visitor counts for the last N hours
-func (r *StatisticsRepository) GetFansOverTime(hours int) ([]FansOverTimePoint, error) {
-	var results []FansOverTimePoint
-
-	since := time.Now().Add(-time.Duration(hours) * time.Hour)
-
-	// Detect database type
-	dbName := r.db.Dialector.Name()
-	var query string
-
-	if dbName == "sqlite" {
-		query = `
-			SELECT 
-				strftime('%Y-%m-%d %H:00:00', start_time) as hour,
-				COUNT(DISTINCT session_id) as count
-			FROM user_trackings
-			WHERE start_time >= ?
-			GROUP BY strftime('%Y-%m-%d %H:00:00', start_time)
-			ORDER BY hour ASC
-		`
-	} else {
-		// MySQL/MariaDB
-		query = `
-			SELECT 
-				DATE_FORMAT(start_time, '%Y-%m-%d %H:00:00') as hour,
-				COUNT(DISTINCT session_id) as count
-			FROM user_trackings
-			WHERE start_time >= ?
-			GROUP BY DATE_FORMAT(start_time, '%Y-%m-%d %H:00:00')
-			ORDER BY hour ASC
-		`
+// GetFansOverTime returns hourly visitor counts for the last N hours, bucketed by the
+// hours of loc. Repeated hours at the end of DST appear once per actual hour.
+func (r *StatisticsRepository) GetFansOverTime(hours int, loc *time.Location) ([]FansOverTimePoint, error) {
+	since := r.now().Add(-time.Duration(hours) * time.Hour)
+
+	slots, err := r.slotSessionsSince(since)
+	if err != nil {
+		return nil, err
+	}
+
+	// Slots never straddle a local hour, so each maps to the instant its hour starts
+	buckets := make(map[int64]int64)
+	for _, slot := range slots {
+		local := slot.Slot.In(loc)
+		hourStart := slot.Slot.Add(-time.Duration(local.Minute()) * time.Minute).Unix()
+		buckets[hourStart] += slot.Sessions
 	}
 
-	err := r.db.Raw(query, since).Scan(&results).Error
-	return results, err
+	starts := make([]int64, 0, len(buckets))
+	for start := range buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	results := make([]FansOverTimePoint, 0, len(starts))
+	for _, start := range starts {
+		results = append(results, FansOverTimePoint{
+			Hour:  time.Unix(start, 0).In(loc).Format(time.DateTime),
+			Count: buckets[start],
+		})
+	}
+	return results, nil
 }
 
 // DailyActiveUsersPoint represents daily active users
@@ -196,39 +183,85 @@ type DailyActiveUsersPoint struct {
 	Count int64  `json:"count"`
 }
 
-// GetDailyActiveUsers returns daily active user counts for the last N days
-func (r *StatisticsRepository) GetDailyActiveUsers(days int) ([]DailyActiveUsersPoint, error) {
-	var results []DailyActiveUsersPoint
-
-	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
-
-	// Detect database type
-	dbName := r.db.Dialector.Name()
-	var query string
-
-	if dbName == "sqlite" {
-		query = `
-			SELECT 
-				date(start_time) as date,
-				COUNT(DISTINCT session_id) as count
-			FROM user_trackings
-			WHERE start_time >= ?
-			GROUP BY date(start_time)
-			ORDER BY date ASC
-		`
-	} else {
-		// MySQL/MariaDB - DATE() works the same
-		query = `
-			SELECT 
-				DATE(start_time) as date,
-				COUNT(DISTINCT session_id) as count
-			FROM user_trackings
-			WHERE start_time >= ?
-			GROUP BY DATE(start_time)
-			ORDER BY date ASC
-		`
+// GetDailyActiveUsers returns daily active user counts for the last N days in loc,
+// including today
+func (r *StatisticsRepository) GetDailyActiveUsers(days int, loc *time.Location) ([]DailyActiveUsersPoint, error) {
+	since := startOfDay(r.now(), loc).AddDate(0, 0, -(days - 1))
+
+	slots, err := r.slotSessionsSince(since)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make(map[string]int64)
+	for _, slot := range slots {
+		buckets[slot.Slot.In(loc).Format(time.DateOnly)] += slot.Sessions
+	}
+
+	results := make([]DailyActiveUsersPoint, 0, len(buckets))
+	for date, count := range buckets {
+		results = append(results, DailyActiveUsersPoint{Date: date, Count: count})
 	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Date < results[j].Date })
+	return results, nil
+}
+
+// slotSessions is the number of sessions first started in a rollup slot
+type slotSessions struct {
+	Slot     time.Time
+	Sessions int64
+}
+
+// slotSessionsSince returns the sessions of each slot started at or after since
+func (r *StatisticsRepository) slotSessionsSince(since time.Time) ([]slotSessions, error) {
+	var slots []slotSessions
+	err := r.rollupsSince(since).
+		Select("slot, SUM(sessions) AS sessions").
+		Group("slot").
+		Scan(&slots).Error
+	return slots, err
+}
+
+// rollups starts a query on the tracking rollups of sessions that weren't started by a
+// bot, so every visitor count leaves bots out
+func (r *StatisticsRepository) rollups() *gorm.DB {
+	return r.db.Model(&tracking.Rollup{}).Where("bot = ?", false)
+}
+
+// rollupsSince limits the rollups to the slots from the one since falls in. A slot
+// can't be split, so a window that doesn't start on a slot boundary (e.g. the last 24
+// hours) also counts the sessions of up to 15 minutes before since.
+func (r *StatisticsRepository) rollupsSince(since time.Time) *gorm.DB {
+	return r.rollups().Where("slot >= ?", tracking.RollupSlot(since))
+}
+
+// rollupsBetween limits the rollups to the slots overlapping from to to. Windows of
+// whole local days or hours start and end on slot boundaries, so they are exact.
+func (r *StatisticsRepository) rollupsBetween(from, to time.Time) *gorm.DB {
+	return r.rollupsSince(from).Where("slot < ?", to.UTC())
+}
+
+// visitorRollups starts a query on the per-visitor rollup totals, leaving out bots like
+// rollups does
+func (r *StatisticsRepository) visitorRollups() *gorm.DB {
+	return r.db.Model(&tracking.VisitorRollup{}).Where("bot = ?", false)
+}
+
+// visitorRollupsSince limits the totals to visitors who started a session at or after since
+func (r *StatisticsRepository) visitorRollupsSince(since time.Time) *gorm.DB {
+	return r.visitorRollups().Where("last_start_at >= ?", since.In(time.Local))
+}
+
+// startOfDay returns midnight of t's day in loc, or the first instant of the day when
+// a DST change skips midnight
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
 
-	err := r.db.Raw(query, since).Scan(&results).Error
-	return results, err
+// civilDate returns t's calendar date in loc as midnight UTC, so days can be stepped
+// with AddDate without DST shifting them
+func civilDate(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
 }
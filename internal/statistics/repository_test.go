@@ -0,0 +1,272 @@
+package statistics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/tracking"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func setupStatisticsRepo(t *testing.T, now time.Time) *StatisticsRepository {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:statistics_%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&tracking.FanTracking{}, &tracking.Rollup{}, &tracking.VisitorRollup{}, &tracking.FanStreak{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	repo := NewStatisticsRepository(db)
+	repo.now = func() time.Time { return now }
+	return repo
+}
+
+// seedVisit records a session started at the given instant
+func seedVisit(t *testing.T, repo *StatisticsRepository, fanID *uint, sessionID string, at time.Time) {
+	t.Helper()
+	seedSession(t, repo, fanID, sessionID, at, 0, false)
+}
+
+// seedSession records a session started at the given instant in its slot's rollup and
+// refreshes the visitor totals
+func seedSession(t *testing.T, repo *StatisticsRepository, fanID *uint, sessionID string, at time.Time, seconds int64, bot bool) {
+	t.Helper()
+
+	row := tracking.Rollup{
+		Slot:        tracking.RollupSlot(at),
+		Owner:       "guest:" + sessionID,
+		Bot:         bot,
+		FanID:       fanID,
+		GuestKey:    sessionID,
+		Sessions:    1,
+		Starts:      1,
+		Seconds:     seconds,
+		LastStartAt: at.In(time.Local),
+	}
+	if fanID != nil {
+		row.Owner = fmt.Sprintf("fan:%d", *fanID)
+		row.GuestKey = ""
+	}
+	if err := repo.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "slot"}, {Name: "owner"}, {Name: "bot"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "sessions"}, Value: gorm.Expr("sessions + 1")},
+			{Column: clause.Column{Name: "starts"}, Value: gorm.Expr("starts + 1")},
+			{Column: clause.Column{Name: "seconds"}, Value: gorm.Expr("seconds + ?", seconds)},
+			{Column: clause.Column{Name: "last_start_at"}, Value: gorm.Expr("CASE WHEN last_start_at < ? THEN ? ELSE last_start_at END", row.LastStartAt, row.LastStartAt)},
+		},
+	}).Create(&row).Error; err != nil {
+		t.Fatalf("failed to seed visit: %v", err)
+	}
+	if _, err := tracking.NewFanTrackingRepository(repo.db).RebuildRollups(); err != nil {
+		t.Fatalf("failed to rebuild visitor totals: %v", err)
+	}
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s unavailable: %v", name, err)
+	}
+	return loc
+}
+
+func TestFanStreakAcrossSpringForward(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	fanID := uint(1)
+
+	// DST starts at 02:00 on 2026-03-08, so that day is 23 hours long. Late-evening
+	// visits fall on the next UTC day.
+	now := time.Date(2026, 3, 9, 23, 50, 0, 0, newYork)
+	repo := setupStatisticsRepo(t, now)
+	seedVisit(t, repo, &fanID, "s1", time.Date(2026, 3, 7, 23, 30, 0, 0, newYork))
+	seedVisit(t, repo, &fanID, "s2", time.Date(2026, 3, 8, 23, 30, 0, 0, newYork))
+	seedVisit(t, repo, &fanID, "s3", time.Date(2026, 3, 9, 23, 30, 0, 0, newYork))
+
+	streak, err := repo.GetFanStreak(fanID, newYork)
+	if err != nil {
+		t.Fatalf("streak failed: %v", err)
+	}
+	if streak != 3 {
+		t.Fatalf("expected a 3 day streak in New York, got %d", streak)
+	}
+
+	// In UTC the same visits fall on 8, 9 and 10 March, and "now" is already 10 March
+	streak, err = repo.GetFanStreak(fanID, time.UTC)
+	if err != nil {
+		t.Fatalf("streak failed: %v", err)
+	}
+	if streak != 3 {
+		t.Fatalf("expected a 3 day streak in UTC, got %d", streak)
+	}
+}
+
+func TestFanStreakAcrossFallBack(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	fanID := uint(2)
+
+	// DST ends at 02:00 on 2026-11-01, so that day is 25 hours long and 24 hours after
+	// its first visit is still 1 November.
+	now := time.Date(2026, 11, 2, 8, 0, 0, 0, newYork)
+	repo := setupStatisticsRepo(t, now)
+	seedVisit(t, repo, &fanID, "s1", time.Date(2026, 10, 31, 0, 30, 0, 0, newYork))
+	seedVisit(t, repo, &fanID, "s2", time.Date(2026, 11, 1, 0, 30, 0, 0, newYork))
+	seedVisit(t, repo, &fanID, "s3", time.Date(2026, 11, 1, 23, 45, 0, 0, newYork))
+
+	streak, err := repo.GetFanStreak(fanID, newYork)
+	if err != nil {
+		t.Fatalf("streak failed: %v", err)
+	}
+	if streak != 2 {
+		t.Fatalf("expected the streak to continue from yesterday for 2 days, got %d", streak)
+	}
+
+	seedVisit(t, repo, &fanID, "s4", time.Date(2026, 11, 2, 0, 30, 0, 0, newYork))
+	streak, err = repo.GetFanStreak(fanID, newYork)
+	if err != nil {
+		t.Fatalf("streak failed: %v", err)
+	}
+	if streak != 3 {
+		t.Fatalf("expected a 3 day streak including today, got %d", streak)
+	}
+}
+
+func TestDailyActiveUsersBucketsByZone(t *testing.T) {
+	london := mustLoad(t, "Europe/London")
+
+	// Clocks go forward at 01:00 UTC on 2026-03-29
+	now := time.Date(2026, 3, 30, 12, 0, 0, 0, time.UTC)
+	repo := setupStatisticsRepo(t, now)
+	seedVisit(t, repo, nil, "a", time.Date(2026, 3, 28, 23, 30, 0, 0, time.UTC)) // 23:30 GMT on the 28th
+	seedVisit(t, repo, nil, "b", time.Date(2026, 3, 29, 0, 30, 0, 0, time.UTC))  // 00:30 GMT on the 29th
+	seedVisit(t, repo, nil, "c", time.Date(2026, 3, 29, 23, 30, 0, 0, time.UTC)) // 00:30 BST on the 30th
+
+	got, err := repo.GetDailyActiveUsers(3, london)
+	if err != nil {
+		t.Fatalf("daily active users failed: %v", err)
+	}
+	want := []DailyActiveUsersPoint{{"2026-03-28", 1}, {"2026-03-29", 1}, {"2026-03-30", 1}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v in London, got %v", want, got)
+	}
+
+	got, err = repo.GetDailyActiveUsers(3, time.UTC)
+	if err != nil {
+		t.Fatalf("daily active users failed: %v", err)
+	}
+	want = []DailyActiveUsersPoint{{"2026-03-28", 1}, {"2026-03-29", 2}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v in UTC, got %v", want, got)
+	}
+
+	today, err := repo.GetActiveUsersToday(london)
+	if err != nil {
+		t.Fatalf("active today failed: %v", err)
+	}
+	if today != 1 {
+		t.Fatalf("expected 1 visitor today in London, got %d", today)
+	}
+}
+
+func TestFansOverTimeBucketsByLocalHour(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+
+	// 01:00-02:00 happens twice on 2026-11-01 in New York
+	now := time.Date(2026, 11, 1, 8, 0, 0, 0, time.UTC)
+	repo := setupStatisticsRepo(t, now)
+	seedVisit(t, repo, nil, "edt", time.Date(2026, 11, 1, 5, 10, 0, 0, time.UTC))   // 01:10 EDT
+	seedVisit(t, repo, nil, "est", time.Date(2026, 11, 1, 6, 10, 0, 0, time.UTC))   // 01:10 EST
+	seedVisit(t, repo, nil, "est-2", time.Date(2026, 11, 1, 6, 35, 0, 0, time.UTC)) // 01:35 EST
+
+	got, err := repo.GetFansOverTime(24, newYork)
+	if err != nil {
+		t.Fatalf("fans over time failed: %v", err)
+	}
+	want := []FansOverTimePoint{{"2026-11-01 01:00:00", 1}, {"2026-11-01 01:00:00", 2}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v in New York, got %v", want, got)
+	}
+
+	// India is 5:30 ahead, so its hours start at half past in UTC
+	got, err = repo.GetFansOverTime(24, mustLoad(t, "Asia/Kolkata"))
+	if err != nil {
+		t.Fatalf("fans over time failed: %v", err)
+	}
+	want = []FansOverTimePoint{{"2026-11-01 10:00:00", 1}, {"2026-11-01 11:00:00", 1}, {"2026-11-01 12:00:00", 1}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v in Kolkata, got %v", want, got)
+	}
+}
+
+func TestVisitorCountsLeaveOutBots(t *testing.T) {
+	now := time.Now()
+	repo := setupStatisticsRepo(t, now)
+	fanID := uint(4)
+
+	seedVisit(t, repo, &fanID, "fan", now.Add(-time.Hour))
+	seedVisit(t, repo, nil, "guest", now.Add(-time.Hour))
+	seedSession(t, repo, nil, "crawler", now.Add(-time.Hour), 0, true)
+
+	counts := []struct {
+		name  string
+		count func() (int64, error)
+		want  int64
+	}{
+		{"ever", repo.GetUniqueVisitors, 2},
+		{"24h", repo.GetUniqueVisitorsLast24Hours, 2},
+		{"guests ever", repo.GetGuestVisitorsEver, 1},
+		{"guests 24h", repo.GetGuestVisitorsLast24Hours, 1},
+		{"between", func() (int64, error) { return repo.GetVisitorsBetween(now.Add(-2*time.Hour), now) }, 2},
+	}
+	for _, c := range counts {
+		got, err := c.count()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got != c.want {
+			t.Fatalf("%s: expected %d visitors without the bot, got %d", c.name, c.want, got)
+		}
+	}
+
+	series, err := repo.GetTimeSeries(MetricVisitors, GranularityDay, now.Add(-2*time.Hour), now, time.UTC, false)
+	if err != nil {
+		t.Fatalf("GetTimeSeries failed: %v", err)
+	}
+	if series.Total != 2 {
+		t.Fatalf("expected 2 visitors in the series, got %v", series.Total)
+	}
+}
+
+func TestGuestVisitorsCountSessions(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	repo := setupStatisticsRepo(t, now)
+
+	// The same visitor comes back for a second session
+	seedVisit(t, repo, nil, "visitor", now.Add(-48*time.Hour))
+	seedVisit(t, repo, nil, "visitor", now.Add(-time.Hour))
+
+	ever, err := repo.GetGuestVisitorsEver()
+	if err != nil {
+		t.Fatalf("guests ever failed: %v", err)
+	}
+	if ever != 2 {
+		t.Fatalf("expected 2 guest sessions ever, got %d", ever)
+	}
+
+	recent, err := repo.GetGuestVisitorsLast24Hours()
+	if err != nil {
+		t.Fatalf("guests 24h failed: %v", err)
+	}
+	if recent != 1 {
+		t.Fatalf("expected 1 guest session in the last 24 hours, got %d", recent)
+	}
+}
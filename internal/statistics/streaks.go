@@ -0,0 +1,126 @@
+package statistics
+
+import (
+	"sort"
+	"time"
+
+	"anonchihaya.co.uk/internal/tracking"
+)
+
+// StreakSummary is a fan's current and longest runs of consecutive active days
+type StreakSummary struct {
+	Current       int    `json:"streak"`
+	Longest       int    `json:"longest"`
+	LastActiveDay string `json:"last_active_day"` // YYYY-MM-DD, empty if the fan was never active
+}
+
+// Calendar is a fan's active days in one year
+type Calendar struct {
+	Year int           `json:"year"`
+	Days []CalendarDay `json:"days"` // Only days with activity, in date order
+}
+
+// CalendarDay is a day with tracked activity
+type CalendarDay struct {
+	Date     string `json:"date"` // YYYY-MM-DD
+	Sessions int64  `json:"sessions"`
+	Seconds  int64  `json:"seconds"` // Time in completed sessions started that day
+}
+
+// GetFanStreakSummary returns a fan's streaks with days counted in loc. The stored
+// streak record is used when it is kept in loc; otherwise the streaks are counted from
+// every visit.
+func (r *StatisticsRepository) GetFanStreakSummary(fanID uint, loc *time.Location) (StreakSummary, error) {
+	var records []tracking.FanStreak
+	if err := r.db.Where("user_id = ?", fanID).Limit(1).Find(&records).Error; err != nil {
+		return StreakSummary{}, err
+	}
+	if len(records) == 1 && records[0].Location().String() == loc.String() {
+		record := records[0]
+		return StreakSummary{Current: r.currentStreak(record, loc), Longest: record.Longest, LastActiveDay: record.LastActiveDay}, nil
+	}
+
+	var slots []time.Time
+
+	// Get all distinct slots the fan has visited in
+	err := r.rollups().
+		Where("user_id = ?", fanID).
+		Distinct("slot").
+		Pluck("slot", &slots).Error
+
+	if err != nil {
+		return StreakSummary{}, err
+	}
+
+	visited := make(map[time.Time]bool, len(slots))
+	for _, slot := range slots {
+		visited[civilDate(slot, loc)] = true
+	}
+
+	days := make([]time.Time, 0, len(visited))
+	for day := range visited {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	summary := StreakSummary{Current: r.streak(visited, loc)}
+	run := 0
+	for i, day := range days {
+		if i > 0 && days[i-1].AddDate(0, 0, 1).Equal(day) {
+			run++
+		} else {
+			run = 1
+		}
+		summary.Longest = max(summary.Longest, run)
+		summary.LastActiveDay = day.Format(time.DateOnly)
+	}
+	return summary, nil
+}
+
+// currentStreak returns the current run of a streak record kept in loc, which only
+// continues if it reached today or yesterday
+func (r *StatisticsRepository) currentStreak(record tracking.FanStreak, loc *time.Location) int {
+	today := civilDate(r.now(), loc)
+	if last, err := time.Parse(time.DateOnly, record.LastActiveDay); err == nil && !last.Before(today.AddDate(0, 0, -1)) {
+		return record.Current
+	}
+	return 0
+}
+
+// GetFanCalendar returns the days of year, in loc, on which a fan started a session
+func (r *StatisticsRepository) GetFanCalendar(fanID uint, year int, loc *time.Location) (*Calendar, error) {
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	to := from.AddDate(1, 0, 0)
+
+	// Every UTC offset is a multiple of the slot size, so local midnight starts a slot
+	var rows []struct {
+		Slot     time.Time
+		Sessions int64
+		Seconds  int64
+	}
+	if err := r.rollups().
+		Select("slot, sessions, seconds").
+		Where("user_id = ? AND slot >= ? AND slot < ?", fanID, tracking.RollupSlot(from), tracking.RollupSlot(to)).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	byDay := make(map[string]*CalendarDay)
+	for _, row := range rows {
+		date := row.Slot.In(loc).Format(time.DateOnly)
+		day, ok := byDay[date]
+		if !ok {
+			day = &CalendarDay{Date: date}
+			byDay[date] = day
+		}
+		day.Sessions += row.Sessions
+		day.Seconds += row.Seconds
+	}
+
+	calendar := &Calendar{Year: year, Days: make([]CalendarDay, 0, len(byDay))}
+	for _, day := range byDay {
+		calendar.Days = append(calendar.Days, *day)
+	}
+	sort.Slice(calendar.Days, func(i, j int) bool { return calendar.Days[i].Date < calendar.Days[j].Date })
+	return calendar, nil
+}
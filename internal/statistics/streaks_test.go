@@ -0,0 +1,96 @@
+package statistics
+
+import (
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/tracking"
+)
+
+func TestFanStreakSummary(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	fanID := uint(1)
+	now := time.Date(2026, 5, 20, 9, 0, 0, 0, newYork)
+	repo := setupStatisticsRepo(t, now)
+
+	for _, at := range []time.Time{
+		time.Date(2026, 5, 1, 10, 0, 0, 0, newYork),
+		time.Date(2026, 5, 2, 10, 0, 0, 0, newYork),
+		time.Date(2026, 5, 3, 10, 0, 0, 0, newYork),
+		time.Date(2026, 5, 19, 10, 0, 0, 0, newYork),
+		time.Date(2026, 5, 20, 8, 0, 0, 0, newYork),
+	} {
+		seedVisit(t, repo, &fanID, at.Format(time.RFC3339), at)
+	}
+
+	// Without a record the streaks are counted from the visits
+	summary, err := repo.GetFanStreakSummary(fanID, newYork)
+	if err != nil {
+		t.Fatalf("GetFanStreakSummary failed: %v", err)
+	}
+	if summary != (StreakSummary{Current: 2, Longest: 3, LastActiveDay: "2026-05-20"}) {
+		t.Fatalf("unexpected summary from visits: %+v", summary)
+	}
+
+	// A record kept in the requested zone is used as is
+	record := tracking.FanStreak{FanID: fanID, Timezone: "America/New_York", Current: 5, Longest: 9, LastActiveDay: "2026-05-19"}
+	if err := repo.db.Create(&record).Error; err != nil {
+		t.Fatalf("failed to seed record: %v", err)
+	}
+	summary, err = repo.GetFanStreakSummary(fanID, newYork)
+	if err != nil || summary != (StreakSummary{Current: 5, Longest: 9, LastActiveDay: "2026-05-19"}) {
+		t.Fatalf("unexpected summary from the record: %+v (err %v)", summary, err)
+	}
+
+	// A run that ended before yesterday is no longer current
+	repo.now = func() time.Time { return now.AddDate(0, 0, 2) }
+	if streak, err := repo.GetFanStreak(fanID, newYork); err != nil || streak != 0 {
+		t.Fatalf("expected the streak to have lapsed, got %d (err %v)", streak, err)
+	}
+
+	// Other zones are counted from the visits
+	summary, err = repo.GetFanStreakSummary(fanID, time.UTC)
+	if err != nil || summary.Longest != 3 {
+		t.Fatalf("unexpected summary in UTC: %+v (err %v)", summary, err)
+	}
+}
+
+func TestFanCalendar(t *testing.T) {
+	tokyo := mustLoad(t, "Asia/Tokyo")
+	fanID := uint(7)
+	otherID := uint(8)
+	repo := setupStatisticsRepo(t, time.Date(2026, 6, 1, 12, 0, 0, 0, tokyo))
+
+	visits := []struct {
+		fanID   *uint
+		session string
+		at      time.Time
+		seconds int64
+	}{
+		{&fanID, "new-year", time.Date(2026, 1, 1, 0, 5, 0, 0, tokyo), 600}, // Still 2025 in UTC
+		{&fanID, "morning", time.Date(2026, 3, 14, 8, 0, 0, 0, tokyo), 1200},
+		{&fanID, "evening", time.Date(2026, 3, 14, 22, 0, 0, 0, tokyo), 300},
+		{&fanID, "last-year", time.Date(2025, 12, 31, 23, 50, 0, 0, tokyo), 60},
+		{&otherID, "someone-else", time.Date(2026, 3, 14, 9, 0, 0, 0, tokyo), 60},
+	}
+	for _, visit := range visits {
+		seedSession(t, repo, visit.fanID, visit.session, visit.at, visit.seconds, false)
+	}
+
+	calendar, err := repo.GetFanCalendar(fanID, 2026, tokyo)
+	if err != nil {
+		t.Fatalf("GetFanCalendar failed: %v", err)
+	}
+	want := []CalendarDay{
+		{Date: "2026-01-01", Sessions: 1, Seconds: 600},
+		{Date: "2026-03-14", Sessions: 2, Seconds: 1500},
+	}
+	if calendar.Year != 2026 || len(calendar.Days) != len(want) {
+		t.Fatalf("unexpected calendar: %+v", calendar)
+	}
+	for i := range want {
+		if calendar.Days[i] != want[i] {
+			t.Fatalf("day %d is %+v, want %+v", i, calendar.Days[i], want[i])
+		}
+	}
+}
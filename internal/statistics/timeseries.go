@@ -0,0 +1,273 @@
+package statistics
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Time series metrics
+const (
+	MetricVisitors           = "visitors"
+	MetricRegisteredVisitors = "registered_visitors"
+	MetricHours              = "hours"
+	MetricSignups            = "signups"
+)
+
+// Time series granularities
+const (
+	GranularityHour  = "hour"
+	GranularityDay   = "day"
+	GranularityWeek  = CohortWeek
+	GranularityMonth = CohortMonth
+)
+
+// MaxTimeSeriesPoints caps the number of buckets in one series
+const MaxTimeSeriesPoints = 1000
+
+// ErrTooManyPoints is returned when a range would need more than MaxTimeSeriesPoints buckets
+var ErrTooManyPoints = fmt.Errorf("the range needs more than %d buckets, use a coarser granularity", MaxTimeSeriesPoints)
+
+// TimeSeries is a metric bucketed over a range. Every bucket is listed, empty ones as zero.
+type TimeSeries struct {
+	Metric      string            `json:"metric"`
+	Granularity string            `json:"granularity"`
+	From        time.Time         `json:"from"` // Start of the first bucket
+	To          time.Time         `json:"to"`
+	Total       float64           `json:"total"` // Distinct visitors over the whole range for the visitor metrics, else the sum of the buckets
+	Points      []TimeSeriesPoint `json:"points"`
+	Previous    *SeriesComparison `json:"previous,omitempty"`
+}
+
+// TimeSeriesPoint is one bucket of a time series
+type TimeSeriesPoint struct {
+	Start    time.Time `json:"start"`
+	Value    float64   `json:"value"`
+	Previous *float64  `json:"previous,omitempty"` // The matching bucket of the previous period
+}
+
+// SeriesComparison is the period of the same number of buckets just before a series
+type SeriesComparison struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Total  float64   `json:"total"`
+	Change *float64  `json:"change"` // Percentage change of the total, nil when the previous total is zero
+}
+
+// ValidSeriesMetric reports whether metric can be charted
+func ValidSeriesMetric(metric string) bool {
+	switch metric {
+	case MetricVisitors, MetricRegisteredVisitors, MetricHours, MetricSignups:
+		return true
+	}
+	return false
+}
+
+// ValidGranularity reports whether granularity is a time series bucket size
+func ValidGranularity(granularity string) bool {
+	switch granularity {
+	case GranularityHour, GranularityDay, GranularityWeek, GranularityMonth:
+		return true
+	}
+	return false
+}
+
+// GetTimeSeries buckets a metric between from and to by hour, day, week (from Monday) or
+// month in loc. With compare, each bucket also gets the value of the matching bucket in
+// the period just before. Visitors are distinct fans and guest visitors; hours are the
+// completed sessions of fans, counted in the bucket they started in.
+func (r *StatisticsRepository) GetTimeSeries(metric, granularity string, from, to time.Time, loc *time.Location, compare bool) (*TimeSeries, error) {
+	if !ValidSeriesMetric(metric) {
+		return nil, errors.New("unknown metric " + metric)
+	}
+	if !ValidGranularity(granularity) {
+		return nil, errors.New("unknown granularity " + granularity)
+	}
+
+	starts, err := bucketStarts(granularity, from, to, loc)
+	if err != nil {
+		return nil, err
+	}
+	values, total, err := r.seriesValues(metric, starts, to)
+	if err != nil {
+		return nil, err
+	}
+
+	series := &TimeSeries{
+		Metric:      metric,
+		Granularity: granularity,
+		From:        starts[0],
+		To:          to,
+		Total:       total,
+		Points:      make([]TimeSeriesPoint, len(starts)),
+	}
+	for i, start := range starts {
+		series.Points[i] = TimeSeriesPoint{Start: start, Value: values[i]}
+	}
+	if !compare {
+		return series, nil
+	}
+
+	// The previous period has as many buckets and ends where this one starts
+	n := len(starts)
+	previousFrom := shiftBuckets(granularity, starts[0], -n, loc)
+	previousTo := shiftBuckets(granularity, to, -n, loc)
+	if !previousTo.After(previousFrom) || previousTo.After(starts[0]) {
+		previousTo = starts[0]
+	}
+	previousStarts, err := bucketStarts(granularity, previousFrom, previousTo, loc)
+	if err != nil {
+		return nil, err
+	}
+	previousValues, previousTotal, err := r.seriesValues(metric, previousStarts, previousTo)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range series.Points {
+		if i < len(previousValues) {
+			value := previousValues[i]
+			series.Points[i].Previous = &value
+		}
+	}
+	series.Previous = &SeriesComparison{From: previousStarts[0], To: previousTo, Total: previousTotal}
+	if previousTotal != 0 {
+		change := (total - previousTotal) / previousTotal * 100
+		series.Previous.Change = &change
+	}
+	return series, nil
+}
+
+// seriesValues returns the metric per bucket and over the whole range. Bucket i runs
+// from starts[i] to the next start, and the last one to to.
+func (r *StatisticsRepository) seriesValues(metric string, starts []time.Time, to time.Time) ([]float64, float64, error) {
+	values := make([]float64, len(starts))
+	bucketOf := func(t time.Time) int {
+		if t.Before(starts[0]) || !t.Before(to) {
+			return -1
+		}
+		// The last bucket starting at or before t
+		return sort.Search(len(starts), func(i int) bool { return starts[i].After(t) }) - 1
+	}
+
+	switch metric {
+	case MetricSignups:
+		var createdAt []time.Time
+		if err := r.db.Table("users").
+			Where("created_at >= ? AND created_at < ?", starts[0], to).
+			Pluck("created_at", &createdAt).Error; err != nil {
+			return nil, 0, err
+		}
+		var total float64
+		for _, at := range createdAt {
+			if i := bucketOf(at); i >= 0 {
+				values[i]++
+				total++
+			}
+		}
+		return values, total, nil
+
+	case MetricHours:
+		var rows []struct {
+			Slot    time.Time
+			Seconds int64
+		}
+		if err := r.rollupsBetween(starts[0], to).
+			Select("slot, SUM(seconds) AS seconds").
+			Where("user_id IS NOT NULL").
+			Group("slot").
+			Scan(&rows).Error; err != nil {
+			return nil, 0, err
+		}
+		var total float64
+		for _, row := range rows {
+			if i := bucketOf(row.Slot); i >= 0 {
+				hours := float64(row.Seconds) / 3600.0
+				values[i] += hours
+				total += hours
+			}
+		}
+		return values, total, nil
+
+	default: // Visitors
+		query := r.rollupsBetween(starts[0], to)
+		if metric == MetricRegisteredVisitors {
+			query = query.Where("user_id IS NOT NULL")
+		}
+		var rows []struct {
+			Slot  time.Time
+			Owner string
+		}
+		if err := query.Distinct("slot", "owner").Scan(&rows).Error; err != nil {
+			return nil, 0, err
+		}
+
+		owners := make([]map[string]bool, len(starts))
+		everyone := make(map[string]bool)
+		for _, row := range rows {
+			i := bucketOf(row.Slot)
+			if i < 0 {
+				continue
+			}
+			if owners[i] == nil {
+				owners[i] = make(map[string]bool)
+			}
+			owners[i][row.Owner] = true
+			everyone[row.Owner] = true
+		}
+		for i := range values {
+			values[i] = float64(len(owners[i]))
+		}
+		return values, float64(len(everyone)), nil
+	}
+}
+
+// bucketStarts returns the start of every bucket from the one containing from up to to.
+// Hour buckets step by the hour in absolute time, so a repeated hour at the end of DST
+// is its own bucket and a skipped hour has none.
+func bucketStarts(granularity string, from, to time.Time, loc *time.Location) ([]time.Time, error) {
+	if !from.Before(to) {
+		return nil, errors.New("from must be before to")
+	}
+
+	start := bucketStart(granularity, from, loc)
+	var starts []time.Time
+	for t := start; t.Before(to); t = shiftBuckets(granularity, t, 1, loc) {
+		if len(starts) == MaxTimeSeriesPoints {
+			return nil, ErrTooManyPoints
+		}
+		starts = append(starts, t)
+	}
+	return starts, nil
+}
+
+// bucketStart returns the start of the bucket t falls in
+func bucketStart(granularity string, t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch granularity {
+	case GranularityHour:
+		// Truncating in loc keeps zones offset by 30 or 45 minutes on their own hours.
+		// Rollup slots are quarter hours, so they never straddle a bucket.
+		return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	case GranularityDay:
+		return startOfDay(t, loc)
+	default:
+		return periodStart(t, granularity)
+	}
+}
+
+// shiftBuckets moves t by n buckets. Days, weeks and months are calendar steps in loc.
+func shiftBuckets(granularity string, t time.Time, n int, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch granularity {
+	case GranularityHour:
+		return t.Add(time.Duration(n) * time.Hour)
+	case GranularityDay:
+		return t.AddDate(0, 0, n)
+	case GranularityWeek:
+		return t.AddDate(0, 0, 7*n)
+	default:
+		return t.AddDate(0, n, 0)
+	}
+}
@@ -0,0 +1,95 @@
+package statistics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeSeriesFillsGapsAndCompares(t *testing.T) {
+	loc := mustLoad(t, "Europe/London")
+	now := time.Date(2024, 3, 20, 12, 0, 0, 0, loc)
+	repo := setupStatisticsRepo(t, now)
+	fanID := uint(7)
+
+	day := func(d, hour int) time.Time { return time.Date(2024, 3, d, hour, 0, 0, 0, loc) }
+	// Previous period: 14-16 March
+	seedVisit(t, repo, nil, "old-guest", day(14, 10))
+	seedVisit(t, repo, &fanID, "old-fan", day(16, 10))
+	// Current period: 17-19 March, nothing on the 18th
+	seedVisit(t, repo, nil, "guest", day(17, 9))
+	seedVisit(t, repo, &fanID, "fan-1", day(17, 20))
+	seedSession(t, repo, &fanID, "fan-2", day(19, 8), 5400, false)
+	// Outside the range
+	seedVisit(t, repo, nil, "late", day(20, 1))
+
+	series, err := repo.GetTimeSeries(MetricVisitors, GranularityDay, day(17, 0), day(20, 0), loc, true)
+	if err != nil {
+		t.Fatalf("GetTimeSeries failed: %v", err)
+	}
+	if len(series.Points) != 3 {
+		t.Fatalf("expected 3 daily points, got %d", len(series.Points))
+	}
+	want := []float64{2, 0, 1}
+	wantPrevious := []float64{1, 0, 1}
+	for i, point := range series.Points {
+		if !point.Start.Equal(day(17+i, 0)) || point.Value != want[i] {
+			t.Fatalf("point %d: expected %v on the %d, got %+v", i, want[i], 17+i, point)
+		}
+		if point.Previous == nil || *point.Previous != wantPrevious[i] {
+			t.Fatalf("point %d: expected previous %v, got %v", i, wantPrevious[i], point.Previous)
+		}
+	}
+	if series.Total != 2 {
+		t.Fatalf("expected 2 distinct visitors, got %v", series.Total)
+	}
+	if series.Previous == nil || series.Previous.Total != 2 || !series.Previous.From.Equal(day(14, 0)) {
+		t.Fatalf("unexpected comparison: %+v", series.Previous)
+	}
+	if series.Previous.Change == nil || *series.Previous.Change != 0 {
+		t.Fatalf("expected no change, got %v", series.Previous.Change)
+	}
+
+	registered, err := repo.GetTimeSeries(MetricRegisteredVisitors, GranularityDay, day(17, 0), day(20, 0), loc, false)
+	if err != nil {
+		t.Fatalf("GetTimeSeries failed: %v", err)
+	}
+	if registered.Points[0].Value != 1 || registered.Total != 1 || registered.Previous != nil {
+		t.Fatalf("unexpected registered visitors: %+v", registered)
+	}
+
+	hours, err := repo.GetTimeSeries(MetricHours, GranularityWeek, day(17, 0), day(20, 0), loc, false)
+	if err != nil {
+		t.Fatalf("GetTimeSeries failed: %v", err)
+	}
+	// The 17th is a Sunday, so the range spans two weeks
+	if len(hours.Points) != 2 || !hours.Points[0].Start.Equal(day(11, 0)) || hours.Points[1].Value != 1.5 || hours.Total != 1.5 {
+		t.Fatalf("unexpected hours: %+v", hours)
+	}
+
+	if _, err := repo.GetTimeSeries(MetricVisitors, GranularityHour, day(1, 0), now.AddDate(1, 0, 0), loc, false); err != ErrTooManyPoints {
+		t.Fatalf("expected ErrTooManyPoints, got %v", err)
+	}
+}
+
+func TestBucketStartsAcrossDST(t *testing.T) {
+	loc := mustLoad(t, "Europe/London")
+
+	// Clocks go forward at 01:00 on 31 March 2024, so that day has 23 hours
+	from := time.Date(2024, 3, 31, 0, 30, 0, 0, loc)
+	to := time.Date(2024, 4, 1, 0, 0, 0, 0, loc)
+	hours, err := bucketStarts(GranularityHour, from, to, loc)
+	if err != nil {
+		t.Fatalf("bucketStarts failed: %v", err)
+	}
+	if len(hours) != 23 || hours[1].Hour() != 2 {
+		t.Fatalf("expected 23 hourly buckets skipping 01:00, got %d starting %v", len(hours), hours[:2])
+	}
+
+	months, err := bucketStarts(GranularityMonth, time.Date(2024, 1, 15, 0, 0, 0, 0, loc), to, loc)
+	if err != nil {
+		t.Fatalf("bucketStarts failed: %v", err)
+	}
+	if len(months) != 3 || !months[2].Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, loc)) {
+		t.Fatalf("unexpected months: %v", months)
+	}
+}
@@ -0,0 +1,349 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultFlushInterval is how often buffered heartbeats are written to user_trackings
+const DefaultFlushInterval = 30 * time.Second
+
+// liveSession is the in-memory state of an active tracking row
+type liveSession struct {
+	id        uint
+	fanID     *uint
+	visitorID string
+	duration  int64
+	lastSeen  time.Time
+	dirty     bool
+}
+
+// HeartbeatAggregator buffers /tracking/update heartbeats in memory and writes the
+// accumulated durations to user_trackings in batches.
+//
+// The database stays the source of truth: each flush writes the absolute duration
+// and the time of the last heartbeat it covers, and start/end/finalize are still
+// written synchronously. If the process dies between flushes, the rows keep the
+// values of the previous flush, so at most one flush interval of heartbeat time is
+// lost and nothing is counted twice. Sessions are reloaded from the database on
+// their next heartbeat after a restart.
+//
+// Fans' sessions stay buffered until they are finalized, so the time in open sessions
+// can be totalled from memory once Load has read the ones opened before a restart.
+// Guests' sessions are dropped once they go quiet.
+type HeartbeatAggregator struct {
+	repo     *FanTrackingRepository
+	interval time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*liveSession // keyed by session ID
+	loaded   bool                    // Every open fan session is buffered, see Load
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// EnableAggregation makes the repository buffer heartbeats in memory.
+// Call Start to begin periodic flushing and Stop on shutdown.
+func (r *FanTrackingRepository) EnableAggregation(interval time.Duration) *HeartbeatAggregator {
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	agg := &HeartbeatAggregator{
+		repo:     r,
+		interval: interval,
+		sessions: make(map[string]*liveSession),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	r.live = agg
+	return agg
+}
+
+// Start loads the open fan sessions and flushes buffered heartbeats every interval
+// until Stop is called or ctx is done. Call it before tracking requests are served.
+func (a *HeartbeatAggregator) Start(ctx context.Context) {
+	if err := a.Load(); err != nil {
+		log.Printf("Warning: Failed to load open tracking sessions: %v", err)
+	}
+	a.started = true
+	go a.run(ctx)
+}
+
+// Load buffers every open fan session, after which their time is totalled from memory
+// instead of the database
+func (a *HeartbeatAggregator) Load() error {
+	var open []FanTracking
+	if err := a.repo.db.Where("end_time IS NULL AND user_id IS NOT NULL").Find(&open).Error; err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range open {
+		a.addLocked(&open[i])
+	}
+	a.loaded = true
+	return nil
+}
+
+func (a *HeartbeatAggregator) run(ctx context.Context) {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Flush(); err != nil {
+				log.Printf("Warning: Failed to flush tracking heartbeats: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Stop ends the flush loop started by Start and writes any remaining heartbeats
+func (a *HeartbeatAggregator) Stop() error {
+	select {
+	case <-a.stop:
+	default:
+		close(a.stop)
+	}
+	if a.started {
+		<-a.done
+	}
+	return a.Flush()
+}
+
+// Flush writes all buffered heartbeats in one transaction and forgets sessions that
+// have gone quiet
+func (a *HeartbeatAggregator) Flush() error {
+	now := time.Now()
+
+	a.mu.Lock()
+	pending := make([]liveSession, 0, len(a.sessions))
+	for sessionID, s := range a.sessions {
+		if s.dirty {
+			pending = append(pending, *s)
+			s.dirty = false
+		} else if s.fanID == nil && now.Sub(s.lastSeen) > inactiveSessionGracePeriod {
+			delete(a.sessions, sessionID)
+		}
+	}
+	a.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := a.repo.db.Transaction(func(tx *gorm.DB) error {
+		for _, s := range pending {
+			// end_time guard: a session finalized since the snapshot keeps its final duration
+			if err := tx.Model(&FanTracking{}).
+				Where("id = ? AND end_time IS NULL", s.id).
+				Updates(map[string]interface{}{
+					"duration":   s.duration,
+					"updated_at": s.lastSeen,
+				}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		a.markDirty(pending)
+	}
+	return err
+}
+
+// ActiveCount returns the number of sessions that sent a heartbeat within the grace period
+func (a *HeartbeatAggregator) ActiveCount() int {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	count := 0
+	for _, s := range a.sessions {
+		if now.Sub(s.lastSeen) <= inactiveSessionGracePeriod {
+			count++
+		}
+	}
+	return count
+}
+
+// fanSeconds returns the time counted so far in the buffered sessions of a fan, or of
+// all fans when fanID is nil, and whether every open fan session is buffered
+func (a *HeartbeatAggregator) fanSeconds(fanID *uint, now time.Time) (int64, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var seconds int64
+	for _, s := range a.sessions {
+		if s.fanID == nil || (fanID != nil && *s.fanID != *fanID) {
+			continue
+		}
+		seconds += s.duration
+		if now.After(s.lastSeen) && now.Sub(s.lastSeen) <= inactiveSessionGracePeriod {
+			seconds += int64(now.Sub(s.lastSeen).Seconds())
+		}
+	}
+	return seconds, a.loaded
+}
+
+// heartbeat extends the buffered session, loading it with db on first sight
+func (a *HeartbeatAggregator) heartbeat(db *gorm.DB, sessionID string, fanID *uint, visitorID string, now time.Time) error {
+	a.mu.Lock()
+	s, ok := a.sessions[sessionID]
+	if ok && sameOwner(s, fanID, visitorID) {
+		if now.After(s.lastSeen) && now.Sub(s.lastSeen) <= inactiveSessionGracePeriod {
+			s.duration += int64(now.Sub(s.lastSeen).Seconds())
+		}
+		s.lastSeen = now
+		s.dirty = true
+		a.mu.Unlock()
+		return nil
+	}
+	a.mu.Unlock()
+
+	tracking, err := findActiveSession(db, sessionID, fanID, visitorID)
+	if err != nil || tracking == nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sessions[sessionID] = &liveSession{
+		id:        tracking.ID,
+		fanID:     tracking.FanID,
+		visitorID: tracking.VisitorID,
+		duration:  calculateDuration(tracking, now),
+		lastSeen:  now,
+		dirty:     true,
+	}
+	return nil
+}
+
+// overlay replaces a row's duration and last update with the buffered values, if any
+func (a *HeartbeatAggregator) overlay(tracking *FanTracking) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if s, ok := a.sessions[tracking.SessionID]; ok && s.id == tracking.ID {
+		tracking.Duration = s.duration
+		tracking.UpdatedAt = s.lastSeen
+	}
+}
+
+// add buffers a newly opened row
+func (a *HeartbeatAggregator) add(tracking *FanTracking) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.addLocked(tracking)
+}
+
+func (a *HeartbeatAggregator) addLocked(tracking *FanTracking) {
+	if s, ok := a.sessions[tracking.SessionID]; ok && s.id >= tracking.ID {
+		return
+	}
+	lastSeen := tracking.UpdatedAt
+	if lastSeen.IsZero() {
+		lastSeen = tracking.StartTime
+	}
+	a.sessions[tracking.SessionID] = &liveSession{
+		id:        tracking.ID,
+		fanID:     tracking.FanID,
+		visitorID: tracking.VisitorID,
+		duration:  tracking.Duration,
+		lastSeen:  lastSeen,
+	}
+}
+
+// remove drops a row from the buffer once it has been finalized. Finalizing applies
+// the buffered values with overlay first, so a rolled back finalize loses nothing.
+func (a *HeartbeatAggregator) remove(tracking *FanTracking) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if s, ok := a.sessions[tracking.SessionID]; ok && s.id == tracking.ID {
+		delete(a.sessions, tracking.SessionID)
+	}
+}
+
+// reassign moves a guest visitor's buffered sessions to the fan they became
+func (a *HeartbeatAggregator) reassign(visitorID string, fanID uint) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, s := range a.sessions {
+		if s.fanID == nil && s.visitorID == visitorID {
+			id := fanID
+			s.fanID = &id
+		}
+	}
+}
+
+// forgetFan drops a fan's buffered sessions, used when their tracking is erased
+func (a *HeartbeatAggregator) forgetFan(fanID uint) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for sessionID, s := range a.sessions {
+		if s.fanID != nil && *s.fanID == fanID {
+			delete(a.sessions, sessionID)
+		}
+	}
+}
+
+func (a *HeartbeatAggregator) markDirty(pending []liveSession) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, p := range pending {
+		for _, s := range a.sessions {
+			if s.id == p.id {
+				s.dirty = true
+			}
+		}
+	}
+}
+
+func sameOwner(s *liveSession, fanID *uint, visitorID string) bool {
+	if fanID != nil {
+		return s.fanID != nil && *s.fanID == *fanID
+	}
+	return visitorID != "" && s.fanID == nil && s.visitorID == visitorID
+}
+
+// findActiveSession returns the most recent active row for a session owned by the
+// given fan or guest visitor, or nil if there is none
+func findActiveSession(db *gorm.DB, sessionID string, fanID *uint, visitorID string) (*FanTracking, error) {
+	query := db.Where("session_id = ? AND end_time IS NULL", sessionID)
+	if fanID != nil {
+		query = query.Where("user_id = ?", *fanID)
+	} else if visitorID != "" {
+		query = query.Where("user_id IS NULL AND visitor_id = ?", visitorID)
+	} else {
+		return nil, nil
+	}
+
+	var tracking FanTracking
+	if err := query.Order("start_time DESC").First(&tracking).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &tracking, nil
+}
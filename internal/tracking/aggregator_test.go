@@ -0,0 +1,165 @@
+package tracking
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregatorBuffersHeartbeatsUntilFlush(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	agg := repo.EnableAggregation(time.Hour)
+
+	fanID := uint(7)
+	tracking, err := repo.StartTracking(&fanID, "", "sess-agg", ClientInfo{})
+	if err != nil {
+		t.Fatalf("failed to start tracking: %v", err)
+	}
+	var created FanTracking
+	repo.db.First(&created, tracking.ID)
+	start := created.UpdatedAt
+
+	if err := agg.heartbeat(repo.db, "sess-agg", &fanID, "", start.Add(30*time.Second)); err != nil {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+	if err := agg.heartbeat(repo.db, "sess-agg", &fanID, "", start.Add(60*time.Second)); err != nil {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+
+	var stored FanTracking
+	repo.db.First(&stored, tracking.ID)
+	if stored.Duration != 0 {
+		t.Fatalf("expected heartbeats to be buffered, got stored duration %d", stored.Duration)
+	}
+	if agg.ActiveCount() != 1 {
+		t.Fatalf("expected one live session, got %d", agg.ActiveCount())
+	}
+
+	if err := agg.Flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	repo.db.First(&stored, tracking.ID)
+	if stored.Duration != 60 {
+		t.Fatalf("expected flushed duration 60, got %d", stored.Duration)
+	}
+}
+
+func TestAggregatorRecoversFromLostBuffer(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	agg := repo.EnableAggregation(time.Hour)
+
+	fanID := uint(8)
+	tracking, err := repo.StartTracking(&fanID, "", "sess-crash", ClientInfo{})
+	if err != nil {
+		t.Fatalf("failed to start tracking: %v", err)
+	}
+	var created FanTracking
+	repo.db.First(&created, tracking.ID)
+	start := created.UpdatedAt
+
+	agg.heartbeat(repo.db, "sess-crash", &fanID, "", start.Add(30*time.Second))
+	if err := agg.Flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	// This heartbeat is never flushed, as if the process died
+	agg.heartbeat(repo.db, "sess-crash", &fanID, "", start.Add(60*time.Second))
+
+	restarted := NewFanTrackingRepository(repo.db)
+	restartedAgg := restarted.EnableAggregation(time.Hour)
+
+	var stored FanTracking
+	restarted.db.First(&stored, tracking.ID)
+	if stored.Duration != 30 {
+		t.Fatalf("expected last flushed duration 30 after restart, got %d", stored.Duration)
+	}
+
+	// The next heartbeat continues from the stored state without counting anything twice
+	restartedAgg.heartbeat(restarted.db, "sess-crash", &fanID, "", start.Add(90*time.Second))
+	if err := restartedAgg.Flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	restarted.db.First(&stored, tracking.ID)
+	if stored.Duration != 90 {
+		t.Fatalf("expected duration 90 after recovery, got %d", stored.Duration)
+	}
+}
+
+func TestAggregatorEndUsesBufferedDuration(t *testing.T) {
+	repo := setupTrackingRepo(t)
+
+	fanID := uint(9)
+	if _, err := repo.StartTracking(&fanID, "", "sess-end", ClientInfo{}); err != nil {
+		t.Fatalf("failed to start tracking: %v", err)
+	}
+	// Started before a restart, heartbeats up to now so ending adds only a moment on top
+	repo.db.Exec("UPDATE user_trackings SET start_time = ?, updated_at = ? WHERE session_id = ?",
+		time.Now().Add(-50*time.Second), time.Now().Add(-50*time.Second), "sess-end")
+	agg := repo.EnableAggregation(time.Hour)
+	if err := repo.UpdateActiveSession("sess-end", &fanID, ""); err != nil {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+
+	if err := repo.EndTracking("sess-end", &fanID); err != nil {
+		t.Fatalf("failed to end tracking: %v", err)
+	}
+
+	var stored FanTracking
+	repo.db.Where("session_id = ?", "sess-end").First(&stored)
+	if stored.EndTime == nil || stored.Duration < 50 || stored.Duration > 52 {
+		t.Fatalf("expected ended session with ~50s, got end=%v duration=%d", stored.EndTime, stored.Duration)
+	}
+	if agg.ActiveCount() != 0 {
+		t.Fatalf("expected ended session to leave the buffer")
+	}
+
+	if err := agg.Stop(); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+}
+
+func TestAggregatorServesTotalsAndSurvivesRollback(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	agg := repo.EnableAggregation(time.Hour)
+	if err := agg.Load(); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	fanID := uint(10)
+	tracking, err := repo.StartTracking(&fanID, "", "sess-batch", ClientInfo{})
+	if err != nil {
+		t.Fatalf("failed to start tracking: %v", err)
+	}
+	var created FanTracking
+	repo.db.First(&created, tracking.ID)
+	agg.heartbeat(repo.db, "sess-batch", &fanID, "", created.UpdatedAt.Add(60*time.Second))
+
+	// Open sessions are totalled from the buffer, not the stored rows
+	repo.db.Exec("UPDATE user_trackings SET duration = 999 WHERE id = ?", tracking.ID)
+	hours, err := repo.GetFanTotalHours(fanID)
+	if err != nil {
+		t.Fatalf("GetFanTotalHours failed: %v", err)
+	}
+	if hours != 60.0/3600.0 {
+		t.Fatalf("expected 60 buffered seconds, got %v hours", hours)
+	}
+
+	ops := []BatchOp{
+		{ClientEventID: "end", Type: OpEnd},
+		{ClientEventID: "broken", Type: "unknown"},
+	}
+	if _, err := repo.ApplyBatch(&fanID, "", "sess-batch", ops); err == nil {
+		t.Fatalf("expected the unknown operation to fail the batch")
+	}
+	if agg.ActiveCount() != 1 {
+		t.Fatalf("expected the rolled back end to leave the session buffered")
+	}
+	if hours, err := repo.GetTotalHours(); err != nil || hours != 60.0/3600.0 {
+		t.Fatalf("expected 60 buffered seconds after the rollback, got %v hours (err %v)", hours, err)
+	}
+
+	if _, err := repo.ApplyBatch(&fanID, "", "sess-batch", ops[:1]); err != nil {
+		t.Fatalf("ApplyBatch failed: %v", err)
+	}
+	if agg.ActiveCount() != 0 {
+		t.Fatalf("expected the committed end to leave the buffer")
+	}
+}
@@ -0,0 +1,102 @@
+package tracking
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Batch operation types
+const (
+	OpStart  = "start"
+	OpUpdate = "update"
+	OpEnd    = "end"
+	OpEvent  = "event"
+)
+
+// ProcessedEventWindow is how long client event IDs are remembered. Beacons are only
+// retried within minutes, so older markers are pruned by the retention job.
+const ProcessedEventWindow = 24 * time.Hour
+
+// BatchOp is a single tracking operation sent in a batch request
+type BatchOp struct {
+	ClientEventID string
+	Type          string
+	Event         *TrackingEvent // Set for OpEvent
+	Client        ClientInfo     // Set for OpStart
+}
+
+// BatchResult reports how many operations of a batch were applied and how many were
+// skipped because their client event ID had already been processed
+type BatchResult struct {
+	Applied    int `json:"applied"`
+	Duplicates int `json:"duplicates"`
+}
+
+// ApplyBatch applies the operations in order inside one transaction.
+// Operations whose client event ID was already seen for the session are skipped.
+// Finalize listeners are called once the transaction has committed.
+func (r *FanTrackingRepository) ApplyBatch(fanID *uint, visitorID, sessionID string, ops []BatchOp) (BatchResult, error) {
+	var result BatchResult
+	var txRepo *FanTrackingRepository
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		txRepo = r.withTx(tx)
+		result = BatchResult{}
+
+		for _, op := range ops {
+			marker := ProcessedEvent{SessionID: sessionID, ClientEventID: op.ClientEventID}
+			inserted := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&marker)
+			if inserted.Error != nil {
+				return inserted.Error
+			}
+			if inserted.RowsAffected == 0 {
+				result.Duplicates++
+				continue
+			}
+
+			if err := txRepo.applyOp(fanID, visitorID, sessionID, op); err != nil {
+				return fmt.Errorf("op %s: %w", op.ClientEventID, err)
+			}
+			result.Applied++
+		}
+		return nil
+	})
+	if err == nil {
+		txRepo.flushNotifications()
+	}
+	return result, err
+}
+
+func (r *FanTrackingRepository) applyOp(fanID *uint, visitorID, sessionID string, op BatchOp) error {
+	switch op.Type {
+	case OpStart:
+		_, err := r.StartTracking(fanID, visitorID, sessionID, op.Client)
+		return err
+	case OpUpdate:
+		return r.UpdateActiveSession(sessionID, fanID, visitorID)
+	case OpEnd:
+		return r.EndTracking(sessionID, fanID)
+	case OpEvent:
+		if op.Event == nil {
+			return fmt.Errorf("missing event")
+		}
+		return r.RecordEvent(op.Event)
+	default:
+		return fmt.Errorf("unknown operation %q", op.Type)
+	}
+}
+
+// PruneProcessedEvents deletes the client event IDs processed before the given time, or
+// with dryRun only counts them
+func (r *FanTrackingRepository) PruneProcessedEvents(before time.Time, dryRun bool) (int64, error) {
+	query := r.db.Model(&ProcessedEvent{}).Where("created_at < ?", before)
+	if dryRun {
+		var count int64
+		err := query.Count(&count).Error
+		return count, err
+	}
+	result := query.Delete(&ProcessedEvent{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,120 @@
+package tracking
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ConsentPolicyVersion is the version of the tracking policy fans currently consent to.
+// Bump it when the policy changes so fans are asked again; consent given for an older
+// version counts as missing.
+const ConsentPolicyVersion = "2026-10"
+
+// TrackingConsent is a fan's answer to the tracking consent prompt
+type TrackingConsent struct {
+	FanID         uint      `gorm:"column:user_id;primaryKey;autoIncrement:false" json:"user_id"`
+	Granted       bool      `gorm:"not null" json:"granted"`
+	PolicyVersion string    `gorm:"type:varchar(32);not null" json:"policy_version"`
+	DecidedAt     time.Time `gorm:"not null" json:"decided_at"`
+}
+
+// TableName sets the table name for tracking consents
+func (TrackingConsent) TableName() string {
+	return "tracking_consents"
+}
+
+// Current reports whether the consent was granted for the current policy version
+func (c *TrackingConsent) Current() bool {
+	return c != nil && c.Granted && c.PolicyVersion == ConsentPolicyVersion
+}
+
+// GetConsent returns a fan's consent record, or nil if they never answered
+func (r *FanTrackingRepository) GetConsent(fanID uint) (*TrackingConsent, error) {
+	var consent TrackingConsent
+	if err := r.db.Where("user_id = ?", fanID).First(&consent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &consent, nil
+}
+
+// HasConsent reports whether a fan granted consent for the current policy version
+func (r *FanTrackingRepository) HasConsent(fanID uint) (bool, error) {
+	consent, err := r.GetConsent(fanID)
+	if err != nil {
+		return false, err
+	}
+	return consent.Current(), nil
+}
+
+// SetConsent records a fan's answer for the current policy version.
+// Denying consent ends the fan's active sessions so no more time is counted.
+func (r *FanTrackingRepository) SetConsent(fanID uint, granted bool) (*TrackingConsent, error) {
+	consent := &TrackingConsent{
+		FanID:         fanID,
+		Granted:       granted,
+		PolicyVersion: ConsentPolicyVersion,
+		DecidedAt:     time.Now(),
+	}
+	if err := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"granted", "policy_version", "decided_at"}),
+	}).Create(consent).Error; err != nil {
+		return nil, err
+	}
+
+	if !granted {
+		if err := r.finalizeActiveSessionsForFan(&fanID); err != nil {
+			return nil, err
+		}
+	}
+	return consent, nil
+}
+
+// EraseFanTracking deletes all of a fan's tracking rows, events, content views, session
+// sources, funnel steps and rollups, so they no longer count towards any statistics. It returns the
+// number of tracking rows deleted.
+func (r *FanTrackingRepository) EraseFanTracking(fanID uint) (int64, error) {
+	var deleted int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ?", fanID).Delete(&FanTracking{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+
+		if err := tx.Where("user_id = ?", fanID).Delete(&TrackingEvent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", fanID).Delete(&ContentView{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", fanID).Delete(&SessionSource{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", fanID).Delete(&FanStreak{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", fanID).Delete(&FunnelEvent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", fanID).Delete(&Rollup{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", fanID).Delete(&VisitorRollup{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if r.live != nil {
+		r.live.forgetFan(fanID)
+	}
+	r.notifyFinalized()
+	return deleted, nil
+}
@@ -0,0 +1,265 @@
+package tracking
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Content types that record views
+const (
+	ContentPost    = "post"
+	ContentProject = "project"
+)
+
+// ContentViewWindow is how long a session's view of an item lasts after its last ping.
+// Pings within the window update the same view instead of counting a new one.
+const ContentViewWindow = 30 * time.Minute
+
+// contentTables maps content types to the tables that hold them
+var contentTables = map[string]string{
+	ContentPost:    "posts",
+	ContentProject: "projects",
+}
+
+// ErrUnknownContent is returned when a view is recorded for an item that doesn't exist
+var ErrUnknownContent = errors.New("unknown content")
+
+// ContentView is one session's view of a post or project
+type ContentView struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ContentType string    `gorm:"type:varchar(16);not null;index:idx_content_views_content" json:"content_type"`
+	ContentID   int       `gorm:"not null;index:idx_content_views_content" json:"content_id"`
+	SessionID   string    `gorm:"type:varchar(255);index;not null" json:"session_id"`
+	FanID       *uint     `gorm:"column:user_id;index" json:"user_id"` // Nullable for guests
+	VisitorID   string    `gorm:"type:varchar(64);index" json:"visitor_id,omitempty"`
+	ReadSeconds int64     `gorm:"default:0" json:"read_seconds"`
+	ScrollDepth int       `gorm:"default:0" json:"scroll_depth"` // Furthest scroll in percent, posts only
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"` // Last ping
+}
+
+// TableName sets the table name for content views
+func (ContentView) TableName() string {
+	return "content_views"
+}
+
+// ContentPing is a client report that a session is viewing an item
+type ContentPing struct {
+	ContentType string
+	ContentID   int
+	SessionID   string
+	FanID       *uint
+	VisitorID   string
+	ReadSeconds int64 // Seconds spent reading so far, as measured by the client
+	ScrollDepth int   // Furthest scroll so far in percent
+}
+
+// ContentStats summarises the views of one item
+type ContentStats struct {
+	ContentType    string             `json:"content_type"`
+	ContentID      int                `json:"content_id"`
+	Title          string             `json:"title"`
+	Views          int64              `json:"views"`
+	Sessions       int64              `json:"sessions"`
+	AvgReadSeconds float64            `json:"avg_read_seconds"`
+	ScrollDepth    *ScrollDepthReport `json:"scroll_depth,omitempty"` // Posts only
+}
+
+// ScrollDepthReport describes how far readers scrolled through a post
+type ScrollDepthReport struct {
+	Average   float64 `json:"average"`
+	Reached25 int64   `json:"reached_25"`
+	Reached50 int64   `json:"reached_50"`
+	Reached75 int64   `json:"reached_75"`
+	Completed int64   `json:"completed"`
+}
+
+// ValidContentType reports whether views can be recorded for the content type
+func ValidContentType(contentType string) bool {
+	_, ok := contentTables[contentType]
+	return ok
+}
+
+// RecordContentView counts a view of an item, or updates the session's current view
+// when it pinged within ContentViewWindow. Read time and scroll depth only grow, so
+// repeated pings are harmless. It returns whether a new view was counted.
+func (r *FanTrackingRepository) RecordContentView(ping ContentPing) (bool, error) {
+	table, ok := contentTables[ping.ContentType]
+	if !ok {
+		return false, ErrUnknownContent
+	}
+	var exists int64
+	if err := r.db.Table(table).Where("id = ?", ping.ContentID).Count(&exists).Error; err != nil {
+		return false, err
+	}
+	if exists == 0 {
+		return false, ErrUnknownContent
+	}
+
+	if ping.ContentType != ContentPost {
+		ping.ScrollDepth = 0
+	}
+	ping.ScrollDepth = min(max(ping.ScrollDepth, 0), 100)
+	ping.ReadSeconds = max(ping.ReadSeconds, 0)
+
+	now := time.Now()
+	created := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var view ContentView
+		err := tx.Where("content_type = ? AND content_id = ? AND session_id = ? AND updated_at >= ?",
+			ping.ContentType, ping.ContentID, ping.SessionID, now.Add(-ContentViewWindow)).
+			Order("updated_at DESC").
+			First(&view).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			created = true
+			return tx.Create(&ContentView{
+				ContentType: ping.ContentType,
+				ContentID:   ping.ContentID,
+				SessionID:   ping.SessionID,
+				FanID:       ping.FanID,
+				VisitorID:   ping.VisitorID,
+				ScrollDepth: ping.ScrollDepth,
+			}).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		// Read time can't exceed the time since the view started
+		elapsed := int64(now.Sub(view.CreatedAt).Seconds()) + 1
+		updates := map[string]interface{}{
+			"read_seconds": max(view.ReadSeconds, min(ping.ReadSeconds, elapsed)),
+			"scroll_depth": max(view.ScrollDepth, ping.ScrollDepth),
+			"updated_at":   now,
+		}
+		if ping.FanID != nil && view.FanID == nil {
+			updates["user_id"] = *ping.FanID
+		}
+		return tx.Model(&view).Updates(updates).Error
+	})
+	return created, err
+}
+
+// GetContentStats returns the views of one item between from and to
+func (r *FanTrackingRepository) GetContentStats(contentType string, contentID int, from, to time.Time) (*ContentStats, error) {
+	stats, err := r.contentStats(from, to, contentType, &contentID, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(stats) == 0 {
+		empty := ContentStats{ContentType: contentType, ContentID: contentID}
+		if contentType == ContentPost {
+			empty.ScrollDepth = &ScrollDepthReport{}
+		}
+		if err := r.fillContentTitles([]ContentStats{empty}); err != nil {
+			return nil, err
+		}
+		return &empty, nil
+	}
+	return &stats[0], nil
+}
+
+// GetTopContent returns the most viewed items between from and to, optionally limited
+// to one content type
+func (r *FanTrackingRepository) GetTopContent(from, to time.Time, contentType string, limit int) ([]ContentStats, error) {
+	return r.contentStats(from, to, contentType, nil, limit)
+}
+
+func (r *FanTrackingRepository) contentStats(from, to time.Time, contentType string, contentID *int, limit int) ([]ContentStats, error) {
+	query := r.db.Model(&ContentView{}).
+		Select(`content_type, content_id,
+			COUNT(*) AS views,
+			COUNT(DISTINCT session_id) AS sessions,
+			AVG(read_seconds) AS avg_read_seconds,
+			AVG(scroll_depth) AS avg_scroll_depth,
+			SUM(CASE WHEN scroll_depth >= 25 THEN 1 ELSE 0 END) AS reached25,
+			SUM(CASE WHEN scroll_depth >= 50 THEN 1 ELSE 0 END) AS reached50,
+			SUM(CASE WHEN scroll_depth >= 75 THEN 1 ELSE 0 END) AS reached75,
+			SUM(CASE WHEN scroll_depth >= 100 THEN 1 ELSE 0 END) AS completed`).
+		Where("created_at >= ? AND created_at < ?", from, to)
+	if contentType != "" {
+		query = query.Where("content_type = ?", contentType)
+	}
+	if contentID != nil {
+		query = query.Where("content_id = ?", *contentID)
+	}
+
+	var rows []struct {
+		ContentType    string
+		ContentID      int
+		Views          int64
+		Sessions       int64
+		AvgReadSeconds float64
+		AvgScrollDepth float64
+		Reached25      int64
+		Reached50      int64
+		Reached75      int64
+		Completed      int64
+	}
+	if err := query.
+		Group("content_type, content_id").
+		Order("views DESC, content_type ASC, content_id ASC").
+		Limit(limit).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make([]ContentStats, 0, len(rows))
+	for _, row := range rows {
+		item := ContentStats{
+			ContentType:    row.ContentType,
+			ContentID:      row.ContentID,
+			Views:          row.Views,
+			Sessions:       row.Sessions,
+			AvgReadSeconds: row.AvgReadSeconds,
+		}
+		if row.ContentType == ContentPost {
+			item.ScrollDepth = &ScrollDepthReport{
+				Average:   row.AvgScrollDepth,
+				Reached25: row.Reached25,
+				Reached50: row.Reached50,
+				Reached75: row.Reached75,
+				Completed: row.Completed,
+			}
+		}
+		stats = append(stats, item)
+	}
+
+	if err := r.fillContentTitles(stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// fillContentTitles looks up the names of the items. Deleted items keep an empty title.
+func (r *FanTrackingRepository) fillContentTitles(stats []ContentStats) error {
+	ids := make(map[string][]int)
+	for _, item := range stats {
+		ids[item.ContentType] = append(ids[item.ContentType], item.ContentID)
+	}
+
+	titles := make(map[string]map[int]string)
+	for contentType, contentIDs := range ids {
+		var rows []struct {
+			ID   int
+			Name string
+		}
+		if err := r.db.Table(contentTables[contentType]).
+			Select("id, name").
+			Where("id IN ?", contentIDs).
+			Scan(&rows).Error; err != nil {
+			return err
+		}
+		titles[contentType] = make(map[int]string, len(rows))
+		for _, row := range rows {
+			titles[contentType][row.ID] = row.Name
+		}
+	}
+
+	for i := range stats {
+		stats[i].Title = titles[stats[i].ContentType][stats[i].ContentID]
+	}
+	return nil
+}
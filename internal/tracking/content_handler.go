@@ -0,0 +1,138 @@
+package tracking
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type contentViewRequest struct {
+	SessionID   string `json:"session_id" binding:"required,max=255"`
+	ContentType string `json:"content_type" binding:"required"`
+	ContentID   int    `json:"content_id" binding:"required"`
+	ReadSeconds int64  `json:"read_seconds"`
+	ScrollDepth int    `json:"scroll_depth"`
+}
+
+// RecordContentView godoc
+// @Summary Record a view of a post or project
+// @Description Send when the item is opened and again while it is read. Pings from the same session within 30 minutes update one view.
+// @Description read_seconds is the time spent reading so far; scroll_depth the furthest scroll in percent (posts only).
+// @Tags tracking
+// @Accept json
+// @Produce json
+// @Param body body TrackingContentViewRequest true "View"
+// @Success 200 {object} TrackingContentViewResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/content [post]
+func (h *TrackingHandler) RecordContentView(c *gin.Context) {
+	var req contentViewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !ValidContentType(req.ContentType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content_type must be post or project"})
+		return
+	}
+
+	fanID, visitorID := trackingIdentity(c)
+	if h.skipTracking(c, fanID) {
+		return
+	}
+
+	counted, err := h.trackingRepo.RecordContentView(ContentPing{
+		ContentType: req.ContentType,
+		ContentID:   req.ContentID,
+		SessionID:   req.SessionID,
+		FanID:       fanID,
+		VisitorID:   visitorID,
+		ReadSeconds: req.ReadSeconds,
+		ScrollDepth: req.ScrollDepth,
+	})
+	if err != nil {
+		if errors.Is(err, ErrUnknownContent) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Content not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record view"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "View recorded successfully", "counted": counted})
+}
+
+// GetContentStats godoc
+// @Summary Views of a post or project
+// @Tags tracking
+// @Produce json
+// @Param type path string true "Content type" Enums(post, project)
+// @Param id path int true "Content ID"
+// @Param from query string false "Start (RFC3339 or YYYY-MM-DD), defaults to 30 days ago"
+// @Param to query string false "End (RFC3339 or YYYY-MM-DD), defaults to now"
+// @Success 200 {object} tracking.ContentStats
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/content/{type}/{id} [get]
+func (h *TrackingHandler) GetContentStats(c *gin.Context) {
+	contentType := c.Param("type")
+	if !ValidContentType(contentType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content type must be post or project"})
+		return
+	}
+	contentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := h.trackingRepo.GetContentStats(contentType, contentID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get content views"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetTopContent godoc
+// @Summary Most viewed posts and projects
+// @Tags tracking
+// @Produce json
+// @Param type query string false "Content type" Enums(post, project)
+// @Param from query string false "Start (RFC3339 or YYYY-MM-DD), defaults to 30 days ago"
+// @Param to query string false "End (RFC3339 or YYYY-MM-DD), defaults to now"
+// @Param limit query int false "Max rows (1-100)" default(10)
+// @Success 200 {array} tracking.ContentStats
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/content/top [get]
+func (h *TrackingHandler) GetTopContent(c *gin.Context) {
+	contentType := c.Query("type")
+	if contentType != "" && !ValidContentType(contentType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be post or project"})
+		return
+	}
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	content, err := h.trackingRepo.GetTopContent(from, to, contentType, parseLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get top content"})
+		return
+	}
+
+	c.JSON(http.StatusOK, content)
+}
@@ -0,0 +1,132 @@
+package tracking
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func setupContentRepo(t *testing.T) *FanTrackingRepository {
+	t.Helper()
+
+	repo := setupTrackingRepo(t)
+	for _, statement := range []string{
+		"CREATE TABLE posts (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE projects (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO posts (id, name) VALUES (1, 'First post'), (2, 'Second post')",
+		"INSERT INTO projects (id, name) VALUES (1, 'A project')",
+	} {
+		if err := repo.db.Exec(statement).Error; err != nil {
+			t.Fatalf("failed to seed content: %v", err)
+		}
+	}
+	return repo
+}
+
+func TestContentViewsAreDeduplicatedPerSessionWindow(t *testing.T) {
+	repo := setupContentRepo(t)
+	ping := ContentPing{ContentType: ContentPost, ContentID: 1, SessionID: "reader-1"}
+
+	counted, err := repo.RecordContentView(ping)
+	if err != nil || !counted {
+		t.Fatalf("first ping: counted=%t err=%v", counted, err)
+	}
+
+	ping.ReadSeconds = 3600 // More than has elapsed, so it is capped
+	ping.ScrollDepth = 80
+	counted, err = repo.RecordContentView(ping)
+	if err != nil || counted {
+		t.Fatalf("ping within the window: counted=%t err=%v", counted, err)
+	}
+	ping.ScrollDepth = 40 // Scrolling back up doesn't lower the depth
+	if _, err := repo.RecordContentView(ping); err != nil {
+		t.Fatalf("failed to record ping: %v", err)
+	}
+
+	var views []ContentView
+	repo.db.Find(&views)
+	if len(views) != 1 {
+		t.Fatalf("expected 1 view, got %d", len(views))
+	}
+	if views[0].ScrollDepth != 80 || views[0].ReadSeconds > 2 {
+		t.Fatalf("unexpected view: %+v", views[0])
+	}
+
+	// After the window the same session counts a new view
+	repo.db.Exec("UPDATE content_views SET updated_at = ?", time.Now().Add(-ContentViewWindow-time.Minute))
+	counted, err = repo.RecordContentView(ContentPing{ContentType: ContentPost, ContentID: 1, SessionID: "reader-1"})
+	if err != nil || !counted {
+		t.Fatalf("ping after the window: counted=%t err=%v", counted, err)
+	}
+
+	if _, err := repo.RecordContentView(ContentPing{ContentType: ContentPost, ContentID: 99, SessionID: "reader-1"}); !errors.Is(err, ErrUnknownContent) {
+		t.Fatalf("expected ErrUnknownContent for a missing post, got %v", err)
+	}
+	if _, err := repo.RecordContentView(ContentPing{ContentType: "page", ContentID: 1, SessionID: "reader-1"}); !errors.Is(err, ErrUnknownContent) {
+		t.Fatalf("expected ErrUnknownContent for an unknown type, got %v", err)
+	}
+}
+
+func TestContentStats(t *testing.T) {
+	repo := setupContentRepo(t)
+	now := time.Now()
+
+	views := []ContentView{
+		{ContentType: ContentPost, ContentID: 1, SessionID: "s1", ReadSeconds: 60, ScrollDepth: 100},
+		{ContentType: ContentPost, ContentID: 1, SessionID: "s2", ReadSeconds: 30, ScrollDepth: 50},
+		{ContentType: ContentPost, ContentID: 1, SessionID: "s2", ReadSeconds: 0, ScrollDepth: 10},
+		{ContentType: ContentPost, ContentID: 2, SessionID: "s1", ReadSeconds: 10, ScrollDepth: 20},
+		{ContentType: ContentProject, ContentID: 1, SessionID: "s1", ReadSeconds: 20},
+		{ContentType: ContentProject, ContentID: 1, SessionID: "s3", ReadSeconds: 40},
+	}
+	for i := range views {
+		if err := repo.db.Create(&views[i]).Error; err != nil {
+			t.Fatalf("failed to seed view: %v", err)
+		}
+	}
+	old := ContentView{ContentType: ContentPost, ContentID: 2, SessionID: "s9", CreatedAt: now.AddDate(0, 0, -40)}
+	if err := repo.db.Create(&old).Error; err != nil {
+		t.Fatalf("failed to seed view: %v", err)
+	}
+
+	from, to := now.AddDate(0, 0, -30), now.Add(time.Minute)
+
+	post, err := repo.GetContentStats(ContentPost, 1, from, to)
+	if err != nil {
+		t.Fatalf("GetContentStats failed: %v", err)
+	}
+	if post.Title != "First post" || post.Views != 3 || post.Sessions != 2 || post.AvgReadSeconds != 30 {
+		t.Fatalf("unexpected post stats: %+v", post)
+	}
+	depth := post.ScrollDepth
+	if depth == nil || depth.Reached25 != 2 || depth.Reached50 != 2 || depth.Reached75 != 1 || depth.Completed != 1 {
+		t.Fatalf("unexpected scroll depth: %+v", depth)
+	}
+
+	unviewed, err := repo.GetContentStats(ContentProject, 2, from, to)
+	if err != nil {
+		t.Fatalf("GetContentStats failed: %v", err)
+	}
+	if unviewed.Views != 0 || unviewed.ScrollDepth != nil {
+		t.Fatalf("unexpected stats for an unviewed project: %+v", unviewed)
+	}
+
+	top, err := repo.GetTopContent(from, to, "", 2)
+	if err != nil {
+		t.Fatalf("GetTopContent failed: %v", err)
+	}
+	if len(top) != 2 || top[0].ContentID != 1 || top[0].Views != 3 {
+		t.Fatalf("unexpected top content: %+v", top)
+	}
+	if top[1].ContentType != ContentProject || top[1].Title != "A project" || top[1].AvgReadSeconds != 30 || top[1].ScrollDepth != nil {
+		t.Fatalf("unexpected second item: %+v", top[1])
+	}
+
+	posts, err := repo.GetTopContent(from, to, ContentPost, 10)
+	if err != nil {
+		t.Fatalf("GetTopContent failed: %v", err)
+	}
+	if len(posts) != 2 || posts[1].ContentID != 2 || posts[1].Views != 1 {
+		t.Fatalf("unexpected top posts: %+v", posts)
+	}
+}
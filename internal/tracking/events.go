@@ -0,0 +1,70 @@
+package tracking
+
+import (
+	"time"
+)
+
+// PageCount is the number of views a path received
+type PageCount struct {
+	Path     string `json:"path"`
+	Views    int64  `json:"views"`
+	Sessions int64  `json:"sessions"`
+}
+
+// ReferrerCount is the number of page views that arrived from a referrer
+type ReferrerCount struct {
+	Referrer string `json:"referrer"`
+	Views    int64  `json:"views"`
+	Sessions int64  `json:"sessions"`
+}
+
+// EventNameCount is the number of times an event was recorded
+type EventNameCount struct {
+	Name     string `json:"name"`
+	Count    int64  `json:"count"`
+	Sessions int64  `json:"sessions"`
+}
+
+// RecordEvent stores a page view or custom event
+func (r *FanTrackingRepository) RecordEvent(event *TrackingEvent) error {
+	return r.db.Create(event).Error
+}
+
+// GetTopPages returns the most viewed paths between from and to
+func (r *FanTrackingRepository) GetTopPages(from, to time.Time, limit int) ([]PageCount, error) {
+	var results []PageCount
+	err := r.db.Model(&TrackingEvent{}).
+		Select("path, COUNT(*) AS views, COUNT(DISTINCT session_id) AS sessions").
+		Where("name = ? AND created_at >= ? AND created_at < ?", EventPageView, from, to).
+		Group("path").
+		Order("views DESC, path ASC").
+		Limit(limit).
+		Scan(&results).Error
+	return results, err
+}
+
+// GetTopReferrers returns the referrers that sent the most page views between from and to
+func (r *FanTrackingRepository) GetTopReferrers(from, to time.Time, limit int) ([]ReferrerCount, error) {
+	var results []ReferrerCount
+	err := r.db.Model(&TrackingEvent{}).
+		Select("referrer, COUNT(*) AS views, COUNT(DISTINCT session_id) AS sessions").
+		Where("name = ? AND created_at >= ? AND created_at < ?", EventPageView, from, to).
+		Where("referrer <> ''").
+		Group("referrer").
+		Order("views DESC, referrer ASC").
+		Limit(limit).
+		Scan(&results).Error
+	return results, err
+}
+
+// GetEventCounts returns how often each event was recorded between from and to
+func (r *FanTrackingRepository) GetEventCounts(from, to time.Time) ([]EventNameCount, error) {
+	var results []EventNameCount
+	err := r.db.Model(&TrackingEvent{}).
+		Select("name, COUNT(*) AS count, COUNT(DISTINCT session_id) AS sessions").
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Group("name").
+		Order("count DESC, name ASC").
+		Scan(&results).Error
+	return results, err
+}
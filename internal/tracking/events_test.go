@@ -0,0 +1,60 @@
+package tracking
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventQueries(t *testing.T) {
+	repo := setupTrackingRepo(t)
+
+	events := []TrackingEvent{
+		{SessionID: "s1", Name: EventPageView, Path: "/", Referrer: "https://google.com"},
+		{SessionID: "s1", Name: EventPageView, Path: "/projects"},
+		{SessionID: "s2", Name: EventPageView, Path: "/", Referrer: "https://google.com"},
+		{SessionID: "s2", Name: EventPageView, Path: "/", Referrer: "https://github.com"},
+		{SessionID: "s3", Name: EventPageView, Path: "/blog"},
+		{SessionID: "s2", Name: "signup_click", Path: "/", Properties: map[string]interface{}{"button": "hero"}},
+	}
+	for i := range events {
+		if err := repo.RecordEvent(&events[i]); err != nil {
+			t.Fatalf("failed to record event: %v", err)
+		}
+	}
+
+	old := TrackingEvent{SessionID: "s9", Name: EventPageView, Path: "/old"}
+	if err := repo.RecordEvent(&old); err != nil {
+		t.Fatalf("failed to record event: %v", err)
+	}
+	repo.db.Exec("UPDATE tracking_events SET created_at = ? WHERE id = ?", time.Now().AddDate(0, 0, -40), old.ID)
+
+	from := time.Now().AddDate(0, 0, -30)
+	to := time.Now().Add(time.Minute)
+
+	pages, err := repo.GetTopPages(from, to, 2)
+	if err != nil {
+		t.Fatalf("GetTopPages failed: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(pages))
+	}
+	if pages[0].Path != "/" || pages[0].Views != 3 || pages[0].Sessions != 2 {
+		t.Fatalf("unexpected top page: %+v", pages[0])
+	}
+
+	referrers, err := repo.GetTopReferrers(from, to, 10)
+	if err != nil {
+		t.Fatalf("GetTopReferrers failed: %v", err)
+	}
+	if len(referrers) != 2 || referrers[0].Referrer != "https://google.com" || referrers[0].Views != 2 {
+		t.Fatalf("unexpected referrers: %+v", referrers)
+	}
+
+	counts, err := repo.GetEventCounts(from, to)
+	if err != nil {
+		t.Fatalf("GetEventCounts failed: %v", err)
+	}
+	if len(counts) != 2 || counts[0].Name != EventPageView || counts[0].Count != 5 || counts[1].Count != 1 {
+		t.Fatalf("unexpected event counts: %+v", counts)
+	}
+}
@@ -0,0 +1,261 @@
+package tracking
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/visitor"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Registration funnel steps, in order
+const (
+	FunnelLanding      = "landing"
+	FunnelPopupShown   = "popup_shown"
+	FunnelRegisterForm = "register_form"
+	FunnelRegistered   = "registered"
+	FunnelVerified     = "email_verified"
+)
+
+// FunnelSteps lists the registration funnel steps in order
+var FunnelSteps = []string{FunnelLanding, FunnelPopupShown, FunnelRegisterForm, FunnelRegistered, FunnelVerified}
+
+// ClientFunnelStep reports whether the client may record the step. Landing, registering
+// and verifying are recorded by the server.
+func ClientFunnelStep(step string) bool {
+	return step == FunnelPopupShown || step == FunnelRegisterForm
+}
+
+// FunnelEvent records that a visitor, or a session for clients without a visitor ID,
+// reached a step of the registration funnel. Only the first time is kept.
+type FunnelEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Step      string    `gorm:"type:varchar(32);not null;uniqueIndex:idx_funnel_events_step_subject" json:"step"`
+	Subject   string    `gorm:"type:varchar(262);not null;uniqueIndex:idx_funnel_events_step_subject;index" json:"subject"` // visitor:<id> or session:<id>
+	SessionID string    `gorm:"type:varchar(255)" json:"session_id"`
+	VisitorID string    `gorm:"type:varchar(64)" json:"visitor_id,omitempty"`
+	FanID     *uint     `gorm:"column:user_id;index" json:"user_id"`
+	Source    string    `gorm:"type:varchar(255);index" json:"source,omitempty"` // Acquisition source, set on landings
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// TableName sets the table name for funnel events
+func (FunnelEvent) TableName() string {
+	return "funnel_events"
+}
+
+// Funnel is the registration funnel of the visitors who landed in a date range
+type Funnel struct {
+	From   time.Time    `json:"from"`
+	To     time.Time    `json:"to"`
+	Source string       `json:"source,omitempty"` // Empty for every source
+	Steps  []FunnelStep `json:"steps"`
+}
+
+// FunnelStep is how far the landed visitors got. Count only includes visitors who also
+// went through every earlier step; Reached includes those who skipped some, e.g. opened
+// the register form without seeing the popup.
+type FunnelStep struct {
+	Step              string  `json:"step"`
+	Count             int64   `json:"count"`
+	Reached           int64   `json:"reached"`
+	ConversionRate    float64 `json:"conversion_rate"`    // Count over the previous step's count
+	OverallConversion float64 `json:"overall_conversion"` // Count over the landings
+}
+
+// SourceName labels a visit's acquisition source the way the source report does: the
+// UTM source, else the referrer host, else DirectSource
+func SourceName(referrerHost, utmSource string) string {
+	if utmSource != "" {
+		return utmSource
+	}
+	if referrerHost != "" {
+		return referrerHost
+	}
+	return DirectSource
+}
+
+// RecordFunnelStep records that a visitor reached a funnel step. The step is keyed by
+// the fan's earlier funnel subject when there is one, so steps taken on another device
+// (e.g. verifying from a phone) still count for the visitor who registered, else by the
+// visitor, else by the session. source is only kept for landings.
+func (r *FanTrackingRepository) RecordFunnelStep(step, sessionID, visitorID string, fanID *uint, source string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		subject, err := fanFunnelSubject(tx, fanID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case subject != "":
+		case visitorID != "":
+			subject = "visitor:" + visitorID
+		case sessionID != "":
+			subject = "session:" + sessionID
+		case fanID != nil:
+			subject = fmt.Sprintf("fan:%d", *fanID)
+		default:
+			return errors.New("funnel step needs a visitor, session or fan")
+		}
+		if step != FunnelLanding {
+			source = ""
+		}
+
+		return createFunnelEvent(tx, &FunnelEvent{
+			Step:      step,
+			Subject:   subject,
+			SessionID: sessionID,
+			VisitorID: visitorID,
+			FanID:     fanID,
+			Source:    source,
+		})
+	})
+}
+
+// RecordFanFunnelStep records a fan's funnel step only under the funnel subject they
+// already have, for requests that opt out of tracking but continue a funnel the fan
+// entered earlier. Nothing is recorded for fans without one.
+func (r *FanTrackingRepository) RecordFanFunnelStep(step string, fanID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		subject, err := fanFunnelSubject(tx, &fanID)
+		if err != nil || subject == "" {
+			return err
+		}
+		return createFunnelEvent(tx, &FunnelEvent{Step: step, Subject: subject, FanID: &fanID})
+	})
+}
+
+// fanFunnelSubject returns the subject of the fan's first funnel step, or "" if they
+// have none
+func fanFunnelSubject(tx *gorm.DB, fanID *uint) (string, error) {
+	if fanID == nil {
+		return "", nil
+	}
+	var subjects []string
+	if err := tx.Model(&FunnelEvent{}).
+		Where("user_id = ?", *fanID).
+		Order("created_at ASC, id ASC").
+		Limit(1).
+		Pluck("subject", &subjects).Error; err != nil {
+		return "", err
+	}
+	if len(subjects) == 0 {
+		return "", nil
+	}
+	return subjects[0], nil
+}
+
+// createFunnelEvent keeps the first event of each step and subject
+func createFunnelEvent(tx *gorm.DB, event *FunnelEvent) error {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(event).Error; err != nil {
+		return err
+	}
+
+	// Registering ties the visitor's earlier steps to the fan
+	if event.FanID != nil {
+		return tx.Model(&FunnelEvent{}).
+			Where("subject = ? AND user_id IS NULL", event.Subject).
+			Update("user_id", *event.FanID).Error
+	}
+	return nil
+}
+
+// GetFunnel returns the registration funnel of the visitors who landed between from and
+// to, optionally only those whose landing came from source
+func (r *FanTrackingRepository) GetFunnel(from, to time.Time, source string) (*Funnel, error) {
+	landings := r.db.Model(&FunnelEvent{}).
+		Select("subject").
+		Where("step = ? AND created_at >= ? AND created_at < ?", FunnelLanding, from, to)
+	if source != "" {
+		landings = landings.Where("source = ?", source)
+	}
+
+	var rows []struct {
+		Subject string
+		Step    string
+	}
+	if err := r.db.Model(&FunnelEvent{}).
+		Select("subject, step").
+		Where("subject IN (?)", landings).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(FunnelSteps))
+	for i, step := range FunnelSteps {
+		index[step] = i
+	}
+	reachedBy := make(map[string][]bool)
+	for _, row := range rows {
+		i, ok := index[row.Step]
+		if !ok {
+			continue
+		}
+		if reachedBy[row.Subject] == nil {
+			reachedBy[row.Subject] = make([]bool, len(FunnelSteps))
+		}
+		reachedBy[row.Subject][i] = true
+	}
+
+	funnel := &Funnel{From: from, To: to, Source: source, Steps: make([]FunnelStep, len(FunnelSteps))}
+	for i, step := range FunnelSteps {
+		funnel.Steps[i].Step = step
+	}
+	for _, reached := range reachedBy {
+		inOrder := true
+		for i := range FunnelSteps {
+			if !reached[i] {
+				inOrder = false
+				continue
+			}
+			funnel.Steps[i].Reached++
+			if inOrder {
+				funnel.Steps[i].Count++
+			}
+		}
+	}
+
+	for i := range funnel.Steps {
+		landed := funnel.Steps[0].Count
+		if landed > 0 {
+			funnel.Steps[i].OverallConversion = float64(funnel.Steps[i].Count) / float64(landed)
+		}
+		if i == 0 {
+			funnel.Steps[i].ConversionRate = funnel.Steps[i].OverallConversion
+			continue
+		}
+		if previous := funnel.Steps[i-1].Count; previous > 0 {
+			funnel.Steps[i].ConversionRate = float64(funnel.Steps[i].Count) / float64(previous)
+		}
+	}
+	return funnel, nil
+}
+
+// FunnelHook records the registered and email verified funnel steps
+func FunnelHook(trackingRepo *FanTrackingRepository) auth.FanHook {
+	return func(c *gin.Context, event auth.FanEvent, fan *auth.Fan) {
+		var step string
+		switch event {
+		case auth.FanRegistered:
+			step = FunnelRegistered
+		case auth.FanEmailVerified:
+			step = FunnelVerified
+		default:
+			return
+		}
+		// Without tracking, the step only continues a funnel the fan is already in
+		var err error
+		if DoNotTrack(c) {
+			err = trackingRepo.RecordFanFunnelStep(step, fan.ID)
+		} else {
+			err = trackingRepo.RecordFunnelStep(step, "", visitor.ID(c), &fan.ID, "")
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to record funnel step %s for fan %d: %v", step, fan.ID, err)
+		}
+	}
+}
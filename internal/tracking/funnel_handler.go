@@ -0,0 +1,77 @@
+package tracking
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type funnelStepRequest struct {
+	SessionID string `json:"session_id" binding:"required,max=255"`
+	Step      string `json:"step" binding:"required"`
+}
+
+// RecordFunnelStep godoc
+// @Summary Record a registration funnel step
+// @Description Send popup_shown when the guest popup is displayed and register_form when the register form is opened. Only the first time per visitor counts.
+// @Tags tracking
+// @Accept json
+// @Produce json
+// @Param body body TrackingFunnelStepRequest true "Step"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/funnel [post]
+func (h *TrackingHandler) RecordFunnelStep(c *gin.Context) {
+	var req funnelStepRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !ClientFunnelStep(req.Step) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "step must be popup_shown or register_form"})
+		return
+	}
+
+	fanID, visitorID := trackingIdentity(c)
+	if h.skipTracking(c, fanID) {
+		return
+	}
+
+	if err := h.trackingRepo.RecordFunnelStep(req.Step, req.SessionID, visitorID, fanID, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record funnel step"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Funnel step recorded successfully"})
+}
+
+// GetFunnel godoc
+// @Summary Registration funnel
+// @Description Follows the guests who first landed in the range through seeing the guest popup, opening the register form, registering and verifying their email.
+// @Description count only includes visitors who went through every earlier step; reached includes those who skipped some.
+// @Tags tracking
+// @Produce json
+// @Param from query string false "Start (RFC3339 or YYYY-MM-DD), defaults to 30 days ago"
+// @Param to query string false "End (RFC3339 or YYYY-MM-DD), defaults to now"
+// @Param source query string false "Only visitors whose landing came from this source, as in the source report"
+// @Success 200 {object} tracking.Funnel
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/funnel [get]
+func (h *TrackingHandler) GetFunnel(c *gin.Context) {
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	funnel, err := h.trackingRepo.GetFunnel(from, to, strings.TrimSpace(c.Query("source")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get funnel"})
+		return
+	}
+
+	c.JSON(http.StatusOK, funnel)
+}
@@ -0,0 +1,110 @@
+package tracking
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFunnelFollowsLandedVisitors(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	fanA, fanB := uint(1), uint(2)
+
+	record := func(step, sessionID, visitorID string, fanID *uint, source string) {
+		t.Helper()
+		if err := repo.RecordFunnelStep(step, sessionID, visitorID, fanID, source); err != nil {
+			t.Fatalf("RecordFunnelStep(%s) failed: %v", step, err)
+		}
+	}
+
+	// v1 goes all the way, verifying from a device without the visitor cookie
+	record(FunnelLanding, "s1", "v1", nil, "twitter")
+	record(FunnelLanding, "s1b", "v1", nil, "google.com") // Later landings keep the first source
+	record(FunnelPopupShown, "s1", "v1", nil, "")
+	record(FunnelRegisterForm, "s1", "v1", nil, "")
+	record(FunnelRegistered, "", "v1", &fanA, "")
+	record(FunnelVerified, "", "", &fanA, "")
+
+	// v2 skips the popup and registers without verifying
+	record(FunnelLanding, "s2", "v2", nil, "twitter")
+	record(FunnelRegisterForm, "s2", "v2", nil, "")
+	record(FunnelRegistered, "", "v2", &fanB, "")
+
+	// A session without a visitor ID only sees the popup
+	record(FunnelLanding, "s3", "", nil, DirectSource)
+	record(FunnelPopupShown, "s3", "", nil, "")
+	record(FunnelPopupShown, "s3", "", nil, "")
+
+	now := time.Now()
+	funnel, err := repo.GetFunnel(now.Add(-time.Hour), now.Add(time.Hour), "")
+	if err != nil {
+		t.Fatalf("GetFunnel failed: %v", err)
+	}
+
+	want := []struct {
+		step           string
+		count, reached int64
+	}{
+		{FunnelLanding, 3, 3},
+		{FunnelPopupShown, 2, 2},
+		{FunnelRegisterForm, 1, 2},
+		{FunnelRegistered, 1, 2},
+		{FunnelVerified, 1, 1},
+	}
+	for i, w := range want {
+		got := funnel.Steps[i]
+		if got.Step != w.step || got.Count != w.count || got.Reached != w.reached {
+			t.Fatalf("step %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+	if rate := funnel.Steps[2].ConversionRate; rate != 0.5 {
+		t.Fatalf("expected half of the popup viewers to open the form, got %v", rate)
+	}
+	if overall := funnel.Steps[4].OverallConversion; overall < 0.33 || overall > 0.34 {
+		t.Fatalf("expected a third of the landings to verify, got %v", overall)
+	}
+
+	twitter, err := repo.GetFunnel(now.Add(-time.Hour), now.Add(time.Hour), "twitter")
+	if err != nil {
+		t.Fatalf("GetFunnel failed: %v", err)
+	}
+	if twitter.Steps[0].Count != 2 || twitter.Steps[3].Reached != 2 {
+		t.Fatalf("unexpected twitter funnel: %+v", twitter.Steps)
+	}
+
+	earlier, err := repo.GetFunnel(now.Add(-48*time.Hour), now.Add(-24*time.Hour), "")
+	if err != nil || earlier.Steps[0].Count != 0 || earlier.Steps[1].ConversionRate != 0 {
+		t.Fatalf("expected an empty funnel, got %+v (err %v)", earlier, err)
+	}
+
+	// Erasing a fan's tracking removes their funnel steps, including the guest ones
+	if _, err := repo.EraseFanTracking(fanA); err != nil {
+		t.Fatalf("EraseFanTracking failed: %v", err)
+	}
+	var left int64
+	repo.db.Model(&FunnelEvent{}).Where("subject = ?", "visitor:v1").Count(&left)
+	if left != 0 {
+		t.Fatalf("expected the fan's funnel steps to be erased, %d left", left)
+	}
+}
+
+func TestFanFunnelStepOnlyContinuesAFunnel(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	registered, other := uint(1), uint(2)
+
+	if err := repo.RecordFunnelStep(FunnelRegistered, "", "v1", &registered, ""); err != nil {
+		t.Fatalf("RecordFunnelStep failed: %v", err)
+	}
+	for _, fanID := range []uint{registered, other} {
+		if err := repo.RecordFanFunnelStep(FunnelVerified, fanID); err != nil {
+			t.Fatalf("RecordFanFunnelStep(%d) failed: %v", fanID, err)
+		}
+	}
+
+	var events []FunnelEvent
+	if err := repo.db.Where("step = ?", FunnelVerified).Find(&events).Error; err != nil {
+		t.Fatalf("failed to load events: %v", err)
+	}
+	if len(events) != 1 || events[0].Subject != "visitor:v1" {
+		t.Fatalf("expected one verification under the registered visitor, got %+v", events)
+	}
+}
@@ -1,10 +1,21 @@
 package tracking
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/visitor"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type TrackingHandler struct {
@@ -20,7 +31,8 @@ func NewTrackingHandler(trackingRepo *FanTrackingRepository) *TrackingHandler {
 // @Tags tracking
 // @Accept json
 // @Produce json
-// @Param body body TrackingSessionRequest true "Session"
+// @Description The referrer and UTM fields of the first call of a session are kept as its source.
+// @Param body body TrackingStartRequest true "Session"
 // @Success 200 {object} models.FanTracking
 // @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
@@ -28,6 +40,7 @@ func NewTrackingHandler(trackingRepo *FanTrackingRepository) *TrackingHandler {
 func (h *TrackingHandler) StartTracking(c *gin.Context) {
 	var req struct {
 		SessionID string `json:"session_id" binding:"required"`
+		sourceFields
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -35,24 +48,18 @@ func (h *TrackingHandler) StartTracking(c *gin.Context) {
 		return
 	}
 
-	// Get fan ID from context if authenticated
-	var fanID *uint
-	if fan, exists := c.Get("user"); exists {
-		if f, ok := fan.(*auth.Fan); ok {
-			fanID = &f.ID
-		}
-	}
-
-	if fanID == nil {
-		c.JSON(http.StatusOK, gin.H{"message": "Guest sessions are not tracked"})
+	fanID, visitorID := trackingIdentity(c)
+	if h.skipTracking(c, fanID) {
 		return
 	}
 
-	tracking, err := h.trackingRepo.StartTracking(fanID, req.SessionID)
+	tracking, err := h.trackingRepo.StartTracking(fanID, visitorID, req.SessionID, ParseUserAgent(c.Request.UserAgent()))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start tracking"})
 		return
 	}
+	h.recordSource(c, req.SessionID, fanID, visitorID, req.sourceFields)
+	h.recordLanding(c, req.SessionID, fanID, visitorID, req.sourceFields)
 
 	c.JSON(http.StatusOK, tracking)
 }
@@ -77,20 +84,7 @@ func (h *TrackingHandler) EndTracking(c *gin.Context) {
 		return
 	}
 
-	// Get fan ID from context if authenticated
-	var fanID *uint
-	if fan, exists := c.Get("user"); exists {
-		if f, ok := fan.(*auth.Fan); ok {
-			fanID = &f.ID
-		}
-	}
-
-	if fanID == nil {
-		// Cleanup any lingering sessions for the guest session ID without tracking it
-		_ = h.trackingRepo.EndTracking(req.SessionID, fanID)
-		c.JSON(http.StatusOK, gin.H{"message": "Guest sessions are not tracked"})
-		return
-	}
+	fanID, _ := trackingIdentity(c)
 
 	if err := h.trackingRepo.EndTracking(req.SessionID, fanID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end tracking"})
@@ -120,20 +114,12 @@ func (h *TrackingHandler) UpdateTracking(c *gin.Context) {
 		return
 	}
 
-	// Get fan ID from context if authenticated
-	var fanID *uint
-	if fan, exists := c.Get("user"); exists {
-		if f, ok := fan.(*auth.Fan); ok {
-			fanID = &f.ID
-		}
-	}
-
-	if fanID == nil {
-		c.JSON(http.StatusOK, gin.H{"message": "Guest sessions are not tracked"})
+	fanID, visitorID := trackingIdentity(c)
+	if h.skipTracking(c, fanID) {
 		return
 	}
 
-	if err := h.trackingRepo.UpdateActiveSession(req.SessionID, fanID); err != nil {
+	if err := h.trackingRepo.UpdateActiveSession(req.SessionID, fanID, visitorID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tracking"})
 		return
 	}
@@ -158,6 +144,23 @@ func (h *TrackingHandler) GetTotalHours(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"total_hours": totalHours})
 }
 
+// GetOnlineCount godoc
+// @Summary Number of sessions active right now
+// @Tags tracking
+// @Produce json
+// @Success 200 {object} OnlineCountResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/online [get]
+func (h *TrackingHandler) GetOnlineCount(c *gin.Context) {
+	online, err := h.trackingRepo.GetOnlineCount()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get online count"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"online": online})
+}
+
 // GetUserTotalHours godoc
 // @Summary Current user tracked hours
 // @Tags tracking
@@ -189,31 +192,799 @@ func (h *TrackingHandler) GetUserTotalHours(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"total_hours": totalHours})
 }
 
-// GetAllTrackingRecords godoc
+// ListTrackingRecords godoc
 // @Summary List tracking records
+// @Description Newest first. Non-admins only see their own records. Pass next_cursor back as cursor to get the next page.
 // @Tags tracking
 // @Produce json
-// @Success 200 {array} models.FanTracking
+// @Param user_id query int false "Fan ID (admins only)"
+// @Param from query string false "Started at or after (RFC3339 or YYYY-MM-DD)"
+// @Param to query string false "Started before (RFC3339 or YYYY-MM-DD)"
+// @Param min_duration query int false "Minimum duration in seconds"
+// @Param status query string false "open or closed"
+// @Param cursor query string false "Cursor from the previous page"
+// @Param limit query int false "Page size (1-200)" default(50)
+// @Success 200 {object} TrackingRecordsResponse
+// @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /tracking/records [get]
-func (h *TrackingHandler) GetAllTrackingRecords(c *gin.Context) {
-	// Optional: filter by current fan if not admin
+func (h *TrackingHandler) ListTrackingRecords(c *gin.Context) {
+	filter, err := parseRecordFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var beforeID uint
+	if cursor := c.Query("cursor"); cursor != "" {
+		parsed, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil || parsed == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		beforeID = uint(parsed)
+	}
+
+	limit := defaultRecordsLimit
+	if v := c.Query("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > maxRecordsLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxRecordsLimit)})
+			return
+		}
+		limit = parsed
+	}
+
+	records, nextID, err := h.trackingRepo.ListTrackingRecords(filter, beforeID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tracking records"})
+		return
+	}
+
+	nextCursor := ""
+	if nextID > 0 {
+		nextCursor = strconv.FormatUint(uint64(nextID), 10)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"records":     records,
+		"next_cursor": nextCursor,
+	})
+}
+
+// ExportTrackingRecords godoc
+// @Summary Export tracking records
+// @Description Streams every matching record as CSV or NDJSON, newest first.
+// @Tags tracking
+// @Produce text/csv,application/x-ndjson
+// @Param format query string false "csv or ndjson" default(csv)
+// @Param user_id query int false "Fan ID"
+// @Param from query string false "Started at or after (RFC3339 or YYYY-MM-DD)"
+// @Param to query string false "Started before (RFC3339 or YYYY-MM-DD)"
+// @Param min_duration query int false "Minimum duration in seconds"
+// @Param status query string false "open or closed"
+// @Success 200 {string} string
+// @Failure 400 {object} ErrorResponse
+// @Router /tracking/records/export [get]
+func (h *TrackingHandler) ExportTrackingRecords(c *gin.Context) {
+	filter, err := parseRecordFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	var write func(*FanTracking) error
+	var finish func() error
+
+	switch format {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write(recordCSVHeader); err != nil {
+			return
+		}
+		write = func(record *FanTracking) error {
+			return w.Write(recordCSVRow(record))
+		}
+		finish = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	case "ndjson":
+		c.Header("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(c.Writer)
+		write = func(record *FanTracking) error {
+			return enc.Encode(record)
+		}
+		finish = func() error { return nil }
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or ndjson"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="tracking-records.%s"`, format))
+	c.Status(http.StatusOK)
+
+	written := 0
+	err = h.trackingRepo.StreamTrackingRecords(filter, func(record *FanTracking) error {
+		if err := write(record); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushEvery == 0 {
+			if err := finish(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		// Headers are already sent, so the client sees a truncated file
+		log.Printf("Warning: Tracking export stopped after %d rows: %v", written, err)
+		return
+	}
+	c.Writer.Flush()
+}
+
+var recordCSVHeader = []string{"id", "user_id", "visitor_id", "session_id", "start_time", "end_time", "duration"}
+
+func recordCSVRow(record *FanTracking) []string {
+	fanID := ""
+	if record.FanID != nil {
+		fanID = strconv.FormatUint(uint64(*record.FanID), 10)
+	}
+	endTime := ""
+	if record.EndTime != nil {
+		endTime = record.EndTime.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		strconv.FormatUint(uint64(record.ID), 10),
+		fanID,
+		record.VisitorID,
+		record.SessionID,
+		record.StartTime.UTC().Format(time.RFC3339),
+		endTime,
+		strconv.FormatInt(record.Duration, 10),
+	}
+}
+
+// parseRecordFilter reads the record filters from the query. Non-admins are always
+// limited to their own records.
+func parseRecordFilter(c *gin.Context) (RecordFilter, error) {
+	var filter RecordFilter
+
+	isAdmin := false
+	if fan, exists := c.Get("user"); exists {
+		if f, ok := fan.(*auth.Fan); ok {
+			if f.IsAdmin {
+				isAdmin = true
+			} else {
+				filter.FanID = &f.ID
+			}
+		}
+	}
+
+	if v := c.Query("user_id"); v != "" && isAdmin {
+		parsed, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return filter, errors.New("invalid user_id")
+		}
+		fanID := uint(parsed)
+		filter.FanID = &fanID
+	}
+
+	if v := c.Query("from"); v != "" {
+		from, err := parseTimeQuery(v)
+		if err != nil {
+			return filter, errors.New("invalid from")
+		}
+		filter.From = &from
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := parseTimeQuery(v)
+		if err != nil {
+			return filter, errors.New("invalid to")
+		}
+		filter.To = &to
+	}
+
+	if v := c.Query("min_duration"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed < 0 {
+			return filter, errors.New("invalid min_duration")
+		}
+		filter.MinDuration = parsed
+	}
+
+	switch status := c.Query("status"); status {
+	case "", RecordStatusOpen, RecordStatusClosed:
+		filter.Status = status
+	default:
+		return filter, errors.New("status must be open or closed")
+	}
+
+	return filter, nil
+}
+
+// trackingIdentity returns the authenticated fan's ID, if any, and the caller's visitor ID
+func trackingIdentity(c *gin.Context) (*uint, string) {
 	var fanID *uint
 	if fan, exists := c.Get("user"); exists {
 		if f, ok := fan.(*auth.Fan); ok {
-			// Check if fan is admin
-			if !f.IsAdmin {
-				// Non-admin fans can only see their own records
-				fanID = &f.ID
+			fanID = &f.ID
+		}
+	}
+	return fanID, visitor.ID(c)
+}
+
+// DoNotTrack reports whether the request carries a Do-Not-Track or Global Privacy
+// Control opt-out, see visitor.DoNotTrack
+func DoNotTrack(c *gin.Context) bool {
+	return visitor.DoNotTrack(c)
+}
+
+// skipTracking answers the request itself and returns true when the caller must not be
+// tracked: they sent DNT or Sec-GPC, or they are a fan without current consent.
+// Skipped requests still succeed so clients don't retry them.
+func (h *TrackingHandler) skipTracking(c *gin.Context, fanID *uint) bool {
+	reason := ""
+	if DoNotTrack(c) {
+		reason = "do_not_track"
+	} else if fanID != nil {
+		granted, err := h.trackingRepo.HasConsent(*fanID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check tracking consent"})
+			return true
+		}
+		if !granted {
+			reason = "no_consent"
+		}
+	}
+
+	if reason == "" {
+		return false
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tracking skipped", "reason": reason})
+	return true
+}
+
+// recordLanding enters a guest into the registration funnel when a session starts; only
+// their first landing is kept. Failures are only logged, like recordSource's.
+func (h *TrackingHandler) recordLanding(c *gin.Context, sessionID string, fanID *uint, visitorID string, fields sourceFields) {
+	if fanID != nil {
+		return
+	}
+	source := SourceName(ReferrerHost(fields.Referrer, c.Request.Host), strings.TrimSpace(fields.UTMSource))
+	if err := h.trackingRepo.RecordFunnelStep(FunnelLanding, sessionID, visitorID, nil, source); err != nil {
+		log.Printf("Warning: Failed to record landing of session %s: %v", sessionID, err)
+	}
+}
+
+// recordSource keeps where the session came from, unless an earlier call already did.
+// Failures are only logged so they never lose the tracking call itself.
+func (h *TrackingHandler) recordSource(c *gin.Context, sessionID string, fanID *uint, visitorID string, fields sourceFields) {
+	if fanID == nil && visitorID == "" {
+		return
+	}
+	source := &SessionSource{
+		SessionID:    sessionID,
+		FanID:        fanID,
+		VisitorID:    visitorID,
+		ReferrerHost: ReferrerHost(fields.Referrer, c.Request.Host),
+		UTMSource:    strings.TrimSpace(fields.UTMSource),
+		UTMMedium:    strings.TrimSpace(fields.UTMMedium),
+		UTMCampaign:  strings.TrimSpace(fields.UTMCampaign),
+	}
+	if err := h.trackingRepo.RecordSessionSource(source); err != nil {
+		log.Printf("Warning: Failed to record source of session %s: %v", sessionID, err)
+	}
+}
+
+// MergeGuestHook moves a visitor's guest tracking rows onto their fan record when they
+// register or log in, unless the request opts out with DNT or Sec-GPC or the fan hasn't
+// consented to tracking
+func MergeGuestHook(trackingRepo *FanTrackingRepository) auth.FanHook {
+	return func(c *gin.Context, event auth.FanEvent, fan *auth.Fan) {
+		if event != auth.FanRegistered && event != auth.FanLoggedIn {
+			return
+		}
+		if DoNotTrack(c) {
+			return
+		}
+		granted, err := trackingRepo.HasConsent(fan.ID)
+		if err != nil {
+			log.Printf("Warning: Failed to check tracking consent of fan %d: %v", fan.ID, err)
+			return
+		}
+		if !granted {
+			return
+		}
+
+		if _, err := trackingRepo.MergeVisitor(visitor.ID(c), fan.ID); err != nil {
+			log.Printf("Warning: Failed to merge guest tracking for fan %d: %v", fan.ID, err)
+		}
+	}
+}
+
+const (
+	maxEventProperties    = 20
+	maxEventPropertyBytes = 2048
+	defaultReportDays     = 30
+	defaultReportLimit    = 10
+	maxReportLimit        = 100
+	maxBatchBytes         = 64 << 10
+	defaultRecordsLimit   = 50
+	maxRecordsLimit       = 200
+	exportFlushEvery      = 500
+)
+
+// RecordEvent godoc
+// @Summary Record a page view or custom event
+// @Tags tracking
+// @Accept json
+// @Produce json
+// @Param body body TrackingEventRequest true "Event"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/event [post]
+func (h *TrackingHandler) RecordEvent(c *gin.Context) {
+	var req eventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fanID, visitorID := trackingIdentity(c)
+	if h.skipTracking(c, fanID) {
+		return
+	}
+	event, err := req.toEvent(req.SessionID, fanID, visitorID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.trackingRepo.RecordEvent(event); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record event"})
+		return
+	}
+	h.recordSource(c, req.SessionID, fanID, visitorID, req.sourceFields)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Event recorded successfully"})
+}
+
+// GetTopPages godoc
+// @Summary Most viewed pages
+// @Tags tracking
+// @Produce json
+// @Param from query string false "Start (RFC3339 or YYYY-MM-DD), defaults to 30 days ago"
+// @Param to query string false "End (RFC3339 or YYYY-MM-DD), defaults to now"
+// @Param limit query int false "Max rows (1-100)" default(10)
+// @Success 200 {array} tracking.PageCount
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/top-pages [get]
+func (h *TrackingHandler) GetTopPages(c *gin.Context) {
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pages, err := h.trackingRepo.GetTopPages(from, to, parseLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get top pages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pages)
+}
+
+// GetTopReferrers godoc
+// @Summary Top referrers
+// @Tags tracking
+// @Produce json
+// @Param from query string false "Start (RFC3339 or YYYY-MM-DD), defaults to 30 days ago"
+// @Param to query string false "End (RFC3339 or YYYY-MM-DD), defaults to now"
+// @Param limit query int false "Max rows (1-100)" default(10)
+// @Success 200 {array} tracking.ReferrerCount
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/top-referrers [get]
+func (h *TrackingHandler) GetTopReferrers(c *gin.Context) {
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	referrers, err := h.trackingRepo.GetTopReferrers(from, to, parseLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get top referrers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, referrers)
+}
+
+// GetSourceReport godoc
+// @Summary Visits and registrations by source
+// @Description Sessions are grouped by the source of their first call; registrations by the fan's first-touch source.
+// @Description The source is the UTM source, else the referrer host, else "(direct)".
+// @Tags tracking
+// @Produce json
+// @Param from query string false "Start (RFC3339 or YYYY-MM-DD), defaults to 30 days ago"
+// @Param to query string false "End (RFC3339 or YYYY-MM-DD), defaults to now"
+// @Param limit query int false "Max rows (1-100)" default(10)
+// @Success 200 {array} tracking.SourceCount
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/sources [get]
+func (h *TrackingHandler) GetSourceReport(c *gin.Context) {
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sources, err := h.trackingRepo.GetSourceCounts(from, to, parseLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sources"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sources)
+}
+
+// GetClientBreakdown godoc
+// @Summary Sessions by browser, OS and device
+// @Description Browser family, OS and device class are parsed from the User-Agent when a session starts. Sessions matching a bot signature are only counted in bots.
+// @Tags tracking
+// @Produce json
+// @Param from query string false "Start (RFC3339 or YYYY-MM-DD), defaults to 30 days ago"
+// @Param to query string false "End (RFC3339 or YYYY-MM-DD), defaults to now"
+// @Success 200 {object} tracking.ClientBreakdown
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/clients [get]
+func (h *TrackingHandler) GetClientBreakdown(c *gin.Context) {
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	breakdown, err := h.trackingRepo.GetClientBreakdown(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get client breakdown"})
+		return
+	}
+
+	c.JSON(http.StatusOK, breakdown)
+}
+
+// GetEventCounts godoc
+// @Summary Event counts
+// @Tags tracking
+// @Produce json
+// @Param from query string false "Start (RFC3339 or YYYY-MM-DD), defaults to 30 days ago"
+// @Param to query string false "End (RFC3339 or YYYY-MM-DD), defaults to now"
+// @Success 200 {array} tracking.EventNameCount
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/event-counts [get]
+func (h *TrackingHandler) GetEventCounts(c *gin.Context) {
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	counts, err := h.trackingRepo.GetEventCounts(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get event counts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+// TrackBatch godoc
+// @Summary Apply a batch of tracking operations
+// @Description Accepts JSON or text/plain bodies so it can be used with navigator.sendBeacon.
+// @Description Operations are applied in order in one transaction; operations whose id was already processed are skipped.
+// @Tags tracking
+// @Accept json,plain
+// @Produce json
+// @Param body body TrackingBatchRequest true "Batch"
+// @Success 200 {object} tracking.BatchResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/batch [post]
+func (h *TrackingHandler) TrackBatch(c *gin.Context) {
+	// sendBeacon posts text/plain, so decode the body as JSON whatever its content type
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBatchBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	if len(body) > maxBatchBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Batch too large"})
+		return
+	}
+
+	var req batchRequest
+	if err := binding.JSON.BindBody(body, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fanID, visitorID := trackingIdentity(c)
+	if h.skipTracking(c, fanID) {
+		return
+	}
+	ops := make([]BatchOp, 0, len(req.Ops))
+	for i, op := range req.Ops {
+		batchOp := BatchOp{ClientEventID: op.ID, Type: op.Type}
+		if op.Type == OpStart {
+			batchOp.Client = ParseUserAgent(c.Request.UserAgent())
+		}
+		if op.Type == OpEvent {
+			event, err := op.toEvent(req.SessionID, fanID, visitorID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("ops[%d]: %v", i, err)})
+				return
 			}
+			batchOp.Event = event
 		}
+		ops = append(ops, batchOp)
 	}
 
-	records, err := h.trackingRepo.GetAllTrackingRecords(fanID)
+	result, err := h.trackingRepo.ApplyBatch(fanID, visitorID, req.SessionID, ops)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tracking records"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply tracking batch"})
+		return
+	}
+	for _, op := range req.Ops {
+		if op.Type == OpStart || op.Type == OpEvent {
+			h.recordSource(c, req.SessionID, fanID, visitorID, op.sourceFields)
+			break
+		}
+	}
+	for _, op := range req.Ops {
+		if op.Type == OpStart {
+			h.recordLanding(c, req.SessionID, fanID, visitorID, op.sourceFields)
+			break
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetConsent godoc
+// @Summary Current user's tracking consent
+// @Tags tracking
+// @Produce json
+// @Success 200 {object} TrackingConsentResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/consent [get]
+func (h *TrackingHandler) GetConsent(c *gin.Context) {
+	fan, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Fan not authenticated"})
+		return
+	}
+	f, ok := fan.(*auth.Fan)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid fan context"})
 		return
 	}
 
-	c.JSON(http.StatusOK, records)
+	consent, err := h.trackingRepo.GetConsent(f.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tracking consent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, consentResponse(c, consent))
+}
+
+// UpdateConsent godoc
+// @Summary Grant or deny tracking consent
+// @Description Records the answer for the current policy version. Denying consent ends any active sessions.
+// @Tags tracking
+// @Accept json
+// @Produce json
+// @Param body body TrackingConsentRequest true "Consent"
+// @Success 200 {object} TrackingConsentResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/consent [put]
+func (h *TrackingHandler) UpdateConsent(c *gin.Context) {
+	fan, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Fan not authenticated"})
+		return
+	}
+	f, ok := fan.(*auth.Fan)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid fan context"})
+		return
+	}
+
+	var req struct {
+		Granted *bool `json:"granted" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	consent, err := h.trackingRepo.SetConsent(f.ID, *req.Granted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tracking consent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, consentResponse(c, consent))
+}
+
+// EraseTracking godoc
+// @Summary Erase the current user's tracking history
+// @Description Deletes all of the caller's tracking records, events and aggregates. Statistics no longer include them.
+// @Tags tracking
+// @Produce json
+// @Success 200 {object} TrackingErasureResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/records [delete]
+func (h *TrackingHandler) EraseTracking(c *gin.Context) {
+	fan, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Fan not authenticated"})
+		return
+	}
+	f, ok := fan.(*auth.Fan)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid fan context"})
+		return
+	}
+
+	deleted, err := h.trackingRepo.EraseFanTracking(f.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase tracking records"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+func consentResponse(c *gin.Context, consent *TrackingConsent) gin.H {
+	return gin.H{
+		"consent":        consent,
+		"granted":        consent.Current(),
+		"policy_version": ConsentPolicyVersion,
+		"do_not_track":   DoNotTrack(c),
+	}
+}
+
+type batchRequest struct {
+	SessionID string    `json:"session_id" binding:"required,max=255"`
+	Ops       []batchOp `json:"ops" binding:"required,min=1,max=50,dive"`
+}
+
+type batchOp struct {
+	ID   string `json:"id" binding:"required,max=64"`
+	Type string `json:"type" binding:"required,oneof=start update end event"`
+	eventFields
+}
+
+type eventRequest struct {
+	SessionID string `json:"session_id" binding:"required,max=255"`
+	eventFields
+}
+
+// eventFields are the event attributes shared by single and batched event requests
+type eventFields struct {
+	Name string `json:"name" binding:"max=64"`
+	Path string `json:"path" binding:"max=512"`
+	sourceFields
+	UTMTerm    string                 `json:"utm_term" binding:"max=255"`
+	UTMContent string                 `json:"utm_content" binding:"max=255"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// sourceFields say where a visit came from; they are sent with session starts and events
+type sourceFields struct {
+	Referrer    string `json:"referrer" binding:"max=1024"`
+	UTMSource   string `json:"utm_source" binding:"max=255"`
+	UTMMedium   string `json:"utm_medium" binding:"max=255"`
+	UTMCampaign string `json:"utm_campaign" binding:"max=255"`
+}
+
+func (req *eventFields) toEvent(sessionID string, fanID *uint, visitorID string) (*TrackingEvent, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = EventPageView
+	}
+	if name == EventPageView && req.Path == "" {
+		return nil, errors.New("path is required for page views")
+	}
+
+	if len(req.Properties) > maxEventProperties {
+		return nil, fmt.Errorf("at most %d properties are allowed", maxEventProperties)
+	}
+	if req.Properties != nil {
+		encoded, err := json.Marshal(req.Properties)
+		if err != nil || len(encoded) > maxEventPropertyBytes {
+			return nil, fmt.Errorf("properties must encode to at most %d bytes", maxEventPropertyBytes)
+		}
+	}
+
+	return &TrackingEvent{
+		SessionID:   sessionID,
+		FanID:       fanID,
+		VisitorID:   visitorID,
+		Name:        name,
+		Path:        req.Path,
+		Referrer:    req.Referrer,
+		UTMSource:   req.UTMSource,
+		UTMMedium:   req.UTMMedium,
+		UTMCampaign: req.UTMCampaign,
+		UTMTerm:     req.UTMTerm,
+		UTMContent:  req.UTMContent,
+		Properties:  req.Properties,
+	}, nil
+}
+
+// parseTimeRange reads the from/to query parameters, defaulting to the last 30 days
+func parseTimeRange(c *gin.Context) (time.Time, time.Time, error) {
+	to := time.Now()
+	if v := c.Query("to"); v != "" {
+		parsed, err := parseTimeQuery(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to")
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -defaultReportDays)
+	if v := c.Query("from"); v != "" {
+		parsed, err := parseTimeQuery(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from")
+		}
+		from = parsed
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+func parseTimeQuery(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, v, time.Local)
+}
+
+func parseLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		return defaultReportLimit
+	}
+	if limit > maxReportLimit {
+		return maxReportLimit
+	}
+	return limit
 }
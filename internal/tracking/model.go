@@ -7,16 +7,63 @@ import (
 // FanTracking tracks time spent by fans on the website
 type FanTracking struct {
 	ID        uint       `gorm:"primaryKey" json:"id"`
-	FanID     *uint      `gorm:"column:user_id;index" json:"user_id"` // Nullable for guest fans, keeping column name as user_id
+	FanID     *uint      `gorm:"column:user_id;index" json:"user_id"`                // Nullable for guest fans, keeping column name as user_id
+	VisitorID string     `gorm:"type:varchar(64);index" json:"visitor_id,omitempty"` // Random first-party visitor ID, links guest rows to a fan once they sign up
 	SessionID string     `gorm:"type:varchar(255);index;not null" json:"session_id"`
-	StartTime time.Time  `gorm:"not null" json:"start_time"`
-	EndTime   *time.Time `json:"end_time"`                  // Nullable for active sessions
-	Duration  int64      `gorm:"default:0" json:"duration"` // Duration in seconds
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
+	StartTime time.Time  `gorm:"not null;index" json:"start_time"`
+	EndTime   *time.Time `gorm:"index" json:"end_time"`           // Nullable for active sessions
+	Duration  int64      `gorm:"default:0" json:"duration"`       // Duration in seconds
+	Browser   string     `gorm:"type:varchar(32)" json:"browser"` // Parsed from the User-Agent when the session starts
+	OS        string     `gorm:"column:os;type:varchar(32)" json:"os"`
+	Device    string     `gorm:"type:varchar(16)" json:"device"`
+	Bot       bool       `gorm:"default:false;index" json:"bot"` // Matched a bot signature, left out of visitor counts
+	// The first row of its session ID, so rollups count each session once. Nil on rows
+	// written before it was recorded until the rollups are rebuilt.
+	FirstStart *bool     `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
 }
 
 // TableName overrides the table name to keep using the existing "user_trackings" table
 func (FanTracking) TableName() string {
 	return "user_trackings"
 }
+
+// EventPageView is the event name used for page views
+const EventPageView = "pageview"
+
+// TrackingEvent records a page view or custom event within a tracking session
+type TrackingEvent struct {
+	ID          uint                   `gorm:"primaryKey" json:"id"`
+	SessionID   string                 `gorm:"type:varchar(255);index;not null" json:"session_id"`
+	FanID       *uint                  `gorm:"column:user_id;index" json:"user_id"` // Nullable for guests
+	VisitorID   string                 `gorm:"type:varchar(64);index" json:"visitor_id,omitempty"`
+	Name        string                 `gorm:"type:varchar(64);index;not null" json:"name"`
+	Path        string                 `gorm:"type:varchar(512);index" json:"path"`
+	Referrer    string                 `gorm:"type:varchar(1024)" json:"referrer"`
+	UTMSource   string                 `gorm:"type:varchar(255)" json:"utm_source"`
+	UTMMedium   string                 `gorm:"type:varchar(255)" json:"utm_medium"`
+	UTMCampaign string                 `gorm:"type:varchar(255)" json:"utm_campaign"`
+	UTMTerm     string                 `gorm:"type:varchar(255)" json:"utm_term"`
+	UTMContent  string                 `gorm:"type:varchar(255)" json:"utm_content"`
+	Properties  map[string]interface{} `gorm:"type:text;serializer:json" json:"properties,omitempty"`
+	CreatedAt   time.Time              `gorm:"index" json:"created_at"`
+}
+
+// TableName sets the table name for tracking events
+func (TrackingEvent) TableName() string {
+	return "tracking_events"
+}
+
+// ProcessedEvent remembers client event IDs already applied from batch requests so
+// retried beacons are not applied twice
+type ProcessedEvent struct {
+	SessionID     string    `gorm:"primaryKey;type:varchar(255)" json:"session_id"`
+	ClientEventID string    `gorm:"primaryKey;type:varchar(64)" json:"client_event_id"`
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+}
+
+// TableName sets the table name for processed batch events
+func (ProcessedEvent) TableName() string {
+	return "tracking_processed_events"
+}
@@ -0,0 +1,97 @@
+package tracking
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Record status filters
+const (
+	RecordStatusOpen   = "open"
+	RecordStatusClosed = "closed"
+)
+
+// RecordFilter narrows the tracking records returned by ListTrackingRecords and
+// StreamTrackingRecords. Zero values don't filter.
+type RecordFilter struct {
+	FanID       *uint
+	From        *time.Time // Inclusive start_time lower bound
+	To          *time.Time // Exclusive start_time upper bound
+	MinDuration int64      // Seconds
+	Status      string     // RecordStatusOpen or RecordStatusClosed
+}
+
+func (r *FanTrackingRepository) recordsQuery(filter RecordFilter) *gorm.DB {
+	query := r.db.Model(&FanTracking{})
+	if filter.FanID != nil {
+		query = query.Where("user_id = ?", *filter.FanID)
+	}
+	if filter.From != nil {
+		query = query.Where("start_time >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("start_time < ?", *filter.To)
+	}
+	if filter.MinDuration > 0 {
+		query = query.Where("duration >= ?", filter.MinDuration)
+	}
+	switch filter.Status {
+	case RecordStatusOpen:
+		query = query.Where("end_time IS NULL")
+	case RecordStatusClosed:
+		query = query.Where("end_time IS NOT NULL")
+	}
+	return query
+}
+
+// ListTrackingRecords returns up to limit records matching filter, newest first.
+// Pass the ID of the last record of the previous page as beforeID to continue; the
+// returned nextID is 0 when there are no more records.
+func (r *FanTrackingRepository) ListTrackingRecords(filter RecordFilter, beforeID uint, limit int) ([]FanTracking, uint, error) {
+	query := r.recordsQuery(filter)
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var records []FanTracking
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&records).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var nextID uint
+	if len(records) > limit {
+		records = records[:limit]
+		nextID = records[limit-1].ID
+	}
+
+	if r.live != nil {
+		for i := range records {
+			if records[i].EndTime == nil {
+				r.live.overlay(&records[i])
+			}
+		}
+	}
+	return records, nextID, nil
+}
+
+// StreamTrackingRecords calls fn for every record matching filter, newest first,
+// reading rows from the database as it goes rather than loading them all
+func (r *FanTrackingRepository) StreamTrackingRecords(filter RecordFilter, fn func(*FanTracking) error) error {
+	rows, err := r.recordsQuery(filter).Order("id DESC").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record FanTracking
+		if err := r.db.ScanRows(rows, &record); err != nil {
+			return err
+		}
+		if err := fn(&record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
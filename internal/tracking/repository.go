@@ -10,34 +10,76 @@ import (
 const inactiveSessionGracePeriod = 2 * time.Minute
 
 type FanTrackingRepository struct {
-	db *gorm.DB
+	db        *gorm.DB
+	live      *HeartbeatAggregator // Optional heartbeat buffer, see EnableAggregation
+	finalized []func()             // See OnSessionsFinalized
+	ended     []func(fanID uint)   // See OnFanSessionEnded
+	pending   *afterCommit         // Set on transaction copies, see withTx
+}
+
+// afterCommit holds back the listener calls and heartbeat buffer changes of a
+// transaction copy of the repository until the transaction has committed
+type afterCommit struct {
+	finalized bool
+	ended     []uint
+	live      []func()
 }
 
 func NewFanTrackingRepository(db *gorm.DB) *FanTrackingRepository {
 	return &FanTrackingRepository{db: db}
 }
 
-// StartTracking creates a new tracking session
-func (r *FanTrackingRepository) StartTracking(fanID *uint, sessionID string) (*FanTracking, error) {
-	// Do not track guests
-	if fanID == nil {
+// StartTracking creates a new tracking session.
+// Guests are tracked by their visitor ID and skipped if they have none.
+// client is what the session's User-Agent says, see ParseUserAgent.
+func (r *FanTrackingRepository) StartTracking(fanID *uint, visitorID, sessionID string, client ClientInfo) (*FanTracking, error) {
+	if fanID == nil && visitorID == "" {
 		return nil, nil
 	}
 
-	// End any lingering active sessions for this fan before starting a new one
+	// End any lingering active sessions for this fan (or guest visitor) before starting a new one
 	// This ensures only one active session exists per fan at any time
-	if err := r.finalizeActiveSessionsForFan(fanID); err != nil {
+	if fanID != nil {
+		if err := r.finalizeActiveSessionsForFan(fanID); err != nil {
+			return nil, err
+		}
+	} else if err := r.finalizeActiveSessionsForVisitor(visitorID); err != nil {
 		return nil, err
 	}
 
+	// Reloading a tab keeps its session ID, so only its first row counts as a new session
+	var earlier int64
+	if err := r.db.Model(&FanTracking{}).Where("session_id = ?", sessionID).Limit(1).Count(&earlier).Error; err != nil {
+		return nil, err
+	}
+	firstStart := earlier == 0
+
 	tracking := &FanTracking{
-		FanID:     fanID,
-		SessionID: sessionID,
-		StartTime: time.Now(),
+		FanID:      fanID,
+		VisitorID:  visitorID,
+		SessionID:  sessionID,
+		StartTime:  time.Now(),
+		Browser:    client.Browser,
+		OS:         client.OS,
+		Device:     client.Device,
+		Bot:        client.Bot,
+		FirstStart: &firstStart,
 	}
 	if err := r.db.Create(tracking).Error; err != nil {
 		return nil, err
 	}
+	if r.live != nil && fanID != nil {
+		opened := *tracking
+		r.updateLive(func() { r.live.add(&opened) })
+	}
+	if err := r.rollupStart(tracking); err != nil {
+		return nil, err
+	}
+	if fanID != nil && !client.Bot {
+		if err := r.recordStreakDay(*fanID, tracking.StartTime); err != nil {
+			return nil, err
+		}
+	}
 	return tracking, nil
 }
 
@@ -56,6 +98,9 @@ func (r *FanTrackingRepository) EndTracking(sessionID string, fanID *uint) error
 		return err
 	}
 
+	if r.live != nil {
+		r.live.overlay(&tracking)
+	}
 	duration := calculateDuration(&tracking, now)
 	updates := map[string]interface{}{
 		"end_time": now,
@@ -63,6 +108,7 @@ func (r *FanTrackingRepository) EndTracking(sessionID string, fanID *uint) error
 	}
 
 	// Update user_id if provided and not already set
+	guestOwner := rollupOwner(nil, guestKeyFor(&tracking))
 	if fanID != nil && tracking.FanID == nil {
 		updates["user_id"] = fanID
 	}
@@ -70,6 +116,20 @@ func (r *FanTrackingRepository) EndTracking(sessionID string, fanID *uint) error
 	if err := r.db.Model(&tracking).Updates(updates).Error; err != nil {
 		return err
 	}
+	r.removeLive(&tracking)
+	if err := r.rollupFinalize(&tracking, duration); err != nil {
+		return err
+	}
+	if _, assigned := updates["user_id"]; assigned {
+		if err := r.rollupRecount(RollupSlot(tracking.StartTime), guestOwner, rollupOwner(fanID, "")); err != nil {
+			return err
+		}
+		if !tracking.Bot {
+			if err := r.recordStreakDay(*fanID, tracking.StartTime); err != nil {
+				return err
+			}
+		}
+	}
 
 	// Clean up any other active sessions tied to the same session ID
 	return r.finalizeActiveSessions(sessionID)
@@ -77,72 +137,229 @@ func (r *FanTrackingRepository) EndTracking(sessionID string, fanID *uint) error
 
 // GetTotalHours returns total hours spent by all fans
 func (r *FanTrackingRepository) GetTotalHours() (float64, error) {
-	// Sum completed sessions
+	// Sum completed sessions from the visitor totals
 	var completedSeconds int64
-	if err := r.db.Model(&FanTracking{}).
-		Where("end_time IS NOT NULL").
+	if err := r.db.Model(&VisitorRollup{}).
 		Where("user_id IS NOT NULL").
-		Select("COALESCE(SUM(duration), 0)").
+		Select("COALESCE(SUM(seconds), 0)").
 		Scan(&completedSeconds).Error; err != nil {
 		return 0, err
 	}
 
-	// Add active sessions (calculate duration on-the-fly)
-	var activeSessions []FanTracking
-	if err := r.db.Where("end_time IS NULL AND user_id IS NOT NULL").Find(&activeSessions).Error; err != nil {
+	activeSeconds, err := r.activeSeconds(nil)
+	if err != nil {
 		return 0, err
 	}
 
-	var activeSeconds int64
-	now := time.Now()
-	for _, session := range activeSessions {
-		activeSeconds += calculateDuration(&session, now)
-	}
-
 	totalSeconds := completedSeconds + activeSeconds
 	return float64(totalSeconds) / 3600.0, nil
 }
 
+// GetHoursBetween returns the hours fans spent in completed sessions started in the
+// rollup slots overlapping from to to, exact when both fall on slot boundaries
+func (r *FanTrackingRepository) GetHoursBetween(from, to time.Time) (float64, error) {
+	var seconds int64
+	if err := r.db.Model(&Rollup{}).
+		Where("user_id IS NOT NULL AND slot >= ? AND slot < ?", RollupSlot(from), to.UTC()).
+		Select("COALESCE(SUM(seconds), 0)").
+		Scan(&seconds).Error; err != nil {
+		return 0, err
+	}
+	return float64(seconds) / 3600.0, nil
+}
+
 // GetFanTotalHours returns total hours spent by a specific fan
 func (r *FanTrackingRepository) GetFanTotalHours(fanID uint) (float64, error) {
-	// Sum completed sessions
+	// Sum completed sessions from the visitor totals
 	var completedSeconds int64
-	if err := r.db.Model(&FanTracking{}).
-		Where("user_id = ? AND end_time IS NOT NULL", fanID).
-		Select("COALESCE(SUM(duration), 0)").
+	if err := r.db.Model(&VisitorRollup{}).
+		Where("user_id = ?", fanID).
+		Select("COALESCE(SUM(seconds), 0)").
 		Scan(&completedSeconds).Error; err != nil {
 		return 0, err
 	}
 
-	// Add active sessions (calculate duration on-the-fly)
-	var activeSessions []FanTracking
-	if err := r.db.Where("user_id = ? AND end_time IS NULL", fanID).Find(&activeSessions).Error; err != nil {
+	activeSeconds, err := r.activeSeconds(&fanID)
+	if err != nil {
 		return 0, err
 	}
 
-	var activeSeconds int64
-	now := time.Now()
-	for _, session := range activeSessions {
-		activeSeconds += calculateDuration(&session, now)
-	}
-
 	totalSeconds := completedSeconds + activeSeconds
 	return float64(totalSeconds) / 3600.0, nil
 }
 
-// GetAllTrackingRecords returns all tracking records with optional fan filter
-func (r *FanTrackingRepository) GetAllTrackingRecords(fanID *uint) ([]FanTracking, error) {
-	var records []FanTracking
-	query := r.db.Order("start_time DESC")
+// activeSeconds returns the time counted so far in the open sessions of a fan, or of
+// all fans when fanID is nil. It is served from the heartbeat buffer once that holds
+// every open session, and calculated from the database otherwise.
+func (r *FanTrackingRepository) activeSeconds(fanID *uint) (int64, error) {
+	now := time.Now()
+	if r.live != nil {
+		if seconds, loaded := r.live.fanSeconds(fanID, now); loaded {
+			return seconds, nil
+		}
+	}
 
+	query := r.db.Where("end_time IS NULL AND user_id IS NOT NULL")
 	if fanID != nil {
 		query = query.Where("user_id = ?", *fanID)
 	}
+	var activeSessions []FanTracking
+	if err := query.Find(&activeSessions).Error; err != nil {
+		return 0, err
+	}
+
+	var seconds int64
+	for _, session := range activeSessions {
+		if r.live != nil {
+			r.live.overlay(&session)
+		}
+		seconds += calculateDuration(&session, now)
+	}
+	return seconds, nil
+}
 
-	if err := query.Find(&records).Error; err != nil {
+// GetFanActiveSeconds returns the time counted so far in each fan's open sessions,
+// limited to sessions started at or after since
+func (r *FanTrackingRepository) GetFanActiveSeconds(since time.Time) (map[uint]int64, error) {
+	var activeSessions []FanTracking
+	if err := r.db.Where("end_time IS NULL AND user_id IS NOT NULL AND start_time >= ?", since).
+		Find(&activeSessions).Error; err != nil {
 		return nil, err
 	}
-	return records, nil
+
+	seconds := make(map[uint]int64)
+	now := time.Now()
+	for _, session := range activeSessions {
+		if r.live != nil {
+			r.live.overlay(&session)
+		}
+		seconds[*session.FanID] += calculateDuration(&session, now)
+	}
+	return seconds, nil
+}
+
+// GetActiveSessions returns the open sessions that sent a heartbeat within the grace period
+func (r *FanTrackingRepository) GetActiveSessions() ([]FanTracking, error) {
+	now := time.Now()
+	cutoff := now.Add(-inactiveSessionGracePeriod)
+	if r.live != nil {
+		// Buffered heartbeats may be newer than updated_at by up to a flush interval
+		cutoff = cutoff.Add(-r.live.interval)
+	}
+
+	var sessions []FanTracking
+	if err := r.db.Where("end_time IS NULL AND updated_at >= ?", cutoff).Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	active := sessions[:0]
+	for _, session := range sessions {
+		if r.live != nil {
+			r.live.overlay(&session)
+		}
+		if now.Sub(session.UpdatedAt) <= inactiveSessionGracePeriod {
+			active = append(active, session)
+		}
+	}
+	return active, nil
+}
+
+// GetOnlineCount returns the number of sessions that sent a heartbeat within the grace period
+func (r *FanTrackingRepository) GetOnlineCount() (int64, error) {
+	sessions, err := r.GetActiveSessions()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(sessions)), nil
+}
+
+// withTx returns a copy of the repository that runs its queries on tx. Its listeners
+// are only called, and its heartbeat buffer only changed, by flushNotifications once
+// tx has committed.
+func (r *FanTrackingRepository) withTx(tx *gorm.DB) *FanTrackingRepository {
+	return &FanTrackingRepository{
+		db:        tx,
+		live:      r.live,
+		finalized: r.finalized,
+		ended:     r.ended,
+		pending:   &afterCommit{},
+	}
+}
+
+// flushNotifications applies the buffer changes and calls the listeners held back by
+// a transaction copy
+func (r *FanTrackingRepository) flushNotifications() {
+	pending := r.pending
+	if pending == nil {
+		return
+	}
+	r.pending = &afterCommit{}
+
+	for _, fn := range pending.live {
+		fn()
+	}
+	if pending.finalized {
+		for _, fn := range r.finalized {
+			fn()
+		}
+	}
+	for _, fanID := range pending.ended {
+		for _, fn := range r.ended {
+			fn(fanID)
+		}
+	}
+}
+
+// updateLive changes the heartbeat buffer, after the commit on a transaction copy
+func (r *FanTrackingRepository) updateLive(fn func()) {
+	if r.pending != nil {
+		r.pending.live = append(r.pending.live, fn)
+		return
+	}
+	fn()
+}
+
+// removeLive drops a finalized row from the heartbeat buffer
+func (r *FanTrackingRepository) removeLive(tracking *FanTracking) {
+	if r.live == nil {
+		return
+	}
+	finalized := *tracking
+	r.updateLive(func() { r.live.remove(&finalized) })
+}
+
+// OnSessionsFinalized registers fn to be called whenever finalized tracking changes:
+// a session is finalized, a guest's sessions are merged into a fan, or a fan's history
+// is erased. Register listeners before the repository is used.
+func (r *FanTrackingRepository) OnSessionsFinalized(fn func()) {
+	r.finalized = append(r.finalized, fn)
+}
+
+func (r *FanTrackingRepository) notifyFinalized() {
+	if r.pending != nil {
+		r.pending.finalized = true
+		return
+	}
+	for _, fn := range r.finalized {
+		fn()
+	}
+}
+
+func (r *FanTrackingRepository) notifyEnded(fanID uint) {
+	if r.pending != nil {
+		r.pending.ended = append(r.pending.ended, fanID)
+		return
+	}
+	for _, fn := range r.ended {
+		fn(fanID)
+	}
+}
+
+// OnFanSessionEnded registers fn to be called with the fan's ID whenever one of a fan's
+// sessions is finalized, after the change has been committed. Register listeners before
+// the repository is used.
+func (r *FanTrackingRepository) OnFanSessionEnded(fn func(fanID uint)) {
+	r.ended = append(r.ended, fn)
 }
 
 // GetActiveSession returns the active tracking session for a session ID
@@ -156,34 +373,20 @@ func (r *FanTrackingRepository) GetActiveSession(sessionID string) (*FanTracking
 	return &tracking, nil
 }
 
-// UpdateActiveSession updates the duration of an active session
-func (r *FanTrackingRepository) UpdateActiveSession(sessionID string, fanID *uint) error {
-	// Do not track guests
-	if fanID == nil {
-		return nil
+// UpdateActiveSession updates the duration of an active session.
+// With aggregation enabled the update is buffered in memory and written on the next flush.
+func (r *FanTrackingRepository) UpdateActiveSession(sessionID string, fanID *uint, visitorID string) error {
+	if r.live != nil {
+		return r.live.heartbeat(r.db, sessionID, fanID, visitorID, time.Now())
 	}
 
-	var tracking FanTracking
-	if err := r.db.Where("session_id = ? AND end_time IS NULL AND user_id = ?", sessionID, *fanID).
-		Order("start_time DESC").
-		First(&tracking).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil
-		}
+	tracking, err := findActiveSession(r.db, sessionID, fanID, visitorID)
+	if err != nil || tracking == nil {
 		return err
 	}
 
-	duration := calculateDuration(&tracking, time.Now())
-	updates := map[string]interface{}{
-		"duration": duration,
-	}
-
-	// Update user_id if provided and not already set
-	if fanID != nil && tracking.FanID == nil {
-		updates["user_id"] = fanID
-	}
-
-	return r.db.Model(&tracking).Updates(updates).Error
+	duration := calculateDuration(tracking, time.Now())
+	return r.db.Model(tracking).Update("duration", duration).Error
 }
 
 func calculateDuration(tracking *FanTracking, now time.Time) int64 {
@@ -213,19 +416,77 @@ func (r *FanTrackingRepository) finalizeActiveSessionsForFan(fanID *uint) error
 		return err
 	}
 
-	now := time.Now()
-	for _, session := range activeSessions {
-		duration := calculateDuration(&session, now)
-		updates := map[string]interface{}{
-			"duration": duration,
-			"end_time": now,
+	return r.finalizeSessions(activeSessions, time.Now())
+}
+
+// MergeVisitor assigns a guest visitor's tracking rows to the fan they signed up or logged
+// in as, in one transaction. The heartbeat buffer and listeners are updated once it has
+// committed.
+func (r *FanTrackingRepository) MergeVisitor(visitorID string, fanID uint) (int64, error) {
+	if visitorID == "" {
+		return 0, nil
+	}
+
+	var merged int64
+	var txRepo *FanTrackingRepository
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		txRepo = r.withTx(tx)
+		var err error
+		merged, err = txRepo.mergeVisitor(visitorID, fanID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	txRepo.flushNotifications()
+	return merged, nil
+}
+
+func (r *FanTrackingRepository) mergeVisitor(visitorID string, fanID uint) (int64, error) {
+	result := r.db.Model(&FanTracking{}).
+		Where("visitor_id = ? AND user_id IS NULL", visitorID).
+		Update("user_id", fanID)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if r.live != nil {
+		// Sessions that went quiet were dropped from the buffer as a guest's
+		var open []FanTracking
+		if err := r.db.Where("visitor_id = ? AND user_id = ? AND end_time IS NULL", visitorID, fanID).Find(&open).Error; err != nil {
+			return result.RowsAffected, err
 		}
-		if err := r.db.Model(&session).Updates(updates).Error; err != nil {
-			return err
+		r.updateLive(func() {
+			r.live.reassign(visitorID, fanID)
+			for i := range open {
+				r.live.add(&open[i])
+			}
+		})
+	}
+	if err := r.mergeVisitorRollups(visitorID, fanID); err != nil {
+		return result.RowsAffected, err
+	}
+	for _, model := range []interface{}{&ContentView{}, &SessionSource{}} {
+		if err := r.db.Model(model).
+			Where("visitor_id = ? AND user_id IS NULL", visitorID).
+			Update("user_id", fanID).Error; err != nil {
+			return result.RowsAffected, err
 		}
 	}
+	if err := r.RebuildFanStreak(fanID); err != nil {
+		return result.RowsAffected, err
+	}
+	r.notifyFinalized()
+	return result.RowsAffected, nil
+}
 
-	return nil
+// finalizeActiveSessionsForVisitor ends all active guest sessions for the given visitor ID.
+func (r *FanTrackingRepository) finalizeActiveSessionsForVisitor(visitorID string) error {
+	var activeSessions []FanTracking
+	if err := r.db.Where("visitor_id = ? AND user_id IS NULL AND end_time IS NULL", visitorID).Find(&activeSessions).Error; err != nil {
+		return err
+	}
+
+	return r.finalizeSessions(activeSessions, time.Now())
 }
 
 // finalizeActiveSessions ends any active sessions for the given session ID.
@@ -236,8 +497,15 @@ func (r *FanTrackingRepository) finalizeActiveSessions(sessionID string) error {
 		return err
 	}
 
-	now := time.Now()
-	for _, session := range activeSessions {
+	return r.finalizeSessions(activeSessions, time.Now())
+}
+
+// finalizeSessions ends the given active sessions at now
+func (r *FanTrackingRepository) finalizeSessions(sessions []FanTracking, now time.Time) error {
+	for _, session := range sessions {
+		if r.live != nil {
+			r.live.overlay(&session)
+		}
 		duration := calculateDuration(&session, now)
 		updates := map[string]interface{}{
 			"duration": duration,
@@ -246,6 +514,10 @@ func (r *FanTrackingRepository) finalizeActiveSessions(sessionID string) error {
 		if err := r.db.Model(&session).Updates(updates).Error; err != nil {
 			return err
 		}
+		r.removeLive(&session)
+		if err := r.rollupFinalize(&session, duration); err != nil {
+			return err
+		}
 	}
 
 	return nil
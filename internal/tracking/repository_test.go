@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"anonchihaya.co.uk/internal/auth"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -19,7 +20,7 @@ func setupTrackingRepo(t *testing.T) *FanTrackingRepository {
 		t.Fatalf("failed to open test database: %v", err)
 	}
 
-	if err := db.AutoMigrate(&FanTracking{}); err != nil {
+	if err := db.AutoMigrate(&FanTracking{}, &TrackingEvent{}, &ProcessedEvent{}, &Rollup{}, &VisitorRollup{}, &TrackingConsent{}, &RetentionSetting{}, &ContentView{}, &SessionSource{}, &FanStreak{}, &FunnelEvent{}, &auth.Fan{}); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
 
@@ -36,15 +37,15 @@ func forceTimestamps(repo *FanTrackingRepository, tracking *FanTracking) {
 	}
 }
 
-func TestStartTrackingSkipsGuests(t *testing.T) {
+func TestStartTrackingSkipsAnonymousGuests(t *testing.T) {
 	repo := setupTrackingRepo(t)
 
-	tracking, err := repo.StartTracking(nil, "sess-guest")
+	tracking, err := repo.StartTracking(nil, "", "sess-guest", ClientInfo{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	if tracking != nil {
-		t.Fatalf("expected nil tracking for guest start, got %+v", tracking)
+		t.Fatalf("expected nil tracking for guest without visitor ID, got %+v", tracking)
 	}
 
 	var count int64
@@ -56,6 +57,73 @@ func TestStartTrackingSkipsGuests(t *testing.T) {
 	}
 }
 
+func TestGuestTrackingMergesIntoFan(t *testing.T) {
+	repo := setupTrackingRepo(t)
+
+	first, err := repo.StartTracking(nil, "visitor-1", "sess-a", ClientInfo{})
+	if err != nil || first == nil {
+		t.Fatalf("failed to start guest tracking: %v", err)
+	}
+	if first.FanID != nil || first.VisitorID != "visitor-1" {
+		t.Fatalf("expected guest row keyed by visitor, got %+v", first)
+	}
+
+	// A second tab for the same guest finalizes the first one
+	if _, err := repo.StartTracking(nil, "visitor-1", "sess-b", ClientInfo{}); err != nil {
+		t.Fatalf("failed to start second guest session: %v", err)
+	}
+	var stillActive int64
+	repo.db.Model(&FanTracking{}).Where("session_id = ? AND end_time IS NULL", "sess-a").Count(&stillActive)
+	if stillActive != 0 {
+		t.Fatalf("expected the first guest session to be finalized")
+	}
+
+	if err := repo.UpdateActiveSession("sess-b", nil, "visitor-1"); err != nil {
+		t.Fatalf("failed to update guest session: %v", err)
+	}
+
+	if _, err := repo.StartTracking(nil, "visitor-2", "sess-c", ClientInfo{}); err != nil {
+		t.Fatalf("failed to start other guest session: %v", err)
+	}
+
+	merged, err := repo.MergeVisitor("visitor-1", 7)
+	if err != nil {
+		t.Fatalf("failed to merge visitor: %v", err)
+	}
+	if merged != 2 {
+		t.Fatalf("expected 2 merged rows, got %d", merged)
+	}
+
+	var fanRows, otherGuestRows int64
+	repo.db.Model(&FanTracking{}).Where("user_id = ?", 7).Count(&fanRows)
+	repo.db.Model(&FanTracking{}).Where("visitor_id = ? AND user_id IS NULL", "visitor-2").Count(&otherGuestRows)
+	if fanRows != 2 || otherGuestRows != 1 {
+		t.Fatalf("expected 2 fan rows and 1 untouched guest row, got %d and %d", fanRows, otherGuestRows)
+	}
+}
+
+func TestMergeVisitorRollsBackOnError(t *testing.T) {
+	repo := setupTrackingRepo(t)
+
+	if _, err := repo.StartTracking(nil, "visitor-1", "sess-a", ClientInfo{}); err != nil {
+		t.Fatalf("failed to start guest tracking: %v", err)
+	}
+	// Moving the session sources fails after the tracking rows were updated
+	if err := repo.db.Migrator().DropTable(&SessionSource{}); err != nil {
+		t.Fatalf("failed to drop session sources: %v", err)
+	}
+
+	if _, err := repo.MergeVisitor("visitor-1", 7); err == nil {
+		t.Fatalf("expected the merge to fail")
+	}
+	var guestRows, guestRollups int64
+	repo.db.Model(&FanTracking{}).Where("visitor_id = ? AND user_id IS NULL", "visitor-1").Count(&guestRows)
+	repo.db.Model(&Rollup{}).Where("owner = ?", "guest:visitor-1").Count(&guestRollups)
+	if guestRows != 1 || guestRollups != 1 {
+		t.Fatalf("expected the guest's row and rollup to be kept, got %d and %d", guestRows, guestRollups)
+	}
+}
+
 func TestStartTrackingFinalizesExistingSessions(t *testing.T) {
 	repo := setupTrackingRepo(t)
 	start := time.Now().Add(-10 * time.Minute)
@@ -75,7 +143,7 @@ func TestStartTrackingFinalizesExistingSessions(t *testing.T) {
 	}
 	forceTimestamps(repo, existing)
 
-	if _, err := repo.StartTracking(&userID, "sess-1"); err != nil {
+	if _, err := repo.StartTracking(&userID, "", "sess-1", ClientInfo{}); err != nil {
 		t.Fatalf("failed to start tracking: %v", err)
 	}
 
@@ -194,6 +262,11 @@ func TestGetTotalHoursIgnoresGuestsAndStaleGrowth(t *testing.T) {
 	}
 	forceTimestamps(repo, guest)
 
+	// Seeded rows bypass the incremental rollups, so backfill them
+	if _, err := repo.RebuildRollups(); err != nil {
+		t.Fatalf("failed to rebuild rollups: %v", err)
+	}
+
 	var storedActive FanTracking
 	if err := repo.db.Where("session_id = ?", "active").First(&storedActive).Error; err != nil {
 		t.Fatalf("failed to fetch active session: %v", err)
@@ -217,3 +290,32 @@ func TestGetTotalHoursIgnoresGuestsAndStaleGrowth(t *testing.T) {
 		t.Fatalf("expected total hours %.9f, got %.9f", expectedHours, totalHours)
 	}
 }
+
+func TestApplyBatchNotifiesAfterCommit(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	fanID := uint(12)
+
+	var ended []uint
+	finalized := 0
+	repo.OnFanSessionEnded(func(id uint) { ended = append(ended, id) })
+	repo.OnSessionsFinalized(func() { finalized++ })
+
+	ops := []BatchOp{
+		{ClientEventID: "start", Type: OpStart},
+		{ClientEventID: "end", Type: OpEnd},
+		{ClientEventID: "broken", Type: "unknown"},
+	}
+	if _, err := repo.ApplyBatch(&fanID, "", "batch-session", ops); err == nil {
+		t.Fatalf("expected the unknown operation to fail the batch")
+	}
+	if len(ended) != 0 || finalized != 0 {
+		t.Fatalf("expected no listeners for a rolled back batch, got %v and %d", ended, finalized)
+	}
+
+	if _, err := repo.ApplyBatch(&fanID, "", "batch-session", ops[:2]); err != nil {
+		t.Fatalf("ApplyBatch failed: %v", err)
+	}
+	if len(ended) != 1 || ended[0] != fanID || finalized != 1 {
+		t.Fatalf("expected one notification after commit, got %v and %d", ended, finalized)
+	}
+}
@@ -0,0 +1,305 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// MinRetentionDays keeps at least a week of raw rows for the records view and
+	// long-running sessions
+	MinRetentionDays = 8
+	// DefaultRetentionInterval is how often the retention job runs
+	DefaultRetentionInterval = 24 * time.Hour
+)
+
+// RetentionSetting is the admin-configured retention policy. There is at most one row;
+// when it is missing the policy from the environment applies.
+type RetentionSetting struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	Days      int       `gorm:"not null" json:"days"` // 0 keeps raw rows forever
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName sets the table name for the retention setting
+func (RetentionSetting) TableName() string {
+	return "tracking_retention_settings"
+}
+
+// RetentionReport describes one compaction run, or what it would do in a dry run
+type RetentionReport struct {
+	DryRun        bool      `json:"dry_run"`
+	Days          int       `json:"days"`   // Retention in days, 0 when disabled
+	Cutoff        time.Time `json:"cutoff"` // Raw rows started before this are compacted
+	Finalized     int64     `json:"finalized"`
+	Compacted     []string  `json:"compacted_days"`
+	Deleted       int64     `json:"deleted"`
+	Skipped       []string  `json:"skipped_days"`   // Days kept because a session on them is still active
+	PrunedMarkers int64     `json:"pruned_markers"` // Batch event IDs past ProcessedEventWindow
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+}
+
+// ValidateRetentionDays checks a retention period; 0 disables retention
+func ValidateRetentionDays(days int) error {
+	if days != 0 && days < MinRetentionDays {
+		return fmt.Errorf("retention must be 0 (keep forever) or at least %d days", MinRetentionDays)
+	}
+	return nil
+}
+
+// GetRetentionSetting returns the admin retention setting, or nil if none was saved
+func (r *FanTrackingRepository) GetRetentionSetting() (*RetentionSetting, error) {
+	var setting RetentionSetting
+	if err := r.db.First(&setting).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &setting, nil
+}
+
+// SetRetentionDays saves the admin retention setting
+func (r *FanTrackingRepository) SetRetentionDays(days int) (*RetentionSetting, error) {
+	if err := ValidateRetentionDays(days); err != nil {
+		return nil, err
+	}
+
+	setting := &RetentionSetting{ID: 1, Days: days, UpdatedAt: time.Now()}
+	if err := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"days", "updated_at"}),
+	}).Create(setting).Error; err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+// CompactTracking folds raw tracking rows that started before the local day of cutoff
+// into the rollups and deletes them.
+//
+// Stale sessions that were never ended are finalized first. Each day is compacted as a
+// whole: its rollups are recomputed from its raw rows in the same transaction that
+// deletes them, so statistics read from the rollups don't change. Days that still have
+// an active session are skipped until a later run. With dryRun nothing is written.
+func (r *FanTrackingRepository) CompactTracking(cutoff time.Time, dryRun bool) (*RetentionReport, error) {
+	now := time.Now()
+	cutoff = startOfLocalDay(cutoff)
+	report := &RetentionReport{
+		DryRun:    dryRun,
+		Cutoff:    cutoff,
+		Compacted: []string{},
+		Skipped:   []string{},
+		StartedAt: now,
+	}
+
+	var stale []FanTracking
+	if err := r.db.Where("end_time IS NULL AND start_time < ? AND updated_at < ?",
+		cutoff, now.Add(-inactiveSessionGracePeriod)).Find(&stale).Error; err != nil {
+		return nil, err
+	}
+	report.Finalized = int64(len(stale))
+	if !dryRun {
+		if err := r.finalizeSessions(stale, now); err != nil {
+			return nil, err
+		}
+	}
+	finalized := make(map[uint]bool, len(stale))
+	for _, session := range stale {
+		finalized[session.ID] = true
+	}
+
+	var days []string
+	rowsPerDay := make(map[string]int64)
+	activeDays := make(map[string]bool)
+	var batch []FanTracking
+	err := r.db.Where("start_time < ?", cutoff).FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for _, tracking := range batch {
+			day := tracking.StartTime.In(time.Local).Format(time.DateOnly)
+			if _, seen := rowsPerDay[day]; !seen {
+				days = append(days, day)
+			}
+			rowsPerDay[day]++
+			if tracking.EndTime == nil && !finalized[tracking.ID] {
+				activeDays[day] = true
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(days)
+
+	for _, day := range days {
+		if activeDays[day] {
+			report.Skipped = append(report.Skipped, day)
+			continue
+		}
+		if !dryRun {
+			if err := r.compactDay(day); err != nil {
+				return nil, fmt.Errorf("compact %s: %w", day, err)
+			}
+		}
+		report.Compacted = append(report.Compacted, day)
+		report.Deleted += rowsPerDay[day]
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// compactDay recomputes a day's rollups from its raw rows and deletes them
+func (r *FanTrackingRepository) compactDay(day string) error {
+	start, err := time.ParseInLocation(time.DateOnly, day, time.Local)
+	if err != nil {
+		return err
+	}
+	end := start.AddDate(0, 0, 1)
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var rows []FanTracking
+		if err := tx.Where("start_time >= ? AND start_time < ?", start, end).Order("id").Find(&rows).Error; err != nil {
+			return err
+		}
+
+		acc := newRollupAccumulator()
+		seen := make(map[string]bool)
+		for i := range rows {
+			acc.add(&rows[i], isFirstStart(&rows[i], seen))
+		}
+		if err := acc.replace(tx); err != nil {
+			return err
+		}
+		return tx.Where("start_time >= ? AND start_time < ?", start, end).Delete(&FanTracking{}).Error
+	})
+}
+
+func startOfLocalDay(t time.Time) time.Time {
+	t = t.In(time.Local)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// RetentionJob periodically compacts raw tracking rows past the retention period.
+// The admin setting takes precedence over the default from the environment.
+type RetentionJob struct {
+	repo        *FanTrackingRepository
+	defaultDays int
+	dryRun      bool
+	interval    time.Duration
+
+	runMu sync.Mutex // Serializes runs
+
+	mu   sync.Mutex
+	last *RetentionReport
+}
+
+// NewRetentionJob creates a retention job. With dryRun the scheduled runs only report
+// what they would compact.
+func NewRetentionJob(repo *FanTrackingRepository, defaultDays int, dryRun bool, interval time.Duration) *RetentionJob {
+	if interval <= 0 {
+		interval = DefaultRetentionInterval
+	}
+	return &RetentionJob{
+		repo:        repo,
+		defaultDays: defaultDays,
+		dryRun:      dryRun,
+		interval:    interval,
+	}
+}
+
+// Start runs the job once and then every interval until ctx is done
+func (j *RetentionJob) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			if report, err := j.Run(j.dryRun); err != nil {
+				log.Printf("Warning: Tracking retention failed: %v", err)
+			} else if report.Days > 0 || report.PrunedMarkers > 0 {
+				log.Printf("Tracking retention (dry run: %t): compacted %d days, deleted %d rows, finalized %d stale sessions, pruned %d batch event IDs",
+					report.DryRun, len(report.Compacted), report.Deleted, report.Finalized, report.PrunedMarkers)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Days returns the retention period in effect
+func (j *RetentionJob) Days() (int, error) {
+	setting, err := j.repo.GetRetentionSetting()
+	if err != nil {
+		return 0, err
+	}
+	if setting != nil {
+		return setting.Days, nil
+	}
+	return j.defaultDays, nil
+}
+
+// DefaultDays returns the retention period from the environment
+func (j *RetentionJob) DefaultDays() int {
+	return j.defaultDays
+}
+
+// DryRun reports whether scheduled runs are dry runs
+func (j *RetentionJob) DryRun() bool {
+	return j.dryRun
+}
+
+// Run applies the retention policy now and prunes processed batch event IDs, which are
+// pruned even with retention disabled.
+func (j *RetentionJob) Run(dryRun bool) (*RetentionReport, error) {
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+
+	days, err := j.Days()
+	if err != nil {
+		return nil, err
+	}
+
+	var report *RetentionReport
+	if days == 0 {
+		now := time.Now()
+		report = &RetentionReport{DryRun: dryRun, Compacted: []string{}, Skipped: []string{}, StartedAt: now, FinishedAt: now}
+	} else {
+		report, err = j.repo.CompactTracking(time.Now().AddDate(0, 0, -days), dryRun)
+		if err != nil {
+			return nil, err
+		}
+		report.Days = days
+	}
+
+	report.PrunedMarkers, err = j.repo.PruneProcessedEvents(time.Now().Add(-ProcessedEventWindow), dryRun)
+	if err != nil {
+		return nil, err
+	}
+	report.FinishedAt = time.Now()
+
+	j.mu.Lock()
+	j.last = report
+	j.mu.Unlock()
+	return report, nil
+}
+
+// LastReport returns the report of the most recent run, or nil before the first run
+func (j *RetentionJob) LastReport() *RetentionReport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.last
+}
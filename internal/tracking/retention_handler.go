@@ -0,0 +1,105 @@
+package tracking
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RetentionHandler struct {
+	job *RetentionJob
+}
+
+func NewRetentionHandler(job *RetentionJob) *RetentionHandler {
+	return &RetentionHandler{job: job}
+}
+
+// GetRetention godoc
+// @Summary Tracking retention policy
+// @Description Returns the retention in effect, where it comes from and the report of the last run.
+// @Tags tracking
+// @Produce json
+// @Success 200 {object} TrackingRetentionResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/retention [get]
+func (h *RetentionHandler) GetRetention(c *gin.Context) {
+	setting, err := h.job.repo.GetRetentionSetting()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get retention policy"})
+		return
+	}
+
+	days := h.job.DefaultDays()
+	if setting != nil {
+		days = setting.Days
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days":         days,
+		"default_days": h.job.DefaultDays(),
+		"setting":      setting,
+		"min_days":     MinRetentionDays,
+		"dry_run":      h.job.DryRun(),
+		"last_report":  h.job.LastReport(),
+	})
+}
+
+// UpdateRetention godoc
+// @Summary Set the tracking retention policy
+// @Description Overrides the retention from the environment. 0 keeps raw rows forever.
+// @Tags tracking
+// @Accept json
+// @Produce json
+// @Param body body TrackingRetentionRequest true "Retention"
+// @Success 200 {object} tracking.RetentionSetting
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/retention [put]
+func (h *RetentionHandler) UpdateRetention(c *gin.Context) {
+	var req struct {
+		Days *int `json:"days" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateRetentionDays(*req.Days); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	setting, err := h.job.repo.SetRetentionDays(*req.Days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update retention policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, setting)
+}
+
+// RunRetention godoc
+// @Summary Run the tracking retention job now
+// @Description With dry_run the report lists what would be compacted without changing anything.
+// @Tags tracking
+// @Produce json
+// @Param dry_run query bool false "Only report" default(true)
+// @Success 200 {object} tracking.RetentionReport
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /tracking/retention/run [post]
+func (h *RetentionHandler) RunRetention(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dry_run"})
+		return
+	}
+
+	report, err := h.job.Run(dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run retention"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
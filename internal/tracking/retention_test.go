@@ -0,0 +1,209 @@
+package tracking
+
+import (
+	"testing"
+	"time"
+)
+
+func seedTracking(t *testing.T, repo *FanTrackingRepository, tracking FanTracking) FanTracking {
+	t.Helper()
+
+	if err := repo.db.Create(&tracking).Error; err != nil {
+		t.Fatalf("failed to seed tracking: %v", err)
+	}
+	forceTimestamps(repo, &tracking)
+	return tracking
+}
+
+func TestCompactTrackingKeepsRollups(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	fanID := uint(21)
+	old := startOfLocalDay(time.Now().AddDate(0, 0, -30)).Add(10 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+
+	for i, start := range []time.Time{old, old.Add(time.Hour), old.AddDate(0, 0, 1), recent} {
+		end := start.Add(10 * time.Minute)
+		seedTracking(t, repo, FanTracking{
+			FanID:     &fanID,
+			SessionID: []string{"old-a", "old-b", "old-c", "recent"}[i],
+			StartTime: start,
+			EndTime:   &end,
+			Duration:  600,
+			CreatedAt: start,
+			UpdatedAt: end,
+		})
+	}
+	seedTracking(t, repo, FanTracking{
+		VisitorID: "old-guest",
+		SessionID: "old-guest",
+		StartTime: old,
+		EndTime:   &old,
+		Duration:  120,
+		CreatedAt: old,
+		UpdatedAt: old,
+	})
+	if _, err := repo.RebuildRollups(); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+
+	before := rollupSnapshot(t, repo)
+	hoursBefore, err := repo.GetFanTotalHours(fanID)
+	if err != nil {
+		t.Fatalf("hours failed: %v", err)
+	}
+
+	dry, err := repo.CompactTracking(time.Now().AddDate(0, 0, -10), true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(dry.Compacted) != 2 || dry.Deleted != 4 {
+		t.Fatalf("expected dry run to report 2 days and 4 rows, got %+v", dry)
+	}
+	var raw int64
+	repo.db.Model(&FanTracking{}).Count(&raw)
+	if raw != 5 {
+		t.Fatalf("expected dry run to keep all 5 raw rows, got %d", raw)
+	}
+
+	report, err := repo.CompactTracking(time.Now().AddDate(0, 0, -10), false)
+	if err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+	if report.Deleted != 4 {
+		t.Fatalf("expected 4 raw rows deleted, got %d", report.Deleted)
+	}
+	repo.db.Model(&FanTracking{}).Count(&raw)
+	if raw != 1 {
+		t.Fatalf("expected only the recent raw row to remain, got %d", raw)
+	}
+
+	assertSnapshot := func(stage string) {
+		t.Helper()
+		after := rollupSnapshot(t, repo)
+		if len(after) != len(before) {
+			t.Fatalf("%s: expected %d rollup rows, got %d", stage, len(before), len(after))
+		}
+		for key, want := range before {
+			got, ok := after[key]
+			if !ok || got.Sessions != want.Sessions || got.Starts != want.Starts || got.Seconds != want.Seconds || !got.LastStartAt.Equal(want.LastStartAt) {
+				t.Fatalf("%s: rollup %s changed: before %+v, after %+v", stage, key, want, got)
+			}
+		}
+	}
+	assertSnapshot("after compaction")
+
+	hoursAfter, err := repo.GetFanTotalHours(fanID)
+	if err != nil {
+		t.Fatalf("hours failed: %v", err)
+	}
+	if hoursAfter != hoursBefore {
+		t.Fatalf("expected total hours to stay %v, got %v", hoursBefore, hoursAfter)
+	}
+
+	// A rebuild only touches days that still have raw rows
+	if _, err := repo.RebuildRollups(); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	assertSnapshot("after rebuild")
+}
+
+func TestCompactTrackingFinalizesStaleAndSkipsActiveDays(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	staleFan, activeFan := uint(31), uint(32)
+	staleDay := startOfLocalDay(time.Now().AddDate(0, 0, -20)).Add(9 * time.Hour)
+	activeDay := startOfLocalDay(time.Now().AddDate(0, 0, -15)).Add(9 * time.Hour)
+
+	seedTracking(t, repo, FanTracking{
+		FanID:     &staleFan,
+		SessionID: "stale",
+		StartTime: staleDay,
+		Duration:  300,
+		CreatedAt: staleDay,
+		UpdatedAt: staleDay.Add(5 * time.Minute),
+	})
+	seedTracking(t, repo, FanTracking{
+		FanID:     &activeFan,
+		SessionID: "long-running",
+		StartTime: activeDay,
+		Duration:  60,
+		CreatedAt: activeDay,
+		UpdatedAt: time.Now(),
+	})
+	if _, err := repo.RebuildRollups(); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+
+	report, err := repo.CompactTracking(time.Now().AddDate(0, 0, -10), false)
+	if err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+	if report.Finalized != 1 || len(report.Compacted) != 1 || len(report.Skipped) != 1 {
+		t.Fatalf("expected 1 finalized, 1 compacted and 1 skipped day, got %+v", report)
+	}
+
+	hours, err := repo.GetFanTotalHours(staleFan)
+	if err != nil {
+		t.Fatalf("hours failed: %v", err)
+	}
+	if hours != 300.0/3600.0 {
+		t.Fatalf("expected the stale session's 300s to be kept, got %v hours", hours)
+	}
+
+	var remaining []FanTracking
+	repo.db.Find(&remaining)
+	if len(remaining) != 1 || remaining[0].SessionID != "long-running" {
+		t.Fatalf("expected only the active session to remain, got %+v", remaining)
+	}
+}
+
+func TestRetentionJobUsesAdminSetting(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	job := NewRetentionJob(repo, 30, false, time.Hour)
+
+	if days, err := job.Days(); err != nil || days != 30 {
+		t.Fatalf("expected the default of 30 days, got %d, %v", days, err)
+	}
+	if _, err := repo.SetRetentionDays(3); err == nil {
+		t.Fatalf("expected retention below the minimum to be rejected")
+	}
+	if _, err := repo.SetRetentionDays(0); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	report, err := job.Run(false)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if report.Days != 0 || report.Deleted != 0 || job.LastReport() != report {
+		t.Fatalf("expected a disabled run to be reported, got %+v", report)
+	}
+}
+
+func TestRetentionJobPrunesProcessedEvents(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	job := NewRetentionJob(repo, 0, false, time.Hour)
+
+	old := time.Now().Add(-ProcessedEventWindow - time.Hour)
+	for _, marker := range []ProcessedEvent{
+		{SessionID: "sess", ClientEventID: "old", CreatedAt: old},
+		{SessionID: "sess", ClientEventID: "recent"},
+	} {
+		if err := repo.db.Create(&marker).Error; err != nil {
+			t.Fatalf("failed to seed marker: %v", err)
+		}
+	}
+
+	report, err := job.Run(true)
+	if err != nil || report.PrunedMarkers != 1 {
+		t.Fatalf("expected a dry run to count 1 marker, got %+v (err %v)", report, err)
+	}
+	if report, err = job.Run(false); err != nil || report.PrunedMarkers != 1 {
+		t.Fatalf("expected 1 marker pruned, got %+v (err %v)", report, err)
+	}
+
+	var left []ProcessedEvent
+	repo.db.Find(&left)
+	if len(left) != 1 || left[0].ClientEventID != "recent" {
+		t.Fatalf("expected only the recent marker to remain, got %+v", left)
+	}
+}
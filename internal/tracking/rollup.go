@@ -0,0 +1,458 @@
+package tracking
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RollupSlotSize is the time granularity of the rollups. Every UTC offset in use is a
+// multiple of 15 minutes, so a slot always falls within a single local hour and day
+// and statistics can be bucketed in any time zone.
+const RollupSlotSize = 15 * time.Minute
+
+// Rollup pre-aggregates user_trackings per 15-minute slot and owner (a fan or a guest
+// visitor) so statistics don't have to scan the raw rows.
+//
+// Rows are counted up when a session starts and receive its duration when it is
+// finalized. A session is counted in Sessions once, in the slot it first started in,
+// so sums of Sessions over any range count distinct sessions. LastStartAt keeps
+// windows that don't start on a slot boundary (e.g. the last 24 hours) exact.
+type Rollup struct {
+	Slot        time.Time `gorm:"primaryKey" json:"slot"`                // Start of the slot, UTC
+	Owner       string    `gorm:"primaryKey;type:varchar(262)" json:"-"` // fan:<id> or guest:<key>, see rollupOwner
+	Bot         bool      `gorm:"primaryKey;default:false" json:"bot"`   // The sessions' client is a bot
+	FanID       *uint     `gorm:"column:user_id;index" json:"user_id"`
+	GuestKey    string    `gorm:"type:varchar(255);index" json:"guest_key,omitempty"` // Visitor ID, or session ID for legacy rows
+	Sessions    int64     `gorm:"default:0" json:"sessions"`                          // Sessions that first started in the slot
+	Starts      int64     `gorm:"default:0" json:"starts"`
+	Seconds     int64     `gorm:"default:0" json:"seconds"` // Completed session seconds
+	LastStartAt time.Time `gorm:"index" json:"last_start_at"`
+}
+
+// TableName sets the table name for tracking rollups
+func (Rollup) TableName() string {
+	return "tracking_rollups"
+}
+
+// VisitorRollup totals the rollups of each owner, so all-time and "visited since"
+// counts read one row per fan or guest visitor
+type VisitorRollup struct {
+	Owner       string    `gorm:"primaryKey;type:varchar(262)" json:"-"`
+	Bot         bool      `gorm:"primaryKey;default:false" json:"bot"`
+	FanID       *uint     `gorm:"column:user_id;index" json:"user_id"`
+	GuestKey    string    `gorm:"type:varchar(255);index" json:"guest_key,omitempty"`
+	Sessions    int64     `gorm:"default:0" json:"sessions"`
+	Starts      int64     `gorm:"default:0" json:"starts"`
+	Seconds     int64     `gorm:"default:0" json:"seconds"`
+	LastStartAt time.Time `gorm:"index" json:"last_start_at"`
+}
+
+// TableName sets the table name for the per-visitor rollup totals
+func (VisitorRollup) TableName() string {
+	return "tracking_visitor_rollups"
+}
+
+// RollupSlot returns the start of the rollup slot a time falls in
+func RollupSlot(t time.Time) time.Time {
+	return t.UTC().Truncate(RollupSlotSize)
+}
+
+func rollupOwner(fanID *uint, guestKey string) string {
+	if fanID != nil {
+		return fmt.Sprintf("fan:%d", *fanID)
+	}
+	return "guest:" + guestKey
+}
+
+func guestKeyFor(tracking *FanTracking) string {
+	if tracking.FanID != nil {
+		return ""
+	}
+	if tracking.VisitorID != "" {
+		return tracking.VisitorID
+	}
+	return tracking.SessionID
+}
+
+func newRollup(tracking *FanTracking) Rollup {
+	guestKey := guestKeyFor(tracking)
+	return Rollup{
+		Slot:        RollupSlot(tracking.StartTime),
+		Owner:       rollupOwner(tracking.FanID, guestKey),
+		Bot:         tracking.Bot,
+		FanID:       tracking.FanID,
+		GuestKey:    guestKey,
+		LastStartAt: tracking.StartTime,
+	}
+}
+
+// isFirstStart reports whether a raw row is the first of its session. Rows written
+// before this was recorded fall back to the session IDs seen so far, in ID order.
+func isFirstStart(tracking *FanTracking, seen map[string]bool) bool {
+	first := !seen[tracking.SessionID]
+	if tracking.FirstStart != nil {
+		first = *tracking.FirstStart
+	}
+	seen[tracking.SessionID] = true
+	return first
+}
+
+var (
+	rollupKey        = []clause.Column{{Name: "slot"}, {Name: "owner"}, {Name: "bot"}}
+	visitorRollupKey = []clause.Column{{Name: "owner"}, {Name: "bot"}}
+)
+
+// rollupAdd adds counts of a raw row to its slot's rollup and its owner's totals
+func (r *FanTrackingRepository) rollupAdd(tracking *FanTracking, sessions, starts, seconds int64) error {
+	row := newRollup(tracking)
+	row.Sessions, row.Starts, row.Seconds = sessions, starts, seconds
+	total := VisitorRollup{
+		Owner:       row.Owner,
+		Bot:         row.Bot,
+		FanID:       row.FanID,
+		GuestKey:    row.GuestKey,
+		Sessions:    sessions,
+		Starts:      starts,
+		Seconds:     seconds,
+		LastStartAt: row.LastStartAt,
+	}
+
+	updates := clause.Set{
+		{Column: clause.Column{Name: "sessions"}, Value: gorm.Expr("sessions + ?", sessions)},
+		{Column: clause.Column{Name: "starts"}, Value: gorm.Expr("starts + ?", starts)},
+		{Column: clause.Column{Name: "seconds"}, Value: gorm.Expr("seconds + ?", seconds)},
+	}
+	if starts > 0 {
+		updates = append(updates, clause.Assignment{Column: clause.Column{Name: "last_start_at"}, Value: tracking.StartTime})
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{Columns: rollupKey, DoUpdates: updates}).Create(&row).Error; err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{Columns: visitorRollupKey, DoUpdates: updates}).Create(&total).Error
+	})
+}
+
+// rollupStart counts a newly started tracking row
+func (r *FanTrackingRepository) rollupStart(tracking *FanTracking) error {
+	var sessions int64
+	if tracking.FirstStart != nil && *tracking.FirstStart {
+		sessions = 1
+	}
+	return r.rollupAdd(tracking, sessions, 1, 0)
+}
+
+// rollupFinalize adds a finalized row's duration to its slot
+func (r *FanTrackingRepository) rollupFinalize(tracking *FanTracking, duration int64) error {
+	if err := r.rollupAdd(tracking, 0, 0, duration); err != nil {
+		return err
+	}
+	r.notifyFinalized()
+	if tracking.FanID != nil {
+		r.notifyEnded(*tracking.FanID)
+	}
+	return nil
+}
+
+// rollupRecount recomputes the rollups of the given owners in a slot from its raw rows,
+// and their totals. The slot must not have been compacted yet.
+func (r *FanTrackingRepository) rollupRecount(slot time.Time, owners ...string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var rows []FanTracking
+		if err := tx.Where("start_time >= ? AND start_time < ?", slot.In(time.Local), slot.Add(RollupSlotSize).In(time.Local)).
+			Order("id").
+			Find(&rows).Error; err != nil {
+			return err
+		}
+
+		acc := newRollupAccumulator()
+		seen := make(map[string]bool)
+		for i := range rows {
+			first := isFirstStart(&rows[i], seen)
+			if slices.Contains(owners, rollupOwner(rows[i].FanID, guestKeyFor(&rows[i]))) {
+				acc.add(&rows[i], first)
+			}
+		}
+
+		if err := tx.Where("slot = ? AND owner IN ?", slot, owners).Delete(&Rollup{}).Error; err != nil {
+			return err
+		}
+		if err := acc.create(tx); err != nil {
+			return err
+		}
+		return refreshVisitorRollups(tx, owners)
+	})
+}
+
+// mergeVisitorRollups moves a guest visitor's rollup rows onto the fan they became,
+// combining them with rows the fan already has for the same slot
+func (r *FanTrackingRepository) mergeVisitorRollups(visitorID string, fanID uint) error {
+	guestOwner := rollupOwner(nil, visitorID)
+	fanOwner := rollupOwner(&fanID, "")
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var rows []Rollup
+		if err := tx.Where("owner = ?", guestOwner).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := tx.Where("owner = ?", guestOwner).Delete(&Rollup{}).Error; err != nil {
+			return err
+		}
+
+		for _, moved := range rows {
+			lastStart := moved.LastStartAt.In(time.Local)
+			moved.Owner = fanOwner
+			moved.FanID = &fanID
+			moved.GuestKey = ""
+			if err := tx.Clauses(clause.OnConflict{
+				Columns: rollupKey,
+				DoUpdates: clause.Set{
+					{Column: clause.Column{Name: "sessions"}, Value: gorm.Expr("sessions + ?", moved.Sessions)},
+					{Column: clause.Column{Name: "starts"}, Value: gorm.Expr("starts + ?", moved.Starts)},
+					{Column: clause.Column{Name: "seconds"}, Value: gorm.Expr("seconds + ?", moved.Seconds)},
+					{Column: clause.Column{Name: "last_start_at"}, Value: gorm.Expr("CASE WHEN last_start_at < ? THEN ? ELSE last_start_at END", lastStart, lastStart)},
+				},
+			}).Create(&moved).Error; err != nil {
+				return err
+			}
+		}
+		return refreshVisitorRollups(tx, []string{guestOwner, fanOwner})
+	})
+}
+
+type rollupKeyOf struct {
+	slot  int64
+	owner string
+	bot   bool
+}
+
+// rollupAccumulator builds rollup rows from raw tracking rows
+type rollupAccumulator struct {
+	rows  map[rollupKeyOf]*Rollup
+	order []rollupKeyOf
+	slots map[int64]time.Time
+}
+
+func newRollupAccumulator() *rollupAccumulator {
+	return &rollupAccumulator{
+		rows:  make(map[rollupKeyOf]*Rollup),
+		slots: make(map[int64]time.Time),
+	}
+}
+
+// add counts a raw row; first says whether it is its session's first, see isFirstStart
+func (a *rollupAccumulator) add(tracking *FanTracking, first bool) {
+	row := newRollup(tracking)
+	key := rollupKeyOf{row.Slot.Unix(), row.Owner, row.Bot}
+
+	existing, ok := a.rows[key]
+	if !ok {
+		existing = &row
+		a.rows[key] = existing
+		a.order = append(a.order, key)
+		a.slots[key.slot] = row.Slot
+	} else if tracking.StartTime.After(existing.LastStartAt) {
+		existing.LastStartAt = tracking.StartTime
+	}
+	existing.Starts++
+	if first {
+		existing.Sessions++
+	}
+	if tracking.EndTime != nil {
+		existing.Seconds += tracking.Duration
+	}
+}
+
+// replace swaps the rollups of every accumulated slot for the accumulated rows
+func (a *rollupAccumulator) replace(tx *gorm.DB) error {
+	slots := make([]time.Time, 0, len(a.slots))
+	for _, slot := range a.slots {
+		slots = append(slots, slot)
+	}
+	for start := 0; start < len(slots); start += 500 {
+		end := min(start+500, len(slots))
+		if err := tx.Where("slot IN ?", slots[start:end]).Delete(&Rollup{}).Error; err != nil {
+			return err
+		}
+	}
+	return a.create(tx)
+}
+
+// create inserts the accumulated rows
+func (a *rollupAccumulator) create(tx *gorm.DB) error {
+	rows := make([]Rollup, 0, len(a.order))
+	for _, key := range a.order {
+		rows = append(rows, *a.rows[key])
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(&rows, 500).Error
+}
+
+type visitorKeyOf struct {
+	owner string
+	bot   bool
+}
+
+// visitorTotals sums rollup rows into per-owner totals
+type visitorTotals struct {
+	rows  map[visitorKeyOf]*VisitorRollup
+	order []visitorKeyOf
+}
+
+func newVisitorTotals() *visitorTotals {
+	return &visitorTotals{rows: make(map[visitorKeyOf]*VisitorRollup)}
+}
+
+func (t *visitorTotals) add(row *Rollup) {
+	key := visitorKeyOf{row.Owner, row.Bot}
+	total, ok := t.rows[key]
+	if !ok {
+		total = &VisitorRollup{Owner: row.Owner, Bot: row.Bot, FanID: row.FanID, GuestKey: row.GuestKey}
+		t.rows[key] = total
+		t.order = append(t.order, key)
+	}
+	total.Sessions += row.Sessions
+	total.Starts += row.Starts
+	total.Seconds += row.Seconds
+	if row.LastStartAt.After(total.LastStartAt) {
+		total.LastStartAt = row.LastStartAt
+	}
+}
+
+func (t *visitorTotals) create(tx *gorm.DB) error {
+	rows := make([]VisitorRollup, 0, len(t.order))
+	for _, key := range t.order {
+		rows = append(rows, *t.rows[key])
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(&rows, 500).Error
+}
+
+// refreshVisitorRollups recomputes the totals of the given owners from their rollups
+func refreshVisitorRollups(tx *gorm.DB, owners []string) error {
+	if err := tx.Where("owner IN ?", owners).Delete(&VisitorRollup{}).Error; err != nil {
+		return err
+	}
+
+	var rows []Rollup
+	if err := tx.Where("owner IN ?", owners).Find(&rows).Error; err != nil {
+		return err
+	}
+	totals := newVisitorTotals()
+	for i := range rows {
+		totals.add(&rows[i])
+	}
+	return totals.create(tx)
+}
+
+// rebuildVisitorRollups recomputes every owner's totals from the rollups
+func rebuildVisitorRollups(tx *gorm.DB) error {
+	if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&VisitorRollup{}).Error; err != nil {
+		return err
+	}
+
+	rows, err := tx.Model(&Rollup{}).Rows()
+	if err != nil {
+		return err
+	}
+
+	totals := newVisitorTotals()
+	for rows.Next() {
+		var row Rollup
+		if err := tx.ScanRows(rows, &row); err != nil {
+			rows.Close()
+			return err
+		}
+		totals.add(&row)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return totals.create(tx)
+}
+
+// RebuildRollups recomputes tracking_rollups from user_trackings for every slot that
+// still has raw rows, and every visitor's totals. Days whose raw rows were compacted by
+// the retention policy keep their rollups. Raw rows written before first starts were
+// recorded are marked on the way.
+// Run it while tracking traffic is stopped; sessions started or finalized during the
+// rebuild may otherwise be counted twice or missed.
+func (r *FanTrackingRepository) RebuildRollups() (int, error) {
+	acc := newRollupAccumulator()
+	seen := make(map[string]bool)
+	unmarked := make(map[bool][]uint)
+
+	var batch []FanTracking
+	err := r.db.Model(&FanTracking{}).FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			first := isFirstStart(&batch[i], seen)
+			if batch[i].FirstStart == nil {
+				unmarked[first] = append(unmarked[first], batch[i].ID)
+			}
+			acc.add(&batch[i], first)
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := acc.replace(tx); err != nil {
+			return err
+		}
+		for first, ids := range unmarked {
+			for start := 0; start < len(ids); start += 500 {
+				end := min(start+500, len(ids))
+				// UpdateColumn keeps updated_at, which active sessions' durations are counted from
+				if err := tx.Model(&FanTracking{}).Where("id IN ?", ids[start:end]).
+					UpdateColumn("first_start", first).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return rebuildVisitorRollups(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(acc.order), nil
+}
+
+// EnsureRollups rebuilds the rollups when they are empty but raw tracking rows exist,
+// e.g. on the first start after upgrading
+func (r *FanTrackingRepository) EnsureRollups() (bool, error) {
+	var rollups int64
+	if err := r.db.Model(&Rollup{}).Limit(1).Count(&rollups).Error; err != nil {
+		return false, err
+	}
+	if rollups > 0 {
+		return false, nil
+	}
+
+	var raw int64
+	if err := r.db.Model(&FanTracking{}).Limit(1).Count(&raw).Error; err != nil {
+		return false, err
+	}
+	if raw == 0 {
+		return false, nil
+	}
+
+	_, err := r.RebuildRollups()
+	return err == nil, err
+}
@@ -0,0 +1,163 @@
+package tracking
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func rollupSnapshot(t *testing.T, repo *FanTrackingRepository) map[string]Rollup {
+	t.Helper()
+
+	var rows []Rollup
+	if err := repo.db.Find(&rows).Error; err != nil {
+		t.Fatalf("failed to load rollups: %v", err)
+	}
+	snapshot := make(map[string]Rollup, len(rows))
+	for _, row := range rows {
+		row.LastStartAt = row.LastStartAt.UTC()
+		snapshot[fmt.Sprintf("%s|%s|%t", row.Slot.UTC().Format(time.RFC3339), row.Owner, row.Bot)] = row
+	}
+	return snapshot
+}
+
+func visitorSnapshot(t *testing.T, repo *FanTrackingRepository) map[string]VisitorRollup {
+	t.Helper()
+
+	var rows []VisitorRollup
+	if err := repo.db.Find(&rows).Error; err != nil {
+		t.Fatalf("failed to load visitor totals: %v", err)
+	}
+	snapshot := make(map[string]VisitorRollup, len(rows))
+	for _, row := range rows {
+		row.LastStartAt = row.LastStartAt.UTC()
+		snapshot[fmt.Sprintf("%s|%t", row.Owner, row.Bot)] = row
+	}
+	return snapshot
+}
+
+func TestIncrementalRollupsMatchRebuild(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	fanID := uint(3)
+
+	// Fan with two tabs; the second start finalizes the first
+	if _, err := repo.StartTracking(&fanID, "", "fan-a", ClientInfo{}); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	if _, err := repo.StartTracking(&fanID, "", "fan-b", ClientInfo{}); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	if err := repo.EndTracking("fan-b", &fanID); err != nil {
+		t.Fatalf("end failed: %v", err)
+	}
+
+	// Guest that later signs up, and a guest that stays anonymous
+	if _, err := repo.StartTracking(nil, "visitor-x", "guest-a", ClientInfo{}); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	if err := repo.EndTracking("guest-a", nil); err != nil {
+		t.Fatalf("end failed: %v", err)
+	}
+	if _, err := repo.StartTracking(nil, "visitor-x", "guest-a", ClientInfo{}); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	if _, err := repo.StartTracking(nil, "visitor-y", "guest-b", ClientInfo{}); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	if _, err := repo.MergeVisitor("visitor-x", 11); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+
+	// Seed durations on ended rows so seconds are compared too
+	repo.db.Exec("UPDATE user_trackings SET duration = 45 WHERE end_time IS NULL")
+	if err := repo.EndTracking("guest-a", nil); err != nil {
+		t.Fatalf("end failed: %v", err)
+	}
+
+	incremental := rollupSnapshot(t, repo)
+	incrementalTotals := visitorSnapshot(t, repo)
+	if _, err := repo.RebuildRollups(); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	rebuilt := rollupSnapshot(t, repo)
+
+	if len(incremental) != len(rebuilt) {
+		t.Fatalf("expected %d rollup rows after rebuild, got %d", len(incremental), len(rebuilt))
+	}
+	for key, want := range rebuilt {
+		got, ok := incremental[key]
+		if !ok {
+			t.Fatalf("incremental rollups are missing %s", key)
+		}
+		if got.Sessions != want.Sessions || got.Starts != want.Starts || got.Seconds != want.Seconds || !got.LastStartAt.Equal(want.LastStartAt) {
+			t.Fatalf("rollup %s differs: incremental %+v, rebuilt %+v", key, got, want)
+		}
+	}
+
+	rebuiltTotals := visitorSnapshot(t, repo)
+	if len(incrementalTotals) != len(rebuiltTotals) {
+		t.Fatalf("expected %d visitor totals after rebuild, got %d", len(incrementalTotals), len(rebuiltTotals))
+	}
+	for key, want := range rebuiltTotals {
+		got := incrementalTotals[key]
+		if got.Sessions != want.Sessions || got.Starts != want.Starts || got.Seconds != want.Seconds || !got.LastStartAt.Equal(want.LastStartAt) {
+			t.Fatalf("visitor totals %s differ: incremental %+v, rebuilt %+v", key, got, want)
+		}
+	}
+
+	var guestRows int64
+	repo.db.Model(&Rollup{}).Where("guest_key = ?", "visitor-x").Count(&guestRows)
+	if guestRows != 0 {
+		t.Fatalf("expected merged guest rollups to move to the fan, %d left", guestRows)
+	}
+}
+
+func TestRollupsCountReloadedSessionsOnce(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	fanID := uint(9)
+
+	// A reload keeps the tab's session ID and starts a new row for it
+	for _, sessionID := range []string{"tab", "tab", "other-tab"} {
+		if _, err := repo.StartTracking(&fanID, "", sessionID, ClientInfo{}); err != nil {
+			t.Fatalf("start failed: %v", err)
+		}
+	}
+
+	var total VisitorRollup
+	if err := repo.db.Where("owner = ?", rollupOwner(&fanID, "")).First(&total).Error; err != nil {
+		t.Fatalf("failed to load visitor totals: %v", err)
+	}
+	if total.Sessions != 2 || total.Starts != 3 {
+		t.Fatalf("expected 2 sessions and 3 starts, got %+v", total)
+	}
+
+	// Rows written before first starts were recorded are marked by a rebuild
+	repo.db.Model(&FanTracking{}).Where("1 = 1").UpdateColumn("first_start", nil)
+	if _, err := repo.RebuildRollups(); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	var unmarked int64
+	repo.db.Model(&FanTracking{}).Where("first_start IS NULL").Count(&unmarked)
+	repo.db.Where("owner = ?", rollupOwner(&fanID, "")).First(&total)
+	if unmarked != 0 || total.Sessions != 2 {
+		t.Fatalf("expected every row marked and 2 sessions, got %d unmarked and %+v", unmarked, total)
+	}
+}
+
+func TestEnsureRollupsBackfillsOnce(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	fanID := uint(5)
+
+	if err := repo.db.Create(&FanTracking{FanID: &fanID, SessionID: "legacy"}).Error; err != nil {
+		t.Fatalf("failed to seed raw row: %v", err)
+	}
+
+	rebuilt, err := repo.EnsureRollups()
+	if err != nil || !rebuilt {
+		t.Fatalf("expected rollups to be rebuilt, got %v, %v", rebuilt, err)
+	}
+	rebuilt, err = repo.EnsureRollups()
+	if err != nil || rebuilt {
+		t.Fatalf("expected existing rollups to be kept, got %v, %v", rebuilt, err)
+	}
+}
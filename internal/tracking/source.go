@@ -0,0 +1,186 @@
+package tracking
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"anonchihaya.co.uk/internal/visitor"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DirectSource labels visits and registrations without a referrer or campaign
+const DirectSource = "(direct)"
+
+// SessionSource is where a tracking session came from, taken from its first tracking
+// call that got through
+type SessionSource struct {
+	SessionID    string    `gorm:"primaryKey;type:varchar(255)" json:"session_id"`
+	FanID        *uint     `gorm:"column:user_id;index" json:"user_id"` // Nullable for guests
+	VisitorID    string    `gorm:"type:varchar(64);index" json:"visitor_id,omitempty"`
+	ReferrerHost string    `gorm:"type:varchar(255)" json:"referrer_host"`
+	UTMSource    string    `gorm:"type:varchar(255)" json:"utm_source"`
+	UTMMedium    string    `gorm:"type:varchar(255)" json:"utm_medium"`
+	UTMCampaign  string    `gorm:"type:varchar(255)" json:"utm_campaign"`
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+}
+
+// TableName sets the table name for session sources
+func (SessionSource) TableName() string {
+	return "tracking_session_sources"
+}
+
+// SourceCount is the number of visits and registrations a source brought in
+type SourceCount struct {
+	Source        string `json:"source"` // UTM source, else referrer host, else DirectSource
+	Medium        string `json:"medium"`
+	Campaign      string `json:"campaign"`
+	Sessions      int64  `json:"sessions"`
+	Visitors      int64  `json:"visitors"`
+	Registrations int64  `json:"registrations"`
+}
+
+// ReferrerHost returns the lower-cased host of a referrer URL without a leading "www.".
+// Referrals from ownHost, i.e. from the site itself, count as direct.
+func ReferrerHost(referrer, ownHost string) string {
+	parsed, err := url.Parse(strings.TrimSpace(referrer))
+	if err != nil || parsed.Hostname() == "" {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+
+	if own, _, err := net.SplitHostPort(ownHost); err == nil {
+		ownHost = own
+	}
+	if host == strings.TrimPrefix(strings.ToLower(ownHost), "www.") {
+		return ""
+	}
+	return host
+}
+
+// RecordSessionSource stores the source of a session unless it already has one, so
+// the first call of the session wins
+func (r *FanTrackingRepository) RecordSessionSource(source *SessionSource) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(source).Error
+}
+
+// FirstVisitorSource returns the source of a visitor's earliest session, or nil
+func (r *FanTrackingRepository) FirstVisitorSource(visitorID string) (*SessionSource, error) {
+	var source SessionSource
+	err := r.db.Where("visitor_id = ?", visitorID).
+		Order("created_at ASC").
+		First(&source).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &source, nil
+}
+
+// sourceLabel is the SQL for a row's source label, given its referrer and UTM source columns
+func sourceLabel(referrerColumn, utmColumn string) string {
+	return "COALESCE(NULLIF(" + utmColumn + ", ''), NULLIF(" + referrerColumn + ", ''), '" + DirectSource + "')"
+}
+
+// GetSourceCounts returns the sessions started and fans registered between from and to
+// per source, medium and campaign, busiest first
+func (r *FanTrackingRepository) GetSourceCounts(from, to time.Time, limit int) ([]SourceCount, error) {
+	var visits []SourceCount
+	if err := r.db.Model(&SessionSource{}).
+		Select(sourceLabel("referrer_host", "utm_source")+` AS source,
+			utm_medium AS medium, utm_campaign AS campaign,
+			COUNT(*) AS sessions, COUNT(DISTINCT visitor_id) AS visitors`).
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Group("source, utm_medium, utm_campaign").
+		Scan(&visits).Error; err != nil {
+		return nil, err
+	}
+
+	var registrations []SourceCount
+	if err := r.db.Model(&auth.Fan{}).
+		Select(sourceLabel("source_referrer", "source_utm_source")+` AS source,
+			source_utm_medium AS medium, source_utm_campaign AS campaign,
+			COUNT(*) AS registrations`).
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Group("source, source_utm_medium, source_utm_campaign").
+		Scan(&registrations).Error; err != nil {
+		return nil, err
+	}
+
+	type sourceKey struct{ source, medium, campaign string }
+	merged := make(map[sourceKey]*SourceCount)
+	for i := range visits {
+		merged[sourceKey{visits[i].Source, visits[i].Medium, visits[i].Campaign}] = &visits[i]
+	}
+	counts := visits
+	for _, row := range registrations {
+		key := sourceKey{row.Source, row.Medium, row.Campaign}
+		if count, ok := merged[key]; ok {
+			count.Registrations = row.Registrations
+			continue
+		}
+		counts = append(counts, row)
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Sessions != b.Sessions {
+			return a.Sessions > b.Sessions
+		}
+		if a.Registrations != b.Registrations {
+			return a.Registrations > b.Registrations
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Medium != b.Medium {
+			return a.Medium < b.Medium
+		}
+		return a.Campaign < b.Campaign
+	})
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts, nil
+}
+
+// AttributionHook keeps the source of a visitor's first session on their fan record
+// when they register
+func AttributionHook(trackingRepo *FanTrackingRepository, fanRepo *auth.FanRepository) auth.FanHook {
+	return func(c *gin.Context, event auth.FanEvent, fan *auth.Fan) {
+		if event != auth.FanRegistered {
+			return
+		}
+
+		visitorID := visitor.ID(c)
+		if visitorID == "" {
+			return
+		}
+
+		source, err := trackingRepo.FirstVisitorSource(visitorID)
+		if err != nil {
+			log.Printf("Warning: Failed to look up first-touch source for fan %d: %v", fan.ID, err)
+			return
+		}
+		if source == nil {
+			return
+		}
+
+		fan.SourceReferrer = source.ReferrerHost
+		fan.SourceUTMSource = source.UTMSource
+		fan.SourceUTMMedium = source.UTMMedium
+		fan.SourceUTMCampaign = source.UTMCampaign
+		if err := fanRepo.Update(fan); err != nil {
+			log.Printf("Warning: Failed to save first-touch source for fan %d: %v", fan.ID, err)
+		}
+	}
+}
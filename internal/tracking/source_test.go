@@ -0,0 +1,84 @@
+package tracking
+
+import (
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+)
+
+func TestReferrerHost(t *testing.T) {
+	cases := []struct {
+		referrer, ownHost, want string
+	}{
+		{"https://www.Google.com/search?q=anon", "anonchihaya.co.uk", "google.com"},
+		{"https://t.co/abc", "anonchihaya.co.uk", "t.co"},
+		{"https://anonchihaya.co.uk/projects", "anonchihaya.co.uk", ""},
+		{"http://localhost:5173/", "localhost:8080", ""},
+		{"", "anonchihaya.co.uk", ""},
+		{"not a url", "anonchihaya.co.uk", ""},
+	}
+	for _, tc := range cases {
+		if got := ReferrerHost(tc.referrer, tc.ownHost); got != tc.want {
+			t.Errorf("ReferrerHost(%q, %q) = %q, want %q", tc.referrer, tc.ownHost, got, tc.want)
+		}
+	}
+}
+
+func TestSourceCounts(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	if err := repo.db.AutoMigrate(&auth.Fan{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	sources := []SessionSource{
+		{SessionID: "s1", VisitorID: "v1", UTMSource: "twitter", UTMMedium: "social", UTMCampaign: "launch"},
+		{SessionID: "s2", VisitorID: "v2", UTMSource: "twitter", UTMMedium: "social", UTMCampaign: "launch", ReferrerHost: "t.co"},
+		{SessionID: "s3", VisitorID: "v2", UTMSource: "twitter", UTMMedium: "social", UTMCampaign: "launch"},
+		{SessionID: "s4", VisitorID: "v3", ReferrerHost: "google.com"},
+		{SessionID: "s5", VisitorID: "v4"},
+	}
+	for i := range sources {
+		if err := repo.RecordSessionSource(&sources[i]); err != nil {
+			t.Fatalf("failed to record source: %v", err)
+		}
+	}
+
+	// Later calls of a session don't replace its source
+	if err := repo.RecordSessionSource(&SessionSource{SessionID: "s4", VisitorID: "v3", UTMSource: "newsletter"}); err != nil {
+		t.Fatalf("failed to record source: %v", err)
+	}
+	first, err := repo.FirstVisitorSource("v3")
+	if err != nil || first == nil || first.ReferrerHost != "google.com" || first.UTMSource != "" {
+		t.Fatalf("unexpected first source: %+v, %v", first, err)
+	}
+
+	fans := []auth.Fan{
+		{Username: "src-1", Email: "src-1@example.com", SourceUTMSource: "twitter", SourceUTMMedium: "social", SourceUTMCampaign: "launch"},
+		{Username: "src-2", Email: "src-2@example.com", SourceReferrer: "github.com"},
+	}
+	for i := range fans {
+		if err := repo.db.Create(&fans[i]).Error; err != nil {
+			t.Fatalf("failed to create fan: %v", err)
+		}
+	}
+
+	counts, err := repo.GetSourceCounts(time.Now().Add(-time.Hour), time.Now().Add(time.Minute), 10)
+	if err != nil {
+		t.Fatalf("GetSourceCounts failed: %v", err)
+	}
+	want := []SourceCount{
+		{Source: "twitter", Medium: "social", Campaign: "launch", Sessions: 3, Visitors: 2, Registrations: 1},
+		{Source: DirectSource, Sessions: 1, Visitors: 1},
+		{Source: "google.com", Sessions: 1, Visitors: 1},
+		{Source: "github.com", Registrations: 1},
+	}
+	if len(counts) != len(want) {
+		t.Fatalf("expected %d rows, got %+v", len(want), counts)
+	}
+	for i := range want {
+		if counts[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, counts[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package tracking
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FanStreak is a fan's run of consecutive active days, kept up to date as sessions
+// start so streaks don't have to be recomputed from every visit
+type FanStreak struct {
+	FanID         uint      `gorm:"column:user_id;primaryKey;autoIncrement:false" json:"user_id"`
+	Timezone      string    `gorm:"type:varchar(64)" json:"timezone"` // Zone the days are counted in, empty for the server's
+	Current       int       `gorm:"default:0" json:"current"`         // Run ending on LastActiveDay
+	Longest       int       `gorm:"default:0" json:"longest"`
+	LastActiveDay string    `gorm:"type:varchar(10)" json:"last_active_day"` // YYYY-MM-DD in Timezone
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TableName sets the table name for streak records
+func (FanStreak) TableName() string {
+	return "fan_streaks"
+}
+
+// Location returns the zone the record's days are counted in
+func (s *FanStreak) Location() *time.Location {
+	return streakLocation(s.Timezone)
+}
+
+// GetFanStreak returns a fan's streak record, or nil if they have none
+func (r *FanTrackingRepository) GetFanStreak(fanID uint) (*FanStreak, error) {
+	var streak FanStreak
+	err := r.db.Where("user_id = ?", fanID).First(&streak).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &streak, nil
+}
+
+// recordStreakDay extends a fan's streak record with a session started at. Fans without
+// a record, or whose time zone has changed since it was written, get it rebuilt from
+// their rollups instead. The record is only changed by conditional updates, so sessions
+// starting at once can't both extend it from the same day.
+func (r *FanTrackingRepository) recordStreakDay(fanID uint, at time.Time) error {
+	timezone, err := r.fanTimezone(fanID)
+	if err != nil {
+		return err
+	}
+	local := at.In(streakLocation(timezone))
+	day := local.Format(time.DateOnly)
+	previous := time.Date(local.Year(), local.Month(), local.Day()-1, 0, 0, 0, 0, time.UTC).Format(time.DateOnly)
+
+	// Longest is set first, so it compares against the current run before the update
+	extended := r.db.Exec("UPDATE fan_streaks SET `longest` = CASE WHEN `longest` < `current` + 1 THEN `current` + 1 ELSE `longest` END, "+
+		"`current` = `current` + 1, last_active_day = ?, updated_at = ? "+
+		"WHERE user_id = ? AND timezone = ? AND last_active_day = ?",
+		day, time.Now(), fanID, timezone, previous)
+	if extended.Error != nil || extended.RowsAffected > 0 {
+		return extended.Error
+	}
+	restarted := r.db.Exec("UPDATE fan_streaks SET `longest` = CASE WHEN `longest` < 1 THEN 1 ELSE `longest` END, "+
+		"`current` = 1, last_active_day = ?, updated_at = ? "+
+		"WHERE user_id = ? AND timezone = ? AND last_active_day < ?",
+		day, time.Now(), fanID, timezone, previous)
+	if restarted.Error != nil || restarted.RowsAffected > 0 {
+		return restarted.Error
+	}
+
+	// Nothing to change means the day was already counted, unless there is no record
+	// in the fan's zone
+	var records int64
+	if err := r.db.Model(&FanStreak{}).Where("user_id = ? AND timezone = ?", fanID, timezone).Count(&records).Error; err != nil {
+		return err
+	}
+	if records > 0 {
+		return nil
+	}
+	return r.RebuildFanStreak(fanID)
+}
+
+// RebuildFanStreak recomputes a fan's streak record from all of their rollups in their
+// current time zone, leaving out sessions from bots
+func (r *FanTrackingRepository) RebuildFanStreak(fanID uint) error {
+	timezone, err := r.fanTimezone(fanID)
+	if err != nil {
+		return err
+	}
+	loc := streakLocation(timezone)
+
+	var slots []time.Time
+	if err := r.db.Model(&Rollup{}).
+		Where("user_id = ? AND bot = ?", fanID, false).
+		Distinct("slot").
+		Pluck("slot", &slots).Error; err != nil {
+		return err
+	}
+
+	days := make(map[string]bool, len(slots))
+	for _, slot := range slots {
+		days[slot.In(loc).Format(time.DateOnly)] = true
+	}
+	if len(days) == 0 {
+		return r.db.Where("user_id = ?", fanID).Delete(&FanStreak{}).Error
+	}
+
+	sorted := make([]string, 0, len(days))
+	for day := range days {
+		sorted = append(sorted, day)
+	}
+	sort.Strings(sorted)
+
+	streak := FanStreak{FanID: fanID, Timezone: timezone}
+	for _, day := range sorted {
+		if streak.LastActiveDay != "" && day == nextDay(streak.LastActiveDay) {
+			streak.Current++
+		} else {
+			streak.Current = 1
+		}
+		streak.Longest = max(streak.Longest, streak.Current)
+		streak.LastActiveDay = day
+	}
+
+	return r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&streak).Error
+}
+
+// fanTimezone returns the time zone a fan counts days in, empty for the server's
+func (r *FanTrackingRepository) fanTimezone(fanID uint) (string, error) {
+	var timezones []string
+	if err := r.db.Model(&auth.Fan{}).
+		Where("id = ?", fanID).
+		Pluck("timezone", &timezones).Error; err != nil {
+		return "", err
+	}
+	if len(timezones) == 0 {
+		return "", nil
+	}
+	return timezones[0], nil
+}
+
+// streakLocation loads a fan's zone, falling back to the server's for empty or
+// unknown names
+func streakLocation(timezone string) *time.Location {
+	if timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// nextDay returns the calendar day after a YYYY-MM-DD date
+func nextDay(day string) string {
+	parsed, err := time.Parse(time.DateOnly, day)
+	if err != nil {
+		return ""
+	}
+	return parsed.AddDate(0, 0, 1).Format(time.DateOnly)
+}
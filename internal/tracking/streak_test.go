@@ -0,0 +1,111 @@
+package tracking
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"anonchihaya.co.uk/internal/auth"
+)
+
+func TestStreakRecordIsUpdatedIncrementally(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	fan := auth.Fan{Username: "streaker", Email: "streaker@example.com"}
+	if err := repo.db.Create(&fan).Error; err != nil {
+		t.Fatalf("failed to create fan: %v", err)
+	}
+
+	day := func(date string) time.Time {
+		parsed, err := time.ParseInLocation(time.DateOnly, date, time.Local)
+		if err != nil {
+			t.Fatalf("bad date %s: %v", date, err)
+		}
+		return parsed.Add(12 * time.Hour)
+	}
+	check := func(current, longest int, last string) {
+		t.Helper()
+		streak, err := repo.GetFanStreak(fan.ID)
+		if err != nil || streak == nil {
+			t.Fatalf("GetFanStreak: %+v, %v", streak, err)
+		}
+		if streak.Current != current || streak.Longest != longest || streak.LastActiveDay != last {
+			t.Fatalf("expected %d/%d/%s, got %+v", current, longest, last, streak)
+		}
+	}
+
+	// Visits from before the record existed are counted when it is first built
+	for i, date := range []string{"2026-04-01", "2026-04-02", "2026-04-03", "2026-04-10"} {
+		row := newRollup(&FanTracking{FanID: &fan.ID, SessionID: fmt.Sprintf("streak-%d", i), StartTime: day(date)})
+		row.Sessions, row.Starts = 1, 1
+		if err := repo.db.Create(&row).Error; err != nil {
+			t.Fatalf("failed to seed rollup: %v", err)
+		}
+	}
+	// Sessions flagged as bots don't extend the streak
+	bot := newRollup(&FanTracking{FanID: &fan.ID, SessionID: "streak-bot", StartTime: day("2026-04-09"), Bot: true})
+	bot.Sessions, bot.Starts = 1, 1
+	if err := repo.db.Create(&bot).Error; err != nil {
+		t.Fatalf("failed to seed rollup: %v", err)
+	}
+	if err := repo.recordStreakDay(fan.ID, day("2026-04-11")); err != nil {
+		t.Fatalf("recordStreakDay failed: %v", err)
+	}
+	check(1, 3, "2026-04-10") // The rebuild reads the rollups, where 11 April isn't yet
+
+	for _, date := range []string{"2026-04-11", "2026-04-11", "2026-04-12", "2026-04-09"} {
+		if err := repo.recordStreakDay(fan.ID, day(date)); err != nil {
+			t.Fatalf("recordStreakDay failed: %v", err)
+		}
+	}
+	check(3, 3, "2026-04-12")
+
+	if err := repo.recordStreakDay(fan.ID, day("2026-04-13")); err != nil {
+		t.Fatalf("recordStreakDay failed: %v", err)
+	}
+	check(4, 4, "2026-04-13")
+	if err := repo.recordStreakDay(fan.ID, day("2026-04-20")); err != nil {
+		t.Fatalf("recordStreakDay failed: %v", err)
+	}
+	check(1, 4, "2026-04-20")
+
+	// Erasing the fan's history removes the record
+	if _, err := repo.EraseFanTracking(fan.ID); err != nil {
+		t.Fatalf("EraseFanTracking failed: %v", err)
+	}
+	if streak, err := repo.GetFanStreak(fan.ID); err != nil || streak != nil {
+		t.Fatalf("expected no record after erasing, got %+v (err %v)", streak, err)
+	}
+}
+
+func TestStreakRecordFollowsTimezone(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("time zone unavailable: %v", err)
+	}
+	repo := setupTrackingRepo(t)
+	fan := auth.Fan{Username: "zoned", Email: "zoned@example.com"}
+	if err := repo.db.Create(&fan).Error; err != nil {
+		t.Fatalf("failed to create fan: %v", err)
+	}
+
+	if _, err := repo.StartTracking(&fan.ID, "", "zoned-session", ClientInfo{}); err != nil {
+		t.Fatalf("StartTracking failed: %v", err)
+	}
+	streak, err := repo.GetFanStreak(fan.ID)
+	if err != nil || streak == nil || streak.Current != 1 || streak.Timezone != "" {
+		t.Fatalf("expected a one day record in the server's zone, got %+v (err %v)", streak, err)
+	}
+
+	// A new zone rebuilds the record in it on the next session
+	repo.db.Model(&fan).Update("timezone", "Asia/Tokyo")
+	if _, err := repo.StartTracking(&fan.ID, "", "zoned-session-2", ClientInfo{}); err != nil {
+		t.Fatalf("StartTracking failed: %v", err)
+	}
+	streak, err = repo.GetFanStreak(fan.ID)
+	if err != nil || streak == nil || streak.Timezone != "Asia/Tokyo" {
+		t.Fatalf("expected the record to be rebuilt in Tokyo, got %+v (err %v)", streak, err)
+	}
+	if want := time.Now().In(tokyo).Format(time.DateOnly); streak.LastActiveDay != want {
+		t.Fatalf("expected last active day %s, got %s", want, streak.LastActiveDay)
+	}
+}
@@ -0,0 +1,232 @@
+package tracking
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Device classes
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// UnknownClient labels a browser, OS or device class that couldn't be recognised, and
+// sessions recorded before clients were
+const UnknownClient = "Unknown"
+
+// ClientInfo is what a session's User-Agent says about the client
+type ClientInfo struct {
+	Browser string `json:"browser"` // Browser family, e.g. Chrome
+	OS      string `json:"os"`
+	Device  string `json:"device"` // One of the device classes, or UnknownClient
+	Bot     bool   `json:"bot"`
+}
+
+// ClientBreakdown splits the sessions started in a range by browser family, OS and
+// device class. Bot sessions are only counted in Bots.
+type ClientBreakdown struct {
+	From     time.Time     `json:"from"`
+	To       time.Time     `json:"to"`
+	Sessions int64         `json:"sessions"`
+	Visitors int64         `json:"visitors"`
+	Bots     int64         `json:"bots"` // Sessions left out as bots
+	Browsers []ClientCount `json:"browsers"`
+	OS       []ClientCount `json:"os"`
+	Devices  []ClientCount `json:"devices"`
+}
+
+// ClientCount is the number of sessions and distinct visitors of a browser, OS or device class
+type ClientCount struct {
+	Name     string `json:"name"`
+	Sessions int64  `json:"sessions"`
+	Visitors int64  `json:"visitors"`
+}
+
+// botSignatures are lower-cased User-Agent fragments of crawlers, link previewers,
+// monitoring services and HTTP libraries, matched anywhere in the header
+var botSignatures = []string{
+	"slurp", "facebookexternalhit", "embedly", "whatsapp", "lighthouse", "pagespeed",
+	"pingdom", "petalbot", "headlesschrome", "phantomjs", "selenium", "puppeteer", "playwright",
+	"curl/", "wget/", "python-requests", "python-urllib", "aiohttp", "httpx", "go-http-client",
+	"okhttp", "java/", "apache-httpclient", "node-fetch", "axios/", "libwww-perl", "postmanruntime",
+}
+
+// botWords are generic words that also occur in device and app names (a Cubot phone, a
+// "monitor" app), so they only match a whole word, or the start or end of a product
+// name: the word before a "/" or the header's first word, as in Googlebot/2.1 or
+// UptimeRobot/2.0
+var botWords = []string{"bot", "crawler", "crawl", "spider", "scraper", "archiver", "preview", "monitor", "uptime"}
+
+// browserFamilies are checked in order, since most User-Agents also name the browsers
+// they are based on (Edge says Chrome and Safari, Chrome says Safari)
+var browserFamilies = []struct{ token, name string }{
+	{"edg/", "Edge"},
+	{"edga/", "Edge"},
+	{"edgios/", "Edge"},
+	{"edge/", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"},
+	{"yabrowser/", "Yandex"},
+	{"vivaldi/", "Vivaldi"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chromium/", "Chromium"},
+	{"chrome/", "Chrome"},
+	{"msie ", "Internet Explorer"},
+	{"trident/", "Internet Explorer"},
+	{"safari/", "Safari"},
+}
+
+// ParseUserAgent classifies a User-Agent header. Only clients matching a bot signature
+// are bots; an empty header is an unknown client.
+func ParseUserAgent(userAgent string) ClientInfo {
+	ua := strings.ToLower(strings.TrimSpace(userAgent))
+	if ua == "" {
+		return ClientInfo{Browser: UnknownClient, OS: UnknownClient, Device: UnknownClient}
+	}
+	if isBot(ua) {
+		return ClientInfo{Browser: UnknownClient, OS: UnknownClient, Device: DeviceBot, Bot: true}
+	}
+
+	return ClientInfo{
+		Browser: browserFamily(ua),
+		OS:      operatingSystem(ua),
+		Device:  deviceClass(ua),
+	}
+}
+
+func isBot(ua string) bool {
+	for _, signature := range botSignatures {
+		if strings.Contains(ua, signature) {
+			return true
+		}
+	}
+
+	for start := 0; start < len(ua); {
+		if !isWordByte(ua[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(ua) && isWordByte(ua[end]) {
+			end++
+		}
+		word := ua[start:end]
+		product := start == 0 || (end < len(ua) && ua[end] == '/')
+		for _, botWord := range botWords {
+			if word == botWord || (product && (strings.HasPrefix(word, botWord) || strings.HasSuffix(word, botWord))) {
+				return true
+			}
+		}
+		start = end
+	}
+	return false
+}
+
+func isWordByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= '0' && b <= '9'
+}
+
+func browserFamily(ua string) string {
+	for _, family := range browserFamilies {
+		if strings.Contains(ua, family.token) {
+			return family.name
+		}
+	}
+	return UnknownClient
+}
+
+func operatingSystem(ua string) string {
+	switch {
+	case strings.Contains(ua, "windows"):
+		return "Windows"
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return "iOS"
+	case strings.Contains(ua, "android"):
+		return "Android"
+	case strings.Contains(ua, "cros"):
+		return "ChromeOS"
+	case strings.Contains(ua, "mac os x"), strings.Contains(ua, "macintosh"):
+		return "macOS"
+	case strings.Contains(ua, "linux"):
+		return "Linux"
+	}
+	return UnknownClient
+}
+
+func deviceClass(ua string) string {
+	switch {
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"):
+		return DeviceTablet
+	// Android tablets leave "Mobile" out of their User-Agent
+	case strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "mobi"), strings.Contains(ua, "iphone"), strings.Contains(ua, "ipod"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+// clientVisitors counts the distinct fans and guest visitors (or guest sessions without
+// a visitor ID) of a group of raw rows, like the rollup owners
+const clientVisitors = "COUNT(DISTINCT user_id) + COUNT(DISTINCT CASE WHEN user_id IS NULL THEN COALESCE(NULLIF(visitor_id, ''), session_id) END)"
+
+// GetClientBreakdown returns the sessions started between from and to by browser, OS
+// and device class, most sessions first. Sessions from before clients were recorded
+// count as UnknownClient.
+func (r *FanTrackingRepository) GetClientBreakdown(from, to time.Time) (*ClientBreakdown, error) {
+	between := func() *gorm.DB {
+		return r.db.Model(&FanTracking{}).Where("start_time >= ? AND start_time < ?", from, to)
+	}
+
+	var totals struct {
+		Sessions int64
+		Visitors int64
+	}
+	if err := between().
+		Select("COUNT(DISTINCT session_id) AS sessions, "+clientVisitors+" AS visitors").
+		Where("bot = ?", false).
+		Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+	var bots int64
+	if err := between().
+		Select("COUNT(DISTINCT session_id)").
+		Where("bot = ?", true).
+		Scan(&bots).Error; err != nil {
+		return nil, err
+	}
+
+	counts := func(column string) ([]ClientCount, error) {
+		result := []ClientCount{}
+		name := fmt.Sprintf("COALESCE(NULLIF(%s, ''), '%s')", column, UnknownClient)
+		err := between().
+			Select(name+" AS name, COUNT(DISTINCT session_id) AS sessions, "+clientVisitors+" AS visitors").
+			Where("bot = ?", false).
+			Group(name).
+			Order("sessions DESC, name").
+			Scan(&result).Error
+		return result, err
+	}
+
+	breakdown := &ClientBreakdown{From: from, To: to, Sessions: totals.Sessions, Visitors: totals.Visitors, Bots: bots}
+	var err error
+	if breakdown.Browsers, err = counts("browser"); err != nil {
+		return nil, err
+	}
+	if breakdown.OS, err = counts("os"); err != nil {
+		return nil, err
+	}
+	if breakdown.Devices, err = counts("device"); err != nil {
+		return nil, err
+	}
+	return breakdown, nil
+}
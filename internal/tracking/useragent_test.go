@@ -0,0 +1,104 @@
+package tracking
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      ClientInfo
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0",
+			ClientInfo{Browser: "Edge", OS: "Windows", Device: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36",
+			ClientInfo{Browser: "Chrome", OS: "macOS", Device: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+			ClientInfo{Browser: "Safari", OS: "iOS", Device: DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (iPad; CPU OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/126.0.6478.54 Mobile/15E148 Safari/604.1",
+			ClientInfo{Browser: "Chrome", OS: "iOS", Device: DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 14; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/25.0 Chrome/121.0.0.0 Safari/537.36",
+			ClientInfo{Browser: "Samsung Internet", OS: "Android", Device: DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0",
+			ClientInfo{Browser: "Firefox", OS: "Linux", Device: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)",
+			ClientInfo{Browser: UnknownClient, OS: UnknownClient, Device: DeviceBot, Bot: true},
+		},
+		{
+			"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/126.0.0.0 Safari/537.36",
+			ClientInfo{Browser: UnknownClient, OS: UnknownClient, Device: DeviceBot, Bot: true},
+		},
+		{"curl/8.5.0", ClientInfo{Browser: UnknownClient, OS: UnknownClient, Device: DeviceBot, Bot: true}},
+		{"Mozilla/5.0+(compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)", ClientInfo{Browser: UnknownClient, OS: UnknownClient, Device: DeviceBot, Bot: true}},
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", ClientInfo{Browser: UnknownClient, OS: UnknownClient, Device: DeviceBot, Bot: true}},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko; Google Web Preview) Chrome/41.0.2272.118 Safari/537.36",
+			ClientInfo{Browser: UnknownClient, OS: UnknownClient, Device: DeviceBot, Bot: true},
+		},
+		{
+			// A Cubot phone, not a bot
+			"Mozilla/5.0 (Linux; Android 11; CUBOT X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36",
+			ClientInfo{Browser: "Chrome", OS: "Android", Device: DeviceMobile},
+		},
+		{"", ClientInfo{Browser: UnknownClient, OS: UnknownClient, Device: UnknownClient}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseUserAgent(tt.userAgent); got != tt.want {
+			t.Errorf("ParseUserAgent(%q) = %+v, want %+v", tt.userAgent, got, tt.want)
+		}
+	}
+}
+
+func TestClientBreakdownLeavesOutBots(t *testing.T) {
+	repo := setupTrackingRepo(t)
+	fanID := uint(3)
+	chrome := ParseUserAgent("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36")
+	bot := ParseUserAgent("Googlebot/2.1 (+http://www.google.com/bot.html)")
+
+	start := func(fanID *uint, visitorID, sessionID string, client ClientInfo) {
+		t.Helper()
+		if _, err := repo.StartTracking(fanID, visitorID, sessionID, client); err != nil {
+			t.Fatalf("StartTracking failed: %v", err)
+		}
+	}
+	start(&fanID, "", "fan-1", chrome)
+	start(&fanID, "", "fan-2", chrome)
+	start(nil, "visitor-1", "guest-1", ClientInfo{})
+	start(nil, "crawler", "bot-1", bot)
+
+	var rollup Rollup
+	if err := repo.db.Where("owner = ?", "guest:crawler").First(&rollup).Error; err != nil || !rollup.Bot {
+		t.Fatalf("expected the bot's rollup to be flagged, got %+v (err %v)", rollup, err)
+	}
+
+	now := time.Now()
+	breakdown, err := repo.GetClientBreakdown(now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetClientBreakdown failed: %v", err)
+	}
+	if breakdown.Sessions != 3 || breakdown.Visitors != 2 || breakdown.Bots != 1 {
+		t.Fatalf("unexpected totals: %+v", breakdown)
+	}
+	want := []ClientCount{{Name: "Chrome", Sessions: 2, Visitors: 1}, {Name: UnknownClient, Sessions: 1, Visitors: 1}}
+	if len(breakdown.Browsers) != len(want) || breakdown.Browsers[0] != want[0] || breakdown.Browsers[1] != want[1] {
+		t.Fatalf("expected browsers %+v, got %+v", want, breakdown.Browsers)
+	}
+	if len(breakdown.Devices) != 2 || breakdown.Devices[0].Name != DeviceDesktop {
+		t.Fatalf("unexpected devices: %+v", breakdown.Devices)
+	}
+}
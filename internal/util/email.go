@@ -3,11 +3,27 @@ package util
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/smtp"
 	"os"
+	"sync/atomic"
 )
 
+// ErrEmailNotConfigured is returned when an email can't be sent because SMTP isn't set up
+var ErrEmailNotConfigured = errors.New("SMTP is not configured")
+
+var (
+	emailsQueued atomic.Int64
+	emailsFailed atomic.Int64
+)
+
+// EmailStats returns the number of emails currently being sent and the number that
+// failed to send since the server started
+func EmailStats() (queued, failed int64) {
+	return emailsQueued.Load(), emailsFailed.Load()
+}
+
 // GenerateVerificationToken generates a random verification token
 func GenerateVerificationToken() string {
 	bytes := make([]byte, 32)
@@ -17,6 +33,9 @@ func GenerateVerificationToken() string {
 
 // SendVerificationEmail sends an email verification link
 func SendVerificationEmail(toEmail, token, frontendURL string) error {
+	emailsQueued.Add(1)
+	defer emailsQueued.Add(-1)
+
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := os.Getenv("SMTP_PORT")
 	smtpUser := os.Getenv("SMTP_USER")
@@ -54,6 +73,7 @@ The Team
 
 	err := smtp.SendMail(addr, auth, fromEmail, []string{toEmail}, message)
 	if err != nil {
+		emailsFailed.Add(1)
 		// In development, just log and continue
 		fmt.Printf("Failed to send email (continuing anyway): %v\n", err)
 		return nil
@@ -61,3 +81,32 @@ The Team
 
 	return nil
 }
+
+// SendHTMLEmail sends an HTML email. Unlike verification emails, failures are returned
+// to the caller.
+func SendHTMLEmail(toEmail, subject, htmlBody string) error {
+	emailsQueued.Add(1)
+	defer emailsQueued.Add(-1)
+
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
+	smtpUser := os.Getenv("SMTP_USER")
+	smtpPass := os.Getenv("SMTP_PASS")
+	fromEmail := os.Getenv("SMTP_FROM")
+
+	if smtpHost == "" || smtpPort == "" {
+		return ErrEmailNotConfigured
+	}
+
+	message := []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s",
+		fromEmail, toEmail, subject, htmlBody))
+
+	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
+	addr := fmt.Sprintf("%s:%s", smtpHost, smtpPort)
+
+	if err := smtp.SendMail(addr, auth, fromEmail, []string{toEmail}, message); err != nil {
+		emailsFailed.Add(1)
+		return err
+	}
+	return nil
+}
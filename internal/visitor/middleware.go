@@ -0,0 +1,92 @@
+package visitor
+
+import (
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	cookieName   = "visitor_id"
+	cookieMaxAge = 365 * 24 * 60 * 60
+
+	// A visitor counts as returning once their first visit is at least this old
+	returningVisitorGap = 30 * time.Minute
+
+	contextKey = "visitor_key"
+)
+
+// Middleware makes sure every request carries a random visitor cookie and stores the
+// matching visitor in the context under "visitor". Requests opting out with DoNotTrack
+// get no cookie and record no visit.
+func Middleware(repo *VisitorRepository, domain string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if DoNotTrack(c) {
+			c.Next()
+			return
+		}
+
+		id, err := c.Cookie(cookieName)
+		if err != nil || uuid.Validate(id) != nil {
+			id = uuid.New().String()
+		}
+		// Refreshed on every visit, so the ID lasts as long as the visitor keeps coming back
+		c.SetCookie(cookieName, id, cookieMaxAge, "/", domain, isHTTPS(c), true)
+
+		v, err := repo.Touch(id)
+		if err != nil {
+			log.Printf("Warning: Failed to record visitor: %v", err)
+			v = &Visitor{ID: id, FirstSeenAt: time.Now(), LastSeenAt: time.Now()}
+		}
+
+		c.Set("visitor", v)
+		c.Set(contextKey, id)
+		c.Next()
+	}
+}
+
+// Identify stores the visitor ID of the caller's existing visitor cookie in the context
+// for ID, without setting a cookie or recording a visit. It is for routes such as sign
+// up and log in that need to know the visitor but aren't visits themselves.
+func Identify() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := c.Cookie(cookieName)
+		if err == nil && uuid.Validate(id) == nil {
+			c.Set(contextKey, id)
+		}
+		c.Next()
+	}
+}
+
+// DoNotTrack reports whether the request carries a Do-Not-Track or Global Privacy
+// Control opt-out
+func DoNotTrack(c *gin.Context) bool {
+	return c.GetHeader("DNT") == "1" || c.GetHeader("Sec-GPC") == "1"
+}
+
+// isHTTPS reports whether the request reached the server, or the proxy in front of it,
+// over HTTPS
+func isHTTPS(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// FromContext returns the visitor set by Middleware, or nil if there is none
+func FromContext(c *gin.Context) *Visitor {
+	v, exists := c.Get("visitor")
+	if !exists {
+		return nil
+	}
+	visitor, ok := v.(*Visitor)
+	if !ok {
+		return nil
+	}
+	return visitor
+}
+
+// ID returns the calling visitor's ID as set by Middleware or Identify. It is empty if
+// unknown.
+func ID(c *gin.Context) string {
+	return c.GetString(contextKey)
+}
@@ -0,0 +1,22 @@
+package visitor
+
+import (
+	"time"
+)
+
+// Visitor is an anonymous browser identified by a random first-party cookie.
+// The ID is the cookie itself, a random UUID rather than anything derived from the IP
+// address or request headers, so it identifies the browser to this site only. Nothing
+// else about the browser is kept.
+type Visitor struct {
+	ID          string    `gorm:"primaryKey;type:varchar(64)" json:"id"`
+	FirstSeenAt time.Time `gorm:"not null" json:"first_seen_at"`
+	LastSeenAt  time.Time `gorm:"not null;index" json:"last_seen_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// IsReturning reports whether the visitor was first seen before the given visit window
+func (v *Visitor) IsReturning(now time.Time) bool {
+	return now.Sub(v.FirstSeenAt) >= returningVisitorGap
+}
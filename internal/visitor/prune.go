@@ -0,0 +1,50 @@
+package visitor
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// StaleVisitorAge is how long a visitor is kept after they were last seen, as long as
+// their cookie lasts
+const StaleVisitorAge = cookieMaxAge * time.Second
+
+// DefaultPruneInterval is how often stale visitors are deleted
+const DefaultPruneInterval = 24 * time.Hour
+
+// PruneJob periodically deletes visitors whose cookie has expired since their last visit
+type PruneJob struct {
+	repo     *VisitorRepository
+	interval time.Duration
+}
+
+// NewPruneJob creates a job deleting stale visitors every interval
+func NewPruneJob(repo *VisitorRepository, interval time.Duration) *PruneJob {
+	if interval <= 0 {
+		interval = DefaultPruneInterval
+	}
+	return &PruneJob{repo: repo, interval: interval}
+}
+
+// Start runs the job once and then every interval until ctx is done
+func (j *PruneJob) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			if pruned, err := j.repo.PruneStale(time.Now().Add(-StaleVisitorAge)); err != nil {
+				log.Printf("Warning: Failed to prune stale visitors: %v", err)
+			} else if pruned > 0 {
+				log.Printf("Pruned %d stale visitors", pruned)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
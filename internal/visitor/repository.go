@@ -0,0 +1,98 @@
+package visitor
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// touchInterval is how often a visitor's last_seen_at is written at most. Heartbeats
+// and beacons arrive every few seconds, so visitors seen more recently are served from
+// memory.
+const touchInterval = 5 * time.Minute
+
+type VisitorRepository struct {
+	db *gorm.DB
+
+	mu     sync.Mutex
+	recent map[string]Visitor // Visitors written within touchInterval, by ID
+	pruned time.Time
+}
+
+func NewVisitorRepository(db *gorm.DB) *VisitorRepository {
+	return &VisitorRepository{db: db, recent: make(map[string]Visitor)}
+}
+
+// Touch records a visit for the given visitor ID, creating the visitor if needed.
+// last_seen_at is only written when it is older than touchInterval.
+func (r *VisitorRepository) Touch(id string) (*Visitor, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	if v, ok := r.recent[id]; ok && now.Sub(v.LastSeenAt) < touchInterval {
+		r.mu.Unlock()
+		return &v, nil
+	}
+	r.mu.Unlock()
+
+	v, err := r.touch(id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recent[id] = *v
+	if now.Sub(r.pruned) >= touchInterval {
+		for key, seen := range r.recent {
+			if now.Sub(seen.LastSeenAt) >= touchInterval {
+				delete(r.recent, key)
+			}
+		}
+		r.pruned = now
+	}
+	return v, nil
+}
+
+func (r *VisitorRepository) touch(id string, now time.Time) (*Visitor, error) {
+	var v Visitor
+	err := r.db.Where("id = ?", id).First(&v).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		v = Visitor{
+			ID:          id,
+			FirstSeenAt: now,
+			LastSeenAt:  now,
+		}
+		if err := r.db.Create(&v).Error; err != nil {
+			return nil, err
+		}
+		return &v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.db.Model(&v).Update("last_seen_at", now).Error; err != nil {
+		return nil, err
+	}
+	v.LastSeenAt = now
+	return &v, nil
+}
+
+// PruneStale deletes visitors not seen since before. Their cookies have expired by
+// then, so they would come back as new visitors anyway.
+func (r *VisitorRepository) PruneStale(before time.Time) (int64, error) {
+	result := r.db.Where("last_seen_at < ?", before).Delete(&Visitor{})
+	return result.RowsAffected, result.Error
+}
+
+// FindByID returns the visitor with the given ID
+func (r *VisitorRepository) FindByID(id string) (*Visitor, error) {
+	var v Visitor
+	if err := r.db.Where("id = ?", id).First(&v).Error; err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
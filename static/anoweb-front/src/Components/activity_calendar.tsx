@@ -0,0 +1,109 @@
+import { useEffect, useMemo, useState } from "react";
+import { apiJson } from "../lib/api";
+
+interface CalendarDay {
+  date: string;
+  sessions: number;
+  seconds: number;
+}
+
+interface Calendar {
+  year: number;
+  days: CalendarDay[];
+}
+
+interface ActivityCalendarProps {
+  tz: string;
+}
+
+const levelStyles = ["bg-white/15", "bg-emerald-200/70", "bg-emerald-300", "bg-emerald-400", "bg-emerald-500"];
+
+// level buckets a day's time into the heatmap's shades; days with only open sessions
+// still count as active
+const level = (day?: CalendarDay) => {
+  if (!day) return 0;
+  const minutes = day.seconds / 60;
+  if (minutes < 15) return 1;
+  if (minutes < 60) return 2;
+  if (minutes < 180) return 3;
+  return 4;
+};
+
+const pad = (n: number) => String(n).padStart(2, "0");
+
+export default function ActivityCalendar({ tz }: ActivityCalendarProps) {
+  const [year, setYear] = useState(new Date().getFullYear());
+  const [calendar, setCalendar] = useState<Calendar | null>(null);
+
+  useEffect(() => {
+    apiJson<Calendar>(`/statistics/calendar?year=${year}&tz=${encodeURIComponent(tz)}`, {
+      credentials: "include",
+    })
+      .then(setCalendar)
+      .catch((err) => console.error("Failed to load activity calendar:", err));
+  }, [year, tz]);
+
+  // Columns of weeks starting on Sunday, padded before 1 January
+  const weeks = useMemo(() => {
+    const byDate = new Map((calendar?.days || []).map((day) => [day.date, day]));
+    const columns: ({ date: string; day?: CalendarDay } | null)[][] = [];
+    const first = new Date(Date.UTC(year, 0, 1));
+    let column: ({ date: string; day?: CalendarDay } | null)[] = Array(first.getUTCDay()).fill(null);
+    for (let d = first; d.getUTCFullYear() === year; d = new Date(d.getTime() + 86400000)) {
+      const date = `${year}-${pad(d.getUTCMonth() + 1)}-${pad(d.getUTCDate())}`;
+      column.push({ date, day: byDate.get(date) });
+      if (column.length === 7) {
+        columns.push(column);
+        column = [];
+      }
+    }
+    if (column.length > 0) columns.push(column);
+    return columns;
+  }, [calendar, year]);
+
+  return (
+    <div className="rounded-2xl bg-white/15 border border-white/20 p-4 shadow-inner">
+      <div className="flex items-center justify-between mb-3">
+        <p className="text-sm text-white/70">
+          {calendar ? `${calendar.days.length} active days in ${year}` : `Activity in ${year}`}
+        </p>
+        <div className="flex items-center gap-2 text-sm font-semibold">
+          <button type="button" onClick={() => setYear((y) => y - 1)} aria-label="Previous year">
+            ←
+          </button>
+          <span>{year}</span>
+          <button
+            type="button"
+            onClick={() => setYear((y) => y + 1)}
+            disabled={year >= new Date().getFullYear()}
+            className="disabled:opacity-40"
+            aria-label="Next year"
+          >
+            →
+          </button>
+        </div>
+      </div>
+      <div className="flex gap-[3px] overflow-x-auto pb-1">
+        {weeks.map((week, i) => (
+          <div key={i} className="flex flex-col gap-[3px]">
+            {week.map((cell, j) =>
+              cell ? (
+                <div
+                  key={cell.date}
+                  title={
+                    cell.day
+                      ? `${cell.date}: ${cell.day.sessions} sessions, ${Math.round(cell.day.seconds / 60)} min`
+                      : cell.date
+                  }
+                  className={`h-3 w-3 rounded-sm ${levelStyles[level(cell.day)]}`}
+                />
+              ) : (
+                <div key={`pad-${j}`} className="h-3 w-3" />
+              )
+            )}
+          </div>
+        ))}
+      </div>
+    </div>
+  );
+}
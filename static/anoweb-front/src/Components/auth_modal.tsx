@@ -1,8 +1,9 @@
-import { useState, useContext } from "react";
+import { useState, useContext, useEffect } from "react";
 import { FanContext } from "../Contexts/fan_context";
 import { useErrorNotifier } from "../Contexts/error_context";
 import { useSuccessNotifier } from "../Contexts/success_context";
 import { apiFetch, apiJson } from "../lib/api";
+import { recordFunnelStep } from "../lib/tracking";
 
 interface AuthModalProps {
   isOpen: boolean;
@@ -22,6 +23,10 @@ export default function AuthModal({ isOpen, onClose, initialMode = "login" }: Au
   const notifyError = useErrorNotifier();
   const notifySuccess = useSuccessNotifier();
 
+  useEffect(() => {
+    if (isOpen && mode === "register") recordFunnelStep("register_form");
+  }, [isOpen, mode]);
+
   if (!isOpen) return null;
 
   const handleGoogleLogin = async () => {
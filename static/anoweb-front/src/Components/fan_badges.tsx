@@ -0,0 +1,61 @@
+import { useEffect, useState } from "react";
+import { apiJson } from "../lib/api";
+
+export interface BadgeStatus {
+  code: string;
+  name: string;
+  description: string;
+  icon: string;
+  holders: number;
+  percentage: number;
+  rarity: string;
+  awarded_at?: string;
+}
+
+interface FanBadgesProps {
+  // Shows a fan's earned badges; without a fan ID, every badge for the signed-in fan
+  fanId?: number;
+}
+
+const rarityStyles: Record<string, string> = {
+  common: "border-slate-200 bg-slate-50",
+  uncommon: "border-emerald-200 bg-emerald-50",
+  rare: "border-blue-200 bg-blue-50",
+  legendary: "border-amber-300 bg-amber-50",
+};
+
+export default function FanBadges({ fanId }: FanBadgesProps) {
+  const [badges, setBadges] = useState<BadgeStatus[]>([]);
+
+  useEffect(() => {
+    const path = fanId === undefined ? "/achievements/me" : `/achievements/fans/${fanId}`;
+    apiJson<BadgeStatus[]>(path, { credentials: "include" })
+      .then(setBadges)
+      .catch((err) => console.error("Failed to load badges:", err));
+  }, [fanId]);
+
+  if (badges.length === 0) {
+    return null;
+  }
+
+  return (
+    <div className="flex flex-wrap gap-2">
+      {badges.map((badge) => {
+        const earned = Boolean(badge.awarded_at);
+        const title = `${badge.name}: ${badge.description} (${badge.rarity}, ${badge.percentage.toFixed(0)}% of fans)`;
+        return (
+          <span
+            key={badge.code}
+            title={earned ? `${title}, earned ${new Date(badge.awarded_at!).toLocaleDateString()}` : title}
+            className={`inline-flex items-center gap-1 rounded-full border px-2 py-1 text-xs font-medium text-slate-700 ${
+              rarityStyles[badge.rarity] || rarityStyles.common
+            } ${earned ? "" : "opacity-40 grayscale"}`}
+          >
+            <span aria-hidden="true">{badge.icon}</span>
+            {fanId === undefined && badge.name}
+          </span>
+        );
+      })}
+    </div>
+  );
+}
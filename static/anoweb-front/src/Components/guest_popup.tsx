@@ -1,12 +1,32 @@
 import { useEffect, useState, useContext } from "react";
 import { FanContext } from "../Contexts/fan_context";
-import { apiJson } from "../lib/api";
+import { apiFetch, apiJson } from "../lib/api";
+import { recordFunnelStep } from "../lib/tracking";
+
+interface PopupBenefit {
+  icon: string;
+  text: string;
+}
 
 interface PopupConfig {
   id: number;
   title: string;
-  benefits: string; // JSON string array
+  benefits: PopupBenefit[];
   is_active: boolean;
+  show_after_seconds: number;
+}
+
+type PopupEvent = "impression" | "dismiss" | "click_register";
+
+function recordPopupEvent(configId: number, event: PopupEvent) {
+  apiFetch("/guest-popup/event", {
+    method: "POST",
+    credentials: "include",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ config_id: configId, event }),
+  }).catch(() => {
+    // Analytics must never break the popup
+  });
 }
 
 interface GuestPopupProps {
@@ -16,7 +36,6 @@ interface GuestPopupProps {
 export default function GuestPopup({ onOpenAuth }: GuestPopupProps) {
   const { fan } = useContext(FanContext);
   const [config, setConfig] = useState<PopupConfig | null>(null);
-  const [benefits, setBenefits] = useState<string[]>([]);
   const [isVisible, setIsVisible] = useState(false);
   const [hasShown, setHasShown] = useState(false);
 
@@ -30,24 +49,19 @@ export default function GuestPopup({ onOpenAuth }: GuestPopupProps) {
 
     const fetchConfig = async () => {
       try {
-        const data = await apiJson<PopupConfig>("/guest-popup/active", {
+        const path = encodeURIComponent(window.location.pathname);
+        const data = await apiJson<PopupConfig>(`/guest-popup/active?path=${path}`, {
           credentials: "include",
         });
         setConfig(data);
-        
-        // Parse benefits JSON
-        try {
-          const parsedBenefits = JSON.parse(data.benefits);
-          setBenefits(Array.isArray(parsedBenefits) ? parsedBenefits : []);
-        } catch {
-          setBenefits([]);
-        }
-
-        // Show popup after a short delay
+
+        // Show popup after the configured delay
         setTimeout(() => {
           setIsVisible(true);
           setHasShown(true);
-        }, 2000);
+          recordPopupEvent(data.id, "impression");
+          recordFunnelStep("popup_shown");
+        }, (data.show_after_seconds || 0) * 1000);
       } catch (err) {
         // If no config found, don't show popup
         console.log("No active guest popup configuration");
@@ -57,18 +71,26 @@ export default function GuestPopup({ onOpenAuth }: GuestPopupProps) {
     fetchConfig();
   }, [fan, hasShown]);
 
-  const handleClose = () => {
+  const hidePopup = () => {
     setIsVisible(false);
     sessionStorage.setItem("guest_popup_dismissed", "true");
   };
 
+  const handleClose = () => {
+    if (config) recordPopupEvent(config.id, "dismiss");
+    hidePopup();
+  };
+
   const handleSignUp = () => {
-    handleClose();
+    if (config) recordPopupEvent(config.id, "click_register");
+    hidePopup();
     onOpenAuth();
   };
 
   if (!isVisible || !config) return null;
 
+  const benefits = config.benefits ?? [];
+
   return (
     <>
       {/* Backdrop */}
@@ -126,9 +148,9 @@ export default function GuestPopup({ onOpenAuth }: GuestPopupProps) {
                   {benefits.map((benefit, index) => (
                     <li key={index} className="flex items-start gap-3">
                       <span className="flex-shrink-0 h-6 w-6 rounded-full bg-indigo-600 text-white flex items-center justify-center text-sm font-bold">
-                        ✓
+                        {benefit.icon || "✓"}
                       </span>
-                      <span className="text-slate-700 leading-relaxed">{benefit}</span>
+                      <span className="text-slate-700 leading-relaxed">{benefit.text}</span>
                     </li>
                   ))}
                 </ul>
@@ -0,0 +1,92 @@
+import { useEffect, useState } from "react";
+import { apiUrl } from "../lib/api";
+
+interface OnlineFan {
+  id: number;
+  username: string;
+  profile_photo: string;
+}
+
+interface PresenceCount {
+  online: number;
+  anonymous: number;
+}
+
+interface PresenceSnapshot extends PresenceCount {
+  fans: OnlineFan[];
+}
+
+const MAX_AVATARS = 8;
+
+export default function OnlineNow() {
+  const [count, setCount] = useState<PresenceCount>({ online: 0, anonymous: 0 });
+  const [fans, setFans] = useState<OnlineFan[]>([]);
+  const [connected, setConnected] = useState(false);
+
+  useEffect(() => {
+    const source = new EventSource(apiUrl("/presence/stream"), { withCredentials: true });
+
+    source.addEventListener("snapshot", (e) => {
+      const data = JSON.parse((e as MessageEvent).data) as PresenceSnapshot;
+      setCount({ online: data.online, anonymous: data.anonymous });
+      setFans(data.fans || []);
+      setConnected(true);
+    });
+    source.addEventListener("join", (e) => {
+      const fan = JSON.parse((e as MessageEvent).data) as OnlineFan;
+      setFans((prev) => (prev.some((f) => f.id === fan.id) ? prev : [...prev, fan]));
+    });
+    source.addEventListener("leave", (e) => {
+      const { id } = JSON.parse((e as MessageEvent).data) as { id: number };
+      setFans((prev) => prev.filter((f) => f.id !== id));
+    });
+    source.addEventListener("count", (e) => {
+      setCount(JSON.parse((e as MessageEvent).data) as PresenceCount);
+    });
+    source.onerror = () => setConnected(false);
+
+    return () => source.close();
+  }, []);
+
+  const shown = fans.slice(0, MAX_AVATARS);
+  const hidden = fans.length - shown.length + count.anonymous;
+
+  return (
+    <div className="flex items-center justify-between gap-3 rounded-xl border border-emerald-100 bg-emerald-50 px-4 py-3">
+      <div className="flex items-center gap-2">
+        <span
+          className={`h-2.5 w-2.5 rounded-full ${connected ? "bg-emerald-500 animate-pulse" : "bg-slate-300"}`}
+        />
+        <span className="text-sm font-semibold text-emerald-800">
+          {count.online} online now
+        </span>
+      </div>
+      <div className="flex -space-x-2">
+        {shown.map((fan) =>
+          fan.profile_photo ? (
+            <img
+              key={fan.id}
+              src={fan.profile_photo}
+              alt={fan.username}
+              title={fan.username}
+              className="h-8 w-8 rounded-full border-2 border-white object-cover"
+            />
+          ) : (
+            <div
+              key={fan.id}
+              title={fan.username}
+              className="h-8 w-8 rounded-full border-2 border-white bg-gradient-to-br from-blue-500 to-purple-600 text-white flex items-center justify-center text-xs font-bold"
+            >
+              {fan.username.charAt(0).toUpperCase()}
+            </div>
+          )
+        )}
+        {hidden > 0 && (
+          <span className="flex h-8 w-8 items-center justify-center rounded-full border-2 border-white bg-slate-200 text-xs font-semibold text-slate-600">
+            +{hidden}
+          </span>
+        )}
+      </div>
+    </div>
+  );
+}
@@ -8,6 +8,8 @@ export interface Fan {
   is_admin: boolean;
   profile_photo: string;
   bio: string;
+  timezone?: string;
+  show_on_leaderboard?: boolean;
   created_at: string;
 }
 
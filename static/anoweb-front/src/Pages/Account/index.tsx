@@ -4,6 +4,7 @@ import { useErrorNotifier } from "../../Contexts/error_context";
 import { useSuccessNotifier } from "../../Contexts/success_context";
 import { apiFetch, apiJson } from "../../lib/api";
 import { useNavigate } from "react-router";
+import FanBadges from "../../Components/fan_badges";
 
 interface TrackingRecord {
   id: number;
@@ -15,31 +16,48 @@ interface TrackingRecord {
   created_at: string;
 }
 
+interface TrackingRecordsPage {
+  records: TrackingRecord[];
+  next_cursor: string;
+}
+
+interface TrackingConsentState {
+  granted: boolean;
+  policy_version: string;
+  do_not_track: boolean;
+}
+
 export default function AccountPage() {
   const { fan, refreshFan } = useContext(FanContext);
   const notifyError = useErrorNotifier();
   const notifySuccess = useSuccessNotifier();
   const navigate = useNavigate();
   const [bio, setBio] = useState(fan?.bio || "");
+  const [timezone, setTimezone] = useState(fan?.timezone || "");
+  const [showOnLeaderboard, setShowOnLeaderboard] = useState(fan?.show_on_leaderboard || false);
   const [loading, setLoading] = useState(false);
   const [records, setRecords] = useState<TrackingRecord[]>([]);
   const [pageIndex, setPageIndex] = useState(0);
+  const [consent, setConsent] = useState<TrackingConsentState | null>(null);
   const fileInputRef = useRef<HTMLInputElement>(null);
   const pageSize = 10;
 
   useEffect(() => {
     const fetchRecords = async () => {
       try {
-        const data = await apiJson<TrackingRecord[]>("/tracking/records", {
+        const data = await apiJson<TrackingRecordsPage>("/tracking/records?limit=200", {
           credentials: "include",
         });
-        setRecords(data);
+        setRecords(data.records);
         setPageIndex(0);
       } catch (err) {
         console.error("Failed to load tracking records:", err);
       }
     };
     fetchRecords();
+    apiJson<TrackingConsentState>("/tracking/consent", { credentials: "include" })
+      .then(setConsent)
+      .catch((err) => console.error("Failed to load tracking consent:", err));
   }, []);
   useEffect(() => {
     if (records.length === 0) {
@@ -65,7 +83,11 @@ export default function AccountPage() {
       await apiFetch("/user/profile", {
         method: "PUT",
         headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ bio }),
+        body: JSON.stringify({
+          bio,
+          timezone: timezone.trim(),
+          show_on_leaderboard: showOnLeaderboard,
+        }),
         credentials: "include",
       });
       await refreshFan();
@@ -100,6 +122,32 @@ export default function AccountPage() {
     }
   };
 
+  const handleConsentChange = async (granted: boolean) => {
+    try {
+      const data = await apiJson<TrackingConsentState>("/tracking/consent", {
+        method: "PUT",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ granted }),
+        credentials: "include",
+      });
+      setConsent(data);
+      notifySuccess(granted ? "Time tracking enabled" : "Time tracking disabled");
+    } catch (err) {
+      notifyError(err instanceof Error ? err.message : "Failed to update tracking consent");
+    }
+  };
+
+  const handleEraseTracking = async () => {
+    if (!window.confirm("Delete your entire session history? This cannot be undone.")) return;
+    try {
+      await apiFetch("/tracking/records", { method: "DELETE", credentials: "include" });
+      setRecords([]);
+      notifySuccess("Your session history was deleted");
+    } catch (err) {
+      notifyError(err instanceof Error ? err.message : "Failed to delete session history");
+    }
+  };
+
   const formatDuration = (seconds: number) => {
     const hours = Math.floor(seconds / 3600);
     const minutes = Math.floor((seconds % 3600) / 60);
@@ -195,6 +243,33 @@ export default function AccountPage() {
               />
             </div>
 
+            <div className="mt-4">
+              <label htmlFor="timezone" className="block text-sm font-medium text-slate-700 mb-1">
+                Time Zone
+              </label>
+              <input
+                id="timezone"
+                type="text"
+                value={timezone}
+                onChange={(e) => setTimezone(e.target.value)}
+                className="w-full px-3 py-2 border border-slate-300 rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                placeholder={Intl.DateTimeFormat().resolvedOptions().timeZone}
+              />
+              <p className="mt-1 text-xs text-slate-500">
+                Used for your streak and activity charts. Leave empty to use your browser's time zone.
+              </p>
+            </div>
+
+            <label className="mt-4 flex items-center gap-2 text-sm text-slate-700">
+              <input
+                type="checkbox"
+                checked={showOnLeaderboard}
+                onChange={(e) => setShowOnLeaderboard(e.target.checked)}
+                className="h-4 w-4 rounded border-slate-300 text-blue-600 focus:ring-blue-500"
+              />
+              Show me on the community leaderboard
+            </label>
+
             <button
               type="submit"
               disabled={loading}
@@ -269,6 +344,48 @@ export default function AccountPage() {
         </div>
       </div>
 
+      {/* Badges */}
+      <div className="mt-6 bg-white rounded-2xl shadow-sm border border-slate-200 p-6 md:p-8">
+        <h2 className="text-2xl font-semibold text-slate-900 mb-4 flex items-center gap-2">
+          <span>🏅</span> Badges
+        </h2>
+        <p className="text-sm text-slate-600 mb-4">Badges you have earned are shown on your community profile.</p>
+        <FanBadges />
+      </div>
+
+      {/* Tracking Privacy */}
+      <div className="mt-6 bg-white rounded-2xl shadow-sm border border-slate-200 p-6 md:p-8">
+        <h2 className="text-2xl font-semibold text-slate-900 mb-4 flex items-center gap-2">
+          <span>🔒</span> Time Tracking
+        </h2>
+        {consent?.do_not_track ? (
+          <p className="text-sm text-slate-600 mb-4">
+            Your browser sends a Do Not Track or Global Privacy Control signal, so your time is not tracked.
+          </p>
+        ) : (
+          <p className="text-sm text-slate-600 mb-4">
+            {consent?.granted
+              ? "Your time on the site is tracked for your stats and streaks."
+              : "Your time on the site is not tracked until you allow it."}
+          </p>
+        )}
+        <div className="flex flex-wrap gap-3">
+          <button
+            onClick={() => handleConsentChange(!consent?.granted)}
+            disabled={!consent}
+            className="px-4 py-2 rounded-lg bg-blue-600 text-white text-sm font-semibold hover:bg-blue-700 disabled:opacity-50"
+          >
+            {consent?.granted ? "Stop tracking my time" : "Allow time tracking"}
+          </button>
+          <button
+            onClick={handleEraseTracking}
+            className="px-4 py-2 rounded-lg border border-red-200 text-red-600 text-sm font-semibold hover:bg-red-50"
+          >
+            Delete my session history
+          </button>
+        </div>
+      </div>
+
       {/* Session History */}
       <div className="mt-6 bg-white rounded-2xl shadow-sm border border-slate-200 p-6 md:p-8">
         <h2 className="text-2xl font-semibold text-slate-900 mb-6 flex items-center gap-2">
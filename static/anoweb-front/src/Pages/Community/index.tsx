@@ -6,6 +6,9 @@ import { useErrorNotifier } from "../../Contexts/error_context";
 import { useEditMode } from "../../Contexts/edit_mode_context";
 import { apiJson } from "../../lib/api";
 import AuthModal from "../../Components/auth_modal";
+import OnlineNow from "../../Components/online_now";
+import FanBadges from "../../Components/fan_badges";
+import ActivityCalendar from "../../Components/activity_calendar";
 
 interface TrackingRecord {
   id: number;
@@ -17,6 +20,11 @@ interface TrackingRecord {
   created_at: string;
 }
 
+interface TrackingRecordsPage {
+  records: TrackingRecord[];
+  next_cursor: string;
+}
+
 interface OverallStats {
   total_users: number;
   unique_visitors_ever: number;
@@ -27,8 +35,30 @@ interface OverallStats {
   guest_visitors_24h: number;
   active_users_today: number;
   total_hours: number;
+  generated_at: string;
+}
+
+interface LeaderboardEntry {
+  rank: number;
+  user_id: number;
+  username: string;
+  profile_photo: string;
+  value: number;
 }
 
+interface Leaderboard {
+  metric: string;
+  total: number;
+  entries: LeaderboardEntry[];
+  me: LeaderboardEntry | null;
+}
+
+const leaderboardMetrics: Record<string, string> = {
+  total_hours: "Total Hours",
+  week_hours: "Hours This Week",
+  streak: "Current Streak",
+};
+
 interface TimePoint {
   hour?: string;
   date?: string;
@@ -57,6 +87,7 @@ export default function Community() {
   const [loading, setLoading] = useState(true);
   const [userHours, setUserHours] = useState(0);
   const [streak, setStreak] = useState(0);
+  const [longestStreak, setLongestStreak] = useState(0);
   const [overallStats, setOverallStats] = useState<OverallStats | null>(null);
   const [fansOverTime, setFansOverTime] = useState<TimePoint[]>([]);
   const [dailyActive, setDailyActive] = useState<TimePoint[]>([]);
@@ -65,6 +96,8 @@ export default function Community() {
   const [communityFans, setCommunityFans] = useState<any[]>([]);
   const [searchQuery, setSearchQuery] = useState("");
   const [sortOrder, setSortOrder] = useState("registration");
+  const [leaderboardMetric, setLeaderboardMetric] = useState("total_hours");
+  const [leaderboard, setLeaderboard] = useState<Leaderboard | null>(null);
 
   const openAuthModal = (mode: "login" | "register") => {
     setAuthModalMode(mode);
@@ -82,8 +115,10 @@ export default function Community() {
     const fetchData = async () => {
       try {
         setLoading(true);
+        // Bucket days and hours in the fan's saved zone, else the browser's
+        const tz = encodeURIComponent(fan?.timezone || Intl.DateTimeFormat().resolvedOptions().timeZone);
 
-        const statsData = await apiJson<OverallStats>("/statistics/overall", {
+        const statsData = await apiJson<OverallStats>(`/statistics/overall?tz=${tz}`, {
           credentials: "include",
         });
         setOverallStats(statsData);
@@ -94,15 +129,16 @@ export default function Community() {
           });
           setUserHours(userHoursData.total_hours);
 
-          const streakData = await apiJson<{ streak: number }>("/statistics/streak", {
+          const streakData = await apiJson<{ streak: number; longest: number }>(`/statistics/streak?tz=${tz}`, {
             credentials: "include",
           });
           setStreak(streakData.streak);
+          setLongestStreak(streakData.longest);
 
-          const recordsData = await apiJson<TrackingRecord[]>("/tracking/records", {
+          const recordsData = await apiJson<TrackingRecordsPage>("/tracking/records?limit=200", {
             credentials: "include",
           });
-          setRecords(recordsData);
+          setRecords(recordsData.records);
 
           const fansData = await apiJson<any[]>("/user/list", {
             credentials: "include",
@@ -110,12 +146,12 @@ export default function Community() {
           setCommunityFans(fansData);
         }
 
-        const fansOverTimeData = await apiJson<TimePoint[]>("/statistics/users-over-time?hours=48", {
+        const fansOverTimeData = await apiJson<TimePoint[]>(`/statistics/users-over-time?hours=48&tz=${tz}`, {
           credentials: "include",
         });
         setFansOverTime(fansOverTimeData);
 
-        const dailyActiveData = await apiJson<TimePoint[]>("/statistics/daily-active?days=14", {
+        const dailyActiveData = await apiJson<TimePoint[]>(`/statistics/daily-active?days=14&tz=${tz}`, {
           credentials: "include",
         });
         setDailyActive(dailyActiveData);
@@ -130,6 +166,18 @@ export default function Community() {
     fetchData();
   }, [fan, notifyError]);
 
+  useEffect(() => {
+    const tz = encodeURIComponent(fan?.timezone || Intl.DateTimeFormat().resolvedOptions().timeZone);
+    apiJson<Leaderboard>(`/statistics/leaderboard?metric=${leaderboardMetric}&limit=10&tz=${tz}`, {
+      credentials: "include",
+    })
+      .then(setLeaderboard)
+      .catch((error) => console.error(error));
+  }, [fan, leaderboardMetric]);
+
+  const formatLeaderboardValue = (value: number) =>
+    leaderboardMetric === "streak" ? `${value} ${value === 1 ? "day" : "days"}` : `${value.toFixed(1)}h`;
+
   const formatHours = (hours: number) => {
     return hours.toFixed(2);
   };
@@ -373,8 +421,10 @@ export default function Community() {
                   {streak}
                   <span className="text-base font-semibold text-white/80">{streak === 1 ? "day" : "days"}</span>
                 </div>
+                <p className="text-xs text-white/70 mt-1">Longest: {longestStreak} {longestStreak === 1 ? "day" : "days"}</p>
               </div>
             </div>
+            <ActivityCalendar tz={fan?.timezone || Intl.DateTimeFormat().resolvedOptions().timeZone} />
           </motion.div>
 
           <motion.div
@@ -409,6 +459,7 @@ export default function Community() {
                   Live overview
                 </span>
               </div>
+              <OnlineNow />
               <div className="grid grid-cols-2 gap-3">
                 {communitySnapshot.map((stat) => (
                   <MiniStatCard key={stat.label} label={stat.label} value={stat.value} />
@@ -438,6 +489,56 @@ export default function Community() {
             <ChartCard title="Daily Active Users (14 days)" data={dailyActive} xKey="date" color="purple" />
           </motion.div>
 
+          {leaderboard && (
+            <motion.div
+              className="rounded-3xl bg-white/85 backdrop-blur-md shadow-xl border border-slate-200/90 p-6 md:p-8 md:col-span-6"
+              variants={itemVariants}
+              initial="hidden"
+              animate="show"
+            >
+              <div className="flex items-center justify-between mb-6 gap-3 flex-wrap">
+                <div>
+                  <p className="text-sm font-semibold text-slate-700">Community</p>
+                  <h2 className="text-2xl font-semibold text-slate-900">🏆 Leaderboard</h2>
+                </div>
+                <select
+                  value={leaderboardMetric}
+                  onChange={(e) => setLeaderboardMetric(e.target.value)}
+                  className="rounded-lg border border-slate-300 px-3 py-2 text-sm focus:outline-none focus:ring-2 focus:ring-blue-500"
+                >
+                  {Object.entries(leaderboardMetrics).map(([metric, label]) => (
+                    <option key={metric} value={metric}>
+                      {label}
+                    </option>
+                  ))}
+                </select>
+              </div>
+              {leaderboard.entries.length === 0 ? (
+                <p className="text-sm text-slate-500">
+                  No one has joined the leaderboard yet. Opt in from your account page.
+                </p>
+              ) : (
+                <ol className="divide-y divide-slate-100">
+                  {leaderboard.entries.map((entry) => (
+                    <li
+                      key={entry.user_id}
+                      className={`flex items-center gap-3 py-2 ${entry.user_id === fan?.id ? "font-semibold text-blue-700" : "text-slate-800"}`}
+                    >
+                      <span className="w-8 text-right text-slate-500">#{entry.rank}</span>
+                      <span className="flex-1 truncate">{entry.username}</span>
+                      <span>{formatLeaderboardValue(entry.value)}</span>
+                    </li>
+                  ))}
+                </ol>
+              )}
+              {leaderboard.me && !leaderboard.entries.some((entry) => entry.user_id === leaderboard.me?.user_id) && (
+                <p className="mt-4 text-sm text-slate-600">
+                  You are #{leaderboard.me.rank} of {leaderboard.total} with {formatLeaderboardValue(leaderboard.me.value)}.
+                </p>
+              )}
+            </motion.div>
+          )}
+
           <motion.div
             className="rounded-3xl bg-white/85 backdrop-blur-md shadow-xl border border-slate-200/90 p-6 md:p-8 md:col-span-6"
             variants={itemVariants}
@@ -495,6 +596,9 @@ export default function Community() {
                   {fan.bio && (
                     <p className="mt-3 text-sm text-slate-700 line-clamp-2">{fan.bio}</p>
                   )}
+                  <div className="mt-3">
+                    <FanBadges fanId={fan.id} />
+                  </div>
                 </motion.div>
               ))}
             </div>
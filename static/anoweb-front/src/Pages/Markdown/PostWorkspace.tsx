@@ -9,6 +9,7 @@ import { useErrorNotifier } from "../../Contexts/error_context";
 import { FanContext } from "../../Contexts/fan_context";
 import { useEditMode } from "../../Contexts/edit_mode_context";
 import { apiFetch, apiJson } from "../../lib/api";
+import { trackContentView } from "../../lib/tracking";
 import type { Post } from "../Projects/types";
 
 type EditorMode = "write" | "preview" | "split";
@@ -116,6 +117,13 @@ export default function PostWorkspace() {
       .finally(() => setIsLoading(false));
   }, [postId, allowMock, demoPost, notifyError]);
 
+  // Count the read once the real post has loaded
+  const viewedPostId = post && post !== demoPost ? post.id : null;
+  useEffect(() => {
+    if (viewedPostId === null) return;
+    return trackContentView("post", viewedPostId);
+  }, [viewedPostId]);
+
   const updatedAt = useMemo(() => {
     if (!post?.updated_at) return "";
     const date = new Date(post.updated_at);
@@ -4,6 +4,7 @@ import { useState, useContext, useEffect } from "react";
 import { FanContext } from "../../Contexts/fan_context";
 import { useEditMode } from "../../Contexts/edit_mode_context";
 import { apiFetch } from "../../lib/api";
+import { trackContentView } from "../../lib/tracking";
 import { type Project } from "./types";
 
 type ProjectDetailsProps = {
@@ -31,6 +32,8 @@ export function ProjectDetails({ project, onProjectUpdate }: ProjectDetailsProps
     setIsDescriptionExpanded(false); // Reset expand state
   }, [project]);
 
+  useEffect(() => trackContentView("project", project.id), [project.id]);
+
   const handleImageUpload = async (e: React.ChangeEvent<HTMLInputElement>) => {
     const file = e.target.files?.[0];
     if (!file) return;
@@ -1,4 +1,4 @@
-import { apiFetch } from "./api";
+import { apiFetch, apiUrl } from "./api";
 
 // Generate a unique session ID for tracking
 export function generateSessionId(): string {
@@ -15,6 +15,16 @@ export function getSessionId(): string {
   return sessionId;
 }
 
+// Where the visit came from; the server keeps the first one of each session
+function sourceFields(params: URLSearchParams = new URLSearchParams(window.location.search)) {
+  return {
+    referrer: document.referrer,
+    utm_source: params.get("utm_source") ?? "",
+    utm_medium: params.get("utm_medium") ?? "",
+    utm_campaign: params.get("utm_campaign") ?? "",
+  };
+}
+
 // Start tracking session
 export async function startTracking(): Promise<void> {
   const sessionId = getSessionId();
@@ -22,7 +32,7 @@ export async function startTracking(): Promise<void> {
     await apiFetch("/tracking/start", {
       method: "POST",
       headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ session_id: sessionId }),
+      body: JSON.stringify({ session_id: sessionId, ...sourceFields() }),
       credentials: "include",
     });
   } catch (err) {
@@ -64,6 +74,37 @@ export async function updateTracking(): Promise<void> {
   }
 }
 
+function generateEventId(): string {
+  if (typeof crypto !== "undefined" && "randomUUID" in crypto) {
+    return crypto.randomUUID();
+  }
+  return `${Date.now()}_${Math.random().toString(36).slice(2, 11)}`;
+}
+
+// Send the end of the session as a beacon so it survives the tab closing.
+// Falls back to a keepalive fetch when sendBeacon is unavailable.
+export function endTrackingBeacon(): void {
+  const sessionId = sessionStorage.getItem("tracking_session_id");
+  if (!sessionId) return;
+
+  const body = JSON.stringify({
+    session_id: sessionId,
+    ops: [{ id: generateEventId(), type: "end" }],
+  });
+  const url = apiUrl("/tracking/batch");
+
+  if (navigator.sendBeacon && navigator.sendBeacon(url, body)) {
+    return;
+  }
+  fetch(url, {
+    method: "POST",
+    headers: { "Content-Type": "text/plain" },
+    body,
+    credentials: "include",
+    keepalive: true,
+  }).catch(() => undefined);
+}
+
 // Initialize tracking on app load
 export function initializeTracking(): () => void {
   // Start tracking
@@ -74,7 +115,7 @@ export function initializeTracking(): () => void {
 
   // End tracking on page unload
   const handleUnload = () => {
-    endTracking();
+    endTrackingBeacon();
   };
 
   window.addEventListener("beforeunload", handleUnload);
@@ -86,3 +127,86 @@ export function initializeTracking(): () => void {
     endTracking();
   };
 }
+
+// Record a page view or custom event for the current session
+export async function recordEvent(
+  name: string = "pageview",
+  properties?: Record<string, unknown>,
+): Promise<void> {
+  const params = new URLSearchParams(window.location.search);
+  try {
+    await apiFetch("/tracking/event", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({
+        session_id: getSessionId(),
+        name,
+        path: window.location.pathname,
+        ...sourceFields(params),
+        utm_term: params.get("utm_term") ?? "",
+        utm_content: params.get("utm_content") ?? "",
+        properties,
+      }),
+      credentials: "include",
+    });
+  } catch (err) {
+    console.error("Failed to record event:", err);
+  }
+}
+
+// Record a registration funnel step; the server keeps the first one per visitor
+export function recordFunnelStep(step: "popup_shown" | "register_form"): void {
+  apiFetch("/tracking/funnel", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ session_id: getSessionId(), step }),
+    credentials: "include",
+  }).catch(() => undefined);
+}
+
+// Report a view of a post or project while it stays open: once on open, then every
+// 15 seconds with the time spent reading (while the tab is visible) and, for posts,
+// how far the page was scrolled. Returns a cleanup function that sends a last report.
+export function trackContentView(contentType: "post" | "project", contentId: number): () => void {
+  let readSeconds = 0;
+  let scrollDepth = 0;
+
+  const measureScroll = () => {
+    if (contentType !== "post") return;
+    const scrollable = document.documentElement.scrollHeight;
+    const seen = scrollable > 0 ? ((window.scrollY + window.innerHeight) / scrollable) * 100 : 100;
+    scrollDepth = Math.max(scrollDepth, Math.min(100, Math.round(seen)));
+  };
+
+  const ping = (keepalive = false) => {
+    fetch(apiUrl("/tracking/content"), {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({
+        session_id: getSessionId(),
+        content_type: contentType,
+        content_id: contentId,
+        read_seconds: readSeconds,
+        scroll_depth: scrollDepth,
+      }),
+      credentials: "include",
+      keepalive,
+    }).catch(() => undefined);
+  };
+
+  measureScroll();
+  ping();
+
+  const tick = setInterval(() => {
+    if (document.visibilityState === "visible") readSeconds++;
+  }, 1000);
+  const report = setInterval(() => ping(), 15000);
+  window.addEventListener("scroll", measureScroll, { passive: true });
+
+  return () => {
+    clearInterval(tick);
+    clearInterval(report);
+    window.removeEventListener("scroll", measureScroll);
+    ping(true);
+  };
+}